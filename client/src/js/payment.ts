@@ -1,49 +1,111 @@
+function selectedTip(): { percent?: number; amount?: number } {
+  const checked = document.querySelector(
+    'input[name="tip"]:checked',
+  ) as HTMLInputElement | null;
+
+  if (!checked || checked.value === "0") {
+    return {};
+  }
+
+  if (checked.value === "custom") {
+    const custom = document.getElementById("tip-custom") as HTMLInputElement;
+    const amount = Math.round(parseFloat(custom?.value || "0") * 100);
+    return amount > 0 ? { amount } : {};
+  }
+
+  return { percent: parseInt(checked.value, 10) };
+}
+
 function initPayment() {
   const paymentForm = document.getElementById("stripe-form");
   const errors = document.getElementById("error-messages");
+  const tipSection = document.getElementById("tip-section");
+  const payButton = document.getElementById("pay-button");
   const publishableKeyElem = document.getElementById("pk") as HTMLInputElement;
+  const csrfElem = document.getElementById("_csrf") as HTMLInputElement;
+
+  if (!paymentForm || !csrfElem) {
+    return;
+  }
 
+  const csrfToken = csrfElem.value;
+  const publishableKey = publishableKeyElem ? publishableKeyElem.value : "";
   if (publishableKeyElem) {
-    const stripe = (window as any).Stripe(publishableKeyElem.value);
     publishableKeyElem.remove();
+  }
 
-    const csrfElem = document.getElementById("_csrf") as HTMLInputElement;
-    if (csrfElem) {
-      const csrfToken = csrfElem.value;
+  let stripe: any = null;
+  let elements: any = null;
+  let starting = false;
 
-      fetch("/intent", {
-        method: "POST",
-        headers: {
-          "X-CSRF-Token": csrfToken,
-          "Content-Type": "application/json",
-        },
-      })
-        .then((res) => res.json())
-        .then((response) => {
-          const elements = stripe.elements({
-            clientSecret: response.clientSecret,
-          });
-          const paymentElement = elements.create("payment");
-          paymentElement.mount("#payment-element");
-
-          if (paymentForm) {
-            paymentForm.addEventListener("submit", (event) => {
-              event.preventDefault();
-              const { error } = stripe.confirmPayment({
-                elements,
-                confirmParams: {
-                  return_url: window.location.origin + "/orders/success",
-                },
-              });
-
-              if (error && errors) {
-                errors.innerHTML = error.message;
-              }
-            });
+  paymentForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+
+    if (stripe && elements) {
+      stripe
+        .confirmPayment({
+          elements,
+          confirmParams: {
+            return_url: window.location.origin + "/orders/success",
+          },
+        })
+        .then(({ error }: { error?: { message: string } }) => {
+          if (error && errors) {
+            errors.innerHTML = error.message;
           }
         });
+      return;
     }
-  }
+
+    if (starting) {
+      return;
+    }
+    starting = true;
+
+    // The tip is settled when the payment is created, it cannot change afterwards
+    fetch("/intent", {
+      method: "POST",
+      headers: {
+        "X-CSRF-Token": csrfToken,
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify(selectedTip()),
+    })
+      .then((res) => res.json())
+      .then((response) => {
+        // Redirect providers (PayPal) take the customer to their own page to approve
+        if (response.approveUrl) {
+          window.location.href = response.approveUrl;
+          return;
+        }
+
+        if (!response.clientSecret) {
+          starting = false;
+          if (errors) {
+            errors.innerHTML = response.message || "Could not start the payment";
+          }
+          return;
+        }
+
+        tipSection?.remove();
+        if (payButton) {
+          payButton.textContent = "Pay Now";
+        }
+
+        stripe = (window as any).Stripe(publishableKey);
+        elements = stripe.elements({
+          clientSecret: response.clientSecret,
+        });
+        const paymentElement = elements.create("payment");
+        paymentElement.mount("#payment-element");
+      })
+      .catch(() => {
+        starting = false;
+        if (errors) {
+          errors.innerHTML = "Could not start the payment";
+        }
+      });
+  });
 }
 
 if (document.readyState !== "loading") {
@@ -4,13 +4,15 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/Francesco99975/rosskery/cmd/boot"
+	"github.com/Francesco99975/rosskery/internal/geo"
 	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/payments"
 	"github.com/Francesco99975/rosskery/internal/storage"
-
-	"github.com/stripe/stripe-go/v78"
+	"github.com/Francesco99975/rosskery/internal/tools"
 )
 
 func main() {
@@ -32,7 +34,21 @@ func main() {
 
 	storage.ValkeySetup(ctx)
 
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+	geo.Setup(geocodeCache())
+
+	geo.SetupAutocomplete()
+
+	go tools.ScheduleSuggestionsPrune(ctx)
+
+	go tools.ScheduleMonthlyExports(ctx)
+
+	go tools.ScheduleLoyaltyExpiry(ctx)
+
+	go tools.ScheduleDuplicateDetection(ctx)
+
+	go tools.PrinterQueue.ProcessQueue(ctx)
+
+	payments.Setup()
 
 	e := createRouter(ctx)
 
@@ -49,3 +65,18 @@ func main() {
 		e.Logger.Fatal(err)
 	}
 }
+
+// geocodeCache keeps geocoded addresses in Valkey, or in Postgres with GEOCODE_CACHE=postgres,
+// for GEOCODE_CACHE_DAYS (30 by default)
+func geocodeCache() geo.Cache {
+	ttl := 30 * 24 * time.Hour
+	if days, err := strconv.Atoi(os.Getenv("GEOCODE_CACHE_DAYS")); err == nil && days > 0 {
+		ttl = time.Duration(days) * 24 * time.Hour
+	}
+
+	if os.Getenv("GEOCODE_CACHE") == "postgres" {
+		return models.NewGeocodeCache(ttl)
+	}
+
+	return geo.NewValkeyCache(ttl)
+}
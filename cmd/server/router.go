@@ -71,8 +71,8 @@ func createRouter(ctx context.Context) *echo.Echo {
 		CookiePath:     "/",
 		CookieHTTPOnly: true,
 		Skipper: func(c echo.Context) bool {
-			// Skip CSRF for the /webhook route
-			return c.Path() == "/webhook"
+			// Skip CSRF for the payment provider webhooks
+			return c.Path() == "/webhook" || c.Path() == "/webhook/paypal"
 
 		},
 	}))
@@ -86,6 +86,20 @@ func createRouter(ctx context.Context) *echo.Echo {
 	web.GET("/photos", controllers.Photos(), middlewares.IsOnline(ctx))
 	web.GET("/shop", controllers.Shop(ctx), middlewares.IsOnline(ctx))
 	web.GET("/checkout", controllers.Checkout(ctx), middlewares.IsOnline(ctx), middlewares.IsOperative(ctx))
+	web.GET("/ref/:code", controllers.Referral(), middlewares.IsOnline(ctx))
+
+	web.GET("/account/login", controllers.AccountLogin(ctx), middlewares.IsOnline(ctx))
+	web.POST("/account/login", controllers.SignIn(ctx), middlewares.IsOnline(ctx))
+	web.POST("/account/signup", controllers.SignUp(ctx), middlewares.IsOnline(ctx))
+	web.POST("/account/magic", controllers.RequestMagicLink(ctx), middlewares.IsOnline(ctx))
+	web.GET("/account/magic/:token", controllers.MagicSignIn(ctx))
+	web.POST("/account/logout", controllers.SignOut())
+	web.GET("/account/verify/:token", controllers.VerifyAccount(ctx))
+	web.GET("/account", controllers.Account(ctx), middlewares.IsOnline(ctx), middlewares.IsCustomer())
+	web.POST("/account/profile", controllers.UpdateAccountProfile(ctx), middlewares.IsCustomer())
+	web.POST("/account/addresses", controllers.AddAccountAddress(ctx), middlewares.IsCustomer())
+	web.POST("/account/addresses/:id/delete", controllers.DeleteAccountAddress(), middlewares.IsCustomer())
+	web.GET("/account/orders/:id/invoice", controllers.AccountInvoice(), middlewares.IsCustomer())
 
 	web.GET("/bag", controllers.GetCartItems(ctx), middlewares.IsOnline(ctx))
 	web.POST("/bag/:id", controllers.AddToCart(ctx), middlewares.IsOnline(ctx))
@@ -95,10 +109,13 @@ func createRouter(ctx context.Context) *echo.Echo {
 	web.POST("/intent", api.CreatePaymentIntent(ctx), middlewares.IsOnline(ctx))
 	web.POST("/orders", api.IssueOrder(ctx, wsManager), middlewares.IsOnline(ctx))
 	web.GET("/orders/success", controllers.Success(ctx), middlewares.IsOnline(ctx))
+	web.GET("/payments/return", api.ReturnPayment(ctx, wsManager), middlewares.IsOnline(ctx))
 
 	web.GET("/address", controllers.AddressAutocomplete())
+	web.GET("/delivery", controllers.DeliveryQuote(ctx), middlewares.IsOnline(ctx))
 
 	web.POST("/webhook", api.PaymentWebhook(ctx, wsManager))
+	web.POST("/webhook/paypal", api.PayPalWebhook(wsManager))
 
 	admin := e.Group("/admin")
 	admin.POST("/login", api.Login(wsManager))
@@ -107,44 +124,106 @@ func createRouter(ctx context.Context) *echo.Echo {
 
 	admin.Use(middlewares.IsAuthenticatedAdmin())
 
-	admin.POST("/signup", api.Signup())
+	admin.POST("/signup", api.Signup(), middlewares.Audit(wsManager, models.AuditCreate, models.AuditUser, nil))
 	admin.GET("/visits", api.GetVisits())
 	admin.GET("/visits/stats", api.GetVisitStats())
 	admin.GET("/visits/graph", api.GetVisitGraph())
 	admin.GET("/visits/standings", api.GetVisitsStandings())
 	admin.GET("/categories", api.Categories())
-	admin.POST("/categories", api.CreateCategory(wsManager))
-	admin.DELETE("/categories/:id", api.DeleteCategory(wsManager))
+	admin.POST("/categories", api.CreateCategory(wsManager), middlewares.Audit(wsManager, models.AuditCreate, models.AuditCategory, nil))
+	admin.DELETE("/categories/:id", api.DeleteCategory(wsManager), middlewares.Audit(wsManager, models.AuditDelete, models.AuditCategory, middlewares.CategorySnapshot))
 	admin.GET("/clientele", api.GetCustomerStats())
+	admin.GET("/clientele/referrals", api.GetReferralReport())
+	admin.GET("/clientele/segments", api.GetSegmentsStats())
+	admin.GET("/clientele/customers", api.GetSegmentedCustomers())
 	admin.GET("/customers", api.Customers())
+	admin.GET("/customers/tags", api.GetCustomerTags())
+	admin.GET("/customers/duplicates", api.GetDuplicateCustomers())
+	admin.POST("/customers/duplicates/scan", api.DetectDuplicateCustomers(), middlewares.Audit(wsManager, models.AuditScan, models.AuditCustomer, nil))
+	admin.POST("/customers/duplicates/:id/merge", api.MergeDuplicateCustomers(wsManager), middlewares.Audit(wsManager, models.AuditMerge, models.AuditCustomer, nil))
+	admin.POST("/customers/duplicates/:id/dismiss", api.DismissDuplicateCustomers(), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditCustomer, nil))
+	admin.GET("/customers/merges", api.GetCustomerMerges())
+	admin.POST("/customers/merges/:id/undo", api.UndoCustomerMerge(wsManager), middlewares.Audit(wsManager, models.AuditUndo, models.AuditCustomer, nil))
 	admin.GET("/customers/:id", api.Customer())
-	admin.DELETE("/customers/:id", api.DeleteCustomer(wsManager))
+	admin.DELETE("/customers/:id", api.DeleteCustomer(wsManager), middlewares.Audit(wsManager, models.AuditDelete, models.AuditCustomer, middlewares.CustomerSnapshot))
+	admin.PUT("/customers/:id/tags", api.SetCustomerTags(wsManager), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditCustomer, middlewares.CustomerSnapshot))
+	admin.POST("/customers/:id/notes", api.AddCustomerNote(), middlewares.Audit(wsManager, models.AuditCreate, models.AuditCustomer, nil))
+	admin.PUT("/customers/:id/notes/:note", api.EditCustomerNote(), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditCustomer, nil))
+	admin.DELETE("/customers/:id/notes/:note", api.DeleteCustomerNote(), middlewares.Audit(wsManager, models.AuditDelete, models.AuditCustomer, nil))
+	admin.GET("/customers/:id/export", api.ExportCustomerData(), middlewares.Audit(wsManager, models.AuditExport, models.AuditCustomer, nil))
+	admin.POST("/customers/:id/erase", api.EraseCustomer(wsManager), middlewares.Audit(wsManager, models.AuditErase, models.AuditCustomer, nil))
+	admin.GET("/privacy", api.GetPrivacyRequests())
+	admin.GET("/customers/:id/points", api.GetCustomerPoints())
+	admin.POST("/customers/:id/points/adjust", api.AdjustCustomerPoints(), middlewares.Audit(wsManager, models.AuditAdjust, models.AuditLoyalty, middlewares.LoyaltySnapshot))
+	admin.GET("/loyalty/promotions", api.GetLoyaltyPromotions())
+	admin.POST("/loyalty/promotions", api.CreateLoyaltyPromotion(), middlewares.Audit(wsManager, models.AuditCreate, models.AuditLoyalty, nil))
+	admin.DELETE("/loyalty/promotions/:id", api.DeleteLoyaltyPromotion(), middlewares.Audit(wsManager, models.AuditDelete, models.AuditLoyalty, middlewares.LoyaltyPromotionSnapshot))
 	admin.GET("/finances", api.GetFinances())
 	admin.GET("/finances/stats", api.GetFinancesStats())
 	admin.GET("/finances/orders", api.GetOrdersData())
 	admin.GET("/finances/monetary", api.GetMonetaryData())
+	admin.GET("/finances/tips", api.GetTipsData())
 	admin.GET("/finances/payments", api.GetPaymentData())
 	admin.GET("/finances/status", api.GetOrdersStatusPie())
 	admin.GET("/finances/methods", api.GetOrdersPaymentPie())
 	admin.GET("/finances/standings", api.GetOrdersStandings())
+	admin.GET("/finances/disputes", api.GetDisputes())
+	admin.GET("/finances/payouts", api.GetPayouts())
+	admin.GET("/finances/reconciliation", api.GetReconciliation())
+	admin.GET("/exports", api.GetExportDatasets())
+	admin.GET("/exports/:dataset", api.Export())
+	admin.GET("/journal", api.GetJournal())
+	admin.GET("/journal/accounts", api.GetLedgerAccounts())
+	admin.PUT("/journal/accounts/:id", api.UpdateLedgerAccount(), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditAccount, middlewares.LedgerAccountSnapshot))
+	admin.GET("/journal/periods", api.GetClosedPeriods())
+	admin.POST("/journal/periods", api.ClosePeriods(), middlewares.Audit(wsManager, models.AuditCreate, models.AuditPeriod, nil))
+	admin.DELETE("/journal/periods/:id", api.ReopenPeriod(), middlewares.Audit(wsManager, models.AuditDelete, models.AuditPeriod, middlewares.ClosedPeriodSnapshot))
+	admin.GET("/delivery/zones", api.GetDeliveryZones())
+	admin.GET("/delivery/route", api.GetDeliveryRoute())
+	admin.POST("/delivery/zones", api.CreateDeliveryZone(), middlewares.Audit(wsManager, models.AuditCreate, models.AuditDelivery, nil))
+	admin.PUT("/delivery/zones/:id", api.UpdateDeliveryZone(), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditDelivery, middlewares.DeliveryZoneSnapshot))
+	admin.DELETE("/delivery/zones/:id", api.DeleteDeliveryZone(), middlewares.Audit(wsManager, models.AuditDelete, models.AuditDelivery, middlewares.DeliveryZoneSnapshot))
+	admin.GET("/giftcards", api.GetGiftCards())
+	admin.GET("/giftcards/:id", api.GetGiftCard())
+	admin.POST("/giftcards/:id/void", api.VoidGiftCard(), middlewares.Audit(wsManager, models.AuditVoid, models.AuditGiftCard, middlewares.GiftCardSnapshot))
+	admin.POST("/giftcards/:id/adjust", api.AdjustGiftCard(), middlewares.Audit(wsManager, models.AuditAdjust, models.AuditGiftCard, middlewares.GiftCardSnapshot))
 	admin.GET("/orders", api.Orders())
 	admin.GET("/orders/:id", api.Order())
-	admin.GET("/fulfill/:id", api.FulfillOrder())
+	admin.GET("/fulfill/:id", api.FulfillOrder(), middlewares.Audit(wsManager, models.AuditFulfill, models.AuditOrder, middlewares.OrderSnapshot))
+	admin.POST("/orders/:id/cash", api.CollectCash(wsManager), middlewares.Audit(wsManager, models.AuditCollect, models.AuditOrder, middlewares.OrderSnapshot))
+	admin.POST("/cash/counts", api.RecordCashCount(), middlewares.Audit(wsManager, models.AuditCreate, models.AuditCash, nil))
+	admin.GET("/cash/closing", api.GetCashClosing())
+	admin.GET("/cash/closing/pdf", api.GetCashClosingPDF())
+	admin.GET("/orders/:id/receipt", api.GetOrderReceipt())
+	admin.POST("/orders/:id/print", api.PrintOrder(), middlewares.Audit(wsManager, models.AuditPrint, models.AuditOrder, nil))
+	admin.POST("/orders/:id/refund", api.RefundOrder(wsManager), middlewares.Audit(wsManager, models.AuditRefund, models.AuditOrder, middlewares.OrderSnapshot))
+	admin.POST("/orders/:id/cancel", api.CancelOrder(wsManager), middlewares.Audit(wsManager, models.AuditCancel, models.AuditOrder, middlewares.OrderSnapshot))
+	admin.GET("/pickup/:id", api.ScanPickup())
+	admin.POST("/pickup/:id", api.HandOverPickup(wsManager), middlewares.Audit(wsManager, models.AuditPickup, models.AuditOrder, middlewares.PickupSnapshot))
+	admin.GET("/printer", api.GetPrinterStatus())
+	admin.POST("/printer/jobs/:id/retry", api.RetryPrintJob(), middlewares.Audit(wsManager, models.AuditPrint, models.AuditPrinter, nil))
+	admin.GET("/pos/products", api.SearchPosProducts())
+	admin.GET("/pos/ticket", api.GetPosTicket(ctx))
+	admin.POST("/pos/ticket", api.AddToPosTicket(ctx), middlewares.Audit(wsManager, models.AuditCreate, models.AuditTicket, nil))
+	admin.DELETE("/pos/ticket/:id", api.RemoveFromPosTicket(ctx), middlewares.Audit(wsManager, models.AuditDelete, models.AuditTicket, nil))
+	admin.DELETE("/pos/ticket", api.ClearPosTicket(ctx), middlewares.Audit(wsManager, models.AuditDelete, models.AuditTicket, nil))
+	admin.POST("/pos/checkout", api.PosCheckout(ctx, wsManager), middlewares.Audit(wsManager, models.AuditCreate, models.AuditOrder, nil))
 	// admin.POST("orders", api.IssueOrder(ctx))
-	admin.DELETE("orders/:id", api.DeleteOrder())
+	admin.DELETE("orders/:id", api.DeleteOrder(), middlewares.Audit(wsManager, models.AuditDelete, models.AuditOrder, middlewares.OrderSnapshot))
 	admin.GET("/products", api.Products())
 	admin.GET("/products/:id", api.Product())
-	admin.POST("/products", api.AddProduct(wsManager))
-	admin.PUT("/products/:id", api.UpdateProduct(wsManager))
-	admin.DELETE("/products/:id", api.DeleteProduct(wsManager))
+	admin.POST("/products", api.AddProduct(wsManager), middlewares.Audit(wsManager, models.AuditCreate, models.AuditProduct, nil))
+	admin.PUT("/products/:id", api.UpdateProduct(wsManager), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditProduct, middlewares.ProductSnapshot))
+	admin.DELETE("/products/:id", api.DeleteProduct(wsManager), middlewares.Audit(wsManager, models.AuditDelete, models.AuditProduct, middlewares.ProductSnapshot))
 	admin.GET("/roles", api.Roles())
 	admin.GET("/users", api.Users())
 	admin.GET("/users/:id", api.User())
-	admin.DELETE("/users/:id", api.DeleteUser())
+	admin.DELETE("/users/:id", api.DeleteUser(), middlewares.Audit(wsManager, models.AuditDelete, models.AuditUser, middlewares.UserSnapshot))
 	admin.GET("/setting/:name", api.GetSetting(ctx))
-	admin.PUT("/setting", api.SetSetting(ctx, wsManager))
+	admin.PUT("/setting", api.SetSetting(ctx, wsManager), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditSetting, middlewares.SettingsSnapshot(ctx)))
 	admin.GET("/message", api.GetMessage(ctx))
-	admin.PUT("/message", api.SetMessage(ctx, wsManager))
+	admin.PUT("/message", api.SetMessage(ctx, wsManager), middlewares.Audit(wsManager, models.AuditUpdate, models.AuditMessage, middlewares.SettingsSnapshot(ctx)))
+	admin.GET("/audit", api.GetAudits())
 
 	e.HTTPErrorHandler = serverErrorHandler
 
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func GetAudits() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter := models.AuditFilter{
+			Actor:    c.QueryParam("actor"),
+			Action:   c.QueryParam("action"),
+			Entity:   c.QueryParam("entity"),
+			EntityId: c.QueryParam("entity_id"),
+		}
+
+		if from := c.QueryParam("from"); from != "" {
+			date, err := time.Parse("2006-01-02", from)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing from date: %v", err), Errors: []string{err.Error()}})
+			}
+			filter.From = date
+		}
+
+		if to := c.QueryParam("to"); to != "" {
+			date, err := time.Parse("2006-01-02", to)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing to date: %v", err), Errors: []string{err.Error()}})
+			}
+			filter.To = date.AddDate(0, 0, 1)
+		}
+
+		if limit := c.QueryParam("limit"); limit != "" {
+			parsedLimit, err := strconv.Atoi(limit)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing limit: %v", err), Errors: []string{err.Error()}})
+			}
+			filter.Limit = parsedLimit
+		}
+
+		if offset := c.QueryParam("offset"); offset != "" {
+			parsedOffset, err := strconv.Atoi(offset)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing offset: %v", err), Errors: []string{err.Error()}})
+			}
+			filter.Offset = parsedOffset
+		}
+
+		audits, err := models.GetAudits(filter)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching audits: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, audits)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+)
+
+type CashCollectionPayload struct {
+	Tendered int `json:"tendered"`
+}
+
+// CollectCash hands over a pay-at-pickup order, recording the cash tendered and the change given
+func CollectCash(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload CashCollectionPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for cash collection: %v", err), Errors: []string{err.Error()}})
+		}
+
+		order, err := models.GetOrder(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching order while collecting cash: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		collection, err := order.CollectCash(userId, payload.Tendered)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error collecting cash: %v", err), Errors: []string{err.Error()}})
+		}
+
+		creditReferral(order.Id)
+
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+		return c.JSON(http.StatusCreated, collection)
+	}
+}
+
+type CashCountPayload struct {
+	Day     string `json:"day"`
+	Counted int    `json:"counted"`
+	Notes   string `json:"notes"`
+}
+
+// RecordCashCount stores the drawer count of the logged in staff member, for today unless a day is given
+func RecordCashCount() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload CashCountPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for cash count: %v", err), Errors: []string{err.Error()}})
+		}
+
+		loc, err := tools.ExportLocation("")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error loading timezone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		day := time.Now().In(loc)
+		if payload.Day != "" {
+			day, err = time.ParseInLocation("2006-01-02", payload.Day, loc)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing day: %v", err), Errors: []string{err.Error()}})
+			}
+		}
+
+		if payload.Counted < 0 {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing cash count: counted cannot be negative", Errors: []string{"counted cannot be negative"}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		count, err := models.RecordCashCount(day, userId, payload.Counted, payload.Notes)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error recording cash count: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusCreated, count)
+	}
+}
+
+func GetCashClosing() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := cashClosingReport(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error computing cash closing: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+func GetCashClosingPDF() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := cashClosingReport(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error computing cash closing: %v", err), Errors: []string{err.Error()}})
+		}
+
+		document, err := tools.GenerateCashClosingReport(report)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error generating cash closing document: %v", err), Errors: []string{err.Error()}})
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", "cash-closing-"+report.Day+".pdf"))
+
+		return c.Blob(http.StatusOK, "application/pdf", document)
+	}
+}
+
+func cashClosingReport(c echo.Context) (*models.CashClosingReport, error) {
+	loc, err := tools.ExportLocation("")
+	if err != nil {
+		return nil, err
+	}
+
+	day := time.Now().In(loc)
+	if param := c.QueryParam("day"); param != "" {
+		day, err = time.ParseInLocation("2006-01-02", param, loc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return models.GetCashClosingReport(day, loc)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/Francesco99975/rosskery/internal/models"
 	"github.com/labstack/echo/v4"
@@ -38,9 +39,15 @@ func GetCustomerStats() echo.HandlerFunc {
 	}
 }
 
+// Customers lists the customers, only those carrying every ?tag when given (repeated or comma separated)
 func Customers() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		customers, err := models.GetCustomers()
+		var tags []string
+		for _, param := range c.QueryParams()["tag"] {
+			tags = append(tags, strings.Split(param, ",")...)
+		}
+
+		customers, err := models.GetTaggedCustomers(tags)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error fetching customers: %v", err), Errors: []string{err.Error()}})
 		}
@@ -52,7 +59,7 @@ func Customers() echo.HandlerFunc {
 func Customer() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		id := c.Param("id")
-		customer, err := models.GetCustomer(id)
+		customer, err := models.GetCustomerProfile(id)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error fetching customer: %v", err), Errors: []string{err.Error()}})
 		}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetDuplicateCustomers lists the suggested merges waiting for review, likeliest first
+func GetDuplicateCustomers() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		suggestions, err := models.GetDuplicateSuggestions()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching duplicate customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, suggestions)
+	}
+}
+
+// DetectDuplicateCustomers runs the detection now instead of waiting for the daily job
+func DetectDuplicateCustomers() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, err := models.DetectDuplicateCustomers(); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error detecting duplicate customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		suggestions, err := models.GetDuplicateSuggestions()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching duplicate customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, suggestions)
+	}
+}
+
+type MergeCustomersPayload struct {
+	Survivor string `json:"survivor"` // Which of the two customers keeps its record
+}
+
+func MergeDuplicateCustomers(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload MergeCustomersPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for merge: %v", err), Errors: []string{err.Error()}})
+		}
+
+		duplicate, err := models.GetCustomerDuplicate(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching duplicate customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if duplicate.Status != string(models.DUPLICATE_PENDING) {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error merging customers: the suggestion was already reviewed", Errors: []string{"the suggestion was already reviewed"}})
+		}
+
+		var merged string
+		switch payload.Survivor {
+		case duplicate.CustomerA:
+			merged = duplicate.CustomerB
+		case duplicate.CustomerB:
+			merged = duplicate.CustomerA
+		default:
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error merging customers: the survivor must be one of the pair", Errors: []string{"the survivor must be one of the pair"}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		merge, err := models.MergeCustomers(payload.Survivor, merged, userId)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error merging customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+		return c.JSON(http.StatusOK, merge)
+	}
+}
+
+func DismissDuplicateCustomers() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		duplicate, err := models.GetCustomerDuplicate(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching duplicate customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		if err := duplicate.Dismiss(userId); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error dismissing duplicate customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func GetCustomerMerges() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		merges, err := models.GetCustomerMerges()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching customer merges: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, merges)
+	}
+}
+
+func UndoCustomerMerge(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		merge, err := models.UndoMerge(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error undoing customer merge: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+		return c.JSON(http.StatusOK, merge)
+	}
+}
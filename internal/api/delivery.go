@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func GetDeliveryZones() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		zones, err := models.GetDeliveryZones()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching delivery zones: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, zones)
+	}
+}
+
+func CreateDeliveryZone() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload models.DeliveryZoneDto
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := payload.Validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error validating delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		zone, err := models.CreateDeliveryZone(payload)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error creating delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusCreated, zone)
+	}
+}
+
+func UpdateDeliveryZone() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		zone, err := models.GetDeliveryZone(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		var payload models.DeliveryZoneDto
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := payload.Validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error validating delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		updated, err := zone.Update(payload)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error updating delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, updated)
+	}
+}
+
+func DeleteDeliveryZone() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		zone, err := models.GetDeliveryZone(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := zone.Delete(); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error deleting delivery zone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
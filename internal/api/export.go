@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+type ExportDatasetInfo struct {
+	Dataset models.ExportDataset  `json:"dataset"`
+	Columns []models.ExportColumn `json:"columns"`
+}
+
+func GetExportDatasets() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		datasets := make([]ExportDatasetInfo, 0, len(models.ExportDatasets))
+
+		for _, dataset := range models.ExportDatasets {
+			columns, err := models.GetExportColumns(dataset)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching export columns: %v", err), Errors: []string{err.Error()}})
+			}
+			datasets = append(datasets, ExportDatasetInfo{Dataset: dataset, Columns: columns})
+		}
+
+		return c.JSON(http.StatusOK, datasets)
+	}
+}
+
+// Export streams a dataset between the from and to dates (inclusive, in the tz timezone)
+func Export() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		dataset, err := models.ParseExportDataset(c.Param("dataset"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing dataset: %v", err), Errors: []string{err.Error()}})
+		}
+
+		format, err := tools.ParseExportFormat(c.QueryParam("format"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing format: %v", err), Errors: []string{err.Error()}})
+		}
+
+		loc, err := tools.ExportLocation(c.QueryParam("tz"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing timezone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		var names []string
+		if columns := c.QueryParam("columns"); columns != "" {
+			names = strings.Split(columns, ",")
+		}
+
+		columns, err := models.ResolveExportColumns(dataset, names)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing columns: %v", err), Errors: []string{err.Error()}})
+		}
+
+		from, to, err := parseDateRange(c, loc)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date range: %v", err), Errors: []string{err.Error()}})
+		}
+
+		filename := fmt.Sprintf("%s-%s-%s.%s", dataset, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), format)
+
+		c.Response().Header().Set(echo.HeaderContentType, format.ContentType())
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+		c.Response().WriteHeader(http.StatusOK)
+
+		if err := tools.WriteExport(c.Response(), format, dataset, columns, from, to, loc); err != nil {
+			// Headers are already sent, the truncated body is all the client will get
+			log.Errorf("Error streaming %s export <- %v", dataset, err)
+		}
+
+		return nil
+	}
+}
+
+// parseDateRange reads the from and to query dates in loc. The range covers whole days,
+// to included, and defaults to the current month.
+func parseDateRange(c echo.Context, loc *time.Location) (time.Time, time.Time, error) {
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 1, 0)
+
+	if param := c.QueryParam("from"); param != "" {
+		date, err := time.ParseInLocation("2006-01-02", param, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date: %v", err)
+		}
+		from = date
+	}
+
+	if param := c.QueryParam("to"); param != "" {
+		date, err := time.ParseInLocation("2006-01-02", param, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date: %v", err)
+		}
+		to = date.AddDate(0, 0, 1)
+	}
+
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not precede from")
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// issueGiftCards creates a card for every gift card bought in the order and emails it to the recipient.
+// The order is already paid, failures are logged for the admins to reissue.
+func issueGiftCards(order *models.Order, recipient string) {
+	for _, purchase := range order.Purchases {
+		if !purchase.Product.IsGiftCard() {
+			continue
+		}
+
+		card, err := models.IssueGiftCard(models.GIFT_CARD, purchase.Product.Price*purchase.Quantity, recipient, order.Id, "", "")
+		if err != nil {
+			log.Errorf("Error issuing gift card for order %s <- %v", order.Id, err)
+			continue
+		}
+
+		if err := tools.SendGiftCard(card, order.Customer.Fullname); err != nil {
+			log.Errorf("Error sending gift card %s <- %v", card.Id, err)
+		}
+	}
+}
+
+// GetGiftCards lists the gift cards and store credit, q filters by code, recipient or order
+func GetGiftCards() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cards, err := models.GetGiftCards(c.QueryParam("q"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching gift cards: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, cards)
+	}
+}
+
+// GetGiftCard returns a card with every change of its balance
+func GetGiftCard() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		card, err := models.GetGiftCard(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		ledger, err := card.GetLedger()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching gift card ledger: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, models.GiftCardDetails{GiftCard: *card, Ledger: ledger})
+	}
+}
+
+type GiftCardPayload struct {
+	Amount int    `json:"amount"` // In cents, negative to take balance off
+	Note   string `json:"note"`
+}
+
+func VoidGiftCard() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload GiftCardPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		card, err := models.GetGiftCard(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		voided, err := card.Void(userId, payload.Note)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error voiding gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, voided)
+	}
+}
+
+func AdjustGiftCard() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload GiftCardPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if payload.Note == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing gift card adjustment: a note is required", Errors: []string{"a note is required"}})
+		}
+
+		card, err := models.GetGiftCard(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		adjusted, err := card.Adjust(payload.Amount, userId, payload.Note)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error adjusting gift card: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, adjusted)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+)
+
+type JournalResponse struct {
+	Settlements []models.Settlement   `json:"settlements"`
+	Entries     []models.JournalEntry `json:"entries"`
+}
+
+// GetJournal returns the daily settlements and their journal entries as JSON,
+// or as a QuickBooks IIF / Xero CSV file when format is iif or xero
+func GetJournal() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		loc, err := tools.ExportLocation("")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error loading timezone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		from, to, err := parseDateRange(c, loc)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date range: %v", err), Errors: []string{err.Error()}})
+		}
+
+		settlements, err := models.GetJournalSettlements(from, to, loc)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching settlements: %v", err), Errors: []string{err.Error()}})
+		}
+
+		entries, err := models.BuildJournal(settlements)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error building journal: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if param := c.QueryParam("format"); param != "" && param != "json" {
+			format, err := tools.ParseJournalFormat(param)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing format: %v", err), Errors: []string{err.Error()}})
+			}
+
+			var buf bytes.Buffer
+			if err := tools.WriteJournal(&buf, format, entries); err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error writing journal: %v", err), Errors: []string{err.Error()}})
+			}
+
+			filename := fmt.Sprintf("journal-%s-%s.%s", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), format.Extension())
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+			return c.Blob(http.StatusOK, format.ContentType(), buf.Bytes())
+		}
+
+		return c.JSON(http.StatusOK, JournalResponse{Settlements: settlements, Entries: entries})
+	}
+}
+
+func GetLedgerAccounts() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		accounts, err := models.GetLedgerAccounts()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching ledger accounts: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, accounts)
+	}
+}
+
+type LedgerAccountPayload struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+func UpdateLedgerAccount() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload LedgerAccountPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for ledger account: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if payload.Code == "" || payload.Name == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing ledger account: code and name are required", Errors: []string{"code and name are required"}})
+		}
+
+		account, err := models.GetLedgerAccount(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching ledger account: %v", err), Errors: []string{err.Error()}})
+		}
+
+		updated, err := account.Update(payload.Code, payload.Name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error updating ledger account: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, updated)
+	}
+}
+
+func GetClosedPeriods() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		loc, err := tools.ExportLocation("")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error loading timezone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		from, to, err := parseDateRange(c, loc)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date range: %v", err), Errors: []string{err.Error()}})
+		}
+
+		periods, err := models.GetClosedPeriods(from, to)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching closed periods: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, periods)
+	}
+}
+
+// ClosePeriods locks every still open day between from and to
+func ClosePeriods() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		loc, err := tools.ExportLocation("")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error loading timezone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if c.QueryParam("from") == "" || c.QueryParam("to") == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing date range: from and to are required", Errors: []string{"from and to are required"}})
+		}
+
+		from, to, err := parseDateRange(c, loc)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date range: %v", err), Errors: []string{err.Error()}})
+		}
+
+		existing, err := models.GetClosedPeriods(from, to)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching closed periods: %v", err), Errors: []string{err.Error()}})
+		}
+
+		closed := make(map[string]bool, len(existing))
+		for _, period := range existing {
+			closed[period.Day.Format("2006-01-02")] = true
+		}
+
+		actor, _ := c.Get("userid").(string)
+		days := make([]time.Time, 0)
+
+		for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+			if !closed[day.Format("2006-01-02")] {
+				days = append(days, day)
+			}
+		}
+
+		periods, err := models.ClosePeriods(days, loc, actor)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error closing periods: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusCreated, periods)
+	}
+}
+
+func ReopenPeriod() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		day, err := time.Parse("2006-01-02", c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing day: %v", err), Errors: []string{err.Error()}})
+		}
+
+		period, err := models.GetClosedPeriod(day)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching closed period: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := period.Reopen(); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error reopening period: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, period)
+	}
+}
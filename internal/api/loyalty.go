@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetCustomerPoints returns the customer's points balance with every change made to it
+func GetCustomerPoints() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		customer, err := models.GetCustomer(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching customer: %v", err), Errors: []string{err.Error()}})
+		}
+
+		account, err := models.GetLoyaltyAccount(customer.Email)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching loyalty points: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, account)
+	}
+}
+
+type LoyaltyAdjustmentPayload struct {
+	Points int    `json:"points"` // Negative to take points off
+	Note   string `json:"note"`
+}
+
+func AdjustCustomerPoints() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload LoyaltyAdjustmentPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for loyalty adjustment: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if payload.Note == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing loyalty adjustment: a note is required", Errors: []string{"a note is required"}})
+		}
+
+		customer, err := models.GetCustomer(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching customer: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if customer.Id == models.AnonymousCustomerId || customer.Email == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error adjusting loyalty points: the customer has no email", Errors: []string{"the customer has no email"}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		account, err := models.AdjustLoyaltyPoints(customer.Email, payload.Points, userId, payload.Note)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error adjusting loyalty points: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, account)
+	}
+}
+
+func GetLoyaltyPromotions() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		promotions, err := models.GetLoyaltyPromotions()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching loyalty promotions: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, promotions)
+	}
+}
+
+type LoyaltyPromotionPayload struct {
+	ProductId  string    `json:"product_id"`
+	Multiplier int       `json:"multiplier"` // 2 earns double points on the product
+	Starts     time.Time `json:"starts"`
+	Ends       time.Time `json:"ends"`
+}
+
+func CreateLoyaltyPromotion() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload LoyaltyPromotionPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for loyalty promotion: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if payload.Multiplier < 2 {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing loyalty promotion: multiplier must be at least 2", Errors: []string{"multiplier must be at least 2"}})
+		}
+
+		if !payload.Ends.After(payload.Starts) {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing loyalty promotion: ends must be after starts", Errors: []string{"ends must be after starts"}})
+		}
+
+		product, err := models.GetProduct(payload.ProductId)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching product: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if product.IsGiftCard() {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error creating loyalty promotion: gift cards do not earn points", Errors: []string{"gift cards do not earn points"}})
+		}
+
+		promotion, err := models.CreateLoyaltyPromotion(product.Id, payload.Multiplier, payload.Starts, payload.Ends)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error creating loyalty promotion: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusCreated, promotion)
+	}
+}
+
+func DeleteLoyaltyPromotion() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		promotion, err := models.GetLoyaltyPromotion(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching loyalty promotion: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := promotion.Delete(); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error deleting loyalty promotion: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
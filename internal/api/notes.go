@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CustomerNotePayload struct {
+	Body string `json:"body"`
+}
+
+type CustomerTagsPayload struct {
+	Tags []string `json:"tags"`
+}
+
+type TagsResponse struct {
+	Tags      []models.TagCount `json:"tags"`
+	Suggested []string          `json:"suggested"`
+}
+
+func GetCustomerTags() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tags, err := models.GetTags()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching tags: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, TagsResponse{Tags: tags, Suggested: models.SuggestedTags})
+	}
+}
+
+func SetCustomerTags(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload CustomerTagsPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for tags: %v", err), Errors: []string{err.Error()}})
+		}
+
+		customer, err := models.GetDbCustomer(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error fetching customer while tagging: %v", err), Errors: []string{err.Error()}})
+		}
+
+		tags, err := models.SetCustomerTags(customer.Id, payload.Tags)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error tagging customer: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
+
+		return c.JSON(http.StatusOK, tags)
+	}
+}
+
+func AddCustomerNote() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload CustomerNotePayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		customer, err := models.GetDbCustomer(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error fetching customer while adding note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		note, err := models.AddCustomerNote(customer.Id, payload.Body, userId)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error adding note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusCreated, note)
+	}
+}
+
+func EditCustomerNote() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload CustomerNotePayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		note, err := models.GetCustomerNote(c.Param("id"), c.Param("note"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		note, err = note.Edit(payload.Body, userId)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error editing note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, note)
+	}
+}
+
+func DeleteCustomerNote() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		note, err := models.GetCustomerNote(c.Param("id"), c.Param("note"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		if err := note.Delete(userId); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error deleting note: %v", err), Errors: []string{err.Error()}})
+		}
+
+		notes, err := models.GetCustomerNotes(note.CustomerId)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching notes: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, notes)
+	}
+}
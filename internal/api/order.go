@@ -7,11 +7,14 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Francesco99975/rosskery/internal/helpers"
 	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/payments"
+	"github.com/Francesco99975/rosskery/internal/storage"
 	"github.com/Francesco99975/rosskery/internal/tools"
 	"github.com/Francesco99975/rosskery/views"
 	"github.com/Francesco99975/rosskery/views/components"
@@ -19,7 +22,6 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/webhook"
 )
 
 func GetOrdersData() echo.HandlerFunc {
@@ -27,11 +29,12 @@ func GetOrdersData() echo.HandlerFunc {
 		timeframeStr := c.QueryParam("timeframe")
 		methodStr := c.QueryParam("method")
 		status := c.QueryParam("status") == "true"
+		channel := models.ParseChannel(c.QueryParam("channel"))
 
 		timeframe := models.ParseTimeframe(timeframeStr)
 		method := models.ParsePaymentMethod(methodStr)
 
-		ordersData, err := models.GetOrdersData(timeframe, method, status)
+		ordersData, err := models.GetOrdersData(timeframe, method, status, channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching orders data: %v", err), Errors: []string{err.Error()}})
 		}
@@ -45,11 +48,12 @@ func GetMonetaryData() echo.HandlerFunc {
 		timeframeStr := c.QueryParam("timeframe")
 		methodStr := c.QueryParam("method")
 		status := c.QueryParam("status") == "true"
+		channel := models.ParseChannel(c.QueryParam("channel"))
 
 		timeframe := models.ParseTimeframe(timeframeStr)
 		method := models.ParsePaymentMethod(methodStr)
 
-		monetaryData, err := models.GetMonetaryData(timeframe, method, status)
+		monetaryData, err := models.GetMonetaryData(timeframe, method, status, channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching monetary data: %v", err), Errors: []string{err.Error()}})
 		}
@@ -58,14 +62,32 @@ func GetMonetaryData() echo.HandlerFunc {
 	}
 }
 
+func GetTipsData() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		timeframeStr := c.QueryParam("timeframe")
+		status := c.QueryParam("status") == "true"
+		channel := models.ParseChannel(c.QueryParam("channel"))
+
+		timeframe := models.ParseTimeframe(timeframeStr)
+
+		tipsData, err := models.GetTipsData(timeframe, status, channel)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching tips data: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, models.Graph{Data: tipsData})
+	}
+}
+
 func GetPaymentData() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		timeframeStr := c.QueryParam("timeframe")
 		status := c.QueryParam("status") == "true"
+		channel := models.ParseChannel(c.QueryParam("channel"))
 
 		timeframe := models.ParseTimeframe(timeframeStr)
 
-		preferredMethodData, err := models.GetPreferredMethodData(timeframe, status)
+		preferredMethodData, err := models.GetPreferredMethodData(timeframe, status, channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching preferred method data: %v", err), Errors: []string{err.Error()}})
 		}
@@ -80,123 +102,143 @@ func GetFinances() echo.HandlerFunc {
 		timeframeStr := c.QueryParam("timeframe")
 		methodStr := c.QueryParam("method")
 		status := c.QueryParam("status") == "true"
+		channel := models.ParseChannel(c.QueryParam("channel"))
 
 		timeframe := models.ParseTimeframe(timeframeStr)
 		method := models.ParsePaymentMethod(methodStr)
 
-		numberOfOrders, err := models.GetOrdersAmount()
+		numberOfOrders, err := models.GetOrdersAmount(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching orders amount: %v", err), Errors: []string{err.Error()}})
 		}
 
-		outstanding, err := models.GetOutstandingCash()
+		outstanding, err := models.GetOutstandingCash(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching outstanding cash: %v", err), Errors: []string{err.Error()}})
 		}
 
-		pending, err := models.GetPendingMoney()
+		pending, err := models.GetPendingMoney(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching pending money: %v", err), Errors: []string{err.Error()}})
 		}
 
-		gains, err := models.GetGains()
+		gains, err := models.GetGains(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching gains: %v", err), Errors: []string{err.Error()}})
 		}
 
-		total, err := models.GetTotalFromOrders()
+		total, err := models.GetTotalFromOrders(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching total from orders: %v", err), Errors: []string{err.Error()}})
 		}
 
-		ordersData, err := models.GetOrdersData(timeframe, method, status)
+		tips, err := models.GetTips(channel)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching tips: %v", err), Errors: []string{err.Error()}})
+		}
+
+		ordersData, err := models.GetOrdersData(timeframe, method, status, channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching orders data: %v", err), Errors: []string{err.Error()}})
 		}
 
-		monetaryData, err := models.GetMonetaryData(timeframe, method, status)
+		monetaryData, err := models.GetMonetaryData(timeframe, method, status, channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching monetary data: %v", err), Errors: []string{err.Error()}})
 		}
 
-		preferredMethodData, err := models.GetPreferredMethodData(timeframe, status)
+		tipsData, err := models.GetTipsData(timeframe, status, channel)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching tips data: %v", err), Errors: []string{err.Error()}})
+		}
+
+		preferredMethodData, err := models.GetPreferredMethodData(timeframe, status, channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching preferred method data: %v", err), Errors: []string{err.Error()}})
 		}
 
-		filledPie, err := models.GetFilledPie()
+		filledPie, err := models.GetFilledPie(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching filled pie: %v", err), Errors: []string{err.Error()}})
 		}
 
-		paymentMethodPie, err := models.GetMethodsPie()
+		paymentMethodPie, err := models.GetMethodsPie(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching payment method pie: %v", err), Errors: []string{err.Error()}})
 		}
 
-		topOrders, err := models.GetTopOrders()
+		topOrders, err := models.GetTopOrders(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching top orders: %v", err), Errors: []string{err.Error()}})
 		}
 
-		topSellers, err := models.GetTopSellers()
+		topSellers, err := models.GetTopSellers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching top sellers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		flopSellers, err := models.GetFlopSellers()
+		flopSellers, err := models.GetFlopSellers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching flop sellers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		topGainers, err := models.GetTopGainers()
+		topGainers, err := models.GetTopGainers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching top gainers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		flopGainers, err := models.GetFlopGainers()
+		flopGainers, err := models.GetFlopGainers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching flop gainers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		return c.JSON(http.StatusOK, models.FinancesResponse{OrdersAmount: numberOfOrders, OutstandingCash: outstanding, PendingMoney: pending, Gains: gains, Total: total, OrdersData: ordersData, MonetaryData: monetaryData, PreferredMethodData: preferredMethodData, FilledPie: filledPie, MethodPie: paymentMethodPie, RankedOrders: topOrders, ToppedSellers: topSellers, FloppedSellers: flopSellers, ToppedGainers: topGainers, FloppedGainers: flopGainers})
+		return c.JSON(http.StatusOK, models.FinancesResponse{OrdersAmount: numberOfOrders, OutstandingCash: outstanding, PendingMoney: pending, Gains: gains, Total: total, Tips: tips, OrdersData: ordersData, MonetaryData: monetaryData, TipsData: tipsData, PreferredMethodData: preferredMethodData, FilledPie: filledPie, MethodPie: paymentMethodPie, RankedOrders: topOrders, ToppedSellers: topSellers, FloppedSellers: flopSellers, ToppedGainers: topGainers, FloppedGainers: flopGainers})
 	}
 }
 
 func GetFinancesStats() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		numberOfOrders, err := models.GetOrdersAmount()
+		channel := models.ParseChannel(c.QueryParam("channel"))
+
+		numberOfOrders, err := models.GetOrdersAmount(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching orders amount: %v", err), Errors: []string{err.Error()}})
 		}
 
-		outstanding, err := models.GetOutstandingCash()
+		outstanding, err := models.GetOutstandingCash(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching outstanding cash: %v", err), Errors: []string{err.Error()}})
 		}
 
-		pending, err := models.GetPendingMoney()
+		pending, err := models.GetPendingMoney(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching pending money: %v", err), Errors: []string{err.Error()}})
 		}
 
-		gains, err := models.GetGains()
+		gains, err := models.GetGains(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching gains: %v", err), Errors: []string{err.Error()}})
 		}
 
-		total, err := models.GetTotalFromOrders()
+		total, err := models.GetTotalFromOrders(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching total from orders: %v", err), Errors: []string{err.Error()}})
 		}
 
-		return c.JSON(http.StatusOK, models.FinancesStats{OrdersAmount: numberOfOrders, OutstandingCash: outstanding, PendingMoney: pending, Gains: gains, Total: total})
+		tips, err := models.GetTips(channel)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching tips: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, models.FinancesStats{OrdersAmount: numberOfOrders, OutstandingCash: outstanding, PendingMoney: pending, Gains: gains, Total: total, Tips: tips})
 	}
 }
 
 func GetOrdersStatusPie() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		filledPie, err := models.GetFilledPie()
+		channel := models.ParseChannel(c.QueryParam("channel"))
+
+		filledPie, err := models.GetFilledPie(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching filled pie: %v", err), Errors: []string{err.Error()}})
 		}
@@ -207,7 +249,9 @@ func GetOrdersStatusPie() echo.HandlerFunc {
 
 func GetOrdersPaymentPie() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		paymentMethodPie, err := models.GetMethodsPie()
+		channel := models.ParseChannel(c.QueryParam("channel"))
+
+		paymentMethodPie, err := models.GetMethodsPie(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching payment method pie: %v", err), Errors: []string{err.Error()}})
 		}
@@ -218,27 +262,29 @@ func GetOrdersPaymentPie() echo.HandlerFunc {
 
 func GetOrdersStandings() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		topOrders, err := models.GetTopOrders()
+		channel := models.ParseChannel(c.QueryParam("channel"))
+
+		topOrders, err := models.GetTopOrders(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching top orders: %v", err), Errors: []string{err.Error()}})
 		}
 
-		topSellers, err := models.GetTopSellers()
+		topSellers, err := models.GetTopSellers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching top sellers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		flopSellers, err := models.GetFlopSellers()
+		flopSellers, err := models.GetFlopSellers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching flop sellers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		topGainers, err := models.GetTopGainers()
+		topGainers, err := models.GetTopGainers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching top gainers: %v", err), Errors: []string{err.Error()}})
 		}
 
-		flopGainers, err := models.GetFlopGainers()
+		flopGainers, err := models.GetFlopGainers(channel)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching flop gainers: %v", err), Errors: []string{err.Error()}})
 		}
@@ -268,28 +314,117 @@ func NewOrderManager() *OrderManager {
 
 var om = NewOrderManager()
 
+// Cache keeps the session's checkout until its payment goes through. The holds of a checkout it replaces
+// are given back unless the new one carries them over.
 func (o *OrderManager) Cache(id string, payload models.OrderDto) {
 	o.lock.Lock()
 	defer o.lock.Unlock()
 
+	if previous, ok := o.cachedOrders[id]; ok {
+		stale := models.OrderDto{}
+		if previous.GiftCardHold != payload.GiftCardHold {
+			stale.GiftCardHold = previous.GiftCardHold
+		}
+		if previous.PointsHold != payload.PointsHold {
+			stale.PointsHold = previous.PointsHold
+		}
+		releaseHolds(stale)
+	}
+
 	o.cachedOrders[id] = payload
 	o.realtedCreationDate[id] = time.Now()
 }
 
-func (o *OrderManager) Confirm(ctx context.Context, id string, cm *models.ConnectionManager) error {
+// Confirm places the session's order once its payment went through
+func (o *OrderManager) Confirm(ctx context.Context, id string, cm *models.ConnectionManager, payment *models.OrderPayment) (*models.Order, error) {
 	o.lock.Lock()
 	defer o.lock.Unlock()
 
-	payload := o.cachedOrders[id]
-	if err := processOrder(ctx, payload, id, cm); err != nil {
-		return err
+	payload, ok := o.cachedOrders[id]
+	if !ok {
+		return nil, fmt.Errorf("no pending order for session %s", id)
+	}
+
+	order, err := processOrder(ctx, payload, id, cm, payment)
+	if err != nil {
+		return nil, err
 	}
 
 	delete(o.cachedOrders, id)
 	delete(o.realtedCreationDate, id)
+	return order, nil
+}
+
+// Get returns the checkout waiting on a payment for the session
+func (o *OrderManager) Get(id string) (models.OrderDto, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	payload, ok := o.cachedOrders[id]
+	return payload, ok
+}
+
+// SetTip records the tip picked on the pay page on the session's checkout
+func (o *OrderManager) SetTip(id string, tip int) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	payload, ok := o.cachedOrders[id]
+	if !ok {
+		return fmt.Errorf("no pending order for session %s", id)
+	}
+
+	payload.Tip = tip
+	o.cachedOrders[id] = payload
+	return nil
+}
+
+// SetGiftCardHold records the gift card amount held for the session's checkout, giving back the previous hold
+func (o *OrderManager) SetGiftCardHold(id string, entryId int) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	payload, ok := o.cachedOrders[id]
+	if !ok {
+		return fmt.Errorf("no pending order for session %s", id)
+	}
+
+	if payload.GiftCardHold != 0 && payload.GiftCardHold != entryId {
+		if err := models.ReleaseGiftCard(payload.GiftCardHold); err != nil {
+			return err
+		}
+	}
+
+	payload.GiftCardHold = entryId
+	o.cachedOrders[id] = payload
 	return nil
 }
 
+// Discard drops the order waiting on a payment that will not happen
+func (o *OrderManager) Discard(id string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	releaseHolds(o.cachedOrders[id])
+	delete(o.cachedOrders, id)
+	delete(o.realtedCreationDate, id)
+}
+
+// releaseHolds gives back the gift card amount and loyalty points held for a checkout that did not go through
+func releaseHolds(payload models.OrderDto) {
+	if payload.GiftCardHold != 0 {
+		if err := models.ReleaseGiftCard(payload.GiftCardHold); err != nil {
+			log.Errorf("Error releasing gift card hold %d <- %v", payload.GiftCardHold, err)
+		}
+	}
+
+	if payload.PointsHold != 0 {
+		if err := models.ReleaseLoyaltyPoints(payload.PointsHold); err != nil {
+			log.Errorf("Error releasing loyalty points hold %d <- %v", payload.PointsHold, err)
+		}
+	}
+}
+
 func (o *OrderManager) AutoClean() error {
 	log.Infof("Cleaning dangling orders")
 	o.lock.Lock()
@@ -297,6 +432,7 @@ func (o *OrderManager) AutoClean() error {
 
 	for id, creationDate := range o.realtedCreationDate {
 		if time.Since(creationDate) > 10*time.Minute {
+			releaseHolds(o.cachedOrders[id])
 			delete(o.cachedOrders, id)
 			delete(o.realtedCreationDate, id)
 		}
@@ -307,64 +443,142 @@ func (o *OrderManager) AutoClean() error {
 	return nil
 }
 
-func processOrder(ctx context.Context, payload models.OrderDto, sessionID string, cm *models.ConnectionManager) error {
+// processOrder places the checkout's order, linked to the payment that settled it when paid online.
+// Once the order is created it is never reported as failed, a retry would only create it again.
+func processOrder(ctx context.Context, payload models.OrderDto, sessionID string, cm *models.ConnectionManager, payment *models.OrderPayment) (*models.Order, error) {
 	var err error
 
 	err = payload.Validate()
 	if err != nil {
-		return fmt.Errorf("Error validating order: %v", err)
+		return nil, fmt.Errorf("Error validating order: %v", err)
 	}
 
 	var customer *models.DbCustomer
 	exists, err := models.CustomerExists(payload.Email)
 	if err != nil {
-		return fmt.Errorf("Error checking if customer exists: %v", err)
+		return nil, fmt.Errorf("Error checking if customer exists: %v", err)
 	}
 
 	if !exists {
 		customer, err = models.CreateCustomer(payload.Fullname, payload.Email, payload.Address, payload.Phone)
 		if err != nil {
-			return fmt.Errorf("Error creating customer: %v", err)
+			return nil, fmt.Errorf("Error creating customer: %v", err)
 		}
 
 	} else {
 		customer, err = models.GetCustomerByEmail(payload.Email)
 		if err != nil {
-			return fmt.Errorf("Error fetching customer: %v", err)
+			return nil, fmt.Errorf("Error fetching customer: %v", err)
 		}
 
 		err := customer.Update(payload.Fullname, payload.Email, payload.Address, payload.Phone)
 		if err != nil {
-			return fmt.Errorf("Error updating customer: %v", err)
+			return nil, fmt.Errorf("Error updating customer: %v", err)
 		}
 	}
 
-	cart, err := models.GetCart(ctx, sessionID)
+	if err := models.RecordCustomerIp(customer.Id, payload.Ip); err != nil {
+		log.Errorf("Error recording address of customer %s <- %v", customer.Id, err)
+	}
+
+	if payload.AccountId != "" {
+		// A verified account ordering with its own email takes over the customer row
+		if account, err := models.GetAccount(payload.AccountId); err == nil && account.Verified && account.CustomerId == nil {
+			if _, err := account.LinkCustomer(customer.Id); err != nil {
+				log.Errorf("Error linking account %s to customer %s <- %v", account.Id, customer.Id, err)
+			}
+		}
+	}
+
+	var address *models.CustomerAddress
+	if payload.Location != nil {
+		if address, err = models.SaveCustomerAddress(customer.Id, *payload.Location); err != nil {
+			log.Errorf("Error saving address of customer %s <- %v", customer.Id, err)
+		}
+	}
+
+	cart, err := models.GetCart(ctx, models.CartId(sessionID, payload.AccountId))
 	if err != nil {
-		return fmt.Errorf("Error fetching cart: %v", err)
+		return nil, fmt.Errorf("Error fetching cart: %v", err)
 	}
 
 	purchases, err := cart.Purchases()
 	if err != nil {
-		return fmt.Errorf("Error fetching purchases: %v", err)
+		return nil, fmt.Errorf("Error fetching purchases: %v", err)
 	}
 
-	order, err := models.CreateOrder(customer.Id, payload.Pickuptime, purchases, payload.Method)
+	var steps []models.OrderStep = make([]models.OrderStep, 0)
+	if payload.IdempotencyKey != "" {
+		steps = append(steps, models.CompleteIdempotencyKey(payload.IdempotencyKey))
+	}
+	if payment != nil {
+		steps = append(steps, models.LinkPayment(*payment))
+	}
+	// The order is only created with the delivery fee, referral, points and gift card the customer was
+	// charged for, so its totals match the charge
+	if payload.Fulfilment == models.DELIVERY {
+		steps = append(steps, models.SetDelivery(payload.DeliveryZone, payload.DeliveryFee, payload.WindowStart, payload.WindowEnd))
+	}
+	if payload.ReferralCode != "" {
+		steps = append(steps, models.ApplyReferral(payload.ReferralCode, payload.ReferralDiscount))
+	}
+	if payload.PointsHold != 0 {
+		steps = append(steps, models.ApplyLoyaltyPoints(payload.PointsHold))
+	}
+	if payload.GiftCardHold != 0 {
+		steps = append(steps, models.ApplyGiftCard(payload.GiftCardHold))
+	}
+
+	order, err := models.CreateOrder(customer.Id, payload.Pickuptime, purchases, payload.Method, models.ONLINE, strings.TrimSpace(payload.Notes), payload.Tip, steps...)
 	if err != nil {
-		return fmt.Errorf("Error creating order: %v", err)
+		return nil, fmt.Errorf("Error creating order: %v", err)
 	}
 
+	if address != nil {
+		if located, err := order.SetAddress(address.Id); err != nil {
+			log.Errorf("Error setting address of order %s <- %v", order.Id, err)
+		} else {
+			order = located
+		}
+	}
+
+	recipient := payload.GiftRecipient
+	if recipient == "" {
+		recipient = order.Customer.Email
+	}
+	issueGiftCards(order, recipient)
+
 	if err = cart.Clear(ctx); err != nil {
-		return fmt.Errorf("Error clearing cart: %v", err)
+		log.Errorf("Error clearing cart of order %s <- %v", order.Id, err)
+	}
+
+	if err = sendOrderReceipt(order); err != nil {
+		log.Errorf("Error sending receipt of order %s <- %v", order.Id, err)
 	}
 
+	if autoprint, err := storage.Valkey.Get(ctx, string(storage.AutoPrint)).Bool(); err == nil && autoprint {
+		if _, err := tools.PrinterQueue.PrintOrder(order, tools.RECEIPT_TICKET, tools.KITCHEN_TICKET); err != nil {
+			log.Errorf("Error queueing tickets for order %s <- %v", order.Id, err)
+		}
+	}
+
+	// tools.GotifyQueue.AddNotification(tools.Notification{Title: "New Order Arrived!", Message: fmt.Sprintf("New order from: %s", order.Customer.Fullname), Priority: 5, Sent: false})
+
+	cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
+
+	return order, nil
+}
+
+// sendOrderReceipt emails the customer a receipt for the order with its invoice attached
+func sendOrderReceipt(order *models.Order) error {
 	total := helpers.FormatPrice(float64(helpers.FoldSlice[models.Purchase, func(models.Purchase, int) int, int](order.Purchases, func(prev models.Purchase, cur int) int {
 		if prev.Product.Weighed {
 			return prev.Product.Price*prev.Quantity/10 + cur
 		} else {
 			return prev.Product.Price*prev.Quantity + cur
 		}
-	}, 0)) / 100.0)
+	}, order.Tip+order.DeliveryFee-order.GiftCard-order.Discount)) / 100.0)
 
 	invoice, err := tools.GenerateInvoice(order)
 	if err != nil {
@@ -372,8 +586,13 @@ func processOrder(ctx context.Context, payload models.OrderDto, sessionID string
 	}
 
 	payStatus := "Pay at Pickup"
+	if order.IsDelivery() {
+		payStatus = "Pay on Delivery"
+	}
 	if models.ParsePaymentMethod(order.Method) != models.CASH {
 		payStatus = "No payment is due"
+	} else if models.Channel(order.Channel) == models.WALKIN {
+		payStatus = "Paid in store"
 	}
 
 	purchaseDetails := helpers.MapSlice[models.Purchase, tools.ReceiptDetail](order.Purchases, func(p models.Purchase) tools.ReceiptDetail {
@@ -386,15 +605,42 @@ func processOrder(ctx context.Context, payload models.OrderDto, sessionID string
 		return tools.ReceiptDetail{Description: fmt.Sprintf("%s - (x%d)", p.Product.Name, p.Quantity), Amount: amount}
 	})
 
-	err = tools.SendReceipt(order.Customer.Email, tools.Receipt{ProductURL: "rosskery.com", ProductName: "Rosskery", Customer: order.Customer.Fullname, PaymentStatus: payStatus, CreditCardStatementName: "Rosskery", OrderID: order.Id, Date: order.Created.Format("2006-01-02 03:04 PM"), PickupDate: order.Pickuptime.Format("2006-01-02 03:04 PM"), ReceiptDetails: purchaseDetails, Total: fmt.Sprint(total), SupportURL: "", CompanyName: "Rosskey", CompanyAddress: "robarra@rosskery.com"}, invoice)
+	if order.IsDelivery() {
+		purchaseDetails = append(purchaseDetails, tools.ReceiptDetail{Description: "Delivery", Amount: helpers.FormatPrice(float64(order.DeliveryFee) / 100.0)})
+	}
+
+	if order.Tip > 0 {
+		purchaseDetails = append(purchaseDetails, tools.ReceiptDetail{Description: "Tip - thank you!", Amount: helpers.FormatPrice(float64(order.Tip) / 100.0)})
+	}
+
+	if order.Discount > 0 {
+		purchaseDetails = append(purchaseDetails, tools.ReceiptDetail{Description: "Discount", Amount: "-" + helpers.FormatPrice(float64(order.Discount)/100.0)})
+	}
+
+	if order.GiftCard > 0 {
+		purchaseDetails = append(purchaseDetails, tools.ReceiptDetail{Description: "Gift card", Amount: "-" + helpers.FormatPrice(float64(order.GiftCard)/100.0)})
+	}
+
+	if order.HasLoyalty() {
+		if balance, err := models.GetLoyaltyBalance(order.Customer.Email); err == nil {
+			purchaseDetails = append(purchaseDetails, tools.ReceiptDetail{Description: "Loyalty points balance", Amount: fmt.Sprintf("%d pts", balance)})
+		}
+
+		purchaseDetails = append(purchaseDetails, tools.ReceiptDetail{Description: "Your referral code, friends get money off their first order", Amount: order.Customer.Referral})
+	}
+
+	receipt := tools.Receipt{ProductURL: "rosskery.com", ProductName: "Rosskery", Customer: order.Customer.Fullname, PaymentStatus: payStatus, CreditCardStatementName: "Rosskery", OrderID: order.Id, Date: order.Created.Format("2006-01-02 03:04 PM"), PickupDate: order.Pickuptime.Format("2006-01-02 03:04 PM"), ReceiptDetails: purchaseDetails, Total: fmt.Sprint(total), SupportURL: "", CompanyName: "Rosskey", CompanyAddress: "robarra@rosskery.com"}
+
+	if order.IsDelivery() {
+		receipt.DeliveryAddress = order.DeliveryAddress()
+		receipt.DeliveryWindow = order.DeliveryWindow()
+	}
+
+	err = tools.SendReceipt(order.Customer.Email, receipt, invoice)
 	if err != nil {
 		return fmt.Errorf("Error sending receipt: %v", err)
 	}
 
-	// tools.GotifyQueue.AddNotification(tools.Notification{Title: "New Order Arrived!", Message: fmt.Sprintf("New order from: %s", order.Customer.Fullname), Priority: 5, Sent: false})
-	cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
-	cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
-
 	return nil
 }
 
@@ -408,34 +654,86 @@ func PaymentWebhook(ctx context.Context, cm *models.ConnectionManager) echo.Hand
 			return echo.NewHTTPError(http.StatusBadRequest, "Error reading body")
 		}
 
-		event := stripe.Event{}
-		if err := json.Unmarshal(payload, &event); err != nil {
-			log.Errorf("Error parsing request body: %v", err)
-			return echo.NewHTTPError(http.StatusBadRequest, "Error parsing request body")
+		provider, err := payments.Get(models.STRIPE)
+		if err != nil {
+			log.Errorf("Error getting stripe provider: %v", err)
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "Stripe payments are not available")
 		}
 
-		signatureHeader := c.Request().Header.Get("Stripe-Signature")
-		endpointSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
-
-		event, err = webhook.ConstructEvent(payload, signatureHeader, endpointSecret)
+		event, err := provider.VerifyWebhook(c.Request().Context(), payload, c.Request().Header)
 		if err != nil {
 			log.Errorf("Error constructing event: %v", err)
 			return echo.NewHTTPError(http.StatusBadRequest, "Error constructing event")
 		}
 
+		claimed, err := models.ClaimWebhookEvent(event.Id, event.Type)
+		if err != nil {
+			log.Errorf("Error registering webhook event: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error registering event")
+		}
+
+		if !claimed {
+			log.Infof("Webhook event %s already processed", event.Id)
+			return c.NoContent(http.StatusOK)
+		}
+
 		switch event.Type {
 		case "payment_intent.succeeded":
 			var paymentIntent stripe.PaymentIntent
-			err := json.Unmarshal(event.Data.Raw, &paymentIntent)
+			err := json.Unmarshal(event.Data, &paymentIntent)
 			if err != nil {
 				log.Errorf("Error parsing payment intent: %v", err)
+				releaseWebhookEvent(event.Id)
 				return echo.NewHTTPError(http.StatusBadRequest, "Error parsing payment intent")
 			}
 
+			if _, err := models.GetOrderByPayment(paymentIntent.ID, ""); err == nil {
+				log.Infof("Order for payment intent %s already created", paymentIntent.ID)
+				return c.NoContent(http.StatusOK)
+			}
+
+			var chargeId string
+			if paymentIntent.LatestCharge != nil {
+				chargeId = paymentIntent.LatestCharge.ID
+			}
+
+			// The event does not carry the fee, it is read from the charge's balance transaction.
+			// An order is never held back for it, a missing fee shows up in the reconciliation.
+			var fee int
+			if settled, err := provider.ConfirmPayment(c.Request().Context(), paymentIntent.ID); err != nil {
+				log.Errorf("Error fetching fee of payment intent %s <- %v", paymentIntent.ID, err)
+			} else {
+				fee = settled.Fee
+			}
+
+			// A payment no order can be placed for is refunded and the event kept, so Stripe stops retrying it
+			payment := &payments.Payment{Id: paymentIntent.ID, ChargeId: chargeId, Amount: int(paymentIntent.AmountReceived)}
+			refundUnplaced := func(reason string) error {
+				log.Errorf("Refunding payment intent %s <- %s", paymentIntent.ID, reason)
+				refundCapture(c.Request().Context(), provider, payment, reason)
+				notifyPayment(cm, models.PaymentNotice{Event: "payment_refunded", Amount: payment.Amount, Message: fmt.Sprintf("Payment %s refunded: %s", paymentIntent.ID, reason)})
+				return c.NoContent(http.StatusOK)
+			}
+
 			sessionID := paymentIntent.Metadata["sessionID"]
-			if err := om.Confirm(ctx, sessionID, cm); err != nil {
+			payload, ok := om.Get(sessionID)
+			if !ok {
+				return refundUnplaced("Checkout expired before the payment went through")
+			}
+
+			due, err := amountDue(ctx, sessionID, payload)
+			if err != nil {
+				log.Errorf("Error computing amount due of session %s <- %v", sessionID, err)
+				return refundUnplaced("Order could not be placed")
+			}
+
+			if payment.Amount != due {
+				return refundUnplaced(fmt.Sprintf("Amount paid %d does not match the %d due", payment.Amount, due))
+			}
+
+			if _, err := om.Confirm(ctx, sessionID, cm, &models.OrderPayment{IntentId: paymentIntent.ID, ChargeId: chargeId, Paid: payment.Amount, Fee: fee}); err != nil {
 				log.Errorf("Error confirming order: %v", err)
-				return echo.NewHTTPError(http.StatusBadRequest, "Error confirming order")
+				return refundUnplaced("Order could not be placed")
 			}
 
 			data := models.GetDefaultSite("Order Confirmed", ctx)
@@ -448,10 +746,35 @@ func PaymentWebhook(ctx context.Context, cm *models.ConnectionManager) echo.Hand
 			}
 
 			return c.Blob(200, "text/html; charset=utf-8", html)
+		case "payment_intent.payment_failed":
+			err = handlePaymentFailed(cm, event.Data)
+		case "payment_intent.canceled":
+			err = handlePaymentCanceled(cm, event.Data)
+		case "charge.refunded":
+			err = handleChargeRefunded(cm, event.Data)
+		case "charge.dispute.created", "charge.dispute.closed":
+			err = handleDispute(cm, event.Data)
+		case "payout.paid":
+			err = handlePayoutPaid(cm, event.Data)
 		default:
-			return echo.NewHTTPError(http.StatusBadRequest, "Unhandled event type")
+			// Acknowledge everything else, an error here would only make Stripe retry it
+			log.Infof("Ignoring webhook event %s of type %s", event.Id, event.Type)
+			return c.NoContent(http.StatusOK)
+		}
+
+		if err != nil {
+			log.Errorf("Error handling webhook event %s of type %s <- %v", event.Id, event.Type, err)
+			releaseWebhookEvent(event.Id)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error handling event")
 		}
 
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func releaseWebhookEvent(id string) {
+	if err := models.ReleaseWebhookEvent(id); err != nil {
+		log.Errorf("Error releasing webhook event %s <- %v", id, err)
 	}
 }
 
@@ -472,12 +795,18 @@ func IssueOrder(ctx context.Context, cm *models.ConnectionManager) echo.HandlerF
 		}
 
 		payload := models.OrderDto{
-			Email:      c.FormValue("email"),
-			Fullname:   c.FormValue("fullname"),
-			Phone:      c.FormValue("phone"),
-			Address:    c.FormValue("address"),
-			Pickuptime: date,
-			Method:     models.ParsePaymentMethod(c.FormValue("method")),
+			Email:         c.FormValue("email"),
+			Fullname:      c.FormValue("fullname"),
+			Phone:         c.FormValue("phone"),
+			Address:       c.FormValue("address"),
+			Notes:         c.FormValue("notes"),
+			Pickuptime:    date,
+			Method:        models.ParsePaymentMethod(c.FormValue("method")),
+			Fulfilment:    models.ParseFulfilment(c.FormValue("fulfilment")),
+			GiftCard:      strings.TrimSpace(c.FormValue("giftcard")),
+			GiftRecipient: strings.TrimSpace(c.FormValue("gift_recipient")),
+			RedeemPoints:  c.FormValue("redeem_points") == "true",
+			ReferralCode:  models.NormalizeReferralCode(c.FormValue("referral")),
 		}
 
 		if err = payload.Validate(); err != nil {
@@ -505,9 +834,162 @@ func IssueOrder(ctx context.Context, cm *models.ConnectionManager) echo.HandlerF
 			return echo.NewHTTPError(http.StatusInternalServerError, "Could not create session")
 		}
 
-		if payload.Method == models.CASH {
-			if err := processOrder(ctx, payload, sessionID, cm); err != nil {
+		payload.AccountId, _ = sess.Values["accountID"].(string)
+		payload.Ip = c.RealIP()
+
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, payload.AccountId))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart")
+		}
+
+		preview, err := cart.Preview(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart preview")
+		}
+
+		giftCardBalance := 0
+		err = resolveDelivery(&payload, &preview)
+		if err == nil {
+			err = resolveReferral(&payload, &preview)
+		}
+		if err == nil {
+			err = resolvePoints(&payload, &preview)
+		}
+		if err == nil {
+			giftCardBalance, err = resolveGiftCard(&payload, &preview)
+		}
+		if err != nil {
+			log.Errorf("Error applying discounts: %v", err)
+			html, err := helpers.GeneratePage(components.Errors(fmt.Sprintf("Error: %v", err)))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+			}
+
+			return c.Blob(http.StatusBadRequest, "text/html; charset=utf-8", html)
+		}
+
+		// A referral link only prefills the first checkout
+		if _, ok := sess.Values["referral"]; ok {
+			delete(sess.Values, "referral")
+			if err := sess.Save(c.Request(), c.Response()); err != nil {
+				log.Errorf("Error saving session <- %v", err)
+			}
+		}
+
+		// Cash and orders covered by a gift card are placed right away, the rest waits on the payment
+		immediate := payload.Method == models.CASH || payload.Method == models.GIFTCARD
+
+		if !immediate {
+			if _, err := payments.Get(payload.Method); err != nil {
+				log.Errorf("Error selecting payment provider: %v", err)
+				html, err := helpers.GeneratePage(components.Errors(fmt.Sprintf("Error: %v", err)))
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+				}
+
+				return c.Blob(http.StatusBadRequest, "text/html; charset=utf-8", html)
+			}
+		}
+
+		payload.IdempotencyKey = checkoutKey(c, sessionID)
+
+		if payload.IdempotencyKey != "" {
+			claimed, orderId, err := models.ClaimIdempotencyKey(payload.IdempotencyKey)
+			if err != nil {
+				log.Errorf("Error claiming idempotency key <- %v", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Could not process order")
+			}
+
+			if !claimed && orderId != "" {
+				log.Infof("Replayed checkout for order %s", orderId)
+
+				data := models.GetDefaultSite("Order Confirmed", ctx)
+				nonce := c.Get("nonce").(string)
+
+				html, err := helpers.GeneratePage(views.Confirmation(data, nonce))
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+				}
+
+				return c.Blob(200, "text/html; charset=utf-8", html)
+			}
+
+			if !claimed {
+				// A double submit of an online checkout pays the checkout already waiting, without holding again
+				if pending, ok := om.Get(sessionID); ok && !immediate && pending.IdempotencyKey == payload.IdempotencyKey {
+					return renderPay(c, ctx, pending, preview.Total, giftCardBalance)
+				}
+
+				html, err := helpers.GeneratePage(components.Errors("Your order is already being processed"))
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+				}
+
+				return c.Blob(http.StatusConflict, "text/html; charset=utf-8", html)
+			}
+		}
+
+		if payload.PointsDiscount > 0 {
+			entry, err := models.HoldLoyaltyPoints(payload.Email, payload.PointsDiscount)
+			if err == nil && (entry == nil || -entry.Points*models.LoyaltyPointValue() < payload.PointsDiscount) {
+				if entry != nil {
+					releaseHolds(models.OrderDto{PointsHold: entry.Id})
+				}
+				err = fmt.Errorf("your points balance changed, please try again")
+			}
+			if err != nil {
+				log.Errorf("Error holding loyalty points <- %v", err)
+				if payload.IdempotencyKey != "" {
+					if err := models.ReleaseIdempotencyKey(payload.IdempotencyKey); err != nil {
+						log.Errorf("Error releasing idempotency key <- %v", err)
+					}
+				}
+				html, err := helpers.GeneratePage(components.Errors(fmt.Sprintf("Error: %v", err)))
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+				}
+
+				return c.Blob(http.StatusBadRequest, "text/html; charset=utf-8", html)
+			}
+
+			payload.PointsHold = entry.Id
+		}
+
+		if immediate {
+			if payload.Method == models.GIFTCARD {
+				due := payload.Due(preview.Total)
+				entry, err := models.HoldGiftCard(payload.GiftCard, due)
+				if err == nil && -entry.Amount < due {
+					releaseHolds(models.OrderDto{GiftCardHold: entry.Id})
+					err = fmt.Errorf("the gift card balance changed, please try again")
+				}
+				if err != nil {
+					log.Errorf("Error holding gift card <- %v", err)
+					releaseHolds(payload)
+					if payload.IdempotencyKey != "" {
+						if err := models.ReleaseIdempotencyKey(payload.IdempotencyKey); err != nil {
+							log.Errorf("Error releasing idempotency key <- %v", err)
+						}
+					}
+					html, err := helpers.GeneratePage(components.Errors(fmt.Sprintf("Error: %v", err)))
+					if err != nil {
+						return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+					}
+
+					return c.Blob(http.StatusBadRequest, "text/html; charset=utf-8", html)
+				}
+
+				payload.GiftCardHold = entry.Id
+			}
+
+			if _, err := processOrder(ctx, payload, sessionID, cm, nil); err != nil {
 				log.Errorf("Error processing order <- %v", err)
+				releaseHolds(payload)
+				if payload.IdempotencyKey != "" {
+					if err := models.ReleaseIdempotencyKey(payload.IdempotencyKey); err != nil {
+						log.Errorf("Error releasing idempotency key <- %v", err)
+					}
+				}
 				html, err := helpers.GeneratePage(components.Errors("Error processing order"))
 				if err != nil {
 					return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
@@ -531,18 +1013,164 @@ func IssueOrder(ctx context.Context, cm *models.ConnectionManager) echo.HandlerF
 
 		om.Cache(sessionID, payload)
 
-		data := models.GetDefaultSite("Pay Online", ctx)
+		return renderPay(c, ctx, payload, preview.Total, giftCardBalance)
+	}
+}
 
-		csrfToken := c.Get("csrf").(string)
-		nonce := c.Get("nonce").(string)
+// renderPay shows the page paying a checkout of subtotal cents online
+func renderPay(c echo.Context, ctx context.Context, payload models.OrderDto, subtotal int, giftCardBalance int) error {
+	data := models.GetDefaultSite("Pay Online", ctx)
 
-		html, err := helpers.GeneratePage(views.Pay(data, os.Getenv("STRIPE_PUBLISHABLE_KEY"), csrfToken, nonce))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
-		}
+	csrfToken := c.Get("csrf").(string)
+	nonce := c.Get("nonce").(string)
+
+	html, err := helpers.GeneratePage(views.Pay(data, payload.Method, subtotal, payload.DeliveryFee, payload.Discounts(), giftCardBalance, os.Getenv("STRIPE_PUBLISHABLE_KEY"), csrfToken, nonce))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+	}
+
+	return c.Blob(200, "text/html; charset=utf-8", html)
+}
+
+// resolveDelivery prices bringing the cart to the address checked out with. The time picked at
+// checkout has to fall in one of the delivery windows of the zone the address is in.
+func resolveDelivery(payload *models.OrderDto, preview *models.CartPreview) error {
+	payload.DeliveryZone = 0
+	payload.DeliveryFee = 0
+	payload.WindowStart = time.Time{}
+	payload.WindowEnd = time.Time{}
+
+	if payload.Fulfilment != models.DELIVERY {
+		return nil
+	}
+
+	quote, err := models.QuoteDelivery(payload.Location, preview.Total, payload.Pickuptime)
+	if err != nil {
+		return err
+	}
+
+	payload.DeliveryZone = quote.Zone.Id
+	payload.DeliveryFee = quote.Fee
+	payload.WindowStart = quote.WindowStart
+	payload.WindowEnd = quote.WindowEnd
+
+	return nil
+}
+
+// resolveReferral checks the referral code entered at checkout against the fraud guards and works out
+// the first order discount. Like points, it leaves online payments at least the minimum charge.
+func resolveReferral(payload *models.OrderDto, preview *models.CartPreview) error {
+	payload.ReferralDiscount = 0
+
+	if payload.ReferralCode == "" {
+		return nil
+	}
+
+	if preview.HasGiftCards() {
+		return fmt.Errorf("referral discounts cannot be used to buy gift cards")
+	}
 
-		return c.Blob(200, "text/html; charset=utf-8", html)
+	if _, err := models.CheckReferral(payload.ReferralCode, payload.Email, payload.Phone, payload.Address, ""); err != nil {
+		return err
 	}
+
+	limit := preview.Total
+	if payload.Method != models.CASH {
+		limit -= minimumCharge
+	}
+
+	payload.ReferralDiscount = max(min(models.ReferralDiscount(), limit), 0)
+
+	return nil
+}
+
+// resolvePoints works out what the customer's loyalty points take off the cart when they chose to redeem them.
+// Online payments keep at least the minimum charge, the points cannot buy gift cards.
+func resolvePoints(payload *models.OrderDto, preview *models.CartPreview) error {
+	payload.PointsDiscount = 0
+
+	if !payload.RedeemPoints {
+		return nil
+	}
+
+	if preview.HasGiftCards() {
+		return fmt.Errorf("loyalty points cannot be used to buy gift cards")
+	}
+
+	balance, err := models.GetLoyaltyBalance(payload.Email)
+	if err != nil {
+		return fmt.Errorf("could not read your points balance")
+	}
+
+	value := models.LoyaltyPointValue()
+
+	limit := preview.Total - payload.ReferralDiscount
+	if payload.Method != models.CASH {
+		limit -= minimumCharge
+	}
+
+	if value <= 0 || balance <= 0 || limit < value {
+		return nil
+	}
+
+	payload.PointsDiscount = min(balance, limit/value) * value
+
+	return nil
+}
+
+// resolveGiftCard checks the gift card entered at checkout and returns its balance. A card covering the whole
+// cart pays for the order by itself, otherwise it has to be combined with an online payment. Gift cards cannot buy gift cards.
+func resolveGiftCard(payload *models.OrderDto, preview *models.CartPreview) (int, error) {
+	giftCards := preview.HasGiftCards()
+
+	if giftCards && payload.Method == models.CASH {
+		return 0, fmt.Errorf("gift cards have to be paid online")
+	}
+
+	if payload.GiftCard == "" {
+		return 0, nil
+	}
+
+	if giftCards {
+		return 0, fmt.Errorf("gift cards cannot be bought with a gift card")
+	}
+
+	card, err := models.GetGiftCardByCode(payload.GiftCard)
+	if err != nil {
+		return 0, fmt.Errorf("gift card not found")
+	}
+
+	if card.Voided || card.Balance <= 0 {
+		return 0, fmt.Errorf("gift card %s has no balance left", card.Code)
+	}
+
+	payload.GiftCard = card.Code
+
+	if card.Balance >= payload.Due(preview.Total) {
+		payload.Method = models.GIFTCARD
+		return card.Balance, nil
+	}
+
+	if payload.Method == models.CASH {
+		return 0, fmt.Errorf("the gift card covers %s, pick an online payment for the rest", helpers.FormatPrice(float64(card.Balance)/100.0))
+	}
+
+	return card.Balance, nil
+}
+
+// checkoutKey identifies a checkout attempt from the Idempotency-Key header or,
+// failing that, the nonce rendered in the checkout form. Keys are scoped to the session.
+func checkoutKey(c echo.Context, sessionID string) string {
+	key := c.Request().Header.Get("Idempotency-Key")
+	if key == "" {
+		key = c.FormValue("idempotency_key")
+	}
+
+	if key == "" {
+		return ""
+	}
+
+	return sessionID + ":" + key
 }
 
 func FulfillOrder() echo.HandlerFunc {
@@ -557,6 +1185,9 @@ func FulfillOrder() echo.HandlerFunc {
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error fulfilling order: %v", err), Errors: []string{err.Error()}})
 		}
+
+		creditReferral(updatedOrder.Id)
+
 		return c.JSON(http.StatusOK, updatedOrder)
 
 	}
@@ -1,18 +1,29 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/Francesco99975/rosskery/internal/helpers"
 	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/payments"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/Francesco99975/rosskery/views"
+	"github.com/Francesco99975/rosskery/views/components"
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
-
-	"github.com/stripe/stripe-go/v78"
-	"github.com/stripe/stripe-go/v78/paymentintent"
+	"github.com/labstack/gommon/log"
 )
 
+// minimumCharge is the smallest amount Stripe accepts, in cents
+const minimumCharge = 50
+
+// CreatePaymentIntent starts the payment of the session's checkout with the provider the customer picked
 func CreatePaymentIntent(ctx context.Context) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		sess, err := session.Get("session", c)
@@ -31,7 +42,18 @@ func CreatePaymentIntent(ctx context.Context) echo.HandlerFunc {
 		if !ok || sessionID == "" {
 			return echo.NewHTTPError(http.StatusBadRequest, "Could not get session id")
 		}
-		cart, err := models.GetCart(ctx, sessionID)
+
+		payload, ok := om.Get(sessionID)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "No pending checkout")
+		}
+
+		provider, err := payments.Get(payload.Method)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, payload.AccountId))
 		if err != nil {
 			return err
 		}
@@ -40,29 +62,383 @@ func CreatePaymentIntent(ctx context.Context) echo.HandlerFunc {
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart preview")
 		}
-		amountToPay := preview.Total
-		if amountToPay <= 0 {
+		if preview.Total <= 0 {
 			return echo.NewHTTPError(http.StatusBadRequest, "No items in cart")
 		}
 
-		params := &stripe.PaymentIntentParams{
-			Amount:   stripe.Int64(int64(amountToPay)),
-			Currency: stripe.String(string(stripe.CurrencyCAD)),
-			AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-				Enabled: stripe.Bool(true),
-			},
-			Metadata: map[string]string{
-				"sessionID": sessionID,
-			},
+		var tipPayload models.TipDto
+		if err := c.Bind(&tipPayload); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Could not parse tip")
+		}
+
+		tip, err := tipPayload.Resolve(preview.Total)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if err := om.SetTip(sessionID, tip); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "No pending checkout")
 		}
 
-		pi, err := paymentintent.New(params)
+		amountToPay := payload.Due(preview.Total) + tip
+
+		// The previous hold is given back first so a changed tip never takes more than needed.
+		// At least the minimum charge is left to the provider.
+		if payload.GiftCard != "" && amountToPay > minimumCharge {
+			if err := om.SetGiftCardHold(sessionID, 0); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Could not release the gift card")
+			}
+
+			entry, err := models.HoldGiftCard(payload.GiftCard, amountToPay-minimumCharge)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+
+			if err := om.SetGiftCardHold(sessionID, entry.Id); err != nil {
+				releaseHolds(models.OrderDto{GiftCardHold: entry.Id})
+				return echo.NewHTTPError(http.StatusBadRequest, "No pending checkout")
+			}
+
+			amountToPay += entry.Amount
+		}
+
+		// Never built from the request, a forged Host header would send the customer elsewhere
+		origin := os.Getenv("HOST")
+
+		payment, err := provider.CreatePayment(c.Request().Context(), payments.PaymentRequest{
+			Amount:    amountToPay,
+			Currency:  "CAD",
+			Reference: sessionID,
+			ReturnURL: origin + "/payments/return",
+			CancelURL: origin + "/checkout",
+		})
 		if err != nil {
+			log.Errorf("Error creating %s payment <- %v", payload.Method, err)
 			return echo.NewHTTPError(http.StatusInternalServerError, "Error creating payment intent")
 		}
 
 		return c.JSON(http.StatusAccepted, struct {
-			ClientSecret string `json:"clientSecret"`
-		}{ClientSecret: pi.ClientSecret})
+			ClientSecret string `json:"clientSecret,omitempty"`
+			ApproveURL   string `json:"approveUrl,omitempty"`
+		}{ClientSecret: payment.ClientSecret, ApproveURL: payment.ApproveURL})
+	}
+}
+
+// ReturnPayment is where redirect providers send the customer back once they approved the payment.
+// The payment is captured and the order created right away.
+func ReturnPayment(ctx context.Context, cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.QueryParam("token")
+		if token == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing payment token")
+		}
+
+		if _, err := models.GetOrderByPayment(token, ""); err == nil {
+			return renderConfirmation(c, ctx)
+		}
+
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Server error on session")
+		}
+
+		sessionID, ok := sess.Values["sessionID"].(string)
+		if !ok || sessionID == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Could not get session id")
+		}
+
+		payload, ok := om.Get(sessionID)
+		if !ok {
+			return renderPaymentError(c, http.StatusGone, "Your checkout expired, please place your order again")
+		}
+
+		provider, err := payments.Get(payload.Method)
+		if err != nil {
+			return renderPaymentError(c, http.StatusBadRequest, err.Error())
+		}
+
+		payment, err := provider.ConfirmPayment(c.Request().Context(), token)
+		if err != nil {
+			log.Errorf("Error confirming %s payment %s <- %v", payload.Method, token, err)
+			return renderPaymentError(c, http.StatusBadGateway, "Your payment could not be confirmed")
+		}
+
+		if payment.Status != payments.SUCCEEDED {
+			return renderPaymentError(c, http.StatusPaymentRequired, "Your payment was not completed")
+		}
+
+		due, err := amountDue(ctx, sessionID, payload)
+		if err != nil {
+			log.Errorf("Error computing amount due of session %s <- %v", sessionID, err)
+			refundCapture(c.Request().Context(), provider, payment, "Order could not be placed")
+			return renderPaymentError(c, http.StatusInternalServerError, "Error processing order, your payment was refunded")
+		}
+
+		if payment.Amount != due {
+			log.Errorf("%s payment %s captured %d but %d is due", payload.Method, token, payment.Amount, due)
+			refundCapture(c.Request().Context(), provider, payment, "Amount paid does not match the order")
+			return renderPaymentError(c, http.StatusConflict, "Your cart changed during the payment, you were refunded, please place your order again")
+		}
+
+		if _, err := om.Confirm(ctx, sessionID, cm, &models.OrderPayment{IntentId: payment.Id, ChargeId: payment.ChargeId, Paid: payment.Amount, Fee: payment.Fee}); err != nil {
+			log.Errorf("Error confirming order: %v", err)
+			refundCapture(c.Request().Context(), provider, payment, "Order could not be placed")
+			return renderPaymentError(c, http.StatusInternalServerError, "Error processing order, your payment was refunded")
+		}
+
+		return renderConfirmation(c, ctx)
+	}
+}
+
+// amountDue is what the provider should have taken for the session's checkout: the cart with its tip,
+// less what the gift card hold already covers
+func amountDue(ctx context.Context, sessionID string, payload models.OrderDto) (int, error) {
+	cart, err := models.GetCart(ctx, models.CartId(sessionID, payload.AccountId))
+	if err != nil {
+		return 0, err
+	}
+
+	preview, err := cart.Preview(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	due := payload.Due(preview.Total) + payload.Tip
+
+	if payload.GiftCardHold != 0 {
+		entry, err := models.GetGiftCardEntry(payload.GiftCardHold)
+		if err != nil {
+			return 0, err
+		}
+		due += entry.Amount
+	}
+
+	return due, nil
+}
+
+// refundCapture gives back a captured payment that no order was created for
+func refundCapture(ctx context.Context, provider payments.Provider, payment *payments.Payment, reason string) {
+	if _, err := provider.Refund(ctx, payment, payment.Amount, reason); err != nil {
+		log.Errorf("Error refunding %s payment %s of %d <- %v", provider.Method(), payment.Id, payment.Amount, err)
+	}
+}
+
+func renderConfirmation(c echo.Context, ctx context.Context) error {
+	data := models.GetDefaultSite("Order Confirmed", ctx)
+	nonce := c.Get("nonce").(string)
+
+	html, err := helpers.GeneratePage(views.Confirmation(data, nonce))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+	}
+
+	return c.Blob(http.StatusOK, "text/html; charset=utf-8", html)
+}
+
+func renderPaymentError(c echo.Context, status int, message string) error {
+	html, err := helpers.GeneratePage(components.Errors(message))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+	}
+
+	return c.Blob(status, "text/html; charset=utf-8", html)
+}
+
+type RefundPayload struct {
+	Amount      int    `json:"amount"` // In cents, the whole remaining amount when 0
+	Reason      string `json:"reason"`
+	StoreCredit bool   `json:"store_credit"` // Give the amount back as store credit instead of money
+}
+
+// RefundOrder gives money back on an order paid online through the provider that took the payment,
+// or store credit on any paid order
+func RefundOrder(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload RefundPayload
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing refund: %v", err), Errors: []string{err.Error()}})
+		}
+
+		order, err := models.GetOrder(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if payload.StoreCredit {
+			return refundStoreCredit(c, cm, order, payload)
+		}
+
+		if order.PaymentIntent == "" {
+			err := fmt.Errorf("order %s was not paid online", order.Id)
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		credited, err := models.GetOrderStoreCredit(order.Id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching store credit: %v", err), Errors: []string{err.Error()}})
+		}
+
+		// Store credit already given back comes off the money that can still be refunded
+		remaining := max(order.Paid-order.Refunded-credited, 0)
+		if payload.Amount == 0 {
+			payload.Amount = remaining
+		}
+
+		if payload.Amount <= 0 || payload.Amount > remaining {
+			err := fmt.Errorf("refund of %d exceeds the %d left on the order", payload.Amount, remaining)
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		provider, err := payments.Get(models.PaymentMethod(order.Method))
+		if err != nil {
+			return c.JSON(http.StatusServiceUnavailable, models.JSONErrorResponse{Code: http.StatusServiceUnavailable, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		refund, err := provider.Refund(c.Request().Context(), &payments.Payment{Id: order.PaymentIntent, ChargeId: order.ChargeId, Amount: order.Paid}, payload.Amount, payload.Reason)
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, models.JSONErrorResponse{Code: http.StatusBadGateway, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		order, err = order.RecordRefunds(order.Refunded+refund.Amount, []models.Refund{{Id: refund.Id, Amount: refund.Amount, Reason: payload.Reason}})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error recording refund: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+		return c.JSON(http.StatusOK, order)
+	}
+}
+
+// refundStoreCredit issues store credit to the customer of the order, up to what they paid in total
+func refundStoreCredit(c echo.Context, cm *models.ConnectionManager, order *models.Order, payload RefundPayload) error {
+	if order.Cancelled {
+		err := fmt.Errorf("order %s was cancelled, what it was paid with was already given back", order.Id)
+		return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+	}
+
+	if !order.Fulfilled && order.Paid == 0 && order.GiftCard == 0 {
+		err := fmt.Errorf("order %s was not paid", order.Id)
+		return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+	}
+
+	if order.Customer.Id == models.AnonymousCustomerId || order.Customer.Email == "" {
+		err := fmt.Errorf("order %s has no customer email to send the credit to", order.Id)
+		return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+	}
+
+	total, err := models.GetOrderTotal(order.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error pricing order: %v", err), Errors: []string{err.Error()}})
+	}
+
+	credited, err := models.GetOrderStoreCredit(order.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching store credit: %v", err), Errors: []string{err.Error()}})
+	}
+
+	remaining := total + order.DeliveryFee + order.Tip - order.Refunded - credited
+	if payload.Amount == 0 {
+		payload.Amount = remaining
+	}
+
+	if payload.Amount <= 0 || payload.Amount > remaining {
+		err := fmt.Errorf("store credit of %d exceeds the %d left on the order", payload.Amount, remaining)
+		return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error refunding order: %v", err), Errors: []string{err.Error()}})
+	}
+
+	userId, _ := c.Get("userid").(string)
+
+	card, err := models.IssueGiftCard(models.STORE_CREDIT, payload.Amount, order.Customer.Email, order.Id, userId, payload.Reason)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error issuing store credit: %v", err), Errors: []string{err.Error()}})
+	}
+
+	if err := tools.SendGiftCard(card, ""); err != nil {
+		log.Errorf("Error sending store credit %s <- %v", card.Id, err)
+	}
+
+	cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+	return c.JSON(http.StatusOK, order)
+}
+
+func GetDisputes() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		disputes, err := models.GetDisputes()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching disputes: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, disputes)
+	}
+}
+
+// GetPayouts lists the Stripe payouts arriving between from and to
+func GetPayouts() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		from, to, err := parseDateRange(c, time.UTC)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date range: %v", err), Errors: []string{err.Error()}})
+		}
+
+		payouts, err := models.GetPayouts(from, to)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching payouts: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, payouts)
+	}
+}
+
+// GetReconciliation matches the Stripe activity between from and to (inclusive, in the tz timezone)
+// to the orders, as JSON or as a CSV file when format is csv
+func GetReconciliation() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		loc, err := tools.ExportLocation(c.QueryParam("tz"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing timezone: %v", err), Errors: []string{err.Error()}})
+		}
+
+		from, to, err := parseDateRange(c, loc)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date range: %v", err), Errors: []string{err.Error()}})
+		}
+
+		provider, err := payments.Get(models.STRIPE)
+		if err != nil {
+			return c.JSON(http.StatusServiceUnavailable, models.JSONErrorResponse{Code: http.StatusServiceUnavailable, Message: fmt.Sprintf("Error reconciling payments: %v", err), Errors: []string{err.Error()}})
+		}
+
+		stripeProvider, ok := provider.(*payments.Stripe)
+		if !ok {
+			err := fmt.Errorf("stripe payments are not handled by Stripe")
+			return c.JSON(http.StatusServiceUnavailable, models.JSONErrorResponse{Code: http.StatusServiceUnavailable, Message: fmt.Sprintf("Error reconciling payments: %v", err), Errors: []string{err.Error()}})
+		}
+
+		// Charges and orders straddling midnight land on different days, pull a day more on each side to match them
+		activity, err := stripeProvider.Activity(c.Request().Context(), from.AddDate(0, 0, -1), to.AddDate(0, 0, 1))
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, models.JSONErrorResponse{Code: http.StatusBadGateway, Message: fmt.Sprintf("Error fetching Stripe activity: %v", err), Errors: []string{err.Error()}})
+		}
+
+		report, err := models.Reconcile(from, to, *activity)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error reconciling payments: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if c.QueryParam("format") == "csv" {
+			var buf bytes.Buffer
+			if err := tools.WriteReconciliationCSV(&buf, report); err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error writing reconciliation: %v", err), Errors: []string{err.Error()}})
+			}
+
+			filename := fmt.Sprintf("reconciliation-%s-%s.csv", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+			return c.Blob(http.StatusOK, "text/csv", buf.Bytes())
+		}
+
+		return c.JSON(http.StatusOK, report)
 	}
 }
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/payments"
+	"github.com/labstack/echo/v4"
+)
+
+type PickupPayload struct {
+	Tendered int `json:"tendered"`
+}
+
+type PickupResponse struct {
+	Order      *models.Order          `json:"order"`
+	Collection *models.CashCollection `json:"collection"`
+}
+
+// ScanPickup looks up the order behind a scanned invoice or ticket code and what is left to pay on it
+func ScanPickup() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		summary, status, err := pickupSummary(c.Param("id"))
+		if err != nil {
+			return c.JSON(status, models.JSONErrorResponse{Code: status, Message: fmt.Sprintf("Error scanning pickup code: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, summary)
+	}
+}
+
+// HandOverPickup marks the scanned order as picked up, collecting the cash due in the same step
+func HandOverPickup(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload PickupPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for pickup: %v", err), Errors: []string{err.Error()}})
+		}
+
+		summary, status, err := pickupSummary(c.Param("id"))
+		if err != nil {
+			return c.JSON(status, models.JSONErrorResponse{Code: status, Message: fmt.Sprintf("Error scanning pickup code: %v", err), Errors: []string{err.Error()}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		collection, err := summary.Order.Pickup(userId, payload.Tendered)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, models.ErrOrderCancelled) || errors.Is(err, models.ErrOrderCollected) {
+				status = http.StatusConflict
+			}
+			return c.JSON(status, models.JSONErrorResponse{Code: status, Message: fmt.Sprintf("Error handing over order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		creditReferral(summary.Order.Id)
+
+		order, err := models.GetOrder(summary.Order.Id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+		return c.JSON(http.StatusOK, PickupResponse{Order: order, Collection: collection})
+	}
+}
+
+func CancelOrder(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		order, err := models.GetOrder(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching order while cancelling: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cancelled, err := order.Cancel()
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, models.ErrOrderCancelled) || errors.Is(err, models.ErrOrderCollected) {
+				status = http.StatusConflict
+			}
+			return c.JSON(status, models.JSONErrorResponse{Code: status, Message: fmt.Sprintf("Error cancelling order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+
+		// The order stays cancelled when the refund fails, the payment is then refunded by hand
+		if cancelled.PaymentIntent != "" {
+			credited, err := models.GetOrderStoreCredit(cancelled.Id)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Order cancelled, error fetching store credit: %v", err), Errors: []string{err.Error()}})
+			}
+
+			if remaining := cancelled.Paid - cancelled.Refunded - credited; remaining > 0 {
+				provider, err := payments.Get(models.PaymentMethod(cancelled.Method))
+				if err != nil {
+					return c.JSON(http.StatusServiceUnavailable, models.JSONErrorResponse{Code: http.StatusServiceUnavailable, Message: fmt.Sprintf("Order cancelled, error refunding it: %v", err), Errors: []string{err.Error()}})
+				}
+
+				refund, err := provider.Refund(c.Request().Context(), &payments.Payment{Id: cancelled.PaymentIntent, ChargeId: cancelled.ChargeId, Amount: cancelled.Paid}, remaining, "Order cancelled")
+				if err != nil {
+					return c.JSON(http.StatusBadGateway, models.JSONErrorResponse{Code: http.StatusBadGateway, Message: fmt.Sprintf("Order cancelled, error refunding it: %v", err), Errors: []string{err.Error()}})
+				}
+
+				cancelled, err = cancelled.RecordRefunds(cancelled.Refunded+refund.Amount, []models.Refund{{Id: refund.Id, Amount: refund.Amount, Reason: "Order cancelled"}})
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Order cancelled and refunded, error recording the refund: %v", err), Errors: []string{err.Error()}})
+				}
+			}
+		}
+
+		return c.JSON(http.StatusOK, cancelled)
+	}
+}
+
+// pickupSummary resolves a scanned code, refusing orders that cannot be handed over
+func pickupSummary(code string) (*models.PickupSummary, int, error) {
+	orderId, err := helpers.VerifyPickupCode(code)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	summary, err := models.GetPickupSummary(orderId)
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("no order matches the scanned code")
+	}
+
+	if err := summary.Order.CheckPickup(); err != nil {
+		return nil, http.StatusConflict, err
+	}
+
+	return summary, http.StatusOK, nil
+}
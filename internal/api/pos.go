@@ -0,0 +1,353 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+type PosReceipt string
+
+const (
+	NO_RECEIPT    PosReceipt = "none"
+	EMAIL_RECEIPT PosReceipt = "email"
+	PRINT_RECEIPT PosReceipt = "print"
+)
+
+type PosItemPayload struct {
+	ProductId string  `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	Lbs       float64 `json:"lbs"`
+}
+
+type PosCheckoutPayload struct {
+	Method   string     `json:"method"`
+	Tendered int        `json:"tendered"`
+	Fullname string     `json:"fullname"`
+	Email    string     `json:"email"`
+	Phone    string     `json:"phone"`
+	Notes    string     `json:"notes"`
+	Receipt  PosReceipt `json:"receipt"`
+}
+
+type PosCheckoutResponse struct {
+	Order      *models.Order          `json:"order"`
+	Collection *models.CashCollection `json:"collection"`
+	Receipt    string                 `json:"receipt"`
+}
+
+// posTicket returns the ticket being built at the counter by the logged in staff member
+func posTicket(ctx context.Context, c echo.Context) (*models.Cart, error) {
+	userId, _ := c.Get("userid").(string)
+
+	return models.GetCart(ctx, "pos:"+userId)
+}
+
+func SearchPosProducts() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		products, err := models.SearchProducts(c.QueryParam("q"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error searching products: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, products)
+	}
+}
+
+func GetPosTicket(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ticket, err := posTicket(ctx, c)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		preview, err := ticket.Preview(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error previewing ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, preview)
+	}
+}
+
+// AddToPosTicket adds a product to the ticket, weighed products are entered in lbs
+func AddToPosTicket(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload PosItemPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for ticket item: %v", err), Errors: []string{err.Error()}})
+		}
+
+		product, err := models.GetProduct(payload.ProductId)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching product for ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		quantity := payload.Quantity
+		if product.Weighed {
+			quantity = int(math.Round(payload.Lbs * 10))
+		}
+
+		if quantity <= 0 {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing ticket item: quantity must be greater than 0", Errors: []string{"quantity must be greater than 0"}})
+		}
+
+		ticket, err := posTicket(ctx, c)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := ticket.AddItem(ctx, product.Id, quantity); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error adding to ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		preview, err := ticket.Preview(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error previewing ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, preview)
+	}
+}
+
+func RemoveFromPosTicket(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ticket, err := posTicket(ctx, c)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := ticket.DeleteItem(ctx, c.Param("id")); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error removing from ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		preview, err := ticket.Preview(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error previewing ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, preview)
+	}
+}
+
+func ClearPosTicket(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ticket, err := posTicket(ctx, c)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if err := ticket.Clear(ctx); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error clearing ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// PosCheckout turns the ticket into a paid walk-in order. Without an email the sale
+// goes to the anonymous walk-in customer.
+func PosCheckout(ctx context.Context, cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload PosCheckoutPayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for counter sale: %v", err), Errors: []string{err.Error()}})
+		}
+
+		method := models.PaymentMethod(payload.Method)
+		if method != models.CASH && method != models.CARD {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing counter sale: method must be cash or card", Errors: []string{"method must be cash or card"}})
+		}
+
+		payload.Email = strings.TrimSpace(payload.Email)
+		if payload.Email != "" {
+			email, err := models.NormalizeEmail(payload.Email)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing counter sale: %v", err), Errors: []string{err.Error()}})
+			}
+
+			payload.Email = email
+		}
+
+		if payload.Receipt == "" {
+			payload.Receipt = NO_RECEIPT
+		}
+
+		switch payload.Receipt {
+		case NO_RECEIPT, PRINT_RECEIPT:
+		case EMAIL_RECEIPT:
+			if payload.Email == "" {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing counter sale: an email is required to email the receipt", Errors: []string{"an email is required to email the receipt"}})
+			}
+		default:
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing counter sale: receipt must be none, email or print", Errors: []string{"receipt must be none, email or print"}})
+		}
+
+		ticket, err := posTicket(ctx, c)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if ticket.Len() == 0 {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error checking out: the ticket is empty", Errors: []string{"the ticket is empty"}})
+		}
+
+		preview, err := ticket.Preview(ctx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error previewing ticket: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if preview.HasGiftCards() && payload.Email == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error checking out: an email is required to send the gift cards", Errors: []string{"an email is required to send the gift cards"}})
+		}
+
+		if method == models.CASH && payload.Tendered < preview.Total {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error checking out: tendered %d is less than the %d due", payload.Tendered, preview.Total), Errors: []string{"tendered is less than the amount due"}})
+		}
+
+		customerId, err := posCustomer(payload)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error saving walk-in customer: %v", err), Errors: []string{err.Error()}})
+		}
+
+		purchases, err := ticket.Purchases()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching purchases: %v", err), Errors: []string{err.Error()}})
+		}
+
+		order, err := models.CreateOrder(customerId, time.Now(), purchases, method, models.WALKIN, strings.TrimSpace(payload.Notes), 0)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error creating order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		response := PosCheckoutResponse{}
+
+		if method == models.CASH {
+			userId, _ := c.Get("userid").(string)
+
+			response.Collection, err = order.CollectCash(userId, payload.Tendered)
+			if err != nil {
+				if _, deleteErr := order.Delete(); deleteErr != nil {
+					log.Errorf("Error deleting unpaid walk-in order %s <- %v", order.Id, deleteErr)
+				}
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error collecting cash: %v", err), Errors: []string{err.Error()}})
+			}
+		} else {
+			if _, err = order.Fulfill(); err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fulfilling order: %v", err), Errors: []string{err.Error()}})
+			}
+		}
+
+		if err := ticket.Clear(ctx); err != nil {
+			log.Errorf("Error clearing ticket after order %s <- %v", order.Id, err)
+		}
+
+		response.Order, err = models.GetOrder(order.Id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching order: %v", err), Errors: []string{err.Error()}})
+		}
+
+		issueGiftCards(response.Order, payload.Email)
+
+		switch payload.Receipt {
+		case EMAIL_RECEIPT:
+			if err := sendOrderReceipt(response.Order); err != nil {
+				log.Errorf("Error emailing receipt for order %s <- %v", order.Id, err)
+			}
+		case PRINT_RECEIPT:
+			// Without a thermal printer the A4 invoice is printed from the browser instead
+			if tools.PrinterAddress() == "" {
+				response.Receipt = fmt.Sprintf("/admin/orders/%s/receipt", order.Id)
+			} else if _, err := tools.PrinterQueue.PrintOrder(response.Order, tools.RECEIPT_TICKET); err != nil {
+				log.Errorf("Error queueing receipt for order %s <- %v", order.Id, err)
+			}
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+		cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
+
+		return c.JSON(http.StatusCreated, response)
+	}
+}
+
+// posCustomer finds or creates the customer of a counter sale, falling back to the anonymous walk-in customer
+func posCustomer(payload PosCheckoutPayload) (string, error) {
+	if payload.Email == "" {
+		return models.AnonymousCustomerId, nil
+	}
+
+	fullname := strings.TrimSpace(payload.Fullname)
+	if fullname == "" {
+		fullname = "Walk-in Customer"
+	}
+
+	exists, err := models.CustomerExists(payload.Email)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		customer, err := models.CreateCustomer(fullname, payload.Email, "", payload.Phone)
+		if err != nil {
+			return "", err
+		}
+
+		return customer.Id, nil
+	}
+
+	customer, err := models.GetCustomerByEmail(payload.Email)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(payload.Fullname) == "" {
+		fullname = customer.Fullname
+	}
+
+	phone := customer.Phone
+	if payload.Phone != "" {
+		phone = payload.Phone
+	}
+
+	if err := customer.Update(fullname, customer.Email, customer.Address, phone); err != nil {
+		return "", err
+	}
+
+	return customer.Id, nil
+}
+
+// GetOrderReceipt returns the printable invoice of an order
+func GetOrderReceipt() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		order, err := models.GetOrder(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching order for receipt: %v", err), Errors: []string{err.Error()}})
+		}
+
+		filename, err := tools.GenerateInvoice(order)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error generating receipt: %v", err), Errors: []string{err.Error()}})
+		}
+		defer os.Remove(filename)
+
+		document, err := os.ReadFile(filename)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error reading receipt: %v", err), Errors: []string{err.Error()}})
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", "receipt-"+order.Id+".pdf"))
+
+		return c.Blob(http.StatusOK, "application/pdf", document)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+)
+
+func GetPrinterStatus() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, tools.PrinterQueue.Status())
+	}
+}
+
+// PrintOrder queues the receipt, the kitchen ticket or both (default) of an order on the counter printer
+func PrintOrder() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		kinds, err := tools.ParseTicketKinds(c.QueryParam("kind"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing ticket kind: %v", err), Errors: []string{err.Error()}})
+		}
+
+		order, err := models.GetOrder(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, models.JSONErrorResponse{Code: http.StatusNotFound, Message: fmt.Sprintf("Error fetching order for printing: %v", err), Errors: []string{err.Error()}})
+		}
+
+		jobs, err := tools.PrinterQueue.PrintOrder(order, kinds...)
+		if err != nil {
+			return c.JSON(http.StatusServiceUnavailable, models.JSONErrorResponse{Code: http.StatusServiceUnavailable, Message: fmt.Sprintf("Error queueing print job: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusAccepted, jobs)
+	}
+}
+
+func RetryPrintJob() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing print job id: %v", err), Errors: []string{err.Error()}})
+		}
+
+		job, err := tools.PrinterQueue.Retry(uint(id))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error retrying print job: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusAccepted, job)
+	}
+}
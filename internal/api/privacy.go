@@ -0,0 +1,123 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// ExportCustomerData answers a customer's access request with everything held about them,
+// as one JSON document or as a ZIP with a file per section (?format=zip)
+func ExportCustomerData() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		format := c.QueryParam("format")
+		if format == "" {
+			format = "json"
+		}
+
+		if format != "json" && format != "zip" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing format: expected json or zip", Errors: []string{"expected json or zip"}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		export, err := models.ExportCustomerData(c.Param("id"), userId, c.QueryParam("reason"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error exporting customer data: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if format == "json" {
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "customer-"+export.Customer.Id+".json"))
+			return c.JSON(http.StatusOK, export)
+		}
+
+		sections := []struct {
+			name string
+			data interface{}
+		}{
+			{"customer.json", export.Customer},
+			{"account.json", export.Account},
+			{"addresses.json", export.Addresses},
+			{"orders.json", export.Orders},
+			{"ips.json", export.Ips},
+			{"visits.json", export.Visits},
+			{"emails.json", export.Emails},
+			{"loyalty_points.json", export.LoyaltyPoints},
+			{"gift_cards.json", export.GiftCards},
+			{"referrals.json", export.Referrals},
+			{"locations.json", export.Locations},
+			{"notes.json", export.Notes},
+			{"tags.json", export.Tags},
+		}
+
+		var buffer bytes.Buffer
+		archive := zip.NewWriter(&buffer)
+
+		for _, section := range sections {
+			file, err := archive.Create(section.name)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error bundling customer data: %v", err), Errors: []string{err.Error()}})
+			}
+
+			encoder := json.NewEncoder(file)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(section.data); err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error bundling customer data: %v", err), Errors: []string{err.Error()}})
+			}
+		}
+
+		if err := archive.Close(); err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error bundling customer data: %v", err), Errors: []string{err.Error()}})
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "customer-"+export.Customer.Id+".zip"))
+
+		return c.Blob(http.StatusOK, "application/zip", buffer.Bytes())
+	}
+}
+
+type ErasurePayload struct {
+	Reason string `json:"reason"`
+}
+
+// EraseCustomer answers a customer's request to be forgotten, their orders stay for the tax records
+func EraseCustomer(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var payload ErasurePayload
+
+		if err := c.Bind(&payload); err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing request body for erasure: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if payload.Reason == "" {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: "Error parsing erasure: a reason is required", Errors: []string{"a reason is required"}})
+		}
+
+		userId, _ := c.Get("userid").(string)
+
+		customer, err := models.EraseCustomer(c.Param("id"), userId, payload.Reason)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error erasing customer: %v", err), Errors: []string{err.Error()}})
+		}
+
+		cm.BroadcastEvent(models.Event{Type: models.EventCustomersChanged, Payload: nil})
+
+		return c.JSON(http.StatusOK, customer)
+	}
+}
+
+func GetPrivacyRequests() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requests, err := models.GetPrivacyRequests()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching privacy requests: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, requests)
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// creditReferral gives the referrer of a fulfilled order their store credit and emails it.
+// The hand over already happened, failures are logged and the referral stays pending.
+func creditReferral(orderId string) {
+	referral, card, err := models.CreditReferral(orderId)
+	if err != nil {
+		log.Errorf("Error crediting referral of order %s <- %v", orderId, err)
+		return
+	}
+
+	if referral == nil || card == nil {
+		return
+	}
+
+	if err := tools.SendGiftCard(card, ""); err != nil {
+		log.Errorf("Error sending referral credit %s <- %v", card.Id, err)
+	}
+}
+
+// GetReferralReport sums what every referrer brought in and what the referrals cost
+func GetReferralReport() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := models.GetReferralReport()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching referral report: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+}
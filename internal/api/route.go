@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+)
+
+// GetDeliveryRoute plans the round of the deliveries of ?date (today by default), only those of the window
+// starting at ?window when given. ?format=pdf returns the driver manifest instead.
+func GetDeliveryRoute() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Delivery windows are stored as the wall clock time picked at checkout
+		day, err := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error reading date: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if param := c.QueryParam("date"); param != "" {
+			day, err = time.Parse("2006-01-02", param)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing date: %v", err), Errors: []string{err.Error()}})
+			}
+		}
+
+		window := c.QueryParam("window")
+		if window != "" {
+			if _, err := time.Parse("15:04", window); err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing window: %v", err), Errors: []string{err.Error()}})
+			}
+		}
+
+		route, err := models.PlanDeliveryRoute(day, window)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error planning delivery route: %v", err), Errors: []string{err.Error()}})
+		}
+
+		if c.QueryParam("format") == "pdf" {
+			document, err := tools.GenerateDriverManifest(route)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error generating driver manifest: %v", err), Errors: []string{err.Error()}})
+			}
+
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", "delivery-route-"+route.Date+".pdf"))
+
+			return c.Blob(http.StatusOK, "application/pdf", document)
+		}
+
+		return c.JSON(http.StatusOK, route)
+	}
+}
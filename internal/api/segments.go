@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/labstack/echo/v4"
+)
+
+func GetSegmentsStats() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		timeframe := models.ParseTimeframe(c.QueryParam("timeframe"))
+
+		stats, err := models.GetSegmentsStats(timeframe)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching segments: %v", err), Errors: []string{err.Error()}})
+		}
+
+		return c.JSON(http.StatusOK, stats)
+	}
+}
+
+// GetSegmentedCustomers lists the customers with their RFM scores, only those of ?segment when given,
+// as JSON or as a CSV file when format is csv
+func GetSegmentedCustomers() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var segment models.Segment
+		if str := c.QueryParam("segment"); str != "" {
+			var err error
+			segment, err = models.ParseSegment(str)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, models.JSONErrorResponse{Code: http.StatusBadRequest, Message: fmt.Sprintf("Error parsing segment: %v", err), Errors: []string{err.Error()}})
+			}
+		}
+
+		customers, err := models.GetCustomersRFM(time.Now())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error fetching segmented customers: %v", err), Errors: []string{err.Error()}})
+		}
+
+		customers = models.FilterSegment(customers, segment)
+
+		if c.QueryParam("format") == "csv" {
+			var buf bytes.Buffer
+			if err := tools.WriteSegmentsCSV(&buf, customers); err != nil {
+				return c.JSON(http.StatusInternalServerError, models.JSONErrorResponse{Code: http.StatusInternalServerError, Message: fmt.Sprintf("Error writing segmented customers: %v", err), Errors: []string{err.Error()}})
+			}
+
+			name := "customers"
+			if segment != "" {
+				name = string(segment)
+			}
+
+			filename := fmt.Sprintf("%s-%s.csv", name, time.Now().Format("20060102"))
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+			return c.Blob(http.StatusOK, "text/csv", buf.Bytes())
+		}
+
+		return c.JSON(http.StatusOK, customers)
+	}
+}
@@ -0,0 +1,368 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/payments"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/stripe/stripe-go/v78"
+)
+
+// handlePaymentFailed warns admins of a declined online payment. The checkout stays
+// cached so the customer can try another card.
+func handlePaymentFailed(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var paymentIntent stripe.PaymentIntent
+	if err := json.Unmarshal(raw, &paymentIntent); err != nil {
+		return fmt.Errorf("error parsing payment intent: %v", err)
+	}
+
+	notice := models.PaymentNotice{Event: "payment_failed", Amount: int(paymentIntent.Amount), Message: "Payment failed"}
+	if paymentIntent.LastPaymentError != nil {
+		notice.Message = paymentIntent.LastPaymentError.Msg
+	}
+
+	order, err := models.GetOrderByPayment(paymentIntent.ID, "")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if order != nil {
+		if _, err := order.SetPaymentStatus(models.PAYMENT_FAILED); err != nil {
+			return err
+		}
+		notice.OrderId = order.Id
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	}
+
+	notifyPayment(cm, notice)
+
+	return nil
+}
+
+// handlePaymentCanceled drops the checkout waiting on the intent, or cancels its order if one was created
+func handlePaymentCanceled(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var paymentIntent stripe.PaymentIntent
+	if err := json.Unmarshal(raw, &paymentIntent); err != nil {
+		return fmt.Errorf("error parsing payment intent: %v", err)
+	}
+
+	notice := models.PaymentNotice{Event: "payment_canceled", Amount: int(paymentIntent.Amount), Message: string(paymentIntent.CancellationReason)}
+
+	order, err := models.GetOrderByPayment(paymentIntent.ID, "")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if order == nil {
+		om.Discard(paymentIntent.Metadata["sessionID"])
+	} else {
+		if !order.Fulfilled && !order.Cancelled {
+			if _, err := order.Cancel(); err != nil {
+				return err
+			}
+		}
+		if _, err := order.SetPaymentStatus(models.PAYMENT_CANCELED); err != nil {
+			return err
+		}
+		notice.OrderId = order.Id
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	}
+
+	notifyPayment(cm, notice)
+
+	return nil
+}
+
+// handleChargeRefunded records the refunds of a charge, whether issued from Rosskery or the Stripe dashboard
+func handleChargeRefunded(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var charge stripe.Charge
+	if err := json.Unmarshal(raw, &charge); err != nil {
+		return fmt.Errorf("error parsing charge: %v", err)
+	}
+
+	var intentId string
+	if charge.PaymentIntent != nil {
+		intentId = charge.PaymentIntent.ID
+	}
+
+	order, err := models.GetOrderByPayment(intentId, charge.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warnf("Refunded charge %s matches no order", charge.ID)
+			notifyPayment(cm, models.PaymentNotice{Event: "charge_refunded", Amount: int(charge.AmountRefunded), Message: fmt.Sprintf("Refunded charge %s matches no order", charge.ID)})
+			return nil
+		}
+		return err
+	}
+
+	refunds := make([]models.Refund, 0)
+	if charge.Refunds != nil && len(charge.Refunds.Data) > 0 {
+		for _, refund := range charge.Refunds.Data {
+			if refund.Status == stripe.RefundStatusFailed || refund.Status == stripe.RefundStatusCanceled {
+				continue
+			}
+			refunds = append(refunds, models.Refund{Id: refund.ID, Amount: int(refund.Amount), Reason: string(refund.Reason)})
+		}
+	} else {
+		// Recent API versions no longer embed the refunds list, book whatever is not recorded yet
+		recorded, err := models.GetRefundedAmount(order.Id)
+		if err != nil {
+			return err
+		}
+
+		if missing := int(charge.AmountRefunded) - recorded; missing > 0 {
+			refunds = append(refunds, models.Refund{Id: fmt.Sprintf("%s:%d", charge.ID, charge.AmountRefunded), Amount: missing, Reason: "stripe"})
+		}
+	}
+
+	if order.Paid == 0 {
+		order.Paid = int(charge.Amount)
+	}
+
+	if _, err := order.RecordRefunds(int(charge.AmountRefunded), refunds); err != nil {
+		return err
+	}
+
+	cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	notifyPayment(cm, models.PaymentNotice{Event: "charge_refunded", OrderId: order.Id, Amount: int(charge.AmountRefunded), Message: "Charge refunded"})
+
+	return nil
+}
+
+// handleDispute tracks a chargeback from its opening to its outcome
+func handleDispute(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var dispute stripe.Dispute
+	if err := json.Unmarshal(raw, &dispute); err != nil {
+		return fmt.Errorf("error parsing dispute: %v", err)
+	}
+
+	var intentId, chargeId string
+	if dispute.PaymentIntent != nil {
+		intentId = dispute.PaymentIntent.ID
+	}
+	if dispute.Charge != nil {
+		chargeId = dispute.Charge.ID
+	}
+
+	record := models.Dispute{Id: dispute.ID, ChargeId: chargeId, Amount: int(dispute.Amount), Reason: string(dispute.Reason), Status: string(dispute.Status)}
+
+	order, err := models.GetOrderByPayment(intentId, chargeId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if order != nil {
+		record.OrderId = &order.Id
+	}
+
+	status := models.PAYMENT_DISPUTED
+	switch dispute.Status {
+	case stripe.DisputeStatusWon:
+		status = models.PAYMENT_DISPUTE_WON
+	case stripe.DisputeStatusLost:
+		status = models.PAYMENT_DISPUTE_LOST
+	}
+
+	if _, err := models.SaveDispute(record, status); err != nil {
+		return err
+	}
+
+	notice := models.PaymentNotice{Event: "dispute_" + string(dispute.Status), Amount: record.Amount, Message: fmt.Sprintf("Dispute %s: %s", dispute.Status, dispute.Reason)}
+	if order != nil {
+		notice.OrderId = order.Id
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	}
+
+	notifyPayment(cm, notice)
+
+	return nil
+}
+
+func handlePayoutPaid(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var payout stripe.Payout
+	if err := json.Unmarshal(raw, &payout); err != nil {
+		return fmt.Errorf("error parsing payout: %v", err)
+	}
+
+	if _, err := models.RecordPayout(models.Payout{Id: payout.ID, Amount: int(payout.Amount), Currency: string(payout.Currency), Arrival: time.Unix(payout.ArrivalDate, 0).UTC(), Status: string(payout.Status)}); err != nil {
+		return err
+	}
+
+	notifyPayment(cm, models.PaymentNotice{Event: "payout_paid", Amount: int(payout.Amount), Message: fmt.Sprintf("Payout %s paid", payout.ID)})
+
+	return nil
+}
+
+func notifyPayment(cm *models.ConnectionManager, notice models.PaymentNotice) {
+	payload, err := json.Marshal(notice)
+	if err != nil {
+		log.Errorf("Error parsing payment notice <- %v", err)
+		return
+	}
+
+	cm.BroadcastAdminEvent(models.Event{Type: models.EventPaymentsChanged, Payload: payload})
+}
+
+// PayPalWebhook handles the notifications PayPal sends about captured orders
+func PayPalWebhook(cm *models.ConnectionManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		const MaxBodyBytes = int64(65536)
+		body := http.MaxBytesReader(c.Response().Writer, c.Request().Body, MaxBodyBytes)
+		payload, err := io.ReadAll(body)
+		if err != nil {
+			log.Errorf("Error reading body: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "Error reading body")
+		}
+
+		provider, err := payments.Get(models.PAYPAL)
+		if err != nil {
+			log.Errorf("Error getting paypal provider: %v", err)
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "PayPal payments are not available")
+		}
+
+		event, err := provider.VerifyWebhook(c.Request().Context(), payload, c.Request().Header)
+		if err != nil {
+			log.Errorf("Error verifying paypal event: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "Error verifying event")
+		}
+
+		claimed, err := models.ClaimWebhookEvent(event.Id, event.Type)
+		if err != nil {
+			log.Errorf("Error registering webhook event: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error registering event")
+		}
+
+		if !claimed {
+			log.Infof("Webhook event %s already processed", event.Id)
+			return c.NoContent(http.StatusOK)
+		}
+
+		switch event.Type {
+		case "PAYMENT.CAPTURE.REFUNDED":
+			err = handlePayPalRefund(cm, event.Data)
+		case "PAYMENT.CAPTURE.DENIED":
+			err = handlePayPalDenied(cm, event.Data)
+		default:
+			log.Infof("Ignoring webhook event %s of type %s", event.Id, event.Type)
+			return c.NoContent(http.StatusOK)
+		}
+
+		if err != nil {
+			log.Errorf("Error handling webhook event %s of type %s <- %v", event.Id, event.Type, err)
+			releaseWebhookEvent(event.Id)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error handling event")
+		}
+
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+type paypalRefund struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Amount struct {
+		Value string `json:"value"`
+	} `json:"amount"`
+	NoteToPayer string `json:"note_to_payer"`
+	Breakdown   struct {
+		TotalRefunded struct {
+			Value string `json:"value"`
+		} `json:"total_refunded_amount"`
+	} `json:"seller_payable_breakdown"`
+	Links []struct {
+		Href string `json:"href"`
+		Rel  string `json:"rel"`
+	} `json:"links"`
+}
+
+// handlePayPalRefund records a refund of a capture, whether issued from Rosskery or the PayPal dashboard
+func handlePayPalRefund(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var refund paypalRefund
+	if err := json.Unmarshal(raw, &refund); err != nil {
+		return fmt.Errorf("error parsing paypal refund: %v", err)
+	}
+
+	// The refunded capture is only referenced by the "up" link
+	var captureId string
+	for _, link := range refund.Links {
+		if link.Rel == "up" {
+			captureId = link.Href[strings.LastIndex(link.Href, "/")+1:]
+		}
+	}
+
+	amount, err := payments.ParsePayPalAmount(refund.Amount.Value)
+	if err != nil {
+		return err
+	}
+
+	order, err := models.GetOrderByPayment("", captureId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warnf("Refunded capture %s matches no order", captureId)
+			notifyPayment(cm, models.PaymentNotice{Event: "charge_refunded", Amount: amount, Message: fmt.Sprintf("Refunded capture %s matches no order", captureId)})
+			return nil
+		}
+		return err
+	}
+
+	refunded := order.Refunded + amount
+	if refund.Breakdown.TotalRefunded.Value != "" {
+		if refunded, err = payments.ParsePayPalAmount(refund.Breakdown.TotalRefunded.Value); err != nil {
+			return err
+		}
+	}
+
+	if _, err := order.RecordRefunds(refunded, []models.Refund{{Id: refund.Id, Amount: amount, Reason: refund.NoteToPayer}}); err != nil {
+		return err
+	}
+
+	cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	notifyPayment(cm, models.PaymentNotice{Event: "charge_refunded", OrderId: order.Id, Amount: amount, Message: "Capture refunded"})
+
+	return nil
+}
+
+// handlePayPalDenied flags an order whose capture PayPal declined after the customer came back
+func handlePayPalDenied(cm *models.ConnectionManager, raw json.RawMessage) error {
+	var capture struct {
+		Id     string `json:"id"`
+		Amount struct {
+			Value string `json:"value"`
+		} `json:"amount"`
+	}
+	if err := json.Unmarshal(raw, &capture); err != nil {
+		return fmt.Errorf("error parsing paypal capture: %v", err)
+	}
+
+	amount, err := payments.ParsePayPalAmount(capture.Amount.Value)
+	if err != nil {
+		return err
+	}
+
+	notice := models.PaymentNotice{Event: "payment_failed", Amount: amount, Message: "PayPal capture denied"}
+
+	order, err := models.GetOrderByPayment("", capture.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if order != nil {
+		if _, err := order.SetPaymentStatus(models.PAYMENT_FAILED); err != nil {
+			return err
+		}
+		notice.OrderId = order.Id
+		cm.BroadcastEvent(models.Event{Type: models.EventOrdersChanged, Payload: nil})
+	}
+
+	notifyPayment(cm, notice)
+
+	return nil
+}
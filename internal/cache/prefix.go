@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type prefixEntry struct {
+	suggestions []string
+	expires     time.Time
+}
+
+// PrefixCache keeps the suggestions of each query prefix typed for ttl, so customers typing the same
+// street do not reach the provider again
+type PrefixCache struct {
+	entries map[string]prefixEntry
+	ttl     time.Duration
+	max     int
+	Lock    sync.Mutex
+}
+
+func NewPrefixCache(ttl time.Duration, max int) *PrefixCache {
+	return &PrefixCache{entries: make(map[string]prefixEntry), ttl: ttl, max: max}
+}
+
+func (p *PrefixCache) Get(prefix string) ([]string, bool) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	entry, ok := p.entries[prefix]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.suggestions, true
+}
+
+func (p *PrefixCache) Set(prefix string, suggestions []string) {
+	p.Lock.Lock()
+	defer p.Lock.Unlock()
+
+	now := time.Now()
+
+	if len(p.entries) >= p.max {
+		for key, entry := range p.entries {
+			if now.After(entry.expires) {
+				delete(p.entries, key)
+			}
+		}
+	}
+
+	// Still full of live entries, make room rather than grow without bound
+	if len(p.entries) >= p.max {
+		for key := range p.entries {
+			delete(p.entries, key)
+			if len(p.entries) < p.max {
+				break
+			}
+		}
+	}
+
+	p.entries[prefix] = prefixEntry{suggestions: suggestions, expires: now.Add(p.ttl)}
+}
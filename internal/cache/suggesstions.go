@@ -1,29 +1,70 @@
 package cache
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
+// SessionSuggestions is how much a session asked the address provider for lately
+type SessionSuggestions struct {
+	Last   time.Time // When the provider was last asked for this session
+	Window time.Time // Start of the current rate limit window
+	Count  int       // Requests let through in the window
+}
+
+// LatestSuggestionsCache tracks the provider requests of each session, so requests arriving too quickly
+// or over the limit never reach the address provider
 type LatestSuggestionsCache struct {
-	Suggestions []string
-	Lock        sync.Mutex
+	Sessions map[string]*SessionSuggestions
+	Lock     sync.Mutex
 }
 
 func NewSuggestionsCache() *LatestSuggestionsCache {
 	return &LatestSuggestionsCache{
-		Suggestions: []string{},
-		Lock:        sync.Mutex{},
+		Sessions: make(map[string]*SessionSuggestions),
+		Lock:     sync.Mutex{},
 	}
 }
 
 var suggestionsCache = NewSuggestionsCache()
 
-func GetSuggestions() []string {
+// AllowSuggestions reports whether the session may ask the provider now: not within debounce of its
+// previous request and not over limit requests per window
+func AllowSuggestions(session string, debounce time.Duration, limit int, window time.Duration) bool {
 	suggestionsCache.Lock.Lock()
 	defer suggestionsCache.Lock.Unlock()
-	return suggestionsCache.Suggestions
+
+	now := time.Now()
+
+	entry, ok := suggestionsCache.Sessions[session]
+	if !ok {
+		entry = &SessionSuggestions{Window: now}
+		suggestionsCache.Sessions[session] = entry
+	}
+
+	if now.Sub(entry.Window) >= window {
+		entry.Window = now
+		entry.Count = 0
+	}
+
+	if (!entry.Last.IsZero() && now.Sub(entry.Last) < debounce) || entry.Count >= limit {
+		return false
+	}
+
+	entry.Last = now
+	entry.Count++
+
+	return true
 }
 
-func SetSuggestions(suggestions []string) {
+// PruneSuggestions forgets the sessions that asked nothing for idle
+func PruneSuggestions(idle time.Duration) {
 	suggestionsCache.Lock.Lock()
 	defer suggestionsCache.Lock.Unlock()
-	suggestionsCache.Suggestions = suggestions
+
+	for session, entry := range suggestionsCache.Sessions {
+		if time.Since(entry.Last) > idle && time.Since(entry.Window) > idle {
+			delete(suggestionsCache.Sessions, session)
+		}
+	}
 }
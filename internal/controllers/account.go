@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/tools"
+	"github.com/Francesco99975/rosskery/views"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// Notices shown after a redirect, looked up by code so nothing from the query ends up on the page
+var accountNotices = map[string]string{
+	"signup":   "Your account is ready. Check your inbox to confirm your email.",
+	"verified": "Your email is confirmed.",
+	"expired":  "The confirmation link is invalid or expired.",
+	"profile":  "Your profile was saved.",
+	"address":  "Your addresses were updated.",
+	"signin":   "Sign in to see your account.",
+	"magic":    "Check your inbox, we sent you a link to sign in.",
+	"link":     "The sign in link is invalid or expired.",
+}
+
+func AccountLogin(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Server error on session")
+		}
+
+		if accountID, ok := sess.Values["accountID"].(string); ok && accountID != "" {
+			return c.Redirect(http.StatusSeeOther, "/account")
+		}
+
+		return renderAccountLogin(ctx, c, http.StatusOK, accountNotices[c.QueryParam("m")])
+	}
+}
+
+func renderAccountLogin(ctx context.Context, c echo.Context, status int, message string) error {
+	data := models.GetDefaultSite("Sign In", ctx)
+
+	csrfToken := c.Get("csrf").(string)
+	nonce := c.Get("nonce").(string)
+
+	html, err := helpers.GeneratePage(views.AccountLogin(data, message, csrfToken, nonce))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page account login")
+	}
+
+	return c.Blob(status, "text/html; charset=utf-8", html)
+}
+
+// signIn keeps the account in the session and moves the guest bag into the account's cart
+func signIn(ctx context.Context, c echo.Context, account *models.Account) error {
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return err
+	}
+	sess.Options = helpers.GetSessionOptions()
+
+	if sessionID, ok := sess.Values["sessionID"].(string); ok && sessionID != "" {
+		guest, err := models.GetCart(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, account.Id))
+		if err != nil {
+			return err
+		}
+
+		if err := cart.Merge(ctx, guest); err != nil {
+			return err
+		}
+	}
+
+	sess.Values["accountID"] = account.Id
+
+	return sess.Save(c.Request(), c.Response())
+}
+
+func SignIn(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account, err := models.Authenticate(c.FormValue("email"), c.FormValue("password"))
+		if err != nil {
+			return renderAccountLogin(ctx, c, http.StatusUnauthorized, err.Error())
+		}
+
+		account, err = account.SignedIn()
+		if err != nil {
+			log.Errorf("Error signing in account <- %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not sign in")
+		}
+
+		if err := signIn(ctx, c, account); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account")
+	}
+}
+
+// RequestMagicLink emails a sign in link, the answer is the same whether the email has an account or not
+func RequestMagicLink(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		email := c.FormValue("email")
+
+		token, err := models.RequestMagicLink(ctx, email, c.RealIP())
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, models.ErrMagicLinkRateLimited) {
+				status = http.StatusTooManyRequests
+			}
+			return renderAccountLogin(ctx, c, status, fmt.Sprintf("Error: %v", err))
+		}
+
+		// Never built from the request, a forged Host header would send the victim's token to the attacker
+		link := os.Getenv("HOST") + "/account/magic/" + token
+
+		go func() {
+			if err := tools.SendMagicLink(email, link); err != nil {
+				log.Errorf("Error sending sign in link <- %v", err)
+			}
+		}()
+
+		return c.Redirect(http.StatusSeeOther, "/account/login?m=magic")
+	}
+}
+
+// MagicSignIn follows a sign in link, the session takes the account and its bag over from there
+func MagicSignIn(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account, err := models.ConsumeMagicLink(ctx, c.Param("token"))
+		if err != nil {
+			if !errors.Is(err, models.ErrMagicLinkExpired) {
+				log.Errorf("Error following sign in link <- %v", err)
+			}
+			return c.Redirect(http.StatusSeeOther, "/account/login?m=link")
+		}
+
+		account, err = account.SignedIn()
+		if err != nil {
+			log.Errorf("Error signing in account <- %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not sign in")
+		}
+
+		if err := signIn(ctx, c, account); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account")
+	}
+}
+
+func SignUp(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account, err := models.CreateAccount(c.FormValue("email"), c.FormValue("password"), c.FormValue("fullname"))
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, models.ErrAccountExists) {
+				status = http.StatusConflict
+			}
+			return renderAccountLogin(ctx, c, status, fmt.Sprintf("Error: %v", err))
+		}
+
+		sendAccountVerification(ctx, c, account)
+
+		if err := signIn(ctx, c, account); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account?m=signup")
+	}
+}
+
+func sendAccountVerification(ctx context.Context, c echo.Context, account *models.Account) {
+	token, err := account.NewAccountVerification(ctx)
+	if err != nil {
+		log.Errorf("Error creating verification for account %s <- %v", account.Id, err)
+		return
+	}
+
+	// Never built from the request, a forged Host header would send the token elsewhere
+	link := os.Getenv("HOST") + "/account/verify/" + token
+
+	go func() {
+		if err := tools.SendAccountVerification(account.Email, link); err != nil {
+			log.Errorf("Error sending verification to account %s <- %v", account.Id, err)
+		}
+	}()
+}
+
+func SignOut() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Server error on session")
+		}
+		sess.Options = helpers.GetSessionOptions()
+		delete(sess.Values, "accountID")
+
+		if err := sess.Save(c.Request(), c.Response()); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/")
+	}
+}
+
+// VerifyAccount confirms the email from the link sent at sign up, then links the guest orders of that email
+func VerifyAccount(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account, err := models.VerifyAccount(ctx, c.Param("token"))
+		if err != nil {
+			if !errors.Is(err, models.ErrVerificationExpired) {
+				log.Errorf("Error verifying account <- %v", err)
+			}
+			return c.Redirect(http.StatusSeeOther, "/account/login?m=expired")
+		}
+
+		account, err = account.SignedIn()
+		if err != nil {
+			log.Errorf("Error linking account <- %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not sign in")
+		}
+
+		if err := signIn(ctx, c, account); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account?m=verified")
+	}
+}
+
+func Account(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account := c.Get("account").(*models.Account)
+
+		return renderAccount(ctx, c, account, http.StatusOK, accountNotices[c.QueryParam("m")])
+	}
+}
+
+func renderAccount(ctx context.Context, c echo.Context, account *models.Account, status int, message string) error {
+	addresses, err := account.GetAddresses()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not get addresses")
+	}
+
+	orders, err := account.GetOrders()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not get orders")
+	}
+
+	data := models.GetDefaultSite("Account", ctx)
+
+	csrfToken := c.Get("csrf").(string)
+	nonce := c.Get("nonce").(string)
+
+	html, err := helpers.GeneratePage(views.Account(data, account, addresses, orders, message, csrfToken, nonce))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page account")
+	}
+
+	return c.Blob(status, "text/html; charset=utf-8", html)
+}
+
+func UpdateAccountProfile(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account := c.Get("account").(*models.Account)
+
+		if _, err := account.UpdateProfile(c.FormValue("fullname"), c.FormValue("phone")); err != nil {
+			return renderAccount(ctx, c, account, http.StatusBadRequest, fmt.Sprintf("Error: %v", err))
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account?m=profile")
+	}
+}
+
+func AddAccountAddress(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account := c.Get("account").(*models.Account)
+
+		if err := account.AddAddress(ctx, c.FormValue("label"), c.FormValue("address"), c.FormValue("preferred") == "true"); err != nil {
+			return renderAccount(ctx, c, account, http.StatusBadRequest, fmt.Sprintf("Error: %v", err))
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account?m=address")
+	}
+}
+
+func DeleteAccountAddress() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account := c.Get("account").(*models.Account)
+
+		if err := account.DeleteAddress(c.Param("id")); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not remove address")
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/account?m=address")
+	}
+}
+
+// AccountInvoice regenerates the invoice of one of the customer's own orders
+func AccountInvoice() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account := c.Get("account").(*models.Account)
+
+		order, err := account.GetOrder(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Order not found")
+		}
+
+		filename, err := tools.GenerateInvoice(order)
+		if err != nil {
+			log.Errorf("Error generating invoice for order %s <- %v", order.Id, err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not generate invoice")
+		}
+		defer os.Remove(filename)
+
+		document, err := os.ReadFile(filename)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not read invoice")
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", "invoice-"+order.Id+".pdf"))
+
+		return c.Blob(http.StatusOK, "application/pdf", document)
+	}
+}
@@ -1,57 +1,52 @@
 package controllers
 
 import (
-	"encoding/json"
-	"io"
 	"net/http"
-	"net/url"
-	"os"
+	"time"
 
+	"github.com/Francesco99975/rosskery/internal/cache"
+	"github.com/Francesco99975/rosskery/internal/geo"
 	"github.com/Francesco99975/rosskery/internal/helpers"
 	"github.com/Francesco99975/rosskery/views/components"
+	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
 )
 
+// AddressAutocomplete suggests addresses as the customer types. Queries already cached are answered
+// right away, the others only reach the providers when the session did not ask within ADDRESS_DEBOUNCE_MS
+// (250 by default) nor more than ADDRESS_RATE_LIMIT times a minute (60 by default). Refused requests and
+// failed providers get no suggestions.
 func AddressAutocomplete() echo.HandlerFunc {
 	return func(c echo.Context) error {
-
-		type Prediction struct {
-			Description string `json:"description"`
-		}
-
-		type AutocompleteResponse struct {
-			Predictions []Prediction `json:"predictions"`
-		}
-
 		query := c.QueryParam("address")
 		if query == "" {
 			return c.JSON(http.StatusOK, []string{})
 		}
 
-		const torontoLatitude = "43.65107"
-		const torontoLongitude = "-79.347015"
-		const radius = "100000" // 100 kilometers in meters
+		suggestions, cached := geo.CachedSuggestions(query)
 
-		apiURL := "https://maps.googleapis.com/maps/api/place/autocomplete/json?input=" + url.QueryEscape(query) + "&types=address&components=country:CA&location=" + torontoLatitude + "," + torontoLongitude + "&radius=" + radius + "&key=" + os.Getenv("GOOGLE_MAPS_API_KEY")
-		resp, err := http.Get(apiURL)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to get suggestions"})
-		}
-		defer resp.Body.Close()
+		if !cached {
+			suggestions = []string{}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to read response"})
-		}
+			// Sessions are created with the cart, a customer without one is told apart by address
+			key := c.RealIP()
+			if sess, err := session.Get("session", c); err == nil {
+				if sessionID, ok := sess.Values["sessionID"].(string); ok && sessionID != "" {
+					key = sessionID
+				}
+			}
 
-		var autocompleteResponse AutocompleteResponse
-		if err := json.Unmarshal(body, &autocompleteResponse); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to unmarshal response"})
-		}
+			debounce := time.Duration(helpers.PositiveIntEnv("ADDRESS_DEBOUNCE_MS", 250)) * time.Millisecond
+			limit := helpers.PositiveIntEnv("ADDRESS_RATE_LIMIT", 60)
 
-		var suggestions []string
-		for _, prediction := range autocompleteResponse.Predictions {
-			suggestions = append(suggestions, prediction.Description)
+			if cache.AllowSuggestions(key, debounce, limit, time.Minute) {
+				if found, err := geo.Suggest(c.Request().Context(), query); err != nil {
+					log.Errorf("Error suggesting addresses <- %v", err)
+				} else {
+					suggestions = found
+				}
+			}
 		}
 
 		nonce := c.Get("nonce").(string)
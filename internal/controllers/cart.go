@@ -32,14 +32,15 @@ func GetCartItems(ctx context.Context) echo.HandlerFunc {
 				return echo.NewHTTPError(http.StatusInternalServerError, "Could not create session")
 			}
 		}
-		cart, err := models.GetCart(ctx, sessionID)
+		accountID, _ := sess.Values["accountID"].(string)
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart")
 		}
 
 		preview, err := cart.Preview(ctx)
 		if err != nil {
-			log.Errorf("Could Not get cart preview <- %w", err)
+			log.Errorf("Could Not get cart preview <- %v", err)
 			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart preview")
 		}
 
@@ -92,7 +93,8 @@ func AddToCart(ctx context.Context) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "Could not get session id")
 		}
 
-		cart, err := models.GetCart(ctx, sessionID)
+		accountID, _ := sess.Values["accountID"].(string)
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
 		if err != nil {
 			return err
 		}
@@ -139,7 +141,8 @@ func RemoveOneFromCart(ctx context.Context) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "Could not get session id")
 		}
 
-		cart, err := models.GetCart(ctx, sessionID)
+		accountID, _ := sess.Values["accountID"].(string)
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
 		if err != nil {
 			return err
 		}
@@ -186,7 +189,8 @@ func RemoveItemFromCart(ctx context.Context) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "Could not get session id")
 		}
 
-		cart, err := models.GetCart(ctx, sessionID)
+		accountID, _ := sess.Values["accountID"].(string)
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
 		if err != nil {
 			return err
 		}
@@ -232,7 +236,8 @@ func ClearCart(ctx context.Context) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, "Could not get session id")
 		}
 
-		cart, err := models.GetCart(ctx, sessionID)
+		accountID, _ := sess.Values["accountID"].(string)
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
 		if err != nil {
 			return err
 		}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/Francesco99975/rosskery/internal/helpers"
 	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/payments"
 	"github.com/Francesco99975/rosskery/views"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
@@ -31,7 +32,8 @@ func Checkout(ctx context.Context) echo.HandlerFunc {
 				return echo.NewHTTPError(http.StatusInternalServerError, "Could not create session")
 			}
 		}
-		cart, err := models.GetCart(ctx, sessionID)
+		accountID, _ := sess.Values["accountID"].(string)
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart")
 		}
@@ -49,7 +51,22 @@ func Checkout(ctx context.Context) echo.HandlerFunc {
 		csrfToken := c.Get("csrf").(string)
 		nonce := c.Get("nonce").(string)
 
-		html, err := helpers.GeneratePage(views.Checkout(data, &preview, overbookedData, csrfToken, nonce))
+		idempotencyKey := uuid.NewV4().String()
+
+		// Set by a referral link, the customer can still change it
+		referral, _ := sess.Values["referral"].(string)
+
+		// Signed in customers start from their profile and preferred address
+		var prefill models.CheckoutPrefill
+		if accountID != "" {
+			if account, err := models.GetAccount(accountID); err == nil {
+				if filled, err := account.Prefill(); err == nil {
+					prefill = *filled
+				}
+			}
+		}
+
+		html, err := helpers.GeneratePage(views.Checkout(data, &preview, overbookedData, payments.Available(), referral, prefill, idempotencyKey, csrfToken, nonce))
 
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
@@ -58,3 +75,23 @@ func Checkout(ctx context.Context) echo.HandlerFunc {
 		return c.Blob(200, "text/html; charset=utf-8", html)
 	}
 }
+
+// Referral keeps the code of a shared referral link in the session to prefill it at checkout
+func Referral() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Server error on session")
+		}
+		sess.Options = helpers.GetSessionOptions()
+
+		if code := models.NormalizeReferralCode(c.Param("code")); code != "" {
+			sess.Values["referral"] = code
+			if err := sess.Save(c.Request(), c.Response()); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+			}
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/shop")
+	}
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/geo"
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/views/components"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// DeliveryQuote updates the checkout total as the customer switches to delivery or changes their address.
+// The window is only checked once a time is picked, the order is priced again when it is placed.
+func DeliveryQuote(ctx context.Context) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Server error on session")
+		}
+
+		sessionID, _ := sess.Values["sessionID"].(string)
+		accountID, _ := sess.Values["accountID"].(string)
+
+		cart, err := models.GetCart(ctx, models.CartId(sessionID, accountID))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart")
+		}
+
+		preview, err := cart.Preview(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not get cart preview")
+		}
+
+		var quote *models.DeliveryQuote
+		var problem string
+
+		address := strings.TrimSpace(c.QueryParam("address"))
+
+		if models.ParseFulfilment(c.QueryParam("fulfilment")) == models.DELIVERY {
+			if address == "" {
+				problem = "Enter your address to see the delivery fee"
+			} else {
+				lookup, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+				defer cancel()
+
+				location, err := geo.Geocode(lookup, address)
+				if err != nil {
+					log.Errorf("Error geocoding address for a delivery quote <- %v", err)
+					problem = "We could not find your address"
+				} else {
+					at, _ := time.Parse("2006-01-02 15:04", c.QueryParam("pickuptime"))
+
+					if quote, err = models.QuoteDelivery(location, preview.Total, at); err != nil {
+						problem = err.Error()
+					}
+				}
+			}
+		}
+
+		html, err := helpers.GeneratePage(components.DeliveryQuote(preview.Total, quote, problem))
+
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Could not parse page home")
+		}
+
+		return c.Blob(200, "text/html; charset=utf-8", html)
+	}
+}
@@ -41,7 +41,7 @@ func Gallery(ctx context.Context) echo.HandlerFunc {
 				// Decode the image
 				img, err := webp.Decode(file)
 				if err != nil {
-					log.Errorf("Error decoding image %s: %w\n", path, err)
+					log.Errorf("Error decoding image %s: %v\n", path, err)
 					return echo.NewHTTPError(http.StatusInternalServerError, "Error decoding image")
 				}
 
@@ -0,0 +1,200 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/cache"
+	"github.com/labstack/gommon/log"
+)
+
+// SuggestionsLimit is how many addresses are suggested at most
+const SuggestionsLimit = 5
+
+// Bias steers suggestions towards the area the bakery serves
+type Bias struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+	Country  string // ISO 3166-1 alpha-2, e.g. CA
+}
+
+// AddressProvider suggests full addresses for what the customer typed so far
+type AddressProvider interface {
+	Name() string
+	Suggest(ctx context.Context, query string, bias Bias) ([]string, error)
+}
+
+// Chain asks each provider in turn until one answers, so running out of quota on one falls back to the next
+type Chain []AddressProvider
+
+func (c Chain) Name() string {
+	names := make([]string, 0, len(c))
+	for _, provider := range c {
+		names = append(names, provider.Name())
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (c Chain) Suggest(ctx context.Context, query string, bias Bias) ([]string, error) {
+	var errs []error
+
+	for _, provider := range c {
+		suggestions, err := provider.Suggest(ctx, query, bias)
+		if err == nil {
+			return suggestions, nil
+		}
+
+		log.Warnf("Address provider %s failed, trying the next one <- %v", provider.Name(), err)
+		errs = append(errs, fmt.Errorf("%s: %v", provider.Name(), err))
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no address provider set up")
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// DistanceKm is the great-circle distance between two points
+func DistanceKm(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+var (
+	autocompleteMu sync.RWMutex
+	provider       AddressProvider = Chain{}
+	bias                           = Bias{Lat: 43.65107, Lng: -79.347015, RadiusKm: 100, Country: "CA"}
+	prefixes                       = cache.NewPrefixCache(24*time.Hour, 10000)
+)
+
+func envFloat(name string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		return value
+	}
+
+	return fallback
+}
+
+// SetupAutocomplete builds the provider chain from ADDRESS_PROVIDERS (comma separated, out of google,
+// photon, nominatim and local), biased towards ADDRESS_BIAS_LAT, ADDRESS_BIAS_LNG within ADDRESS_BIAS_RADIUS_KM
+// in ADDRESS_COUNTRY. Suggestions are cached per query for ADDRESS_CACHE_HOURS (24 by default).
+func SetupAutocomplete() {
+	configured := Bias{
+		Lat:      envFloat("ADDRESS_BIAS_LAT", bias.Lat),
+		Lng:      envFloat("ADDRESS_BIAS_LNG", bias.Lng),
+		RadiusKm: envFloat("ADDRESS_BIAS_RADIUS_KM", bias.RadiusKm),
+		Country:  bias.Country,
+	}
+
+	if country := strings.TrimSpace(os.Getenv("ADDRESS_COUNTRY")); country != "" {
+		configured.Country = strings.ToUpper(country)
+	}
+
+	names := os.Getenv("ADDRESS_PROVIDERS")
+	if names == "" {
+		names = "google,photon,local"
+	}
+
+	var chain Chain
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "google":
+			if key := os.Getenv("GOOGLE_MAPS_API_KEY"); key != "" {
+				chain = append(chain, NewGooglePlaces(key))
+			}
+		case "photon":
+			chain = append(chain, NewPhoton(os.Getenv("PHOTON_URL")))
+		case "nominatim":
+			chain = append(chain, NewNominatim(os.Getenv("NOMINATIM_URL")))
+		case "local":
+			path := os.Getenv("ADDRESS_DATASET")
+			if path == "" {
+				continue
+			}
+
+			postal, err := LoadPostalCodes(path)
+			if err != nil {
+				log.Errorf("Error loading postal codes from %s <- %v", path, err)
+				continue
+			}
+			chain = append(chain, postal)
+		case "":
+		default:
+			log.Warnf("Unknown address provider %s", name)
+		}
+	}
+
+	ttl := 24 * time.Hour
+	if hours, err := strconv.Atoi(os.Getenv("ADDRESS_CACHE_HOURS")); err == nil && hours > 0 {
+		ttl = time.Duration(hours) * time.Hour
+	}
+
+	UseAutocomplete(chain, configured, ttl)
+}
+
+// UseAutocomplete replaces the provider, bias and cache used by Suggest
+func UseAutocomplete(p AddressProvider, b Bias, ttl time.Duration) {
+	autocompleteMu.Lock()
+	defer autocompleteMu.Unlock()
+
+	provider = p
+	bias = b
+	prefixes = cache.NewPrefixCache(ttl, 10000)
+}
+
+// CachedSuggestions returns the suggestions for the query when they need no provider, either cached or
+// nothing for a query too short to suggest anything
+func CachedSuggestions(query string) ([]string, bool) {
+	key := CacheKey(query)
+	if len(key) < 3 {
+		return []string{}, true
+	}
+
+	autocompleteMu.RLock()
+	c := prefixes
+	autocompleteMu.RUnlock()
+
+	return c.Get(key)
+}
+
+// Suggest returns the suggestions for the query from the cache, asking the providers on a miss
+func Suggest(ctx context.Context, query string) ([]string, error) {
+	if suggestions, ok := CachedSuggestions(query); ok {
+		return suggestions, nil
+	}
+
+	key := CacheKey(query)
+
+	autocompleteMu.RLock()
+	p, b, c := provider, bias, prefixes
+	autocompleteMu.RUnlock()
+
+	suggestions, err := p.Suggest(ctx, query, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(suggestions) > SuggestionsLimit {
+		suggestions = suggestions[:SuggestionsLimit]
+	}
+
+	c.Set(key, suggestions)
+
+	return suggestions, nil
+}
@@ -0,0 +1,75 @@
+package geo
+
+import (
+	"context"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var fakePostalCode = regexp.MustCompile(`(?i)\b([ABCEGHJKLMNPRSTVXY]\d[A-Z])\s?(\d[A-Z]\d)\b`)
+
+// Fake geocodes without contacting anyone, for development and tests. Addresses added with Add are
+// returned as given, any other is split on commas and placed at a stable spot around Toronto.
+type Fake struct {
+	mu        sync.Mutex
+	addresses map[string]Address
+	Lookups   int // Queries answered, to check the cache in front of it
+}
+
+func NewFake() *Fake {
+	return &Fake{addresses: make(map[string]Address)}
+}
+
+func (f *Fake) Add(query string, address Address) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.addresses[CacheKey(query)] = address
+}
+
+func (f *Fake) Geocode(ctx context.Context, query string) (*Address, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Lookups++
+
+	key := CacheKey(query)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+
+	if address, ok := f.addresses[key]; ok {
+		return &address, nil
+	}
+
+	parts := strings.Split(query, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	address := Address{Street: parts[0], Country: "CA", Formatted: strings.Join(parts, ", ")}
+
+	if len(parts) > 1 {
+		address.City = parts[1]
+	}
+
+	if len(parts) > 2 {
+		address.Province = strings.TrimSpace(fakePostalCode.ReplaceAllString(parts[2], ""))
+	}
+
+	if match := fakePostalCode.FindStringSubmatch(query); match != nil {
+		address.PostalCode = strings.ToUpper(match[1] + " " + match[2])
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	sum := hash.Sum32()
+
+	// Within about 20km of downtown Toronto
+	address.Lat = 43.65107 + (float64(sum%1000)/1000-0.5)*0.36
+	address.Lng = -79.347015 + (float64((sum/1000)%1000)/1000-0.5)*0.5
+
+	return &address, nil
+}
@@ -0,0 +1,124 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/labstack/gommon/log"
+)
+
+// ErrNotFound is returned when nothing matches the address looked up
+var ErrNotFound = errors.New("address not found")
+
+// Address is a geocoded address split in its parts
+type Address struct {
+	Street     string  `json:"street"` // Number and street name
+	Unit       string  `json:"unit"`
+	City       string  `json:"city"`
+	Province   string  `json:"province"`
+	PostalCode string  `json:"postal_code" db:"postalcode"`
+	Country    string  `json:"country"`
+	Lat        float64 `json:"lat"`
+	Lng        float64 `json:"lng"`
+	Formatted  string  `json:"formatted"` // One line version as returned by the geocoder
+}
+
+// Geocoder turns a free-form address into a structured one with its coordinates
+type Geocoder interface {
+	Geocode(ctx context.Context, query string) (*Address, error)
+}
+
+// Cache keeps geocoded addresses by their normalized query
+type Cache interface {
+	Get(ctx context.Context, key string) (*Address, bool, error)
+	Set(ctx context.Context, key string, address *Address) error
+}
+
+// CacheKey normalizes a query so spacing and casing differences hit the same entry
+func CacheKey(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// Cached looks addresses up in the cache before asking the geocoder, failing the cache only costs a lookup
+type Cached struct {
+	geocoder Geocoder
+	cache    Cache
+}
+
+func NewCached(geocoder Geocoder, cache Cache) *Cached {
+	return &Cached{geocoder: geocoder, cache: cache}
+}
+
+func (c *Cached) Geocode(ctx context.Context, query string) (*Address, error) {
+	key := CacheKey(query)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+
+	address, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		log.Errorf("Error reading geocode cache <- %v", err)
+	} else if ok {
+		return address, nil
+	}
+
+	address, err = c.geocoder.Geocode(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.cache.Set(ctx, key, address); err != nil {
+		log.Errorf("Error writing geocode cache <- %v", err)
+	}
+
+	return address, nil
+}
+
+var (
+	mu       sync.RWMutex
+	geocoder Geocoder
+)
+
+// Setup picks the geocoder from the environment and caches its results in cache. Addresses are only
+// geocoded by the fake geocoder with GEOCODER=fake, without it a missing Google Maps key stops the server.
+func Setup(cache Cache) {
+	var base Geocoder
+	if os.Getenv("GEOCODER") == "fake" {
+		log.Warn("Addresses are geocoded by the fake geocoder, their coordinates are made up")
+		base = NewFake()
+	} else if key := os.Getenv("GOOGLE_MAPS_API_KEY"); key != "" {
+		base = NewGoogle(key)
+	} else {
+		log.Fatal("GOOGLE_MAPS_API_KEY is not set, set GEOCODER=fake to geocode with made up coordinates")
+	}
+
+	if cache != nil {
+		base = NewCached(base, cache)
+	}
+
+	Use(base)
+}
+
+// Use replaces the geocoder used by Geocode
+func Use(g Geocoder) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	geocoder = g
+}
+
+func Geocode(ctx context.Context, query string) (*Address, error) {
+	mu.RLock()
+	g := geocoder
+	mu.RUnlock()
+
+	if g == nil {
+		return nil, fmt.Errorf("no geocoder set up")
+	}
+
+	return g.Geocode(ctx, query)
+}
@@ -0,0 +1,135 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memoryCache keeps geocoded addresses in a map, failing every call once broken is set
+type memoryCache struct {
+	mu        sync.Mutex
+	addresses map[string]Address
+	broken    bool
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{addresses: make(map[string]Address)}
+}
+
+func (m *memoryCache) Get(ctx context.Context, key string) (*Address, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.broken {
+		return nil, false, errors.New("cache unavailable")
+	}
+
+	address, ok := m.addresses[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return &address, true, nil
+}
+
+func (m *memoryCache) Set(ctx context.Context, key string, address *Address) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.broken {
+		return errors.New("cache unavailable")
+	}
+
+	m.addresses[key] = *address
+	return nil
+}
+
+func TestCachedGeocodesOncePerAddress(t *testing.T) {
+	fake := NewFake()
+	cache := newMemoryCache()
+	cached := NewCached(fake, cache)
+	ctx := context.Background()
+
+	first, err := cached.Geocode(ctx, "100 Queen St W, Toronto, ON M5H 2N2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Spacing and casing differences are the same address
+	second, err := cached.Geocode(ctx, "  100 queen st w,   TORONTO, on m5h 2n2 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.Lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", fake.Lookups)
+	}
+
+	if *first != *second {
+		t.Errorf("expected the cached address %+v, got %+v", first, second)
+	}
+
+	if _, err := cached.Geocode(ctx, "1 Yonge St, Toronto, ON M5E 1E5"); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.Lookups != 2 {
+		t.Errorf("expected a lookup for a new address, got %d in total", fake.Lookups)
+	}
+}
+
+func TestCachedFallsBackWhenCacheFails(t *testing.T) {
+	fake := NewFake()
+	cache := newMemoryCache()
+	cache.broken = true
+	cached := NewCached(fake, cache)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := cached.Geocode(ctx, "100 Queen St W, Toronto"); err != nil {
+			t.Fatalf("a failing cache should not fail the lookup: %v", err)
+		}
+	}
+
+	if fake.Lookups != 2 {
+		t.Errorf("expected every lookup to reach the geocoder, got %d", fake.Lookups)
+	}
+}
+
+func TestCachedSkipsEmptyQueries(t *testing.T) {
+	fake := NewFake()
+	cached := NewCached(fake, newMemoryCache())
+
+	if _, err := cached.Geocode(context.Background(), "   "); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if fake.Lookups != 0 {
+		t.Errorf("expected no lookup for an empty query, got %d", fake.Lookups)
+	}
+}
+
+func TestFakeGeocodesAddedAddresses(t *testing.T) {
+	fake := NewFake()
+	fake.Add("Shop", Address{Street: "1 Bakery Rd", City: "Toronto", Lat: 43.7, Lng: -79.4})
+
+	address, err := fake.Geocode(context.Background(), "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address.Street != "1 Bakery Rd" || address.Lat != 43.7 || address.Lng != -79.4 {
+		t.Errorf("expected the added address, got %+v", address)
+	}
+
+	parsed, err := fake.Geocode(context.Background(), "5 King St E, Toronto, ON m5c1a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.City != "Toronto" || parsed.Province != "ON" || parsed.PostalCode != "M5C 1A1" {
+		t.Errorf("unexpected parsed address %+v", parsed)
+	}
+}
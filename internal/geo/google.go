@@ -0,0 +1,102 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Google geocodes with the Google Maps Geocoding API
+type Google struct {
+	key    string
+	client *http.Client
+}
+
+func NewGoogle(key string) *Google {
+	return &Google{key: key, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type googleComponent struct {
+	LongName  string   `json:"long_name"`
+	ShortName string   `json:"short_name"`
+	Types     []string `json:"types"`
+}
+
+type googleResponse struct {
+	Results []struct {
+		FormattedAddress  string            `json:"formatted_address"`
+		AddressComponents []googleComponent `json:"address_components"`
+		Geometry          struct {
+			Location struct {
+				Lat float64 `json:"lat"`
+				Lng float64 `json:"lng"`
+			} `json:"location"`
+		} `json:"geometry"`
+	} `json:"results"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+}
+
+func (g *Google) Geocode(ctx context.Context, query string) (*Address, error) {
+	apiURL := "https://maps.googleapis.com/maps/api/geocode/json?address=" + url.QueryEscape(query) + "&key=" + g.key
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response googleResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing geocode response: %v", err)
+	}
+
+	if response.Status == "ZERO_RESULTS" || (response.Status == "OK" && len(response.Results) == 0) {
+		return nil, ErrNotFound
+	}
+
+	if response.Status != "OK" {
+		return nil, fmt.Errorf("geocoding failed with %s: %s", response.Status, response.ErrorMessage)
+	}
+
+	result := response.Results[0]
+
+	component := func(kind string, short bool) string {
+		for _, c := range result.AddressComponents {
+			if slices.Contains(c.Types, kind) {
+				if short {
+					return c.ShortName
+				}
+				return c.LongName
+			}
+		}
+		return ""
+	}
+
+	city := component("locality", false)
+	if city == "" {
+		city = component("postal_town", false)
+	}
+
+	return &Address{
+		Street:     strings.TrimSpace(component("street_number", false) + " " + component("route", false)),
+		Unit:       component("subpremise", false),
+		City:       city,
+		Province:   component("administrative_area_level_1", true),
+		PostalCode: component("postal_code", false),
+		Country:    component("country", true),
+		Lat:        result.Geometry.Location.Lat,
+		Lng:        result.Geometry.Location.Lng,
+		Formatted:  result.FormattedAddress,
+	}, nil
+}
@@ -0,0 +1,184 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const osmUserAgent = "Rosskery address autocomplete"
+
+// formatSuggestion joins the parts of an address the way Google writes them
+func formatSuggestion(number string, street string, city string, province string, postalCode string) string {
+	line := strings.TrimSpace(number + " " + street)
+	region := strings.TrimSpace(province + " " + postalCode)
+
+	parts := make([]string, 0, 3)
+	for _, part := range []string{line, city, region} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func getJSON(ctx context.Context, client *http.Client, endpoint string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", osmUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// Photon suggests addresses from a Photon server (https://photon.komoot.io by default)
+type Photon struct {
+	base   string
+	client *http.Client
+}
+
+func NewPhoton(base string) *Photon {
+	if base == "" {
+		base = "https://photon.komoot.io"
+	}
+
+	return &Photon{base: strings.TrimRight(base, "/"), client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *Photon) Name() string {
+	return "photon"
+}
+
+func (p *Photon) Suggest(ctx context.Context, query string, bias Bias) ([]string, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("limit", strconv.Itoa(SuggestionsLimit*2))
+	params.Set("lat", strconv.FormatFloat(bias.Lat, 'f', -1, 64))
+	params.Set("lon", strconv.FormatFloat(bias.Lng, 'f', -1, 64))
+	params.Set("layer", "house")
+
+	var response struct {
+		Features []struct {
+			Properties struct {
+				HouseNumber string `json:"housenumber"`
+				Street      string `json:"street"`
+				Name        string `json:"name"`
+				City        string `json:"city"`
+				State       string `json:"state"`
+				Postcode    string `json:"postcode"`
+				CountryCode string `json:"countrycode"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+
+	if err := getJSON(ctx, p.client, p.base+"/api/?"+params.Encode(), &response); err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]string, 0, len(response.Features))
+	for _, feature := range response.Features {
+		props := feature.Properties
+		if bias.Country != "" && !strings.EqualFold(props.CountryCode, bias.Country) {
+			continue
+		}
+
+		street := props.Street
+		if street == "" {
+			street = props.Name
+		}
+
+		suggestions = append(suggestions, formatSuggestion(props.HouseNumber, street, props.City, props.State, props.Postcode))
+	}
+
+	return suggestions, nil
+}
+
+// Nominatim suggests addresses from a Nominatim server (https://nominatim.openstreetmap.org by default)
+type Nominatim struct {
+	base   string
+	client *http.Client
+}
+
+func NewNominatim(base string) *Nominatim {
+	if base == "" {
+		base = "https://nominatim.openstreetmap.org"
+	}
+
+	return &Nominatim{base: strings.TrimRight(base, "/"), client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (n *Nominatim) Name() string {
+	return "nominatim"
+}
+
+func (n *Nominatim) Suggest(ctx context.Context, query string, bias Bias) ([]string, error) {
+	// A degree of latitude is about 111km, longitude shrinks towards the poles
+	dLat := bias.RadiusKm / 111
+	dLng := bias.RadiusKm / (111 * math.Cos(bias.Lat*math.Pi/180))
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "jsonv2")
+	params.Set("addressdetails", "1")
+	params.Set("limit", strconv.Itoa(SuggestionsLimit))
+	params.Set("viewbox", fmt.Sprintf("%f,%f,%f,%f", bias.Lng-dLng, bias.Lat+dLat, bias.Lng+dLng, bias.Lat-dLat))
+	if bias.Country != "" {
+		params.Set("countrycodes", strings.ToLower(bias.Country))
+	}
+
+	var response []struct {
+		DisplayName string `json:"display_name"`
+		Address     struct {
+			HouseNumber string `json:"house_number"`
+			Road        string `json:"road"`
+			City        string `json:"city"`
+			Town        string `json:"town"`
+			Village     string `json:"village"`
+			State       string `json:"state"`
+			Postcode    string `json:"postcode"`
+		} `json:"address"`
+	}
+
+	if err := getJSON(ctx, n.client, n.base+"/search?"+params.Encode(), &response); err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]string, 0, len(response))
+	for _, result := range response {
+		address := result.Address
+		if address.Road == "" {
+			suggestions = append(suggestions, result.DisplayName)
+			continue
+		}
+
+		city := address.City
+		if city == "" {
+			city = address.Town
+		}
+		if city == "" {
+			city = address.Village
+		}
+
+		suggestions = append(suggestions, formatSuggestion(address.HouseNumber, address.Road, city, address.State, address.Postcode))
+	}
+
+	return suggestions, nil
+}
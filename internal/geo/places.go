@@ -0,0 +1,73 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GooglePlaces suggests addresses with the Google Places Autocomplete API
+type GooglePlaces struct {
+	key    string
+	client *http.Client
+}
+
+func NewGooglePlaces(key string) *GooglePlaces {
+	return &GooglePlaces{key: key, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (g *GooglePlaces) Name() string {
+	return "google"
+}
+
+func (g *GooglePlaces) Suggest(ctx context.Context, query string, bias Bias) ([]string, error) {
+	params := url.Values{}
+	params.Set("input", query)
+	params.Set("types", "address")
+	params.Set("location", strconv.FormatFloat(bias.Lat, 'f', -1, 64)+","+strconv.FormatFloat(bias.Lng, 'f', -1, 64))
+	params.Set("radius", strconv.Itoa(int(bias.RadiusKm*1000)))
+	params.Set("key", g.key)
+	if bias.Country != "" {
+		params.Set("components", "country:"+strings.ToLower(bias.Country))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://maps.googleapis.com/maps/api/place/autocomplete/json?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Predictions []struct {
+			Description string `json:"description"`
+		} `json:"predictions"`
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing autocomplete response: %v", err)
+	}
+
+	// Running out of quota or a revoked key is reported as a status, fail so the next provider is asked
+	if response.Status != "OK" && response.Status != "ZERO_RESULTS" {
+		return nil, fmt.Errorf("autocomplete failed with %s: %s", response.Status, response.ErrorMessage)
+	}
+
+	suggestions := make([]string, 0, len(response.Predictions))
+	for _, prediction := range response.Predictions {
+		suggestions = append(suggestions, prediction.Description)
+	}
+
+	return suggestions, nil
+}
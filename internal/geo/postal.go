@@ -0,0 +1,154 @@
+package geo
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var postalPrefix = regexp.MustCompile(`^[A-Z]\d[A-Z0-9]{0,4}$`)
+
+// PostalCode is one entry of the local postal code dataset
+type PostalCode struct {
+	Code     string // Compact, e.g. M5V2T6
+	City     string
+	Province string
+	Lat      float64
+	Lng      float64
+}
+
+// formatted spaces a Canadian postal code after its forward sortation area
+func (p PostalCode) formatted() string {
+	if len(p.Code) == 6 {
+		return p.Code[:3] + " " + p.Code[3:]
+	}
+
+	return p.Code
+}
+
+// PostalCodes suggests addresses offline from a postal code dataset, completing what was typed with the
+// city and province of the postal code or city it ends with. It keeps checkout working with no provider reachable.
+type PostalCodes struct {
+	codes []PostalCode
+}
+
+// LoadPostalCodes reads a CSV of postal_code,city,province,lat,lng with a header row
+func LoadPostalCodes(path string) (*PostalCodes, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadPostalCodes(file)
+}
+
+func ReadPostalCodes(r io.Reader) (*PostalCodes, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 5
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the postal code dataset is empty")
+	}
+
+	codes := make([]PostalCode, 0, len(records)-1)
+	for i, record := range records[1:] {
+		lat, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude %s", i+2, record[3])
+		}
+
+		lng, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude %s", i+2, record[4])
+		}
+
+		codes = append(codes, PostalCode{
+			Code:     strings.ReplaceAll(strings.ToUpper(record[0]), " ", ""),
+			City:     strings.TrimSpace(record[1]),
+			Province: strings.TrimSpace(record[2]),
+			Lat:      lat,
+			Lng:      lng,
+		})
+	}
+
+	return &PostalCodes{codes: codes}, nil
+}
+
+func (p *PostalCodes) Name() string {
+	return "local"
+}
+
+func (p *PostalCodes) Suggest(ctx context.Context, query string, bias Bias) ([]string, error) {
+	parts := strings.Split(query, ",")
+	street := strings.TrimSpace(parts[0])
+
+	words := strings.Fields(strings.ToUpper(query))
+
+	var matches []PostalCode
+
+	// The query ends with the start of a postal code, spaced or not
+	for _, take := range []int{2, 1} {
+		if len(words) < take {
+			continue
+		}
+
+		prefix := strings.Join(words[len(words)-take:], "")
+		if !postalPrefix.MatchString(prefix) {
+			continue
+		}
+
+		for _, code := range p.codes {
+			if strings.HasPrefix(code.Code, prefix) {
+				matches = append(matches, code)
+			}
+		}
+
+		if len(parts) == 1 {
+			street = strings.TrimSpace(strings.Join(strings.Fields(query)[:len(words)-take], " "))
+		}
+		break
+	}
+
+	// Otherwise the part after the street starts the city
+	if len(matches) == 0 && len(parts) > 1 {
+		city := strings.ToLower(strings.TrimSpace(parts[1]))
+		if city == "" {
+			return []string{}, nil
+		}
+
+		seen := make(map[string]bool)
+		for _, code := range p.codes {
+			key := code.City + code.Province
+			if strings.HasPrefix(strings.ToLower(code.City), city) && !seen[key] {
+				seen[key] = true
+				matches = append(matches, PostalCode{City: code.City, Province: code.Province, Lat: code.Lat, Lng: code.Lng})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return DistanceKm(bias.Lat, bias.Lng, matches[i].Lat, matches[i].Lng) < DistanceKm(bias.Lat, bias.Lng, matches[j].Lat, matches[j].Lng)
+	})
+
+	suggestions := make([]string, 0, SuggestionsLimit)
+	for _, match := range matches {
+		if len(suggestions) == SuggestionsLimit {
+			break
+		}
+		suggestions = append(suggestions, formatSuggestion("", street, match.City, match.Province, match.formatted()))
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,132 @@
+package geo
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Point is a place on the map
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// mapsStopsPerLink is how many stops Google Maps takes in one directions link, nine waypoints and the destination
+const mapsStopsPerLink = 10
+
+// PlanRoute orders the stops of a round leaving from origin and returns their indexes in visiting order.
+// A nearest-neighbour round is improved with 2-opt until no reversal makes it shorter. The round ends at
+// the last stop, the way back is left out.
+func PlanRoute(origin Point, stops []Point) []int {
+	points := append([]Point{origin}, stops...)
+
+	distances := make([][]float64, len(points))
+	for i := range points {
+		distances[i] = make([]float64, len(points))
+		for j := range points {
+			distances[i][j] = DistanceKm(points[i].Lat, points[i].Lng, points[j].Lat, points[j].Lng)
+		}
+	}
+
+	round := nearestNeighbour(distances)
+	twoOpt(round, distances)
+
+	order := make([]int, 0, len(stops))
+	for _, point := range round[1:] {
+		order = append(order, point-1)
+	}
+
+	return order
+}
+
+// nearestNeighbour starts at the origin and always drives to the closest stop not visited yet
+func nearestNeighbour(distances [][]float64) []int {
+	visited := make([]bool, len(distances))
+	visited[0] = true
+
+	round := []int{0}
+	for len(round) < len(distances) {
+		last := round[len(round)-1]
+		next := -1
+
+		for candidate := range distances {
+			if !visited[candidate] && (next == -1 || distances[last][candidate] < distances[last][next]) {
+				next = candidate
+			}
+		}
+
+		visited[next] = true
+		round = append(round, next)
+	}
+
+	return round
+}
+
+// twoOpt reverses stretches of the round while doing so shortens it, the origin stays first
+func twoOpt(round []int, distances [][]float64) {
+	const epsilon = 1e-9
+
+	// Each pass that improves the round shortens it, the cap only guards against floating point loops
+	for pass := 0; pass < 100*len(round); pass++ {
+		improved := false
+
+		for i := 1; i < len(round)-1; i++ {
+			for j := i + 1; j < len(round); j++ {
+				before := distances[round[i-1]][round[i]]
+				after := distances[round[i-1]][round[j]]
+
+				// The round is open, reversing up to the last stop leaves no edge after it
+				if j+1 < len(round) {
+					before += distances[round[j]][round[j+1]]
+					after += distances[round[i]][round[j+1]]
+				}
+
+				if after < before-epsilon {
+					for left, right := i, j; left < right; left, right = left+1, right-1 {
+						round[left], round[right] = round[right], round[left]
+					}
+					improved = true
+				}
+			}
+		}
+
+		if !improved {
+			return
+		}
+	}
+}
+
+func mapsCoordinates(point Point) string {
+	return fmt.Sprintf("%.6f,%.6f", point.Lat, point.Lng)
+}
+
+// MapsDirectionsURLs links Google Maps driving directions through the stops in order. Google takes
+// ten stops in a link, longer rounds are split in legs each starting where the previous one ended.
+func MapsDirectionsURLs(origin Point, stops []Point) []string {
+	var urls []string = make([]string, 0)
+
+	for start := 0; start < len(stops); start += mapsStopsPerLink {
+		leg := stops[start:min(start+mapsStopsPerLink, len(stops))]
+
+		waypoints := make([]string, 0, len(leg)-1)
+		for _, stop := range leg[:len(leg)-1] {
+			waypoints = append(waypoints, mapsCoordinates(stop))
+		}
+
+		query := url.Values{}
+		query.Set("api", "1")
+		query.Set("origin", mapsCoordinates(origin))
+		query.Set("destination", mapsCoordinates(leg[len(leg)-1]))
+		query.Set("travelmode", "driving")
+		if len(waypoints) > 0 {
+			query.Set("waypoints", strings.Join(waypoints, "|"))
+		}
+
+		urls = append(urls, "https://www.google.com/maps/dir/?"+query.Encode())
+
+		origin = leg[len(leg)-1]
+	}
+
+	return urls
+}
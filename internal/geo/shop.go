@@ -0,0 +1,10 @@
+package geo
+
+// Shop returns where the bakery is, deliveries are measured from there. It reads SHOP_LAT and SHOP_LNG,
+// falling back on the autocomplete bias.
+func Shop() (float64, float64) {
+	autocompleteMu.RLock()
+	defer autocompleteMu.RUnlock()
+
+	return envFloat("SHOP_LAT", bias.Lat), envFloat("SHOP_LNG", bias.Lng)
+}
@@ -0,0 +1,45 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	valkey "github.com/Desquaredp/go-valkey"
+	"github.com/Francesco99975/rosskery/internal/storage"
+)
+
+// ValkeyCache keeps geocoded addresses in Valkey for ttl
+type ValkeyCache struct {
+	ttl time.Duration
+}
+
+func NewValkeyCache(ttl time.Duration) *ValkeyCache {
+	return &ValkeyCache{ttl: ttl}
+}
+
+func (v *ValkeyCache) Get(ctx context.Context, key string) (*Address, bool, error) {
+	raw, err := storage.Valkey.Get(ctx, "geocode:"+key).Result()
+	if err == valkey.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var address Address
+	if err := json.Unmarshal([]byte(raw), &address); err != nil {
+		return nil, false, err
+	}
+
+	return &address, true, nil
+}
+
+func (v *ValkeyCache) Set(ctx context.Context, key string, address *Address) error {
+	raw, err := json.Marshal(address)
+	if err != nil {
+		return err
+	}
+
+	return storage.Valkey.Set(ctx, "geocode:"+key, raw, v.ttl).Err()
+}
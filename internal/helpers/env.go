@@ -0,0 +1,15 @@
+package helpers
+
+import (
+	"os"
+	"strconv"
+)
+
+// PositiveIntEnv reads a positive integer setting, the fallback is used when it is unset or invalid
+func PositiveIntEnv(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+
+	return fallback
+}
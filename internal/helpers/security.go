@@ -1,8 +1,13 @@
 package helpers
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
 )
 
 func GenerateNonce() (string, error) {
@@ -12,3 +17,45 @@ func GenerateNonce() (string, error) {
 	}
 	return base64.StdEncoding.EncodeToString(bytes), nil
 }
+
+// SignPickupCode appends an HMAC of the order id keyed by PICKUP_SECRET, so printed codes cannot be forged.
+// Without a secret the bare order id is used.
+func SignPickupCode(orderId string) string {
+	secret := os.Getenv("PICKUP_SECRET")
+	if secret == "" {
+		return orderId
+	}
+
+	return orderId + "." + pickupSignature(orderId, secret)
+}
+
+// VerifyPickupCode returns the order id of a scanned code, either a bare order id or a signed one
+func VerifyPickupCode(code string) (string, error) {
+	code = strings.TrimSpace(code)
+
+	orderId, signature, signed := strings.Cut(code, ".")
+	if !signed {
+		if orderId == "" {
+			return "", fmt.Errorf("empty pickup code")
+		}
+		return orderId, nil
+	}
+
+	secret := os.Getenv("PICKUP_SECRET")
+	if secret == "" {
+		return "", fmt.Errorf("signed pickup codes are not enabled")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(pickupSignature(orderId, secret))) {
+		return "", fmt.Errorf("invalid pickup code signature")
+	}
+
+	return orderId, nil
+}
+
+func pickupSignature(orderId string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(orderId))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,233 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// Snapshot returns the current state of the entity targeted by the request
+type Snapshot func(c echo.Context) (interface{}, error)
+
+const maxAuditedBody = int64(65536)
+
+// Audit records who performed a mutating admin action together with the state of
+// the entity before and after it. When no snapshot is available (e.g. creations)
+// the submitted payload is stored as the after state.
+func Audit(cm *models.ConnectionManager, action models.AuditAction, entity models.AuditEntity, snapshot Snapshot) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var before, after []byte
+
+			if snapshot != nil {
+				before = marshalSnapshot(c, snapshot)
+			}
+
+			payload := capturePayload(c)
+
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+
+			if snapshot != nil {
+				after = marshalSnapshot(c, snapshot)
+			} else {
+				after = payload
+			}
+
+			actor, _ := c.Get("userid").(string)
+
+			audit, auditErr := models.CreateAudit(actor, action, entity, c.Param("id"), before, after, c.Request().Method, c.Request().URL.Path, status, c.RealIP(), c.Request().UserAgent())
+			if auditErr != nil {
+				log.Errorf("Error recording audit <- %v", auditErr)
+				return err
+			}
+
+			rawAudit, marshalErr := json.Marshal(audit)
+			if marshalErr != nil {
+				log.Errorf("Error parsing audit <- %v", marshalErr)
+				return err
+			}
+
+			cm.BroadcastAdminEvent(models.Event{Type: models.EventAuditLogged, Payload: rawAudit})
+
+			return err
+		}
+	}
+}
+
+func marshalSnapshot(c echo.Context, snapshot Snapshot) []byte {
+	state, err := snapshot(c)
+	if err != nil || state == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Errorf("Error parsing audit snapshot <- %v", err)
+		return nil
+	}
+
+	return data
+}
+
+// capturePayload reads the request payload without consuming it for the handler.
+// Uploaded files are skipped and credentials are redacted.
+func capturePayload(c echo.Context) []byte {
+	req := c.Request()
+	contentType := req.Header.Get(echo.HeaderContentType)
+
+	var fields map[string]interface{}
+
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxAuditedBody))
+		// Only the captured part is audited, the handler still gets the whole body
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		if err != nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil
+		}
+	case strings.HasPrefix(contentType, echo.MIMEApplicationForm), strings.HasPrefix(contentType, echo.MIMEMultipartForm):
+		params, err := c.FormParams()
+		if err != nil {
+			return nil
+		}
+
+		fields = make(map[string]interface{}, len(params))
+		for key, values := range params {
+			if len(values) == 1 {
+				fields[key] = values[0]
+			} else {
+				fields[key] = values
+			}
+		}
+	default:
+		return nil
+	}
+
+	for key := range fields {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "csrf") || strings.Contains(lower, "token") {
+			fields[key] = "[redacted]"
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func ProductSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetProduct(c.Param("id"))
+}
+
+func CategorySnapshot(c echo.Context) (interface{}, error) {
+	return models.GetCategory(c.Param("id"))
+}
+
+func CustomerSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetCustomer(c.Param("id"))
+}
+
+func OrderSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetOrder(c.Param("id"))
+}
+
+// PickupSnapshot resolves the scanned code in the id param to its order
+func PickupSnapshot(c echo.Context) (interface{}, error) {
+	orderId, err := helpers.VerifyPickupCode(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return models.GetOrder(orderId)
+}
+
+func UserSnapshot(c echo.Context) (interface{}, error) {
+	user, err := models.GetUserById(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return user.ToUser()
+}
+
+func LedgerAccountSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetLedgerAccount(c.Param("id"))
+}
+
+func GiftCardSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetGiftCard(c.Param("id"))
+}
+
+// LoyaltySnapshot captures the points of the customer in the path
+func LoyaltySnapshot(c echo.Context) (interface{}, error) {
+	customer, err := models.GetCustomer(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return models.GetLoyaltyAccount(customer.Email)
+}
+
+func LoyaltyPromotionSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetLoyaltyPromotion(c.Param("id"))
+}
+
+func DeliveryZoneSnapshot(c echo.Context) (interface{}, error) {
+	return models.GetDeliveryZone(c.Param("id"))
+}
+
+func ClosedPeriodSnapshot(c echo.Context) (interface{}, error) {
+	day, err := time.Parse("2006-01-02", c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return models.GetClosedPeriod(day)
+}
+
+func SettingsSnapshot(ctx context.Context) Snapshot {
+	return func(c echo.Context) (interface{}, error) {
+		online, err := storage.Valkey.Get(ctx, string(storage.Online)).Bool()
+		if err != nil {
+			return nil, err
+		}
+		operative, err := storage.Valkey.Get(ctx, string(storage.Operative)).Bool()
+		if err != nil {
+			return nil, err
+		}
+		message, err := storage.Valkey.Get(ctx, string(storage.Message)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		return storage.Settings{Online: online, Operative: operative, Message: message}, nil
+	}
+}
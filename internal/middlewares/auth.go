@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 
 	"github.com/Francesco99975/rosskery/internal/helpers"
@@ -132,3 +133,35 @@ func CsrfIsRequestedFromWithinTheServer() echo.MiddlewareFunc {
 		}
 	}
 }
+
+// IsCustomer lets through storefront customers signed in to their account, sending the rest to sign in
+func IsCustomer() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			sess, err := session.Get("session", c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Server error on session")
+			}
+
+			accountID, ok := sess.Values["accountID"].(string)
+			if !ok || accountID == "" {
+				return c.Redirect(http.StatusSeeOther, "/account/login?m=signin")
+			}
+
+			account, err := models.GetAccount(accountID)
+			if err != nil {
+				// The account is gone, sign the session out
+				sess.Options = helpers.GetSessionOptions()
+				delete(sess.Values, "accountID")
+				if err := sess.Save(c.Request(), c.Response()); err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "Could not save session")
+				}
+				return c.Redirect(http.StatusSeeOther, "/account/login?m=signin")
+			}
+
+			c.Set("account", account)
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/geo"
+	"github.com/Francesco99975/rosskery/internal/storage"
+	"github.com/labstack/gommon/log"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAccountExists       = errors.New("an account already uses this email")
+	ErrWrongCredentials    = errors.New("wrong email or password")
+	ErrVerificationExpired = errors.New("the verification link is invalid or expired")
+)
+
+const accountVerificationTTL = 48 * time.Hour
+
+// Account is a customer signing in on the storefront. Accounts are kept apart from the staff users
+// so a storefront sign-up can never reach the admin. Once the email is verified the account takes over
+// the customer row of that email, guest orders included.
+type Account struct {
+	Id         string     `json:"id"`
+	Email      string     `json:"email"`
+	Password   string     `json:"-"`
+	Fullname   string     `json:"fullname"`
+	Phone      string     `json:"phone"`
+	Verified   bool       `json:"verified"`
+	CustomerId *string    `json:"customer_id" db:"customerid"`
+	LastLogin  *time.Time `json:"last_login" db:"lastlogin"`
+	Created    time.Time  `json:"created"`
+	Updated    time.Time  `json:"updated"`
+}
+
+type AccountAddress struct {
+	Id        string    `json:"id"`
+	AccountId string    `json:"account_id" db:"accountid"`
+	Label     string    `json:"label"`
+	Address   string    `json:"address"`
+	Preferred bool      `json:"preferred"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+
+	// Parts and coordinates of the address, geocoded when saved
+	Location `json:"location"`
+}
+
+// CheckoutPrefill is what the checkout form starts with for a signed in customer
+type CheckoutPrefill struct {
+	Email    string
+	Fullname string
+	Phone    string
+	Address  string
+}
+
+func normalizeAccountEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func CreateAccount(email string, password string, fullname string) (*Account, error) {
+	email = normalizeAccountEmail(email)
+
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, fmt.Errorf("invalid email")
+	}
+
+	if len(password) < 8 {
+		return nil, fmt.Errorf("password must be at least 8 characters")
+	}
+
+	if len(fullname) > 30 {
+		return nil, fmt.Errorf("name cannot be longer than 30 characters")
+	}
+
+	var exists bool
+	if err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM accounts WHERE email = $1)", email); err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, ErrAccountExists
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return nil, fmt.Errorf("error while hashing password: %v", err)
+	}
+
+	statement := "INSERT INTO accounts (id, email, password, fullname) VALUES ($1, $2, $3, $4)"
+
+	id := uuid.NewV4().String()
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, id, email, string(hashedPassword), strings.TrimSpace(fullname)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetAccount(id)
+}
+
+func GetAccount(id string) (*Account, error) {
+	var account Account
+
+	statement := "SELECT * FROM accounts WHERE id = $1"
+
+	err := db.Get(&account, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func GetAccountByEmail(email string) (*Account, error) {
+	var account Account
+
+	statement := "SELECT * FROM accounts WHERE email = $1"
+
+	err := db.Get(&account, statement, normalizeAccountEmail(email))
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// Authenticate signs a customer in, the error never tells whether the email has an account
+func Authenticate(email string, password string) (*Account, error) {
+	account, err := GetAccountByEmail(email)
+	if err != nil {
+		return nil, ErrWrongCredentials
+	}
+
+	if account.Password == "" || bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password)) != nil {
+		return nil, ErrWrongCredentials
+	}
+
+	return account, nil
+}
+
+// SignedIn records the login and, for a verified email, links the guest customer row on the first one
+func (a *Account) SignedIn() (*Account, error) {
+	if _, err := db.Exec("UPDATE accounts SET lastlogin = NOW() WHERE id = $1", a.Id); err != nil {
+		return nil, err
+	}
+
+	if a.Verified && a.CustomerId == nil {
+		return a.LinkCustomer("")
+	}
+
+	return GetAccount(a.Id)
+}
+
+// LinkCustomer ties a verified account to the customer row of its email, the given one when it is known.
+// A customer already linked to another account is left alone.
+func (a *Account) LinkCustomer(customerId string) (*Account, error) {
+	if !a.Verified {
+		return nil, fmt.Errorf("the account email is not verified")
+	}
+
+	statement := `UPDATE accounts SET customerid = (
+									SELECT c.id FROM customers c
+									WHERE LOWER(c.email) = $2 AND c.id != $3 AND ($4 = '' OR c.id = $4)
+										AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.customerid = c.id)
+									ORDER BY c.created ASC
+									LIMIT 1
+								)
+								WHERE id = $1 AND customerid IS NULL`
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, a.Id, a.Email, AnonymousCustomerId, customerId); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetAccount(a.Id)
+}
+
+// NewAccountVerification stores a single use token proving the account owns its email
+func (a *Account) NewAccountVerification(ctx context.Context) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(bytes)
+
+	if err := storage.Valkey.Set(ctx, "account:verify:"+token, a.Id, accountVerificationTTL).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyAccount consumes a verification token and marks its account verified
+func VerifyAccount(ctx context.Context, token string) (*Account, error) {
+	id, err := storage.Valkey.GetDel(ctx, "account:verify:"+token).Result()
+	if err != nil || id == "" {
+		return nil, ErrVerificationExpired
+	}
+
+	if _, err := db.Exec("UPDATE accounts SET verified = true WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+
+	return GetAccount(id)
+}
+
+func (a *Account) UpdateProfile(fullname string, phone string) (*Account, error) {
+	fullname = strings.TrimSpace(fullname)
+	phone = strings.TrimSpace(phone)
+
+	if len(fullname) > 30 {
+		return nil, fmt.Errorf("name cannot be longer than 30 characters")
+	}
+
+	if len(phone) > 15 {
+		return nil, fmt.Errorf("phone cannot be longer than 15 characters")
+	}
+
+	statement := "UPDATE accounts SET fullname = $1, phone = $2 WHERE id = $3"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, fullname, phone, a.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetAccount(a.Id)
+}
+
+func (a *Account) GetAddresses() ([]AccountAddress, error) {
+	var addresses []AccountAddress = make([]AccountAddress, 0)
+
+	statement := "SELECT * FROM account_addresses WHERE accountid = $1 ORDER BY preferred DESC, created ASC"
+
+	err := db.Select(&addresses, statement, a.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+// AddAddress saves an address, a preferred one replaces the previous preferred address
+func (a *Account) AddAddress(ctx context.Context, label string, address string, preferred bool) error {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return fmt.Errorf("address cannot be empty")
+	}
+
+	label = strings.TrimSpace(label)
+	if len(label) > 30 {
+		return fmt.Errorf("label cannot be longer than 30 characters")
+	}
+
+	location, err := geo.Geocode(ctx, address)
+	if err != nil {
+		log.Errorf("Error geocoding account address <- %v", err)
+		return fmt.Errorf("address is not a valid address")
+	}
+
+	tx := db.MustBegin()
+
+	if preferred {
+		if _, err := tx.Exec("UPDATE account_addresses SET preferred = false WHERE accountid = $1", a.Id); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	statement := `INSERT INTO account_addresses (id, accountid, label, address, preferred, street, unit, city, province, postalcode, country, lat, lng, formatted)
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+
+	if _, err := tx.Exec(statement, uuid.NewV4().String(), a.Id, label, address, preferred, location.Street, location.Unit, location.City, location.Province, location.PostalCode, location.Country, location.Lat, location.Lng, location.Formatted); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (a *Account) DeleteAddress(id string) error {
+	statement := "DELETE FROM account_addresses WHERE id = $1 AND accountid = $2"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, id, a.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// AccountOrder is an order as listed on the customer's account page
+type AccountOrder struct {
+	Order
+	Total int `json:"total"` // In cents, the purchases and delivery less the discounts
+}
+
+// GetOrders lists the orders of the linked customer, newest first
+func (a *Account) GetOrders() ([]AccountOrder, error) {
+	var orders []AccountOrder = make([]AccountOrder, 0)
+
+	if a.CustomerId == nil {
+		return orders, nil
+	}
+
+	var ids []string = make([]string, 0)
+
+	err := db.Select(&ids, "SELECT id FROM orders WHERE customer = $1 ORDER BY created DESC", *a.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		order, err := GetOrder(id)
+		if err != nil {
+			return nil, err
+		}
+
+		total, err := GetOrderTotal(id)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, AccountOrder{Order: *order, Total: max(total+order.DeliveryFee-order.Discount, 0)})
+	}
+
+	return orders, nil
+}
+
+// GetOrder returns one of the account's orders, refusing the orders of anyone else
+func (a *Account) GetOrder(id string) (*Order, error) {
+	order, err := GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.CustomerId == nil || order.Customer.Id != *a.CustomerId {
+		return nil, fmt.Errorf("order not found")
+	}
+
+	return order, nil
+}
+
+// Prefill fills the checkout from the profile and the preferred address, falling back to the linked customer
+func (a *Account) Prefill() (*CheckoutPrefill, error) {
+	prefill := &CheckoutPrefill{Email: a.Email, Fullname: a.Fullname, Phone: a.Phone}
+
+	addresses, err := a.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addresses) > 0 {
+		prefill.Address = addresses[0].Address
+	}
+
+	if a.CustomerId != nil && (prefill.Fullname == "" || prefill.Phone == "" || prefill.Address == "") {
+		customer, err := GetDbCustomer(*a.CustomerId)
+		if err != nil {
+			return nil, err
+		}
+
+		if prefill.Fullname == "" {
+			prefill.Fullname = customer.Fullname
+		}
+		if prefill.Phone == "" {
+			prefill.Phone = customer.Phone
+		}
+		if prefill.Address == "" {
+			prefill.Address = customer.Address
+		}
+	}
+
+	return prefill, nil
+}
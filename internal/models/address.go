@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/geo"
+)
+
+// Location is a structured address with its coordinates, embedded where an address is stored
+type Location = geo.Address
+
+// CustomerAddress is an address a customer ordered to, kept with its parts and coordinates
+type CustomerAddress struct {
+	Id         int    `json:"id"`
+	CustomerId string `json:"customer_id" db:"customerid"`
+	Label      string `json:"label"`
+	Location
+	Preferred bool      `json:"preferred"`
+	LastUsed  time.Time `json:"last_used" db:"lastused"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// GeocodeCache keeps geocoded addresses in Postgres, for when they should outlive a Valkey flush
+type GeocodeCache struct {
+	ttl time.Duration
+}
+
+func NewGeocodeCache(ttl time.Duration) *GeocodeCache {
+	return &GeocodeCache{ttl: ttl}
+}
+
+func (g *GeocodeCache) Get(ctx context.Context, key string) (*geo.Address, bool, error) {
+	var raw []byte
+
+	statement := "SELECT address FROM geocodes WHERE query = $1 AND created > $2"
+
+	err := db.GetContext(ctx, &raw, statement, key, time.Now().Add(-g.ttl))
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var address geo.Address
+	if err := json.Unmarshal(raw, &address); err != nil {
+		return nil, false, err
+	}
+
+	return &address, true, nil
+}
+
+func (g *GeocodeCache) Set(ctx context.Context, key string, address *geo.Address) error {
+	raw, err := json.Marshal(address)
+	if err != nil {
+		return err
+	}
+
+	statement := `INSERT INTO geocodes (query, address) VALUES ($1, $2)
+								ON CONFLICT (query) DO UPDATE SET address = EXCLUDED.address, created = NOW()`
+
+	_, err = db.ExecContext(ctx, statement, key, raw)
+
+	return err
+}
+
+func GetCustomerAddresses(customerId string) ([]CustomerAddress, error) {
+	var addresses []CustomerAddress = make([]CustomerAddress, 0)
+
+	statement := "SELECT * FROM customer_addresses WHERE customerid = $1 ORDER BY preferred DESC, lastused DESC"
+
+	err := db.Select(&addresses, statement, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+func GetCustomerAddress(id int) (*CustomerAddress, error) {
+	var address CustomerAddress
+
+	statement := "SELECT * FROM customer_addresses WHERE id = $1"
+
+	err := db.Get(&address, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &address, nil
+}
+
+// SaveCustomerAddress remembers an address the customer ordered to, the same address used again is only
+// marked as used. The first address saved becomes the customer's preferred one.
+func SaveCustomerAddress(customerId string, address geo.Address) (*CustomerAddress, error) {
+	if customerId == AnonymousCustomerId {
+		return nil, fmt.Errorf("the walk-in customer keeps no addresses")
+	}
+
+	tx := db.MustBegin()
+
+	rollback := func(err error) (*CustomerAddress, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	var saved CustomerAddress
+
+	err := tx.Get(&saved, "UPDATE customer_addresses SET lastused = NOW() WHERE customerid = $1 AND LOWER(formatted) = LOWER($2) RETURNING *", customerId, address.Formatted)
+	if err == sql.ErrNoRows {
+		statement := `INSERT INTO customer_addresses (customerid, street, unit, city, province, postalcode, country, lat, lng, formatted, preferred)
+									VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOT EXISTS(SELECT 1 FROM customer_addresses WHERE customerid = $1))
+									RETURNING *`
+
+		err = tx.Get(&saved, statement, customerId, address.Street, address.Unit, address.City, address.Province, address.PostalCode, address.Country, address.Lat, address.Lng, address.Formatted)
+	}
+	if err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return &saved, nil
+}
+
+// SetAddress records where the order goes
+func (o *Order) SetAddress(addressId int) (*Order, error) {
+	if _, err := db.Exec("UPDATE orders SET addressid = $1 WHERE id = $2", addressId, o.Id); err != nil {
+		return nil, err
+	}
+
+	return GetOrder(o.Id)
+}
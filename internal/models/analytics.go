@@ -138,6 +138,7 @@ func (m *ConnectionManager) setupEventHandlers() {
 	m.handlers[EventRemoveProduct] = SendRemoveProductHandler
 	m.handlers[EventOrdersChanged] = SendAdminUpdateHandler
 	m.handlers[EventCustomersChanged] = SendAdminUpdateHandler
+	m.handlers[EventAuditLogged] = SendAdminUpdateHandler
 }
 
 // routeEvent is used to make sure the correct event goes into the correct handler
@@ -160,6 +161,15 @@ func (cm *ConnectionManager) BroadcastEvent(event Event) {
 	}
 }
 
+// BroadcastAdminEvent sends the event only to clients authenticated in the admin room
+func (cm *ConnectionManager) BroadcastAdminEvent(event Event) {
+	for client := range cm.clients {
+		if client.room == "admin" {
+			client.egress <- event
+		}
+	}
+}
+
 func (cm *ConnectionManager) Run() {
 	for {
 		select {
@@ -267,7 +277,7 @@ func (client *Client) read() {
 		log.Debugf("event received: %v", request)
 
 		if err := client.manager.routeEvent(request, client); err != nil {
-			log.Errorf("Error handeling Message: ", err)
+			log.Errorf("Error handeling Message: %v", err)
 		}
 	}
 }
@@ -308,7 +318,7 @@ func (client *Client) write() {
 			log.Debug("Ping")
 			// Send the Ping
 			if err := client.socket.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-				log.Errorf("writemsg: ", err)
+				log.Errorf("writemsg: %v", err)
 				return // return to break this goroutine triggeing cleanup
 			}
 		}
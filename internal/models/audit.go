@@ -0,0 +1,213 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete"
+	AuditFulfill AuditAction = "fulfill"
+	AuditCollect AuditAction = "collect"
+	AuditPickup  AuditAction = "pickup"
+	AuditCancel  AuditAction = "cancel"
+	AuditRefund  AuditAction = "refund"
+	AuditVoid    AuditAction = "void"
+	AuditAdjust  AuditAction = "adjust"
+	AuditExport  AuditAction = "export"
+	AuditErase   AuditAction = "erase"
+	AuditMerge   AuditAction = "merge"
+	AuditUndo    AuditAction = "undo"
+	AuditPrint   AuditAction = "print"
+	AuditScan    AuditAction = "scan"
+)
+
+type AuditEntity string
+
+const (
+	AuditProduct  AuditEntity = "product"
+	AuditCategory AuditEntity = "category"
+	AuditCustomer AuditEntity = "customer"
+	AuditOrder    AuditEntity = "order"
+	AuditUser     AuditEntity = "user"
+	AuditSetting  AuditEntity = "setting"
+	AuditMessage  AuditEntity = "message"
+	AuditAccount  AuditEntity = "account"
+	AuditPeriod   AuditEntity = "period"
+	AuditCash     AuditEntity = "cash"
+	AuditGiftCard AuditEntity = "giftcard"
+	AuditLoyalty  AuditEntity = "loyalty"
+	AuditDelivery AuditEntity = "delivery"
+	AuditPrinter  AuditEntity = "printer"
+	AuditTicket   AuditEntity = "ticket"
+)
+
+type Audit struct {
+	Id        string           `json:"id"`
+	Actor     string           `json:"actor"`
+	ActorName string           `json:"actor_name" db:"actor_name"`
+	Action    string           `json:"action"`
+	Entity    string           `json:"entity"`
+	EntityId  string           `json:"entity_id" db:"entityid"`
+	Before    *json.RawMessage `json:"before"`
+	After     *json.RawMessage `json:"after"`
+	Method    string           `json:"method"`
+	Path      string           `json:"path"`
+	Status    int              `json:"status"`
+	Ip        string           `json:"ip"`
+	Agent     string           `json:"agent"`
+	Created   time.Time        `json:"created"`
+}
+
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityId string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+func CreateAudit(actor string, action AuditAction, entity AuditEntity, entityId string, before []byte, after []byte, method string, path string, status int, ip string, agent string) (*Audit, error) {
+	statement := "INSERT INTO audits (id, actor, action, entity, entityid, before, after, method, path, status, ip, agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
+
+	id := uuid.NewV4().String()
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, id, actor, action, entity, entityId, nullableJSON(before), nullableJSON(after), method, path, status, ip, agent); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetAudit(id)
+}
+
+func GetAudit(id string) (*Audit, error) {
+	var audit Audit
+
+	statement := `SELECT
+									a.id AS id,
+									a.actor AS actor,
+									COALESCE(u.username, '') AS actor_name,
+									a.action AS action,
+									a.entity AS entity,
+									a.entityid AS entityid,
+									a.before AS before,
+									a.after AS after,
+									a.method AS method,
+									a.path AS path,
+									a.status AS status,
+									a.ip AS ip,
+									a.agent AS agent,
+									a.created AS created
+								FROM audits a
+								LEFT JOIN users u ON a.actor = u.id
+								WHERE a.id = $1`
+
+	err := db.Get(&audit, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &audit, nil
+}
+
+func GetAudits(filter AuditFilter) ([]Audit, error) {
+	var audits []Audit = make([]Audit, 0)
+
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Actor != "" {
+		addCondition("(a.actor = $%[1]d OR u.username = $%[1]d)", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("a.action = $%d", filter.Action)
+	}
+	if filter.Entity != "" {
+		addCondition("a.entity = $%d", filter.Entity)
+	}
+	if filter.EntityId != "" {
+		addCondition("a.entityid = $%d", filter.EntityId)
+	}
+	if !filter.From.IsZero() {
+		addCondition("a.created >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("a.created < $%d", filter.To)
+	}
+
+	whereStm := ""
+	if len(conditions) > 0 {
+		whereStm = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+
+	statement := `SELECT
+									a.id AS id,
+									a.actor AS actor,
+									COALESCE(u.username, '') AS actor_name,
+									a.action AS action,
+									a.entity AS entity,
+									a.entityid AS entityid,
+									a.before AS before,
+									a.after AS after,
+									a.method AS method,
+									a.path AS path,
+									a.status AS status,
+									a.ip AS ip,
+									a.agent AS agent,
+									a.created AS created
+								FROM audits a
+								LEFT JOIN users u ON a.actor = u.id
+								` + whereStm + `
+								ORDER BY a.created DESC
+								LIMIT $` + fmt.Sprint(len(args)-1) + ` OFFSET $` + fmt.Sprint(len(args))
+
+	err := db.Select(&audits, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+}
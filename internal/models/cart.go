@@ -63,6 +63,17 @@ func (c *Cart) Preview(ctx context.Context) (CartPreview, error) {
 	return preview, nil
 }
 
+// HasGiftCards tells whether gift cards are among the items, they have to be paid online
+func (p *CartPreview) HasGiftCards() bool {
+	for _, item := range p.Items {
+		if item.Product.IsGiftCard() {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Cart) Purchases() ([]PurchasedItem, error) {
 	purchases := make([]PurchasedItem, 0)
 
@@ -135,6 +146,32 @@ func (c *Cart) Len() int {
 	return len
 }
 
+// CartId is the key of the cart a session fills, signed in customers keep one cart across their devices
+func CartId(sessionID string, accountID string) string {
+	if accountID == "" {
+		return sessionID
+	}
+
+	return "cart:account:" + accountID
+}
+
+// Merge moves the items of a guest cart into this one and empties the guest cart
+func (c *Cart) Merge(ctx context.Context, guest *Cart) error {
+	if guest.Id == c.Id || len(guest.Items) == 0 {
+		return nil
+	}
+
+	for productId, quantity := range guest.Items {
+		c.Items[productId] += quantity
+	}
+
+	if err := c.Save(ctx); err != nil {
+		return err
+	}
+
+	return guest.Clear(ctx)
+}
+
 func GetCart(ctx context.Context, sessionID string) (*Cart, error) {
 	cart := &Cart{Id: sessionID, Items: make(map[string]int, 0)}
 	cartData, err := storage.Valkey.Get(ctx, sessionID).Result()
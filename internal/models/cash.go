@@ -0,0 +1,276 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type CashCollection struct {
+	Id          string    `json:"id"`
+	OrderId     string    `json:"order_id" db:"orderid"`
+	CollectedBy string    `json:"collected_by" db:"collectedby"`
+	Amount      int       `json:"amount"`
+	Tendered    int       `json:"tendered"`
+	Change      int       `json:"change"`
+	Created     time.Time `json:"created"`
+}
+
+// CollectCash records the cash handed over for a pay-at-pickup order and marks it fulfilled
+func (o *Order) CollectCash(userId string, tendered int) (*CashCollection, error) {
+	if PaymentMethod(o.Method) != CASH {
+		return nil, fmt.Errorf("order %s is not paid in cash", o.Id)
+	}
+
+	total, err := GetOrderTotal(o.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := max(total+o.DeliveryFee-o.Discount, 0)
+
+	if tendered < amount {
+		return nil, fmt.Errorf("tendered %d is less than the %d due", tendered, amount)
+	}
+
+	collection := &CashCollection{Id: uuid.NewV4().String(), OrderId: o.Id, CollectedBy: userId, Amount: amount, Tendered: tendered, Change: tendered - amount}
+
+	tx := db.MustBegin()
+
+	statement := "INSERT INTO cash_collections (id, orderid, collectedby, amount, tendered, change) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (orderid) DO NOTHING"
+
+	result, err := tx.Exec(statement, collection.Id, collection.OrderId, collection.CollectedBy, collection.Amount, collection.Tendered, collection.Change)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, ErrOrderCollected
+	}
+
+	result, err = tx.Exec("UPDATE orders SET fulfilled = true WHERE id = $1 AND cancelled = false", o.Id)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, ErrOrderCancelled
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	rewardLoyalty(o.Id)
+
+	return GetCashCollection(o.Id)
+}
+
+func GetCashCollection(orderId string) (*CashCollection, error) {
+	var collection CashCollection
+
+	statement := "SELECT * FROM cash_collections WHERE orderid = $1"
+
+	err := db.Get(&collection, statement, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+type CashCount struct {
+	Id      string    `json:"id"`
+	Day     time.Time `json:"day"`
+	UserId  string    `json:"user_id" db:"userid"`
+	Counted int       `json:"counted"`
+	Notes   string    `json:"notes"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// RecordCashCount stores what a staff member counted in the drawer at the end of the day, replacing a previous count
+func RecordCashCount(day time.Time, userId string, counted int, notes string) (*CashCount, error) {
+	statement := `INSERT INTO cash_counts (id, day, userid, counted, notes) VALUES ($1, $2, $3, $4, $5)
+								ON CONFLICT (day, userid) DO UPDATE SET counted = EXCLUDED.counted, notes = EXCLUDED.notes`
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, uuid.NewV4().String(), day.Format("2006-01-02"), userId, counted, notes); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	var count CashCount
+
+	err := db.Get(&count, "SELECT * FROM cash_counts WHERE day = $1 AND userid = $2", day.Format("2006-01-02"), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &count, nil
+}
+
+type CashClosingLine struct {
+	UserId      string `json:"user_id"`
+	Username    string `json:"username"`
+	Collections int    `json:"collections"`
+	Expected    int    `json:"expected"`
+	Tendered    int    `json:"tendered"`
+	Change      int    `json:"change"`
+	Counted     *int   `json:"counted"`
+	Discrepancy int    `json:"discrepancy"`
+	Flagged     bool   `json:"flagged"`
+	Notes       string `json:"notes"`
+}
+
+type CashClosingReport struct {
+	Day         string            `json:"day"`
+	Lines       []CashClosingLine `json:"lines"`
+	Expected    int               `json:"expected"`
+	Counted     int               `json:"counted"`
+	Discrepancy int               `json:"discrepancy"`
+	Tolerance   int               `json:"tolerance"`
+	Flagged     bool              `json:"flagged"`
+	Outstanding int               `json:"outstanding"` // Cash still owed by unfulfilled orders
+}
+
+// GetCashClosingReport compares the cash each staff member collected on a local day with what they counted.
+// Lines whose difference exceeds CASH_TOLERANCE, or that were never counted, are flagged.
+func GetCashClosingReport(day time.Time, loc *time.Location) (*CashClosingReport, error) {
+	type collectedRow struct {
+		UserId      string `db:"userid"`
+		Username    string
+		Collections int
+		Expected    int
+		Tendered    int
+		Change      int
+	}
+
+	type countedRow struct {
+		UserId   string `db:"userid"`
+		Username string
+		Counted  int
+		Notes    string
+	}
+
+	starts := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	ends := starts.AddDate(0, 0, 1)
+
+	var collected []collectedRow = make([]collectedRow, 0)
+	var counted []countedRow = make([]countedRow, 0)
+
+	statement := `SELECT cc.collectedby AS userid,
+										COALESCE(u.username, '') AS username,
+										COUNT(*) AS collections,
+										COALESCE(SUM(cc.amount), 0) AS expected,
+										COALESCE(SUM(cc.tendered), 0) AS tendered,
+										COALESCE(SUM(cc.change), 0) AS change
+									FROM cash_collections cc
+									LEFT JOIN users u ON cc.collectedby = u.id
+									WHERE cc.created >= $1 AND cc.created < $2
+									GROUP BY cc.collectedby, u.username`
+
+	err := db.Select(&collected, statement, starts.UTC(), ends.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	statement = `SELECT c.userid AS userid,
+									COALESCE(u.username, '') AS username,
+									c.counted AS counted,
+									c.notes AS notes
+								FROM cash_counts c
+								LEFT JOIN users u ON c.userid = u.id
+								WHERE c.day = $1`
+
+	err = db.Select(&counted, statement, starts.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding, err := GetOutstandingCash("")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CashClosingReport{Day: starts.Format("2006-01-02"), Tolerance: getCashTolerance(), Outstanding: outstanding}
+	lines := make(map[string]*CashClosingLine)
+
+	for _, row := range collected {
+		lines[row.UserId] = &CashClosingLine{UserId: row.UserId, Username: row.Username, Collections: row.Collections, Expected: row.Expected, Tendered: row.Tendered, Change: row.Change}
+	}
+
+	for _, row := range counted {
+		line, ok := lines[row.UserId]
+		if !ok {
+			line = &CashClosingLine{UserId: row.UserId, Username: row.Username}
+			lines[row.UserId] = line
+		}
+		value := row.Counted
+		line.Counted = &value
+		line.Notes = row.Notes
+	}
+
+	report.Lines = make([]CashClosingLine, 0, len(lines))
+	for _, line := range lines {
+		if line.Counted == nil {
+			line.Discrepancy = -line.Expected
+			line.Flagged = line.Expected != 0
+		} else {
+			line.Discrepancy = *line.Counted - line.Expected
+			line.Flagged = line.Discrepancy > report.Tolerance || line.Discrepancy < -report.Tolerance
+			report.Counted += *line.Counted
+		}
+
+		report.Expected += line.Expected
+		report.Flagged = report.Flagged || line.Flagged
+		report.Lines = append(report.Lines, *line)
+	}
+
+	sort.Slice(report.Lines, func(i, j int) bool {
+		return report.Lines[i].Username < report.Lines[j].Username
+	})
+
+	report.Discrepancy = report.Counted - report.Expected
+
+	return report, nil
+}
+
+// getCashTolerance reads CASH_TOLERANCE, the discrepancy in cents accepted without flagging
+func getCashTolerance() int {
+	tolerance, err := strconv.Atoi(os.Getenv("CASH_TOLERANCE"))
+	if err != nil || tolerance < 0 {
+		return 0
+	}
+
+	return tolerance
+}
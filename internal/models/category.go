@@ -1,9 +1,14 @@
 package models
 
 import (
+	"fmt"
+
 	uuid "github.com/satori/go.uuid"
 )
 
+// GiftCardCategoryId is the seeded category of the products sold as gift cards
+const GiftCardCategoryId = "giftcards"
+
 type Category struct {
 	Id   string `json:"id"`
 	Name string `json:"name"`
@@ -113,6 +118,10 @@ func (category *Category) Update(name string) error {
 }
 
 func (category *Category) Delete() ([]Category, error) {
+	if category.Id == GiftCardCategoryId {
+		return nil, fmt.Errorf("the gift cards category cannot be deleted")
+	}
+
 	statement := "DELETE FROM categories WHERE id = $1"
 
 	tx := db.MustBegin()
@@ -5,28 +5,37 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
 )
 
+// AnonymousCustomerId is the shared customer of walk-in sales made without contact details
+const AnonymousCustomerId = "anonymous"
+
 type DbCustomer struct {
-	Id       string    `json:"id"`
-	Fullname string    `json:"fullname"`
-	Email    string    `json:"email"`
-	Address  string    `json:"address"`
-	Phone    string    `json:"phone"`
-	Created  time.Time `json:"created"`
-	Updated  time.Time `json:"updated"`
+	Id       string     `json:"id"`
+	Fullname string     `json:"fullname"`
+	Email    string     `json:"email"`
+	Address  string     `json:"address"`
+	Phone    string     `json:"phone"`
+	Created  time.Time  `json:"created"`
+	Updated  time.Time  `json:"updated"`
+	Referral string     `json:"referral"` // Code the customer shares to refer others
+	Erased   *time.Time `json:"erased"`   // When the personal details were erased on request
 }
 
 type Customer struct {
-	Id          string    `json:"id"`
-	Fullname    string    `json:"fullname"`
-	Email       string    `json:"email"`
-	Address     string    `json:"address"`
-	Phone       string    `json:"phone"`
-	Created     time.Time `json:"created"`
-	LastOrdered time.Time `json:"last_ordered" db:"last_ordered"`
-	TotalSpent  int       `json:"total_spent" db:"total_spent"`
+	Id          string         `json:"id"`
+	Fullname    string         `json:"fullname"`
+	Email       string         `json:"email"`
+	Address     string         `json:"address"`
+	Phone       string         `json:"phone"`
+	Created     time.Time      `json:"created"`
+	LastOrdered time.Time      `json:"last_ordered" db:"last_ordered"`
+	TotalSpent  int            `json:"total_spent" db:"total_spent"`
+	Referral    string         `json:"referral"`
+	Erased      *time.Time     `json:"erased"`
+	Tags        pq.StringArray `json:"tags"`
 }
 
 func (dbp *DbCustomer) ConvertToCustomer(lastOrdered time.Time, totalSpent int) *Customer {
@@ -39,6 +48,9 @@ func (dbp *DbCustomer) ConvertToCustomer(lastOrdered time.Time, totalSpent int)
 		Created:     dbp.Created,
 		LastOrdered: lastOrdered,
 		TotalSpent:  totalSpent,
+		Referral:    dbp.Referral,
+		Erased:      dbp.Erased,
+		Tags:        make(pq.StringArray, 0),
 	}
 }
 
@@ -55,13 +67,13 @@ func CustomerExists(email string) (bool, error) {
 }
 
 func CreateCustomer(fullname string, email string, address string, phone string) (*DbCustomer, error) {
-	statement := "INSERT INTO customers (id, fullname, email, address, phone) VALUES ($1, $2, $3, $4, $5)"
+	statement := "INSERT INTO customers (id, fullname, email, address, phone) VALUES ($1, $2, $3, $4, $5) RETURNING referral"
 
 	tx := db.MustBegin()
 
 	c := &DbCustomer{Id: uuid.NewV4().String(), Fullname: fullname, Email: email, Address: address, Phone: phone}
 
-	if _, err := tx.Exec(statement, c.Id, c.Fullname, c.Email, c.Address, c.Phone); err != nil {
+	if err := tx.Get(&c.Referral, statement, c.Id, c.Fullname, c.Email, c.Address, c.Phone); err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			return nil, rollbackErr
 		}
@@ -79,6 +91,11 @@ func CreateCustomer(fullname string, email string, address string, phone string)
 }
 
 func GetCustomers() ([]Customer, error) {
+	return GetTaggedCustomers(nil)
+}
+
+// GetTaggedCustomers lists the customers carrying every one of the tags, all of them when there are none
+func GetTaggedCustomers(tags []string) ([]Customer, error) {
 	var customers []Customer = make([]Customer, 0)
 
 	statement := `SELECT
@@ -88,6 +105,9 @@ func GetCustomers() ([]Customer, error) {
 									c.address as address,
 									c.phone as phone,
 									c.created as created,
+									c.referral as referral,
+									c.erased as erased,
+									ARRAY(SELECT t.tag FROM customer_tags t WHERE t.customerid = c.id ORDER BY t.tag) AS tags,
 									MAX(o.created) AS last_ordered,
 									COALESCE(SUM(p.quantity * pr.price), 0) AS total_spent
 								FROM
@@ -98,12 +118,14 @@ func GetCustomers() ([]Customer, error) {
 										purchases p ON o.id = p.orderid
 								LEFT JOIN
 										products pr ON p.productid = pr.id
+								WHERE
+										(SELECT COUNT(*) FROM customer_tags t WHERE t.customerid = c.id AND t.tag = ANY($1)) = CARDINALITY($1::TEXT[])
 								GROUP BY
 										c.id, c.fullname, c.email
 								ORDER BY
 										c.fullname ASC`
 
-	err := db.Select(&customers, statement)
+	err := db.Select(&customers, statement, pq.Array(NormalizeTags(tags)))
 
 	if err != nil {
 		return nil, err
@@ -122,6 +144,9 @@ func GetCustomer(id string) (*Customer, error) {
 									c.address as address,
 									c.phone as phone,
 									c.created as created,
+									c.referral as referral,
+									c.erased as erased,
+									ARRAY(SELECT t.tag FROM customer_tags t WHERE t.customerid = c.id ORDER BY t.tag) AS tags,
 									MAX(o.created) AS last_ordered,
 									COALESCE(SUM(p.quantity * pr.price), 0) AS total_spent
 								FROM
@@ -203,6 +228,20 @@ func (customer *DbCustomer) Update(fullname string, email string, address string
 }
 
 func (customer *Customer) Delete() ([]Customer, error) {
+	if customer.Id == AnonymousCustomerId {
+		return nil, fmt.Errorf("the walk-in customer cannot be deleted")
+	}
+
+	// Deleting cascades to the orders, the tax records need them kept
+	var ordered bool
+	if err := db.Get(&ordered, "SELECT EXISTS(SELECT 1 FROM orders WHERE customer = $1)", customer.Id); err != nil {
+		return nil, err
+	}
+
+	if ordered {
+		return nil, fmt.Errorf("the customer has orders, erase their personal details instead")
+	}
+
 	statement := "DELETE FROM customers WHERE id = $1"
 
 	tx := db.MustBegin()
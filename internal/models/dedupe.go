@@ -0,0 +1,560 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+type DuplicateStatus string
+
+const (
+	DUPLICATE_PENDING   DuplicateStatus = "pending"
+	DUPLICATE_DISMISSED DuplicateStatus = "dismissed"
+)
+
+// Scores out of 100 each signal adds to a suggested pair, a pair needs duplicateThreshold to be suggested
+const (
+	duplicatePhoneScore   = 50
+	duplicateAddressScore = 30
+	duplicateEmailScore   = 30
+	duplicateNameScore    = 20
+	duplicateThreshold    = 50
+	// Names at least this similar count as the same person spelled differently
+	duplicateNameSimilarity = 0.85
+	// Emails at most this many edits apart count as a typo
+	duplicateEmailEdits = 2
+)
+
+// CustomerDuplicate is a pair of customers the detection thinks are the same person
+type CustomerDuplicate struct {
+	Id        int       `json:"id"`
+	CustomerA string    `json:"customer_a" db:"customera"`
+	CustomerB string    `json:"customer_b" db:"customerb"`
+	Score     int       `json:"score"`
+	Reasons   string    `json:"reasons"` // Comma separated signals that matched
+	Status    string    `json:"status"`
+	Actor     string    `json:"actor"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+type DuplicateSuggestion struct {
+	CustomerDuplicate
+	First  *Customer `json:"first"`
+	Second *Customer `json:"second"`
+}
+
+// MergeMoves lists what a merge moved over to the surviving customer so it can be moved back
+type MergeMoves struct {
+	Orders    []string `json:"orders"`
+	Referrals []string `json:"referrals"`  // Referrals made by the merged customer
+	Referred  string   `json:"referred"`   // Referral the merged customer was brought in by
+	Account   string   `json:"account"`    // Account moved to the survivor
+	Unlinked  string   `json:"unlinked"`   // Account unlinked because the survivor had one already
+	Loyalty   []int    `json:"loyalty"`    // Loyalty entries moved to the survivor's email
+	Ips       []string `json:"ips"`        // Addresses the merged customer ordered from
+	AddedIps  []string `json:"added_ips"`  // Of those, the ones the survivor did not have
+	Notes     []int    `json:"notes"`      // Notes moved to the survivor
+	Tags      []string `json:"tags"`       // Tags the merged customer carried
+	AddedTags []string `json:"added_tags"` // Of those, the ones the survivor did not have
+	Addresses []int    `json:"addresses"`  // Saved addresses moved to the survivor
+}
+
+// CustomerMerge is the undo record of a merge
+type CustomerMerge struct {
+	Id       int             `json:"id"`
+	Survivor string          `json:"survivor"`
+	Merged   string          `json:"merged"`
+	Snapshot json.RawMessage `json:"snapshot"` // The merged customer row as it was
+	Moves    json.RawMessage `json:"moves"`
+	Actor    string          `json:"actor"`
+	Undone   *time.Time      `json:"undone"`
+	Created  time.Time       `json:"created"`
+}
+
+// normalizeName lowercases a name and sorts its words so "Rossi Mario" and "mario rossi" compare equal
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	sort.Strings(words)
+
+	return strings.Join(words, " ")
+}
+
+// levenshtein counts the single character edits turning a into b
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+// nameSimilarity is 1 for the same normalised name down to 0 for nothing in common
+func nameSimilarity(a string, b string) float64 {
+	a, b = normalizeName(a), normalizeName(b)
+
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 0
+	}
+
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// scoreDuplicate weighs the signals two customers share, with the names of those that matched
+func scoreDuplicate(a *DbCustomer, b *DbCustomer) (int, []string) {
+	score := 0
+	reasons := make([]string, 0)
+
+	if phone := NormalizePhone(a.Phone); phone != "" && phone == NormalizePhone(b.Phone) {
+		score += duplicatePhoneScore
+		reasons = append(reasons, "phone")
+	}
+
+	if address := NormalizeAddress(a.Address); address != "" && address == NormalizeAddress(b.Address) {
+		score += duplicateAddressScore
+		reasons = append(reasons, "address")
+	}
+
+	emailA, emailB := strings.ToLower(strings.TrimSpace(a.Email)), strings.ToLower(strings.TrimSpace(b.Email))
+	if emailA != "" && emailB != "" && levenshtein(emailA, emailB) <= duplicateEmailEdits {
+		score += duplicateEmailScore
+		reasons = append(reasons, "email")
+	}
+
+	if similarity := nameSimilarity(a.Fullname, b.Fullname); similarity >= duplicateNameSimilarity {
+		score += int(float64(duplicateNameScore) * similarity)
+		reasons = append(reasons, "name")
+	}
+
+	return score, reasons
+}
+
+// DetectDuplicateCustomers suggests pairs of customers that look like the same person. Only customers sharing
+// a phone, an address or a name are compared, pairs already reviewed keep their status.
+func DetectDuplicateCustomers() (int, error) {
+	var customers []DbCustomer = make([]DbCustomer, 0)
+
+	if err := db.Select(&customers, "SELECT * FROM customers WHERE id != $1 AND erased IS NULL", AnonymousCustomerId); err != nil {
+		return 0, err
+	}
+
+	blocks := make(map[string][]int)
+	for i, customer := range customers {
+		if phone := NormalizePhone(customer.Phone); phone != "" {
+			blocks["phone:"+phone] = append(blocks["phone:"+phone], i)
+		}
+		if address := NormalizeAddress(customer.Address); address != "" {
+			blocks["address:"+address] = append(blocks["address:"+address], i)
+		}
+		if name := normalizeName(customer.Fullname); name != "" {
+			blocks["name:"+name] = append(blocks["name:"+name], i)
+		}
+	}
+
+	compared := make(map[[2]int]bool)
+	suggested := 0
+
+	statement := `INSERT INTO customer_duplicates (customera, customerb, score, reasons) VALUES ($1, $2, $3, $4)
+								ON CONFLICT (customera, customerb) DO UPDATE SET score = EXCLUDED.score, reasons = EXCLUDED.reasons
+								WHERE customer_duplicates.status = 'pending'`
+
+	for _, members := range blocks {
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				pair := [2]int{min(members[x], members[y]), max(members[x], members[y])}
+				if compared[pair] {
+					continue
+				}
+				compared[pair] = true
+
+				a, b := &customers[pair[0]], &customers[pair[1]]
+				if a.Id > b.Id {
+					a, b = b, a
+				}
+
+				score, reasons := scoreDuplicate(a, b)
+				if score < duplicateThreshold {
+					continue
+				}
+
+				if _, err := db.Exec(statement, a.Id, b.Id, score, strings.Join(reasons, ",")); err != nil {
+					return suggested, err
+				}
+				suggested++
+			}
+		}
+	}
+
+	return suggested, nil
+}
+
+func GetDuplicateSuggestions() ([]DuplicateSuggestion, error) {
+	var duplicates []CustomerDuplicate = make([]CustomerDuplicate, 0)
+
+	statement := "SELECT * FROM customer_duplicates WHERE status = $1 ORDER BY score DESC, created ASC"
+
+	if err := db.Select(&duplicates, statement, DUPLICATE_PENDING); err != nil {
+		return nil, err
+	}
+
+	var suggestions []DuplicateSuggestion = make([]DuplicateSuggestion, 0, len(duplicates))
+
+	for _, duplicate := range duplicates {
+		first, err := GetCustomer(duplicate.CustomerA)
+		if err != nil {
+			return nil, err
+		}
+
+		second, err := GetCustomer(duplicate.CustomerB)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, DuplicateSuggestion{CustomerDuplicate: duplicate, First: first, Second: second})
+	}
+
+	return suggestions, nil
+}
+
+func GetCustomerDuplicate(id string) (*CustomerDuplicate, error) {
+	var duplicate CustomerDuplicate
+
+	statement := "SELECT * FROM customer_duplicates WHERE id = $1"
+
+	err := db.Get(&duplicate, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &duplicate, nil
+}
+
+// Dismiss marks the pair as different people so detection stops suggesting it
+func (d *CustomerDuplicate) Dismiss(actor string) error {
+	statement := "UPDATE customer_duplicates SET status = $1, actor = $2 WHERE id = $3 AND status = $4"
+
+	result, err := db.Exec(statement, DUPLICATE_DISMISSED, actor, d.Id, DUPLICATE_PENDING)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return fmt.Errorf("the suggestion was already reviewed")
+	}
+
+	return nil
+}
+
+// MergeCustomers folds the merged customer into the survivor in one transaction: orders, referrals, the account,
+// loyalty points and addresses move over, then the merged row is deleted. The survivor keeps its own details and
+// fills the blank ones from the merged customer. An undo record keeps what is needed to split them again.
+func MergeCustomers(survivorId string, mergedId string, actor string) (*CustomerMerge, error) {
+	if survivorId == mergedId {
+		return nil, fmt.Errorf("a customer cannot be merged into itself")
+	}
+
+	if survivorId == AnonymousCustomerId || mergedId == AnonymousCustomerId {
+		return nil, fmt.Errorf("the walk-in customer cannot be merged")
+	}
+
+	tx := db.MustBegin()
+
+	rollback := func(err error) (*CustomerMerge, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	var customers []DbCustomer
+	if err := tx.Select(&customers, "SELECT * FROM customers WHERE id = ANY($1) ORDER BY id FOR UPDATE", pq.Array([]string{survivorId, mergedId})); err != nil {
+		return rollback(err)
+	}
+
+	if len(customers) != 2 {
+		return rollback(fmt.Errorf("customer not found"))
+	}
+
+	survivor, merged := &customers[0], &customers[1]
+	if survivor.Id != survivorId {
+		survivor, merged = merged, survivor
+	}
+
+	if survivor.Erased != nil || merged.Erased != nil {
+		return rollback(fmt.Errorf("erased customers cannot be merged"))
+	}
+
+	var crossReferred bool
+	if err := tx.Get(&crossReferred, "SELECT EXISTS(SELECT 1 FROM referrals WHERE (referrer = $1 AND referred = $2) OR (referrer = $2 AND referred = $1))", survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if crossReferred {
+		return rollback(fmt.Errorf("one customer referred the other, review the referral before merging"))
+	}
+
+	moves := MergeMoves{Orders: make([]string, 0), Referrals: make([]string, 0), Loyalty: make([]int, 0), Ips: make([]string, 0), AddedIps: make([]string, 0), Notes: make([]int, 0), Tags: make([]string, 0), AddedTags: make([]string, 0), Addresses: make([]int, 0)}
+
+	if err := tx.Select(&moves.Orders, "UPDATE orders SET customer = $1 WHERE customer = $2 RETURNING id", survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Select(&moves.Referrals, "UPDATE referrals SET referrer = $1 WHERE referrer = $2 RETURNING id", survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	var referred []string
+	if err := tx.Select(&referred, "SELECT id FROM referrals WHERE referred = $1", merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if len(referred) > 0 {
+		var survivorReferred bool
+		if err := tx.Get(&survivorReferred, "SELECT EXISTS(SELECT 1 FROM referrals WHERE referred = $1)", survivor.Id); err != nil {
+			return rollback(err)
+		}
+
+		if survivorReferred {
+			return rollback(fmt.Errorf("both customers were referred, review their referrals before merging"))
+		}
+
+		if _, err := tx.Exec("UPDATE referrals SET referred = $1 WHERE id = $2", survivor.Id, referred[0]); err != nil {
+			return rollback(err)
+		}
+		moves.Referred = referred[0]
+	}
+
+	var accounts []string
+	if err := tx.Select(&accounts, "SELECT id FROM accounts WHERE customerid = $1", merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if len(accounts) > 0 {
+		var survivorAccount bool
+		if err := tx.Get(&survivorAccount, "SELECT EXISTS(SELECT 1 FROM accounts WHERE customerid = $1)", survivor.Id); err != nil {
+			return rollback(err)
+		}
+
+		if survivorAccount {
+			if _, err := tx.Exec("UPDATE accounts SET customerid = NULL WHERE id = $1", accounts[0]); err != nil {
+				return rollback(err)
+			}
+			moves.Unlinked = accounts[0]
+		} else {
+			if _, err := tx.Exec("UPDATE accounts SET customerid = $1 WHERE id = $2", survivor.Id, accounts[0]); err != nil {
+				return rollback(err)
+			}
+			moves.Account = accounts[0]
+		}
+	}
+
+	survivorEmail, mergedEmail := strings.ToLower(strings.TrimSpace(survivor.Email)), strings.ToLower(strings.TrimSpace(merged.Email))
+	if mergedEmail != "" && survivorEmail != "" && mergedEmail != survivorEmail {
+		if err := tx.Select(&moves.Loyalty, "UPDATE loyalty_points SET email = $1 WHERE email = $2 RETURNING id", survivorEmail, mergedEmail); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := tx.Select(&moves.Ips, "SELECT ip FROM customer_ips WHERE customerid = $1", merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Select(&moves.AddedIps, `INSERT INTO customer_ips (customerid, ip, seen)
+																					SELECT $1, ip, seen FROM customer_ips WHERE customerid = $2
+																					ON CONFLICT (customerid, ip) DO NOTHING
+																					RETURNING ip`, survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Select(&moves.Notes, "UPDATE customer_notes SET customerid = $1 WHERE customerid = $2 RETURNING id", survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if _, err := tx.Exec("UPDATE customer_note_events SET customerid = $1 WHERE customerid = $2", survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Select(&moves.Tags, "SELECT tag FROM customer_tags WHERE customerid = $1", merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Select(&moves.AddedTags, `INSERT INTO customer_tags (customerid, tag, created)
+																					SELECT $1, tag, created FROM customer_tags WHERE customerid = $2
+																					ON CONFLICT (customerid, tag) DO NOTHING
+																					RETURNING tag`, survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Select(&moves.Addresses, "UPDATE customer_addresses SET customerid = $1, preferred = false WHERE customerid = $2 RETURNING id", survivor.Id, merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	fill := func(own string, other string) string {
+		if strings.TrimSpace(own) == "" {
+			return other
+		}
+		return own
+	}
+
+	if _, err := tx.Exec("UPDATE customers SET fullname = $1, address = $2, phone = $3 WHERE id = $4", fill(survivor.Fullname, merged.Fullname), fill(survivor.Address, merged.Address), fill(survivor.Phone, merged.Phone), survivor.Id); err != nil {
+		return rollback(err)
+	}
+
+	snapshot, err := json.Marshal(merged)
+	if err != nil {
+		return rollback(err)
+	}
+
+	rawMoves, err := json.Marshal(moves)
+	if err != nil {
+		return rollback(err)
+	}
+
+	var merge CustomerMerge
+	if err := tx.Get(&merge, "INSERT INTO customer_merges (survivor, merged, snapshot, moves, actor) VALUES ($1, $2, $3, $4, $5) RETURNING *", survivor.Id, merged.Id, snapshot, rawMoves, actor); err != nil {
+		return rollback(err)
+	}
+
+	// Everything pointing at the merged customer has moved, its suggestions go with it
+	if _, err := tx.Exec("DELETE FROM customers WHERE id = $1", merged.Id); err != nil {
+		return rollback(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return &merge, nil
+}
+
+func GetCustomerMerges() ([]CustomerMerge, error) {
+	var merges []CustomerMerge = make([]CustomerMerge, 0)
+
+	statement := "SELECT * FROM customer_merges ORDER BY created DESC"
+
+	err := db.Select(&merges, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return merges, nil
+}
+
+// UndoMerge recreates the merged customer and moves back what the merge moved. Orders placed by the
+// survivor after the merge stay with the survivor, and so do the survivor's details filled from the merged one.
+func UndoMerge(id string) (*CustomerMerge, error) {
+	tx := db.MustBegin()
+
+	rollback := func(err error) (*CustomerMerge, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	var merge CustomerMerge
+	if err := tx.Get(&merge, "SELECT * FROM customer_merges WHERE id = $1 FOR UPDATE", id); err != nil {
+		return rollback(err)
+	}
+
+	if merge.Undone != nil {
+		return rollback(fmt.Errorf("the merge was already undone"))
+	}
+
+	var merged DbCustomer
+	if err := json.Unmarshal(merge.Snapshot, &merged); err != nil {
+		return rollback(fmt.Errorf("error parsing snapshot of merge %d: %v", merge.Id, err))
+	}
+
+	var moves MergeMoves
+	if err := json.Unmarshal(merge.Moves, &moves); err != nil {
+		return rollback(fmt.Errorf("error parsing moves of merge %d: %v", merge.Id, err))
+	}
+
+	var survivorExists bool
+	if err := tx.Get(&survivorExists, "SELECT EXISTS(SELECT 1 FROM customers WHERE id = $1)", merge.Survivor); err != nil {
+		return rollback(err)
+	}
+
+	if !survivorExists {
+		return rollback(fmt.Errorf("the surviving customer no longer exists"))
+	}
+
+	statement := "INSERT INTO customers (id, fullname, email, address, phone, created, referral) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	if _, err := tx.Exec(statement, merged.Id, merged.Fullname, merged.Email, merged.Address, merged.Phone, merged.Created, merged.Referral); err != nil {
+		return rollback(fmt.Errorf("error recreating customer %s: %v", merged.Id, err))
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE orders SET customer = $1 WHERE customer = $2 AND id = ANY($3)", []interface{}{merged.Id, merge.Survivor, pq.Array(moves.Orders)}},
+		{"UPDATE referrals SET referrer = $1 WHERE referrer = $2 AND id = ANY($3)", []interface{}{merged.Id, merge.Survivor, pq.Array(moves.Referrals)}},
+		{"UPDATE referrals SET referred = $1 WHERE referred = $2 AND id = $3", []interface{}{merged.Id, merge.Survivor, moves.Referred}},
+		{"UPDATE accounts SET customerid = $1 WHERE customerid = $2 AND id = $3", []interface{}{merged.Id, merge.Survivor, moves.Account}},
+		{"UPDATE accounts SET customerid = $1 WHERE customerid IS NULL AND id = $2", []interface{}{merged.Id, moves.Unlinked}},
+		{"UPDATE loyalty_points SET email = $1 WHERE id = ANY($2)", []interface{}{strings.ToLower(strings.TrimSpace(merged.Email)), pq.Array(moves.Loyalty)}},
+		{"INSERT INTO customer_ips (customerid, ip) SELECT $1, UNNEST($2::TEXT[]) ON CONFLICT (customerid, ip) DO NOTHING", []interface{}{merged.Id, pq.Array(moves.Ips)}},
+		{"DELETE FROM customer_ips WHERE customerid = $1 AND ip = ANY($2)", []interface{}{merge.Survivor, pq.Array(moves.AddedIps)}},
+		{"UPDATE customer_notes SET customerid = $1 WHERE customerid = $2 AND id = ANY($3)", []interface{}{merged.Id, merge.Survivor, pq.Array(moves.Notes)}},
+		{"UPDATE customer_note_events SET customerid = $1 WHERE customerid = $2 AND noteid = ANY($3)", []interface{}{merged.Id, merge.Survivor, pq.Array(moves.Notes)}},
+		{"INSERT INTO customer_tags (customerid, tag) SELECT $1, UNNEST($2::TEXT[]) ON CONFLICT (customerid, tag) DO NOTHING", []interface{}{merged.Id, pq.Array(moves.Tags)}},
+		{"DELETE FROM customer_tags WHERE customerid = $1 AND tag = ANY($2)", []interface{}{merge.Survivor, pq.Array(moves.AddedTags)}},
+		{"UPDATE customer_addresses SET customerid = $1 WHERE customerid = $2 AND id = ANY($3)", []interface{}{merged.Id, merge.Survivor, pq.Array(moves.Addresses)}},
+		{`UPDATE customer_addresses SET preferred = true WHERE id = (
+				SELECT id FROM customer_addresses WHERE customerid = $1 ORDER BY lastused DESC LIMIT 1
+			) AND NOT EXISTS(SELECT 1 FROM customer_addresses WHERE customerid = $1 AND preferred = true)`, []interface{}{merged.Id}},
+		{"UPDATE customer_merges SET undone = NOW() WHERE id = $1", []interface{}{merge.Id}},
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	var undone CustomerMerge
+	if err := db.Get(&undone, "SELECT * FROM customer_merges WHERE id = $1", merge.Id); err != nil {
+		return nil, err
+	}
+
+	return &undone, nil
+}
@@ -0,0 +1,420 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/geo"
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type Fulfilment string
+
+const (
+	PICKUP   Fulfilment = "pickup"
+	DELIVERY Fulfilment = "delivery"
+)
+
+func ParseFulfilment(fulfilment string) Fulfilment {
+	switch fulfilment {
+	case "delivery":
+		return DELIVERY
+	default:
+		return PICKUP
+	}
+}
+
+type ZoneKind string
+
+const (
+	ZONE_POSTAL ZoneKind = "postal"
+	ZONE_RADIUS ZoneKind = "radius"
+)
+
+// DeliveryWindow is when the drivers are out on a weekday, Start and End are "15:04" times
+type DeliveryWindow struct {
+	Weekday time.Weekday `json:"weekday"`
+	Start   string       `json:"start"`
+	End     string       `json:"end"`
+}
+
+type DbDeliveryZone struct {
+	Id       int             `json:"id"`
+	Name     string          `json:"name"`
+	Kind     string          `json:"kind"`
+	Prefixes pq.StringArray  `json:"prefixes"`
+	Radius   float64         `json:"radius"`
+	Fee      int             `json:"fee"`
+	Minimum  int             `json:"minimum"`
+	Windows  json.RawMessage `json:"windows"`
+	Active   bool            `json:"active"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+}
+
+// DeliveryZone is an area the shop delivers to, either the postal codes starting with one of the
+// prefixes or every address within the radius (in km) from the shop
+type DeliveryZone struct {
+	Id       int              `json:"id"`
+	Name     string           `json:"name"`
+	Kind     ZoneKind         `json:"kind"`
+	Prefixes []string         `json:"prefixes"`
+	Radius   float64          `json:"radius"`
+	Fee      int              `json:"fee"`     // In cents, added to the order
+	Minimum  int              `json:"minimum"` // In cents, the smallest cart delivered
+	Windows  []DeliveryWindow `json:"windows"`
+	Active   bool             `json:"active"`
+	Created  time.Time        `json:"created"`
+	Updated  time.Time        `json:"updated"`
+}
+
+func (dbz *DbDeliveryZone) ConvertToDeliveryZone() (*DeliveryZone, error) {
+	var windows []DeliveryWindow = make([]DeliveryWindow, 0)
+	if err := json.Unmarshal(dbz.Windows, &windows); err != nil {
+		return nil, fmt.Errorf("error reading windows of delivery zone %d: %v", dbz.Id, err)
+	}
+
+	return &DeliveryZone{
+		Id:       dbz.Id,
+		Name:     dbz.Name,
+		Kind:     ZoneKind(dbz.Kind),
+		Prefixes: dbz.Prefixes,
+		Radius:   dbz.Radius,
+		Fee:      dbz.Fee,
+		Minimum:  dbz.Minimum,
+		Windows:  windows,
+		Active:   dbz.Active,
+		Created:  dbz.Created,
+		Updated:  dbz.Updated,
+	}, nil
+}
+
+type DeliveryZoneDto struct {
+	Name     string           `json:"name"`
+	Kind     ZoneKind         `json:"kind"`
+	Prefixes []string         `json:"prefixes"`
+	Radius   float64          `json:"radius"`
+	Fee      int              `json:"fee"`
+	Minimum  int              `json:"minimum"`
+	Windows  []DeliveryWindow `json:"windows"`
+	Active   bool             `json:"active"`
+}
+
+// normalizePostalCode uppercases a postal code and drops its spaces so "m5v 2t6" matches the prefix "M5V"
+func normalizePostalCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+func (z *DeliveryZoneDto) Validate() error {
+	z.Name = strings.TrimSpace(z.Name)
+	if z.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+
+	switch z.Kind {
+	case ZONE_POSTAL:
+		var prefixes []string = make([]string, 0)
+		for _, prefix := range z.Prefixes {
+			if prefix = normalizePostalCode(prefix); prefix != "" {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+
+		if len(prefixes) == 0 {
+			return fmt.Errorf("postal zones need at least one prefix")
+		}
+
+		z.Prefixes = prefixes
+		z.Radius = 0
+	case ZONE_RADIUS:
+		if z.Radius <= 0 {
+			return fmt.Errorf("radius zones need a positive radius")
+		}
+
+		z.Prefixes = make([]string, 0)
+	default:
+		return fmt.Errorf("kind must be %s or %s", ZONE_POSTAL, ZONE_RADIUS)
+	}
+
+	if z.Fee < 0 {
+		return fmt.Errorf("fee cannot be negative")
+	}
+
+	if z.Minimum < 0 {
+		return fmt.Errorf("minimum cannot be negative")
+	}
+
+	if len(z.Windows) == 0 {
+		return fmt.Errorf("at least one delivery window is needed")
+	}
+
+	for _, window := range z.Windows {
+		if window.Weekday < time.Sunday || window.Weekday > time.Saturday {
+			return fmt.Errorf("weekday %d is not between 0 (sunday) and 6 (saturday)", window.Weekday)
+		}
+
+		start, err := time.Parse("15:04", window.Start)
+		if err != nil {
+			return fmt.Errorf("start %s is not a 15:04 time", window.Start)
+		}
+
+		end, err := time.Parse("15:04", window.End)
+		if err != nil {
+			return fmt.Errorf("end %s is not a 15:04 time", window.End)
+		}
+
+		if !end.After(start) {
+			return fmt.Errorf("window on %s ends before it starts", window.Weekday)
+		}
+	}
+
+	return nil
+}
+
+// Contains tells whether the address falls in the zone
+func (z *DeliveryZone) Contains(location *Location) bool {
+	switch z.Kind {
+	case ZONE_POSTAL:
+		code := normalizePostalCode(location.PostalCode)
+		if code == "" {
+			return false
+		}
+
+		for _, prefix := range z.Prefixes {
+			if strings.HasPrefix(code, prefix) {
+				return true
+			}
+		}
+	case ZONE_RADIUS:
+		lat, lng := geo.Shop()
+		return geo.DistanceKm(lat, lng, location.Lat, location.Lng) <= z.Radius
+	}
+
+	return false
+}
+
+// Window finds the delivery window the time falls in, returning when it starts and ends on that day
+func (z *DeliveryZone) Window(at time.Time) (time.Time, time.Time, error) {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+
+	for _, window := range z.Windows {
+		if window.Weekday != at.Weekday() {
+			continue
+		}
+
+		start, err := time.Parse("15:04", window.Start)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+
+		end, err := time.Parse("15:04", window.End)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+
+		starts := day.Add(time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute)
+		ends := day.Add(time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute)
+
+		if !at.Before(starts) && at.Before(ends) {
+			return starts, ends, nil
+		}
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("%s is outside the delivery windows of %s", at.Format("Monday 03:04 PM"), z.Name)
+}
+
+func GetDeliveryZones() ([]DeliveryZone, error) {
+	var db_zones []DbDeliveryZone = make([]DbDeliveryZone, 0)
+	var zones []DeliveryZone = make([]DeliveryZone, 0)
+
+	// Postal zones are more precise than a radius, they are tried first and the narrowest radius after them
+	statement := "SELECT * FROM delivery_zones ORDER BY kind = 'radius' ASC, radius ASC, id ASC"
+
+	err := db.Select(&db_zones, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, db_zone := range db_zones {
+		zone, err := db_zone.ConvertToDeliveryZone()
+		if err != nil {
+			return nil, err
+		}
+
+		zones = append(zones, *zone)
+	}
+
+	return zones, nil
+}
+
+func GetDeliveryZone(id string) (*DeliveryZone, error) {
+	var db_zone DbDeliveryZone
+
+	statement := "SELECT * FROM delivery_zones WHERE id = $1"
+
+	err := db.Get(&db_zone, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return db_zone.ConvertToDeliveryZone()
+}
+
+func CreateDeliveryZone(dto DeliveryZoneDto) (*DeliveryZone, error) {
+	windows, err := json.Marshal(dto.Windows)
+	if err != nil {
+		return nil, err
+	}
+
+	var db_zone DbDeliveryZone
+
+	statement := `INSERT INTO delivery_zones (name, kind, prefixes, radius, fee, minimum, windows, active)
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *`
+
+	err = db.Get(&db_zone, statement, dto.Name, dto.Kind, pq.Array(dto.Prefixes), dto.Radius, dto.Fee, dto.Minimum, windows, dto.Active)
+	if err != nil {
+		return nil, err
+	}
+
+	return db_zone.ConvertToDeliveryZone()
+}
+
+func (z *DeliveryZone) Update(dto DeliveryZoneDto) (*DeliveryZone, error) {
+	windows, err := json.Marshal(dto.Windows)
+	if err != nil {
+		return nil, err
+	}
+
+	var db_zone DbDeliveryZone
+
+	statement := `UPDATE delivery_zones SET name = $1, kind = $2, prefixes = $3, radius = $4, fee = $5, minimum = $6, windows = $7, active = $8
+								WHERE id = $9 RETURNING *`
+
+	err = db.Get(&db_zone, statement, dto.Name, dto.Kind, pq.Array(dto.Prefixes), dto.Radius, dto.Fee, dto.Minimum, windows, dto.Active, z.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return db_zone.ConvertToDeliveryZone()
+}
+
+// Delete removes the zone, orders delivered to it keep their fee and window
+func (z *DeliveryZone) Delete() error {
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec("DELETE FROM delivery_zones WHERE id = $1", z.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// DeliveryQuote is what delivering an order costs and when it is brought
+type DeliveryQuote struct {
+	Zone        *DeliveryZone `json:"zone"`
+	Fee         int           `json:"fee"`
+	WindowStart time.Time     `json:"window_start"`
+	WindowEnd   time.Time     `json:"window_end"`
+}
+
+// FindDeliveryZone returns the first active zone the address falls in
+func FindDeliveryZone(location *Location) (*DeliveryZone, error) {
+	if location == nil {
+		return nil, fmt.Errorf("the address could not be located")
+	}
+
+	zones, err := GetDeliveryZones()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zone := range zones {
+		if zone.Active && zone.Contains(location) {
+			return &zone, nil
+		}
+	}
+
+	return nil, fmt.Errorf("we do not deliver to %s yet", location.Formatted)
+}
+
+// QuoteDelivery prices delivering a cart of subtotal cents to the address at the time asked.
+// A zero time leaves the window out, for quotes given before the customer picked a time.
+func QuoteDelivery(location *Location, subtotal int, at time.Time) (*DeliveryQuote, error) {
+	zone, err := FindDeliveryZone(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtotal < zone.Minimum {
+		return nil, fmt.Errorf("delivery to %s needs an order of at least %s", zone.Name, helpers.FormatPrice(float64(zone.Minimum)/100.0))
+	}
+
+	if at.IsZero() {
+		return &DeliveryQuote{Zone: zone, Fee: zone.Fee}, nil
+	}
+
+	starts, ends, err := zone.Window(at)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeliveryQuote{Zone: zone, Fee: zone.Fee, WindowStart: starts, WindowEnd: ends}, nil
+}
+
+// SetDelivery records the zone, fee and window of a new delivered order
+func SetDelivery(zoneId int, fee int, windowStart time.Time, windowEnd time.Time) OrderStep {
+	return func(tx *sqlx.Tx, order *Order) error {
+		statement := "UPDATE orders SET fulfilment = $1, deliveryzone = $2, deliveryfee = $3, windowstart = $4, windowend = $5 WHERE id = $6"
+
+		if _, err := tx.Exec(statement, DELIVERY, zoneId, fee, windowStart, windowEnd, order.Id); err != nil {
+			return err
+		}
+
+		order.Fulfilment = string(DELIVERY)
+		order.DeliveryZone = &zoneId
+		order.DeliveryFee = fee
+		order.WindowStart = &windowStart
+		order.WindowEnd = &windowEnd
+
+		return nil
+	}
+}
+
+// IsDelivery tells whether the order is brought to the customer rather than picked up
+func (o *Order) IsDelivery() bool {
+	return Fulfilment(o.Fulfilment) == DELIVERY
+}
+
+// DeliveryAddress is where the order is brought, the customer's address when the order kept none
+func (o *Order) DeliveryAddress() string {
+	if o.AddressId != nil {
+		if address, err := GetCustomerAddress(*o.AddressId); err == nil {
+			return address.Formatted
+		}
+	}
+
+	return o.Customer.Address
+}
+
+// DeliveryWindow formats the window the order is brought in, empty for pickups
+func (o *Order) DeliveryWindow() string {
+	if o.WindowStart == nil || o.WindowEnd == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s - %s", o.WindowStart.Format("2006-01-02 03:04 PM"), o.WindowEnd.Format("03:04 PM"))
+}
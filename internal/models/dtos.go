@@ -1,16 +1,15 @@
 package models
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"os"
+	"math"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/Francesco99975/rosskery/internal/geo"
 	"github.com/labstack/gommon/log"
 )
 
@@ -91,12 +90,61 @@ func (p *PurchasedItem) Validate() error {
 }
 
 type OrderDto struct {
-	Pickuptime time.Time     `json:"pickuptime"`
-	Method     PaymentMethod `json:"method"`
-	Fullname   string        `json:"fullname"`
-	Email      string        `json:"email"`
-	Address    string        `json:"address"`
-	Phone      string        `json:"phone"`
+	Pickuptime       time.Time     `json:"pickuptime"`
+	Method           PaymentMethod `json:"method"`
+	Fullname         string        `json:"fullname"`
+	Email            string        `json:"email"`
+	Address          string        `json:"address"`
+	Phone            string        `json:"phone"`
+	Notes            string        `json:"notes"`
+	Tip              int           `json:"tip"`               // In cents, picked on the pay page
+	GiftCard         string        `json:"gift_card"`         // Code of the gift card or store credit paying part of the order
+	GiftCardHold     int           `json:"gift_card_hold"`    // Ledger entry holding the gift card amount until the order is placed
+	GiftRecipient    string        `json:"gift_recipient"`    // Who receives the gift cards bought, the customer when empty
+	RedeemPoints     bool          `json:"redeem_points"`     // Spend the customer's loyalty points on the order
+	PointsDiscount   int           `json:"points_discount"`   // In cents, what the redeemed points take off
+	PointsHold       int           `json:"points_hold"`       // Loyalty entry holding the redeemed points until the order is placed
+	ReferralCode     string        `json:"referral_code"`     // Code of the customer who referred this one
+	ReferralDiscount int           `json:"referral_discount"` // In cents, what the referral takes off the first order
+	AccountId        string        `json:"account_id"`        // Storefront account signed in at checkout, empty for guests
+	Ip               string        `json:"ip"`                // Where the order was placed from, kept for data export requests
+	Location         *Location     `json:"location"`          // The address geocoded by Validate
+	Fulfilment       Fulfilment    `json:"fulfilment"`        // Picked up at the shop or delivered
+	DeliveryZone     int           `json:"delivery_zone"`     // Zone the address fell in, for deliveries
+	DeliveryFee      int           `json:"delivery_fee"`      // In cents, added to the cart for deliveries
+	WindowStart      time.Time     `json:"window_start"`      // Delivery window the pickup time fell in
+	WindowEnd        time.Time     `json:"window_end"`
+	IdempotencyKey   string        `json:"idempotency_key"`
+}
+
+// Discounts is what the points and referral take off the cart
+func (o *OrderDto) Discounts() int {
+	return o.PointsDiscount + o.ReferralDiscount
+}
+
+// Due is what is left to pay on a cart of subtotal cents, delivery included and discounts taken off
+func (o *OrderDto) Due(subtotal int) int {
+	return subtotal + o.DeliveryFee - o.Discounts()
+}
+
+// customerEmailLength is the longest email the customers table holds
+const customerEmailLength = 30
+
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+// NormalizeEmail checks the email of a customer and lowercases it, so the same address never makes two customers
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	if !emailRegex.MatchString(email) {
+		return "", fmt.Errorf("email is not a valid email address")
+	}
+
+	if len(email) > customerEmailLength {
+		return "", fmt.Errorf("email cannot be longer than %d characters", customerEmailLength)
+	}
+
+	return strings.ToLower(email), nil
 }
 
 func (o *OrderDto) Validate() error {
@@ -109,6 +157,18 @@ func (o *OrderDto) Validate() error {
 		return fmt.Errorf("method cannot be empty")
 	}
 
+	if o.Method == CARD {
+		return fmt.Errorf("card payments are only taken at the counter")
+	}
+
+	if o.Fulfilment == "" {
+		o.Fulfilment = PICKUP
+	}
+
+	if o.Fulfilment != PICKUP && o.Fulfilment != DELIVERY {
+		return fmt.Errorf("fulfilment must be %s or %s", PICKUP, DELIVERY)
+	}
+
 	if o.Fullname == "" {
 		return fmt.Errorf("fullname cannot be empty")
 	}
@@ -117,6 +177,18 @@ func (o *OrderDto) Validate() error {
 		return fmt.Errorf("email cannot be empty")
 	}
 
+	if o.Tip < 0 {
+		return fmt.Errorf("tip cannot be negative")
+	}
+
+	if o.Tip > 0 && o.Method == CASH {
+		return fmt.Errorf("tips can only be added to online payments")
+	}
+
+	if len(o.Notes) > 500 {
+		return fmt.Errorf("notes cannot be longer than 500 characters")
+	}
+
 	if o.Address == "" {
 		return fmt.Errorf("address cannot be empty")
 	}
@@ -127,16 +199,21 @@ func (o *OrderDto) Validate() error {
 
 	//Validate Email
 
-	const emailRegexPattern = `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
+	email, err := NormalizeEmail(o.Email)
+	if err != nil {
+		return err
+	}
+
+	o.Email = email
 
-	re := regexp.MustCompile(emailRegexPattern)
+	if o.GiftRecipient != "" {
+		if !emailRegex.MatchString(o.GiftRecipient) {
+			return fmt.Errorf("gift recipient is not a valid email address")
+		}
 
-	if !re.MatchString(o.Email) {
-		return fmt.Errorf("email is not a valid email address")
+		o.GiftRecipient = strings.ToLower(o.GiftRecipient)
 	}
 
-	o.Email = strings.ToLower(o.Email)
-
 	// Validate phone number
 	phoneRegex := regexp.MustCompile(`^\(?\d{3}\)?[-.\s]?\d{3}[-.\s]?\d{4}$`)
 	phoneCleaned := regexp.MustCompile(`[^\d]`).ReplaceAllString(o.Phone, "")
@@ -160,46 +237,17 @@ func (o *OrderDto) Validate() error {
 	formattedAddress = regexp.MustCompile(`\s{2,}`).ReplaceAllString(formattedAddress, " ") // Replace multiple spaces with a single space
 	formattedAddress = regexp.MustCompile(`,\s*`).ReplaceAllString(formattedAddress, ", ")  // Ensure a single space after commas
 
-	type GeocodeResponse struct {
-		Results []struct {
-			FormattedAddress string `json:"formatted_address"`
-			Geometry         struct {
-				Location struct {
-					Lat float64 `json:"lat"`
-					Lng float64 `json:"lng"`
-				} `json:"location"`
-			} `json:"geometry"`
-		} `json:"results"`
-		Status string `json:"status"`
-	}
-
-	apiURL := "https://maps.googleapis.com/maps/api/geocode/json?address=" + url.QueryEscape(o.Address) + "&key=" + os.Getenv("GOOGLE_MAPS_API_KEY")
-
-	resp, err := http.Get(apiURL)
-	if err != nil {
-		log.Errorf("Failed to get geocode response: %v", err)
-		return fmt.Errorf("address is not a valid address")
-	}
-	defer resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	body, err := io.ReadAll(resp.Body)
+	location, err := geo.Geocode(ctx, formattedAddress)
 	if err != nil {
-		log.Error("Failed to read geocode response")
-		return fmt.Errorf("address is not a valid address")
-	}
-
-	var geocodeResponse GeocodeResponse
-	if err := json.Unmarshal(body, &geocodeResponse); err != nil {
-		log.Errorf("Failed to unmarshal geocode response: %v", err)
-		return fmt.Errorf("address is not a valid address")
-	}
-
-	if geocodeResponse.Status != "OK" || len(geocodeResponse.Results) == 0 {
-		log.Errorf("Failed to geocode address: %v", geocodeResponse.Status)
+		log.Errorf("Failed to geocode address: %v", err)
 		return fmt.Errorf("address is not a valid address")
 	}
 
 	o.Address = formattedAddress
+	o.Location = location
 
 	return nil
 }
@@ -209,3 +257,36 @@ type JSONErrorResponse struct {
 	Message string   `json:"message"`
 	Errors  []string `json:"errors"`
 }
+
+// Tip percentages offered on the pay page
+var TipPresets = []int{10, 15, 20}
+
+// TipDto is the tip picked on the pay page, either a preset percentage or a custom amount in cents
+type TipDto struct {
+	Percent int `json:"percent"`
+	Amount  int `json:"amount"`
+}
+
+// Resolve returns the tip in cents for an order of subtotal cents
+func (t *TipDto) Resolve(subtotal int) (int, error) {
+	if t.Percent != 0 && t.Amount != 0 {
+		return 0, fmt.Errorf("tip cannot be both a percentage and an amount")
+	}
+
+	if t.Percent != 0 {
+		if !slices.Contains(TipPresets, t.Percent) {
+			return 0, fmt.Errorf("tip percentage %d is not offered", t.Percent)
+		}
+		return int(math.Round(float64(subtotal) * float64(t.Percent) / 100.0)), nil
+	}
+
+	if t.Amount < 0 {
+		return 0, fmt.Errorf("tip cannot be negative")
+	}
+
+	if t.Amount > subtotal {
+		return 0, fmt.Errorf("tip cannot be larger than the order")
+	}
+
+	return t.Amount, nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// EmailRecord is an email the store sent, kept so a customer can be told what was sent to them
+type EmailRecord struct {
+	Id        int       `json:"id"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Tag       string    `json:"tag"`
+	Created   time.Time `json:"created"`
+}
+
+func LogEmail(recipient string, subject string, tag string) error {
+	statement := "INSERT INTO emails (recipient, subject, tag) VALUES ($1, $2, $3)"
+
+	_, err := db.Exec(statement, strings.TrimSpace(recipient), subject, tag)
+
+	return err
+}
+
+func GetEmailsTo(recipient string) ([]EmailRecord, error) {
+	var emails []EmailRecord = make([]EmailRecord, 0)
+
+	statement := "SELECT * FROM emails WHERE LOWER(recipient) = LOWER($1) ORDER BY created ASC"
+
+	err := db.Select(&emails, statement, strings.TrimSpace(recipient))
+	if err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
@@ -31,6 +31,8 @@ const (
 	EventRemoveCategory    = "removecategory"
 	EventOrdersChanged     = "orderschanged"
 	EventCustomersChanged  = "customerschanged"
+	EventAuditLogged       = "auditlogged"
+	EventPaymentsChanged   = "paymentschanged"
 )
 
 func SendAdminUpdateHandler(event Event, client *Client) error {
@@ -0,0 +1,274 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ExportDataset string
+
+const (
+	ExportOrders    ExportDataset = "orders"
+	ExportItems     ExportDataset = "items"
+	ExportPayments  ExportDataset = "payments"
+	ExportRefunds   ExportDataset = "refunds"
+	ExportCustomers ExportDataset = "customers"
+)
+
+var ExportDatasets = []ExportDataset{ExportOrders, ExportItems, ExportPayments, ExportRefunds, ExportCustomers}
+
+func ParseExportDataset(dataset string) (ExportDataset, error) {
+	for _, d := range ExportDatasets {
+		if string(d) == dataset {
+			return d, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown export dataset: %s", dataset)
+}
+
+type ExportKind string
+
+const (
+	ExportText   ExportKind = "text"
+	ExportNumber ExportKind = "number"
+	ExportMoney  ExportKind = "money"
+	ExportTime   ExportKind = "time"
+	ExportBool   ExportKind = "bool"
+)
+
+type ExportColumn struct {
+	Name string     `json:"name"`
+	Kind ExportKind `json:"kind"`
+	expr string
+}
+
+type exportSource struct {
+	from    string
+	date    string
+	columns []ExportColumn
+}
+
+// orderTotals sums every order at current product prices, like the finance aggregates, and the gift cards sold in it
+const orderTotals = `(SELECT p.orderid AS orderid,
+										COUNT(*) AS items,
+										COALESCE(ROUND(SUM(
+											CASE
+												WHEN pr.weighed = true THEN (p.quantity / 10.0) * pr.price
+												ELSE p.quantity * pr.price
+											END
+										)), 0) AS total,
+										COALESCE(SUM(CASE WHEN pr.category = '` + GiftCardCategoryId + `' THEN p.quantity * pr.price ELSE 0 END), 0) AS giftcards
+									FROM purchases p
+									JOIN products pr ON p.productid = pr.id
+									GROUP BY p.orderid)`
+
+var exportSources = map[ExportDataset]exportSource{
+	ExportOrders: {
+		from: `orders o
+					JOIN customers c ON o.customer = c.id
+					LEFT JOIN ` + orderTotals + ` t ON t.orderid = o.id`,
+		date: "o.created",
+		columns: []ExportColumn{
+			{Name: "id", Kind: ExportText, expr: "o.id"},
+			{Name: "created", Kind: ExportTime, expr: "o.created"},
+			{Name: "pickuptime", Kind: ExportTime, expr: "o.pickuptime"},
+			{Name: "customer", Kind: ExportText, expr: "c.fullname"},
+			{Name: "email", Kind: ExportText, expr: "c.email"},
+			{Name: "method", Kind: ExportText, expr: "o.method::TEXT"},
+			{Name: "fulfilled", Kind: ExportBool, expr: "o.fulfilled"},
+			{Name: "items", Kind: ExportNumber, expr: "COALESCE(t.items, 0)"},
+			{Name: "total", Kind: ExportMoney, expr: "COALESCE(t.total, 0)"},
+			{Name: "fulfilment", Kind: ExportText, expr: "o.fulfilment"},
+			{Name: "delivery", Kind: ExportMoney, expr: "o.deliveryfee"},
+			{Name: "tip", Kind: ExportMoney, expr: "o.tip"},
+		},
+	},
+	ExportItems: {
+		from: `purchases p
+					JOIN orders o ON p.orderid = o.id
+					JOIN products pr ON p.productid = pr.id
+					JOIN categories cat ON pr.category = cat.id`,
+		date: "o.created",
+		columns: []ExportColumn{
+			{Name: "id", Kind: ExportText, expr: "p.id"},
+			{Name: "order", Kind: ExportText, expr: "o.id"},
+			{Name: "created", Kind: ExportTime, expr: "o.created"},
+			{Name: "product", Kind: ExportText, expr: "pr.name"},
+			{Name: "category", Kind: ExportText, expr: "cat.name"},
+			{Name: "weighed", Kind: ExportBool, expr: "pr.weighed"},
+			{Name: "quantity", Kind: ExportNumber, expr: "CASE WHEN pr.weighed = true THEN p.quantity / 10.0 ELSE p.quantity END"},
+			{Name: "price", Kind: ExportMoney, expr: "pr.price"},
+			{Name: "subtotal", Kind: ExportMoney, expr: "ROUND(CASE WHEN pr.weighed = true THEN (p.quantity / 10.0) * pr.price ELSE p.quantity * pr.price END)"},
+		},
+	},
+	ExportPayments: {
+		from: `orders o
+					JOIN customers c ON o.customer = c.id
+					LEFT JOIN ` + orderTotals + ` t ON t.orderid = o.id`,
+		date: "o.created",
+		columns: []ExportColumn{
+			{Name: "order", Kind: ExportText, expr: "o.id"},
+			{Name: "created", Kind: ExportTime, expr: "o.created"},
+			{Name: "customer", Kind: ExportText, expr: "c.fullname"},
+			{Name: "method", Kind: ExportText, expr: "o.method::TEXT"},
+			{Name: "status", Kind: ExportText, expr: "CASE WHEN o.cancelled = true THEN 'cancelled' WHEN o.paymentstatus NOT IN ('', 'succeeded') THEN o.paymentstatus WHEN o.method = 'cash' AND o.fulfilled = false THEN 'pending' ELSE 'paid' END"},
+			{Name: "subtotal", Kind: ExportMoney, expr: "COALESCE(t.total, 0)"},
+			{Name: "discount", Kind: ExportMoney, expr: "o.discount"},
+			{Name: "giftcard", Kind: ExportMoney, expr: "o.giftcard"},
+			{Name: "delivery", Kind: ExportMoney, expr: "o.deliveryfee"},
+			{Name: "tip", Kind: ExportMoney, expr: "o.tip"},
+			// What was taken from the customer, as settled by the provider when paid online and what is due at the counter otherwise
+			{Name: "amount", Kind: ExportMoney, expr: "CASE WHEN o.paymentintent != '' THEN o.paid ELSE GREATEST(COALESCE(t.total, 0) + o.deliveryfee + o.tip - o.discount - o.giftcard, 0) END"},
+			{Name: "refunded", Kind: ExportMoney, expr: "o.refunded"},
+		},
+	},
+	ExportRefunds: {
+		from: `refunds r
+					JOIN orders o ON r.orderid = o.id
+					JOIN customers c ON o.customer = c.id`,
+		date: "r.created",
+		columns: []ExportColumn{
+			{Name: "id", Kind: ExportText, expr: "r.id"},
+			{Name: "order", Kind: ExportText, expr: "o.id"},
+			{Name: "created", Kind: ExportTime, expr: "r.created"},
+			{Name: "customer", Kind: ExportText, expr: "c.fullname"},
+			{Name: "method", Kind: ExportText, expr: "o.method::TEXT"},
+			{Name: "reason", Kind: ExportText, expr: "r.reason"},
+			{Name: "amount", Kind: ExportMoney, expr: "r.amount"},
+		},
+	},
+	ExportCustomers: {
+		from: `customers c
+					LEFT JOIN (SELECT o.customer AS customer, COUNT(*) AS orders, COALESCE(SUM(t.total), 0) AS spent
+										FROM orders o
+										LEFT JOIN ` + orderTotals + ` t ON t.orderid = o.id
+										GROUP BY o.customer) s ON s.customer = c.id`,
+		date: "c.created",
+		columns: []ExportColumn{
+			{Name: "id", Kind: ExportText, expr: "c.id"},
+			{Name: "created", Kind: ExportTime, expr: "c.created"},
+			{Name: "fullname", Kind: ExportText, expr: "c.fullname"},
+			{Name: "email", Kind: ExportText, expr: "c.email"},
+			{Name: "phone", Kind: ExportText, expr: "c.phone"},
+			{Name: "address", Kind: ExportText, expr: "c.address"},
+			{Name: "orders", Kind: ExportNumber, expr: "COALESCE(s.orders, 0)"},
+			{Name: "spent", Kind: ExportMoney, expr: "COALESCE(s.spent, 0)"},
+		},
+	},
+}
+
+// GetExportColumns lists the columns available for a dataset
+func GetExportColumns(dataset ExportDataset) ([]ExportColumn, error) {
+	source, ok := exportSources[dataset]
+	if !ok {
+		return nil, fmt.Errorf("unknown export dataset: %s", dataset)
+	}
+
+	return source.columns, nil
+}
+
+// ResolveExportColumns picks the requested columns in the given order. No names selects every column.
+func ResolveExportColumns(dataset ExportDataset, names []string) ([]ExportColumn, error) {
+	available, err := GetExportColumns(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return available, nil
+	}
+
+	columns := make([]ExportColumn, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range available {
+			if column.Name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %s for dataset %s", name, dataset)
+		}
+	}
+
+	return columns, nil
+}
+
+// StreamExport runs the export query for [from, to) and hands every row to emit as it is read,
+// so large ranges are never held in memory. Timestamps are stored in UTC and rendered in loc.
+func StreamExport(dataset ExportDataset, columns []ExportColumn, from time.Time, to time.Time, loc *time.Location, emit func(record []string) error) error {
+	source, ok := exportSources[dataset]
+	if !ok {
+		return fmt.Errorf("unknown export dataset: %s", dataset)
+	}
+
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns selected")
+	}
+
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		selects[i] = column.expr
+	}
+
+	statement := "SELECT " + strings.Join(selects, ", ") + " FROM " + source.from + " WHERE " + source.date + " >= $1 AND " + source.date + " < $2 ORDER BY " + source.date + " ASC"
+
+	rows, err := db.Queryx(statement, from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return err
+		}
+
+		record := make([]string, len(values))
+		for i, value := range values {
+			record[i] = formatExportValue(columns[i].Kind, value, loc)
+		}
+
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func formatExportValue(kind ExportKind, value interface{}, loc *time.Location) string {
+	if value == nil {
+		return ""
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), time.UTC).In(loc).Format("2006-01-02 15:04:05")
+	case []byte:
+		value = string(v)
+	}
+
+	switch kind {
+	case ExportMoney:
+		var cents float64
+		if _, err := fmt.Sscan(fmt.Sprint(value), &cents); err != nil {
+			return fmt.Sprint(value)
+		}
+		return fmt.Sprintf("%.2f", cents/100.0)
+	case ExportNumber:
+		var number float64
+		if _, err := fmt.Sscan(fmt.Sprint(value), &number); err != nil {
+			return fmt.Sprint(value)
+		}
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", number), "0"), ".")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,524 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+type GiftCardKind string
+
+const (
+	GIFT_CARD    GiftCardKind = "giftcard" // Bought by a customer for a recipient
+	STORE_CREDIT GiftCardKind = "credit"   // Given back on a refund instead of money
+)
+
+type GiftCardReason string
+
+const (
+	GIFTCARD_ISSUE   GiftCardReason = "issue"
+	GIFTCARD_REDEEM  GiftCardReason = "redeem"
+	GIFTCARD_RELEASE GiftCardReason = "release" // A redemption given back because the checkout was abandoned or the order cancelled
+	GIFTCARD_ADJUST  GiftCardReason = "adjust"
+	GIFTCARD_VOID    GiftCardReason = "void"
+)
+
+// giftCardAlphabet leaves out the characters easily mistaken for one another (0/O, 1/I/L)
+const giftCardAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+type GiftCard struct {
+	Id        string       `json:"id"`
+	Code      string       `json:"code"`
+	Kind      GiftCardKind `json:"kind"`
+	Initial   int          `json:"initial"`
+	Balance   int          `json:"balance"`
+	Recipient string       `json:"recipient"`
+	OrderId   *string      `json:"order_id" db:"orderid"` // Order that bought the card or was refunded with it
+	Voided    bool         `json:"voided"`
+	Created   time.Time    `json:"created"`
+	Updated   time.Time    `json:"updated"`
+}
+
+// GiftCardEntry is a change of a card balance, Amount being negative when money leaves the card
+type GiftCardEntry struct {
+	Id      int            `json:"id"`
+	CardId  string         `json:"card_id" db:"cardid"`
+	Reason  GiftCardReason `json:"reason"`
+	Amount  int            `json:"amount"`
+	Balance int            `json:"balance"` // Card balance after the change
+	OrderId *string        `json:"order_id" db:"orderid"`
+	Ref     *int           `json:"ref"` // Entry a release gives back
+	Actor   string         `json:"actor"`
+	Note    string         `json:"note"`
+	Created time.Time      `json:"created"`
+}
+
+type GiftCardDetails struct {
+	GiftCard
+	Ledger []GiftCardEntry `json:"ledger"`
+}
+
+// NewGiftCardCode draws a random XXXX-XXXX-XXXX-XXXX code
+func NewGiftCardCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(giftCardAlphabet)))
+
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(giftCardAlphabet[n.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+// NormalizeGiftCardCode formats a code typed by a customer, ignoring case, spaces and dashes
+func NormalizeGiftCardCode(code string) string {
+	var cleaned strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			cleaned.WriteRune(r)
+		}
+	}
+
+	raw := cleaned.String()
+	groups := make([]string, 0, 4)
+	for len(raw) > 4 {
+		groups = append(groups, raw[:4])
+		raw = raw[4:]
+	}
+	groups = append(groups, raw)
+
+	return strings.Join(groups, "-")
+}
+
+func nullableString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// IssueGiftCard creates a card holding amount and records its issue in the ledger
+func IssueGiftCard(kind GiftCardKind, amount int, recipient string, orderId string, actor string, note string) (*GiftCard, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("gift card amount must be positive")
+	}
+
+	code, err := NewGiftCardCode()
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewV4().String()
+
+	tx := db.MustBegin()
+
+	statement := "INSERT INTO giftcards (id, code, kind, initial, balance, recipient, orderid) VALUES ($1, $2, $3, $4, $4, $5, $6)"
+
+	if _, err := tx.Exec(statement, id, code, kind, amount, strings.ToLower(strings.TrimSpace(recipient)), nullableString(orderId)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	statement = "INSERT INTO giftcard_ledger (cardid, reason, amount, balance, orderid, actor, note) VALUES ($1, $2, $3, $3, $4, $5, $6)"
+
+	if _, err := tx.Exec(statement, id, GIFTCARD_ISSUE, amount, nullableString(orderId), actor, note); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetGiftCard(id)
+}
+
+func GetGiftCard(id string) (*GiftCard, error) {
+	var card GiftCard
+
+	statement := "SELECT * FROM giftcards WHERE id = $1"
+
+	err := db.Get(&card, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+func GetGiftCardByCode(code string) (*GiftCard, error) {
+	var card GiftCard
+
+	statement := "SELECT * FROM giftcards WHERE code = $1"
+
+	err := db.Get(&card, statement, NormalizeGiftCardCode(code))
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+// GetGiftCardEntry returns a single movement of a card's ledger
+func GetGiftCardEntry(id int) (*GiftCardEntry, error) {
+	var entry GiftCardEntry
+
+	err := db.Get(&entry, "SELECT * FROM giftcard_ledger WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// GetGiftCards lists the latest cards, filtered by code, recipient or order when a query is given
+func GetGiftCards(query string) ([]GiftCard, error) {
+	var cards []GiftCard = make([]GiftCard, 0)
+
+	query = strings.TrimSpace(query)
+
+	statement := `SELECT * FROM giftcards
+								WHERE $1 = ''
+									OR code = $2
+									OR recipient ILIKE '%' || $1 || '%'
+									OR orderid = $1
+								ORDER BY created DESC
+								LIMIT 100`
+
+	err := db.Select(&cards, statement, query, NormalizeGiftCardCode(query))
+	if err != nil {
+		return nil, err
+	}
+
+	return cards, nil
+}
+
+// GetOrderStoreCredit sums the store credit given back on an order
+func GetOrderStoreCredit(orderId string) (int, error) {
+	var credited int
+
+	statement := "SELECT COALESCE(SUM(initial), 0) FROM giftcards WHERE orderid = $1 AND kind = $2"
+
+	err := db.Get(&credited, statement, orderId, STORE_CREDIT)
+	if err != nil {
+		return 0, err
+	}
+
+	return credited, nil
+}
+
+func (g *GiftCard) GetLedger() ([]GiftCardEntry, error) {
+	var entries []GiftCardEntry = make([]GiftCardEntry, 0)
+
+	statement := "SELECT * FROM giftcard_ledger WHERE cardid = $1 ORDER BY created ASC, id ASC"
+
+	err := db.Select(&entries, statement, g.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// lockGiftCard reads a card and keeps it locked until the transaction ends
+func lockGiftCard(tx *sqlx.Tx, where string, value string) (*GiftCard, error) {
+	var card GiftCard
+
+	if err := tx.Get(&card, "SELECT * FROM giftcards WHERE "+where+" = $1 FOR UPDATE", value); err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+// HoldGiftCard takes up to amount off the card for a checkout. The redemption is not tied to an order
+// until ApplyGiftCard, and ReleaseGiftCard gives it back if the checkout never completes.
+func HoldGiftCard(code string, amount int) (*GiftCardEntry, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("nothing to pay with the gift card")
+	}
+
+	tx := db.MustBegin()
+
+	card, err := lockGiftCard(tx, "code", NormalizeGiftCardCode(code))
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("gift card not found")
+		}
+		return nil, err
+	}
+
+	if card.Voided || card.Balance <= 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, fmt.Errorf("gift card %s has no balance left", card.Code)
+	}
+
+	applied := min(amount, card.Balance)
+
+	var entry GiftCardEntry
+
+	if _, err := tx.Exec("UPDATE giftcards SET balance = balance - $1 WHERE id = $2", applied, card.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	statement := "INSERT INTO giftcard_ledger (cardid, reason, amount, balance) VALUES ($1, $2, $3, $4) RETURNING *"
+
+	if err := tx.Get(&entry, statement, card.Id, GIFTCARD_REDEEM, -applied, card.Balance-applied); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return &entry, nil
+}
+
+// ReleaseGiftCard gives back a redemption that never made it onto an order. Releasing twice does nothing.
+func ReleaseGiftCard(entryId int) error {
+	tx := db.MustBegin()
+
+	var entry GiftCardEntry
+
+	if err := tx.Get(&entry, "SELECT * FROM giftcard_ledger WHERE id = $1 AND reason = $2 FOR UPDATE", entryId, GIFTCARD_REDEEM); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	var released bool
+	if err := tx.Get(&released, "SELECT EXISTS(SELECT 1 FROM giftcard_ledger WHERE ref = $1)", entry.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if entry.OrderId != nil || released {
+		return tx.Rollback()
+	}
+
+	if err := releaseGiftCardEntry(tx, entry); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// releaseGiftCardEntry puts a redemption back on its card, recording the release against it
+func releaseGiftCardEntry(tx *sqlx.Tx, entry GiftCardEntry) error {
+	card, err := lockGiftCard(tx, "id", entry.CardId)
+	if err != nil {
+		return err
+	}
+
+	// A card voided meanwhile stays empty, the release is still recorded to close the redemption
+	amount := -entry.Amount
+	if card.Voided {
+		amount = 0
+	}
+
+	if _, err := tx.Exec("UPDATE giftcards SET balance = balance + $1 WHERE id = $2", amount, card.Id); err != nil {
+		return err
+	}
+
+	statement := "INSERT INTO giftcard_ledger (cardid, reason, amount, balance, orderid, ref) VALUES ($1, $2, $3, $4, $5, $6)"
+
+	_, err = tx.Exec(statement, card.Id, GIFTCARD_RELEASE, amount, card.Balance+amount, entry.OrderId, entry.Id)
+	return err
+}
+
+// releaseOrderGiftCards gives back the gift card amounts an order was paid with
+func releaseOrderGiftCards(tx *sqlx.Tx, orderId string) error {
+	var entries []GiftCardEntry = make([]GiftCardEntry, 0)
+
+	statement := `SELECT * FROM giftcard_ledger l
+								WHERE orderid = $1 AND reason = $2 AND NOT EXISTS (SELECT 1 FROM giftcard_ledger WHERE ref = l.id)
+								ORDER BY id FOR UPDATE`
+
+	if err := tx.Select(&entries, statement, orderId, GIFTCARD_REDEEM); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := releaseGiftCardEntry(tx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyGiftCard ties a held redemption to the new order it paid for
+func ApplyGiftCard(entryId int) OrderStep {
+	return func(tx *sqlx.Tx, order *Order) error {
+		var applied int
+
+		statement := `UPDATE giftcard_ledger SET orderid = $1
+									WHERE id = $2 AND reason = $3 AND orderid IS NULL
+										AND NOT EXISTS (SELECT 1 FROM giftcard_ledger WHERE ref = $2)
+									RETURNING -amount`
+
+		if err := tx.Get(&applied, statement, order.Id, entryId, GIFTCARD_REDEEM); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("gift card redemption %d is no longer held", entryId)
+			}
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE orders SET giftcard = giftcard + $1 WHERE id = $2", applied, order.Id); err != nil {
+			return err
+		}
+
+		order.GiftCard += applied
+
+		return nil
+	}
+}
+
+// Adjust adds amount to the balance, or takes it off when negative
+func (g *GiftCard) Adjust(amount int, actor string, note string) (*GiftCard, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("adjustment cannot be zero")
+	}
+
+	tx := db.MustBegin()
+
+	card, err := lockGiftCard(tx, "id", g.Id)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if card.Voided {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, fmt.Errorf("gift card %s is void", card.Code)
+	}
+
+	if card.Balance+amount < 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, fmt.Errorf("adjustment of %d exceeds the %d balance", amount, card.Balance)
+	}
+
+	if _, err := tx.Exec("UPDATE giftcards SET balance = balance + $1 WHERE id = $2", amount, card.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	statement := "INSERT INTO giftcard_ledger (cardid, reason, amount, balance, actor, note) VALUES ($1, $2, $3, $4, $5, $6)"
+
+	if _, err := tx.Exec(statement, card.Id, GIFTCARD_ADJUST, amount, card.Balance+amount, actor, note); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetGiftCard(g.Id)
+}
+
+// Void empties the card for good, the remaining balance is written off in the ledger
+func (g *GiftCard) Void(actor string, note string) (*GiftCard, error) {
+	tx := db.MustBegin()
+
+	card, err := lockGiftCard(tx, "id", g.Id)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if card.Voided {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, fmt.Errorf("gift card %s is already void", card.Code)
+	}
+
+	if _, err := tx.Exec("UPDATE giftcards SET balance = 0, voided = true WHERE id = $1", card.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	statement := "INSERT INTO giftcard_ledger (cardid, reason, amount, balance, actor, note) VALUES ($1, $2, $3, 0, $4, $5)"
+
+	if _, err := tx.Exec(statement, card.Id, GIFTCARD_VOID, -card.Balance, actor, note); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetGiftCard(g.Id)
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ClaimIdempotencyKey reserves the key for a checkout attempt. When the key was
+// already claimed it returns false together with the order it produced, if any.
+func ClaimIdempotencyKey(key string) (bool, string, error) {
+	statement := "INSERT INTO idempotency_keys (id) VALUES ($1) ON CONFLICT (id) DO NOTHING"
+
+	tx := db.MustBegin()
+
+	result, err := tx.Exec(statement, key)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return false, "", rollbackErr
+		}
+		return false, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return false, "", fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return false, "", fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, "", err
+	}
+
+	if affected > 0 {
+		return true, "", nil
+	}
+
+	var orderId sql.NullString
+
+	err = db.Get(&orderId, "SELECT orderid FROM idempotency_keys WHERE id = $1", key)
+	if err != nil {
+		return false, "", err
+	}
+
+	return false, orderId.String, nil
+}
+
+// CompleteIdempotencyKey ties the key to the order its checkout created, replays of the checkout then find the order
+func CompleteIdempotencyKey(key string) OrderStep {
+	return func(tx *sqlx.Tx, order *Order) error {
+		_, err := tx.Exec("UPDATE idempotency_keys SET orderid = $1 WHERE id = $2", order.Id, key)
+		return err
+	}
+}
+
+// ReleaseIdempotencyKey frees a key whose checkout attempt failed so it can be retried
+func ReleaseIdempotencyKey(key string) error {
+	statement := "DELETE FROM idempotency_keys WHERE id = $1 AND orderid IS NULL"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, key); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// ClaimWebhookEvent marks a provider event as being processed.
+// It returns false when the event was already received.
+func ClaimWebhookEvent(id string, eventType string) (bool, error) {
+	statement := "INSERT INTO webhook_events (id, type) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING"
+
+	tx := db.MustBegin()
+
+	result, err := tx.Exec(statement, id, eventType)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return false, rollbackErr
+		}
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return false, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// ReleaseWebhookEvent forgets an event whose processing failed so a retry is handled again
+func ReleaseWebhookEvent(id string) error {
+	statement := "DELETE FROM webhook_events WHERE id = $1"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
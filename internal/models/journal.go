@@ -0,0 +1,309 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type LedgerAccount struct {
+	Key     string    `json:"key"`
+	Code    string    `json:"code"`
+	Name    string    `json:"name"`
+	Updated time.Time `json:"updated"`
+}
+
+func GetLedgerAccounts() ([]LedgerAccount, error) {
+	var accounts []LedgerAccount = make([]LedgerAccount, 0)
+
+	statement := "SELECT * FROM ledger_accounts ORDER BY code ASC"
+
+	err := db.Select(&accounts, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func GetLedgerAccount(key string) (*LedgerAccount, error) {
+	var account LedgerAccount
+
+	statement := "SELECT * FROM ledger_accounts WHERE key = $1"
+
+	err := db.Get(&account, statement, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func (a *LedgerAccount) Update(code string, name string) (*LedgerAccount, error) {
+	statement := "UPDATE ledger_accounts SET code = $1, name = $2 WHERE key = $3"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, code, name, a.Key); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetLedgerAccount(a.Key)
+}
+
+type ClosedPeriod struct {
+	Day      time.Time       `json:"day"`
+	Starts   time.Time       `json:"starts"`
+	Ends     time.Time       `json:"ends"`
+	Closedby string          `json:"closed_by"`
+	Snapshot json.RawMessage `json:"snapshot"`
+	Created  time.Time       `json:"created"`
+}
+
+func GetClosedPeriods(from time.Time, to time.Time) ([]ClosedPeriod, error) {
+	var periods []ClosedPeriod = make([]ClosedPeriod, 0)
+
+	statement := "SELECT * FROM closed_periods WHERE day >= $1 AND day < $2 ORDER BY day ASC"
+
+	err := db.Select(&periods, statement, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	return periods, nil
+}
+
+// ClosePeriods freezes the settlements of local days. Exports keep using the stored snapshot and
+// the database refuses further changes to the orders and refunds of those days. The days are closed
+// together, none of them is when one fails.
+func ClosePeriods(days []time.Time, loc *time.Location, actor string) ([]ClosedPeriod, error) {
+	periods := make([]ClosedPeriod, 0, len(days))
+
+	statement := "INSERT INTO closed_periods (day, starts, ends, closedby, snapshot) VALUES ($1, $2, $3, $4, $5) RETURNING *"
+
+	tx := db.MustBegin()
+
+	rollback := func(err error) ([]ClosedPeriod, error) {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	for _, day := range days {
+		starts := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+		ends := starts.AddDate(0, 0, 1)
+
+		if ends.After(time.Now()) {
+			return rollback(fmt.Errorf("cannot close %s before the day is over", starts.Format("2006-01-02")))
+		}
+
+		settlements, err := GetDailySettlements(starts, ends, loc)
+		if err != nil {
+			return rollback(fmt.Errorf("error settling %s: %v", starts.Format("2006-01-02"), err))
+		}
+
+		snapshot, err := json.Marshal(settlements)
+		if err != nil {
+			return rollback(err)
+		}
+
+		var period ClosedPeriod
+		if err := tx.Get(&period, statement, starts.Format("2006-01-02"), starts.UTC(), ends.UTC(), actor, string(snapshot)); err != nil {
+			return rollback(fmt.Errorf("error closing %s: %v", starts.Format("2006-01-02"), err))
+		}
+
+		periods = append(periods, period)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return periods, nil
+}
+
+func GetClosedPeriod(day time.Time) (*ClosedPeriod, error) {
+	var period ClosedPeriod
+
+	statement := "SELECT * FROM closed_periods WHERE day = $1"
+
+	err := db.Get(&period, statement, day.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &period, nil
+}
+
+func (p *ClosedPeriod) Reopen() error {
+	statement := "DELETE FROM closed_periods WHERE day = $1"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, p.Day.Format("2006-01-02")); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetJournalSettlements returns the daily settlements for [from, to), taking closed days from their snapshot
+func GetJournalSettlements(from time.Time, to time.Time, loc *time.Location) ([]Settlement, error) {
+	live, err := GetDailySettlements(from, to, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	periods, err := GetClosedPeriods(from.In(loc), to.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	closed := make(map[string][]Settlement, len(periods))
+	for _, period := range periods {
+		var snapshot []Settlement
+		if err := json.Unmarshal(period.Snapshot, &snapshot); err != nil {
+			return nil, fmt.Errorf("error parsing snapshot of %s: %v", period.Day.Format("2006-01-02"), err)
+		}
+		closed[period.Day.Format("2006-01-02")] = snapshot
+	}
+
+	settlements := make([]Settlement, 0, len(live))
+	emitted := make(map[string]bool, len(closed))
+
+	for _, settlement := range live {
+		snapshot, ok := closed[settlement.Day]
+		if !ok {
+			settlements = append(settlements, settlement)
+			continue
+		}
+
+		if !emitted[settlement.Day] {
+			settlements = append(settlements, snapshot...)
+			emitted[settlement.Day] = true
+		}
+	}
+
+	// Closed days whose live rows have since vanished still belong in the journal
+	for _, period := range periods {
+		day := period.Day.Format("2006-01-02")
+		if !emitted[day] {
+			settlements = append(settlements, closed[day]...)
+			emitted[day] = true
+		}
+	}
+
+	sort.Slice(settlements, func(i, j int) bool {
+		if settlements[i].Day != settlements[j].Day {
+			return settlements[i].Day < settlements[j].Day
+		}
+		return settlements[i].Method < settlements[j].Method
+	})
+
+	return settlements, nil
+}
+
+type JournalLine struct {
+	Account LedgerAccount `json:"account"`
+	Debit   int           `json:"debit"`
+	Credit  int           `json:"credit"`
+	Memo    string        `json:"memo"`
+}
+
+type JournalEntry struct {
+	Day    string        `json:"day"`
+	Method PaymentMethod `json:"method"`
+	Memo   string        `json:"memo"`
+	Lines  []JournalLine `json:"lines"`
+}
+
+// BuildJournal turns settlements into balanced double-entry journal entries, one per day and method.
+// Sales are booked gross into the method's clearing account, then discounts, refunds and fees are taken out of it.
+// Tips go through the clearing account too but are owed to the staff rather than earned.
+// Gift cards sold and store credit are owed as card balance, redeemed cards pay for sales in place of the method.
+func BuildJournal(settlements []Settlement) ([]JournalEntry, error) {
+	accounts, err := GetLedgerAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	chart := make(map[string]LedgerAccount, len(accounts))
+	for _, account := range accounts {
+		chart[account.Key] = account
+	}
+
+	for _, key := range []string{"sales", "tax", "discounts", "refunds", "fees", "tips", "delivery", "giftcard", "cash", "stripe", "paypal", "card"} {
+		if _, ok := chart[key]; !ok {
+			return nil, fmt.Errorf("missing ledger account %s", key)
+		}
+	}
+
+	entries := make([]JournalEntry, 0, len(settlements))
+
+	for _, s := range settlements {
+		clearing := chart[string(s.Method)]
+		lines := make([]JournalLine, 0)
+
+		add := func(account LedgerAccount, debit int, credit int, memo string) {
+			if debit == 0 && credit == 0 {
+				return
+			}
+			lines = append(lines, JournalLine{Account: account, Debit: debit, Credit: credit, Memo: memo})
+		}
+
+		add(clearing, s.Sales-s.Discounts, 0, "Sales received")
+		add(chart["discounts"], s.Discounts, 0, "Discounts granted")
+		add(chart["sales"], 0, s.Sales-s.GiftCardsSold-s.Tax, "Sales")
+		add(chart["tax"], 0, s.Tax, "Tax collected")
+		add(chart["giftcard"], 0, s.GiftCardsSold, "Gift cards sold")
+		add(clearing, s.Delivery, 0, "Delivery fees received")
+		add(chart["delivery"], 0, s.Delivery, "Delivery fees")
+		add(clearing, s.Tips, 0, "Tips received")
+		add(chart["tips"], 0, s.Tips, "Tips owed to staff")
+		add(chart["refunds"], s.Refunds, 0, "Refunds")
+		add(clearing, 0, s.Refunds, "Refunds paid")
+		add(chart["fees"], s.Fees, 0, "Processing fees")
+		add(clearing, 0, s.Fees, "Processing fees")
+		add(chart["refunds"], s.StoreCredit, 0, "Refunds as store credit")
+		add(chart["giftcard"], 0, s.StoreCredit, "Store credit issued")
+
+		// Orders paid in full by gift card already clear through the liability
+		if clearing.Key != "giftcard" {
+			add(chart["giftcard"], s.GiftCards, 0, "Gift cards redeemed")
+			add(clearing, 0, s.GiftCards, "Paid with gift cards")
+		}
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		entries = append(entries, JournalEntry{Day: s.Day, Method: s.Method, Memo: fmt.Sprintf("Rosskery %s settlement %s", s.Method, s.Day), Lines: lines})
+	}
+
+	return entries, nil
+}
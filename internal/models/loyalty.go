@@ -0,0 +1,568 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/gommon/log"
+	uuid "github.com/satori/go.uuid"
+)
+
+type LoyaltyReason string
+
+const (
+	LOYALTY_EARN    LoyaltyReason = "earn"
+	LOYALTY_BONUS   LoyaltyReason = "bonus" // Extra points from a promotion on the products bought
+	LOYALTY_REDEEM  LoyaltyReason = "redeem"
+	LOYALTY_RELEASE LoyaltyReason = "release" // A redemption given back because the checkout was abandoned or the order cancelled
+	LOYALTY_EXPIRE  LoyaltyReason = "expire"
+	LOYALTY_ADJUST  LoyaltyReason = "adjust"
+)
+
+// LoyaltyEntry is a change of a customer's points, Points being negative when they are spent or lost
+type LoyaltyEntry struct {
+	Id      int           `json:"id"`
+	Email   string        `json:"email"`
+	Reason  LoyaltyReason `json:"reason"`
+	Points  int           `json:"points"`
+	OrderId *string       `json:"order_id" db:"orderid"`
+	Ref     *int          `json:"ref"` // Entry a release gives back
+	Actor   string        `json:"actor"`
+	Note    string        `json:"note"`
+	Created time.Time     `json:"created"`
+}
+
+type LoyaltyAccount struct {
+	Email   string         `json:"email"`
+	Balance int            `json:"balance"`
+	Value   int            `json:"value"` // What the balance is worth at checkout, in cents
+	History []LoyaltyEntry `json:"history"`
+}
+
+type LoyaltyPromotion struct {
+	Id         string    `json:"id"`
+	ProductId  string    `json:"product_id" db:"productid"`
+	Multiplier int       `json:"multiplier"`
+	Starts     time.Time `json:"starts"`
+	Ends       time.Time `json:"ends"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+// LoyaltyPointsPerDollar reads LOYALTY_POINTS_PER_DOLLAR, the points earned per dollar spent (1 by default)
+func LoyaltyPointsPerDollar() int {
+	return loyaltySetting("LOYALTY_POINTS_PER_DOLLAR", 1)
+}
+
+// LoyaltyPointValue reads LOYALTY_POINT_VALUE, what a point is worth at checkout in cents (1 by default)
+func LoyaltyPointValue() int {
+	return loyaltySetting("LOYALTY_POINT_VALUE", 1)
+}
+
+// LoyaltyExpiry reads LOYALTY_EXPIRY_DAYS, how long earned points last (365 days by default, 0 never expires)
+func LoyaltyExpiry() time.Duration {
+	return time.Duration(loyaltySetting("LOYALTY_EXPIRY_DAYS", 365)) * 24 * time.Hour
+}
+
+func loyaltySetting(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+
+	return value
+}
+
+func normalizeLoyaltyEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func GetLoyaltyBalance(email string) (int, error) {
+	var balance int
+
+	statement := "SELECT COALESCE(SUM(points), 0) FROM loyalty_points WHERE email = $1"
+
+	err := db.Get(&balance, statement, normalizeLoyaltyEmail(email))
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+func GetLoyaltyAccount(email string) (*LoyaltyAccount, error) {
+	email = normalizeLoyaltyEmail(email)
+
+	var history []LoyaltyEntry = make([]LoyaltyEntry, 0)
+
+	statement := "SELECT * FROM loyalty_points WHERE email = $1 ORDER BY created DESC, id DESC"
+
+	err := db.Select(&history, statement, email)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := 0
+	for _, entry := range history {
+		balance += entry.Points
+	}
+
+	return &LoyaltyAccount{Email: email, Balance: balance, Value: balance * LoyaltyPointValue(), History: history}, nil
+}
+
+func insertLoyaltyEntry(exec interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, email string, reason LoyaltyReason, points int, orderId string, actor string, note string) error {
+	statement := "INSERT INTO loyalty_points (email, reason, points, orderid, actor, note) VALUES ($1, $2, $3, $4, $5, $6)"
+
+	_, err := exec.Exec(statement, email, reason, points, nullableString(orderId), actor, note)
+	return err
+}
+
+// AdjustLoyaltyPoints adds points to the customer, or takes them off when negative
+func AdjustLoyaltyPoints(email string, points int, actor string, note string) (*LoyaltyAccount, error) {
+	email = normalizeLoyaltyEmail(email)
+
+	if points == 0 {
+		return nil, fmt.Errorf("adjustment cannot be zero")
+	}
+
+	tx := db.MustBegin()
+
+	// Serialises the changes to a customer's points so the balance cannot go negative
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", email); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	var balance int
+	if err := tx.Get(&balance, "SELECT COALESCE(SUM(points), 0) FROM loyalty_points WHERE email = $1", email); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if balance+points < 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, fmt.Errorf("adjustment of %d exceeds the %d points balance", points, balance)
+	}
+
+	if err := insertLoyaltyEntry(tx, email, LOYALTY_ADJUST, points, "", actor, note); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetLoyaltyAccount(email)
+}
+
+// HoldLoyaltyPoints spends up to maxDiscount worth of the customer's points for a checkout. The redemption
+// is not tied to an order until ApplyLoyaltyPoints, and ReleaseLoyaltyPoints gives it back if the checkout
+// never completes. Nil is returned when there are no points to spend.
+func HoldLoyaltyPoints(email string, maxDiscount int) (*LoyaltyEntry, error) {
+	email = normalizeLoyaltyEmail(email)
+
+	value := LoyaltyPointValue()
+	if value <= 0 || maxDiscount < value {
+		return nil, nil
+	}
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", email); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	var balance int
+	if err := tx.Get(&balance, "SELECT COALESCE(SUM(points), 0) FROM loyalty_points WHERE email = $1", email); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	points := min(balance, maxDiscount/value)
+	if points <= 0 {
+		return nil, tx.Rollback()
+	}
+
+	var entry LoyaltyEntry
+
+	statement := "INSERT INTO loyalty_points (email, reason, points) VALUES ($1, $2, $3) RETURNING *"
+
+	if err := tx.Get(&entry, statement, email, LOYALTY_REDEEM, -points); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return &entry, nil
+}
+
+// ReleaseLoyaltyPoints gives back a redemption that never made it onto an order. Releasing twice does nothing.
+func ReleaseLoyaltyPoints(entryId int) error {
+	tx := db.MustBegin()
+
+	var entry LoyaltyEntry
+
+	if err := tx.Get(&entry, "SELECT * FROM loyalty_points WHERE id = $1 AND reason = $2 FOR UPDATE", entryId, LOYALTY_REDEEM); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	var released bool
+	if err := tx.Get(&released, "SELECT EXISTS(SELECT 1 FROM loyalty_points WHERE ref = $1)", entry.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if entry.OrderId != nil || released {
+		return tx.Rollback()
+	}
+
+	statement := "INSERT INTO loyalty_points (email, reason, points, ref) VALUES ($1, $2, $3, $4)"
+
+	if _, err := tx.Exec(statement, entry.Email, LOYALTY_RELEASE, -entry.Points, entry.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// releaseOrderLoyaltyPoints gives back the points redeemed on an order
+func releaseOrderLoyaltyPoints(tx *sqlx.Tx, orderId string) error {
+	statement := `INSERT INTO loyalty_points (email, reason, points, orderid, ref)
+								SELECT email, $2, -points, orderid, id FROM loyalty_points l
+								WHERE orderid = $1 AND reason = $3 AND NOT EXISTS (SELECT 1 FROM loyalty_points WHERE ref = l.id)`
+
+	_, err := tx.Exec(statement, orderId, LOYALTY_RELEASE, LOYALTY_REDEEM)
+	return err
+}
+
+// ApplyLoyaltyPoints ties a held redemption to the new order it discounts
+func ApplyLoyaltyPoints(entryId int) OrderStep {
+	return func(tx *sqlx.Tx, order *Order) error {
+		var points int
+
+		statement := `UPDATE loyalty_points SET orderid = $1
+									WHERE id = $2 AND reason = $3 AND orderid IS NULL
+										AND NOT EXISTS (SELECT 1 FROM loyalty_points WHERE ref = $2)
+									RETURNING -points`
+
+		if err := tx.Get(&points, statement, order.Id, entryId, LOYALTY_REDEEM); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("points redemption %d is no longer held", entryId)
+			}
+			return err
+		}
+
+		discount := points * LoyaltyPointValue()
+
+		if _, err := tx.Exec("UPDATE orders SET discount = discount + $1 WHERE id = $2", discount, order.Id); err != nil {
+			return err
+		}
+
+		order.Discount += discount
+
+		return nil
+	}
+}
+
+// HasLoyalty tells whether the order's customer collects points, walk-in sales without contact details do not
+func (o *Order) HasLoyalty() bool {
+	return o.Customer.Id != AnonymousCustomerId && normalizeLoyaltyEmail(o.Customer.Email) != ""
+}
+
+// EarnLoyaltyPoints credits the customer of a fulfilled order with points for what they spent, plus the
+// bonus of the promotions running when the order was placed. Gift cards earn nothing until they are spent.
+// Orders are only credited once.
+func (o *Order) EarnLoyaltyPoints() error {
+	if !o.HasLoyalty() || !o.Fulfilled || o.Cancelled {
+		return nil
+	}
+
+	email := normalizeLoyaltyEmail(o.Customer.Email)
+
+	promotions, err := GetActiveLoyaltyPromotions(o.Created)
+	if err != nil {
+		return err
+	}
+
+	multipliers := make(map[string]int, len(promotions))
+	for _, promotion := range promotions {
+		multipliers[promotion.ProductId] = max(multipliers[promotion.ProductId], promotion.Multiplier)
+	}
+
+	perDollar := LoyaltyPointsPerDollar()
+	spent := 0
+	bonus := 0
+
+	for _, purchase := range o.Purchases {
+		if purchase.Product.IsGiftCard() {
+			continue
+		}
+
+		amount := purchase.Product.Price * purchase.Quantity
+		if purchase.Product.Weighed {
+			amount /= 10
+		}
+		spent += amount
+
+		if multiplier, ok := multipliers[purchase.Product.Id]; ok {
+			bonus += amount / 100 * perDollar * (multiplier - 1)
+		}
+	}
+
+	earned := max(spent-o.Discount, 0) / 100 * perDollar
+
+	tx := db.MustBegin()
+
+	statement := `INSERT INTO loyalty_points (email, reason, points, orderid) VALUES ($1, $2, $3, $4)
+								ON CONFLICT (orderid, reason) WHERE reason IN ('earn', 'bonus') DO NOTHING`
+
+	for _, entry := range []struct {
+		reason LoyaltyReason
+		points int
+	}{{LOYALTY_EARN, earned}, {LOYALTY_BONUS, bonus}} {
+		if entry.points <= 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(statement, email, entry.reason, entry.points, o.Id); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return rollbackErr
+			}
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// rewardLoyalty credits the points of an order that was just fulfilled. The hand over already happened,
+// failures are logged and the points can be added by hand.
+func rewardLoyalty(orderId string) {
+	order, err := GetOrder(orderId)
+	if err == nil {
+		err = order.EarnLoyaltyPoints()
+	}
+
+	if err != nil {
+		log.Errorf("Error crediting loyalty points for order %s <- %v", orderId, err)
+	}
+}
+
+// ExpireLoyaltyPoints takes off the points earned before now minus the expiry period that were not spent yet.
+// Spending uses the oldest points first, so whatever was earned before the cutoff beyond all the points
+// ever spent, expired or taken off is what expires.
+func ExpireLoyaltyPoints(now time.Time) (int, error) {
+	expiry := LoyaltyExpiry()
+	if expiry == 0 {
+		return 0, nil
+	}
+
+	type expiring struct {
+		Email  string
+		Points int
+	}
+
+	var accounts []expiring = make([]expiring, 0)
+
+	statement := `SELECT email, earned - spent AS points FROM (
+										SELECT email,
+											COALESCE(SUM(CASE WHEN points > 0 AND reason != $2 AND created < $1 THEN points ELSE 0 END), 0) AS earned,
+											COALESCE(SUM(CASE WHEN points < 0 THEN -points WHEN reason = $2 THEN -points ELSE 0 END), 0) AS spent
+										FROM loyalty_points
+										GROUP BY email
+									) totals
+									WHERE earned > spent`
+
+	err := db.Select(&accounts, statement, now.Add(-expiry).UTC(), LOYALTY_RELEASE)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+
+	for _, account := range accounts {
+		tx := db.MustBegin()
+
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", account.Email); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return expired, rollbackErr
+			}
+			return expired, err
+		}
+
+		// Never take more than the balance, held redemptions included
+		var balance int
+		if err := tx.Get(&balance, "SELECT COALESCE(SUM(points), 0) FROM loyalty_points WHERE email = $1", account.Email); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return expired, rollbackErr
+			}
+			return expired, err
+		}
+
+		points := min(account.Points, balance)
+		if points <= 0 {
+			if err := tx.Rollback(); err != nil {
+				return expired, err
+			}
+			continue
+		}
+
+		if err := insertLoyaltyEntry(tx, account.Email, LOYALTY_EXPIRE, -points, "", "", fmt.Sprintf("Earned before %s", now.Add(-expiry).Format("2006-01-02"))); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return expired, rollbackErr
+			}
+			return expired, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return expired, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+			}
+			return expired, fmt.Errorf("error committing transaction: %v", err)
+		}
+
+		expired += points
+	}
+
+	return expired, nil
+}
+
+func GetLoyaltyPromotions() ([]LoyaltyPromotion, error) {
+	var promotions []LoyaltyPromotion = make([]LoyaltyPromotion, 0)
+
+	statement := "SELECT * FROM loyalty_promotions ORDER BY starts DESC"
+
+	err := db.Select(&promotions, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+// GetActiveLoyaltyPromotions lists the promotions running at the given time
+func GetActiveLoyaltyPromotions(at time.Time) ([]LoyaltyPromotion, error) {
+	var promotions []LoyaltyPromotion = make([]LoyaltyPromotion, 0)
+
+	statement := "SELECT * FROM loyalty_promotions WHERE starts <= $1 AND ends > $1"
+
+	err := db.Select(&promotions, statement, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+func GetLoyaltyPromotion(id string) (*LoyaltyPromotion, error) {
+	var promotion LoyaltyPromotion
+
+	statement := "SELECT * FROM loyalty_promotions WHERE id = $1"
+
+	err := db.Get(&promotion, statement, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &promotion, nil
+}
+
+func CreateLoyaltyPromotion(productId string, multiplier int, starts time.Time, ends time.Time) (*LoyaltyPromotion, error) {
+	statement := "INSERT INTO loyalty_promotions (id, productid, multiplier, starts, ends) VALUES ($1, $2, $3, $4, $5)"
+
+	id := uuid.NewV4().String()
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, id, productId, multiplier, starts, ends); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetLoyaltyPromotion(id)
+}
+
+func (p *LoyaltyPromotion) Delete() error {
+	statement := "DELETE FROM loyalty_promotions WHERE id = $1"
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, p.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
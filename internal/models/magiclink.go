@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/storage"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	ErrMagicLinkExpired     = errors.New("the sign in link is invalid or expired")
+	ErrMagicLinkRateLimited = errors.New("too many sign in links requested, please try again later")
+)
+
+const (
+	magicLinkTTL    = 15 * time.Minute
+	magicLinkWindow = time.Hour
+)
+
+// MagicLinkEmailLimit reads MAGIC_LINK_EMAIL_LIMIT, the sign in links an email can receive per hour (3 by default)
+func MagicLinkEmailLimit() int {
+	return helpers.PositiveIntEnv("MAGIC_LINK_EMAIL_LIMIT", 3)
+}
+
+// MagicLinkIPLimit reads MAGIC_LINK_IP_LIMIT, the sign in links an address can request per hour (10 by default)
+func MagicLinkIPLimit() int {
+	return helpers.PositiveIntEnv("MAGIC_LINK_IP_LIMIT", 10)
+}
+
+// allowMagicLink counts a request against a rate limit key, the window starts with the first request
+func allowMagicLink(ctx context.Context, key string, limit int) (bool, error) {
+	count, err := storage.Valkey.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if count == 1 {
+		if err := storage.Valkey.Expire(ctx, key, magicLinkWindow).Err(); err != nil {
+			return false, err
+		}
+	}
+
+	return count <= int64(limit), nil
+}
+
+// RequestMagicLink issues a single use sign in token for the email. The token carries the email rather
+// than an account so nothing is created until the link is followed, which also proves the email is theirs.
+func RequestMagicLink(ctx context.Context, email string, ip string) (string, error) {
+	email = normalizeAccountEmail(email)
+
+	if _, err := mail.ParseAddress(email); err != nil {
+		return "", fmt.Errorf("invalid email")
+	}
+
+	allowed, err := allowMagicLink(ctx, "magic:ip:"+ip, MagicLinkIPLimit())
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return "", ErrMagicLinkRateLimited
+	}
+
+	allowed, err = allowMagicLink(ctx, "magic:email:"+email, MagicLinkEmailLimit())
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return "", ErrMagicLinkRateLimited
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(bytes)
+
+	if err := storage.Valkey.Set(ctx, "magic:token:"+token, email, magicLinkTTL).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeMagicLink spends a sign in token and returns the verified account of its email,
+// opening a passwordless one for a customer signing in for the first time. An unverified account of the
+// email may have been opened by someone else, it loses its password, details and saved addresses and
+// gets a new id, which signs out every session still holding the old one.
+func ConsumeMagicLink(ctx context.Context, token string) (*Account, error) {
+	email, err := storage.Valkey.GetDel(ctx, "magic:token:"+token).Result()
+	if err != nil || email == "" {
+		return nil, ErrMagicLinkExpired
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM account_addresses WHERE accountid IN (SELECT id FROM accounts WHERE email = $1 AND verified = false)", []interface{}{email}},
+		{`INSERT INTO accounts (id, email, verified) VALUES ($1, $2, true)
+								ON CONFLICT (email) DO UPDATE SET
+									id = CASE WHEN accounts.verified THEN accounts.id ELSE EXCLUDED.id END,
+									password = CASE WHEN accounts.verified THEN accounts.password ELSE '' END,
+									fullname = CASE WHEN accounts.verified THEN accounts.fullname ELSE '' END,
+									phone = CASE WHEN accounts.verified THEN accounts.phone ELSE '' END,
+									verified = true`, []interface{}{uuid.NewV4().String(), email}},
+	}
+
+	tx := db.MustBegin()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetAccountByEmail(email)
+}
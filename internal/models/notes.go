@@ -0,0 +1,234 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+type NoteAction string
+
+const (
+	NOTE_CREATED NoteAction = "created"
+	NOTE_EDITED  NoteAction = "edited"
+	NOTE_DELETED NoteAction = "deleted"
+)
+
+// SuggestedTags are offered to the admin when tagging, any other tag is accepted as well
+var SuggestedTags = []string{"wholesale", "allergy-nut", "vip", "frequent-no-show"}
+
+// CustomerNote is a free-form note the staff keeps about a customer
+type CustomerNote struct {
+	Id         int       `json:"id"`
+	CustomerId string    `json:"customer_id" db:"customerid"`
+	Body       string    `json:"body"`
+	Author     string    `json:"author"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+// CustomerNoteEvent keeps each version of a note, deleted notes included, for the customer's timeline
+type CustomerNoteEvent struct {
+	Id         int        `json:"id"`
+	NoteId     int        `json:"note_id" db:"noteid"`
+	CustomerId string     `json:"customer_id" db:"customerid"`
+	Action     NoteAction `json:"action"`
+	Body       string     `json:"body"`
+	Actor      string     `json:"actor"`
+	Created    time.Time  `json:"created"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag lowercases a tag and joins its words with dashes so "Allergy Nut" and "allergy-nut" are one tag
+func NormalizeTag(tag string) string {
+	words := strings.FieldsFunc(strings.ToLower(tag), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	return strings.Join(words, "-")
+}
+
+// NormalizeTags normalizes the tags dropping blanks and repeats
+func NormalizeTags(tags []string) []string {
+	var normalized []string = make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+func GetCustomerTags(customerId string) ([]string, error) {
+	var tags []string = make([]string, 0)
+
+	statement := "SELECT tag FROM customer_tags WHERE customerid = $1 ORDER BY tag ASC"
+
+	err := db.Select(&tags, statement, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetTags counts the customers carrying each tag in use
+func GetTags() ([]TagCount, error) {
+	var tags []TagCount = make([]TagCount, 0)
+
+	statement := "SELECT tag, COUNT(*) AS count FROM customer_tags GROUP BY tag ORDER BY count DESC, tag ASC"
+
+	err := db.Select(&tags, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// SetCustomerTags replaces the customer's tags
+func SetCustomerTags(customerId string, tags []string) ([]string, error) {
+	tags = NormalizeTags(tags)
+
+	for _, tag := range tags {
+		if len(tag) > 40 {
+			return nil, fmt.Errorf("tag %s is longer than 40 characters", tag)
+		}
+	}
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec("DELETE FROM customer_tags WHERE customerid = $1 AND tag != ALL($2)", customerId, pq.Array(tags)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO customer_tags (customerid, tag) SELECT $1, UNNEST($2::TEXT[]) ON CONFLICT (customerid, tag) DO NOTHING", customerId, pq.Array(tags)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetCustomerTags(customerId)
+}
+
+func GetCustomerNotes(customerId string) ([]CustomerNote, error) {
+	var notes []CustomerNote = make([]CustomerNote, 0)
+
+	statement := "SELECT * FROM customer_notes WHERE customerid = $1 ORDER BY created DESC"
+
+	err := db.Select(&notes, statement, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+func GetCustomerNote(customerId string, id string) (*CustomerNote, error) {
+	var note CustomerNote
+
+	statement := "SELECT * FROM customer_notes WHERE id = $1 AND customerid = $2"
+
+	err := db.Get(&note, statement, id, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+// recordNote writes the note change and its event in one transaction
+func recordNote(statement string, args []interface{}, action NoteAction, actor string) (*CustomerNote, error) {
+	tx := db.MustBegin()
+
+	var note CustomerNote
+	if err := tx.Get(&note, statement, args...); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO customer_note_events (noteid, customerid, action, body, actor) VALUES ($1, $2, $3, $4, $5)", note.Id, note.CustomerId, action, note.Body, actor); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return &note, nil
+}
+
+func AddCustomerNote(customerId string, body string, author string) (*CustomerNote, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, fmt.Errorf("the note is empty")
+	}
+
+	statement := "INSERT INTO customer_notes (customerid, body, author) VALUES ($1, $2, $3) RETURNING *"
+
+	return recordNote(statement, []interface{}{customerId, body, author}, NOTE_CREATED, author)
+}
+
+func (note *CustomerNote) Edit(body string, actor string) (*CustomerNote, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, fmt.Errorf("the note is empty")
+	}
+
+	statement := "UPDATE customer_notes SET body = $1 WHERE id = $2 RETURNING *"
+
+	return recordNote(statement, []interface{}{body, note.Id}, NOTE_EDITED, actor)
+}
+
+func (note *CustomerNote) Delete(actor string) error {
+	statement := "DELETE FROM customer_notes WHERE id = $1 RETURNING *"
+
+	_, err := recordNote(statement, []interface{}{note.Id}, NOTE_DELETED, actor)
+
+	return err
+}
+
+func GetCustomerNoteEvents(customerId string) ([]CustomerNoteEvent, error) {
+	var events []CustomerNoteEvent = make([]CustomerNoteEvent, 0)
+
+	statement := "SELECT * FROM customer_note_events WHERE customerid = $1 ORDER BY created DESC"
+
+	err := db.Select(&events, statement, customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
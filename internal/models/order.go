@@ -2,6 +2,10 @@ package models
 
 import (
 	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -143,12 +147,14 @@ func (p *Purchase) Delete() (*Purchase, error) {
 type PaymentMethod string
 
 const (
-	CASH   PaymentMethod = "cash"
-	STRIPE PaymentMethod = "stripe"
-	PAYPAL PaymentMethod = "paypal"
+	CASH     PaymentMethod = "cash"
+	STRIPE   PaymentMethod = "stripe"
+	PAYPAL   PaymentMethod = "paypal"
+	CARD     PaymentMethod = "card"
+	GIFTCARD PaymentMethod = "giftcard" // Orders fully covered by a gift card or store credit
 )
 
-var PaymentMethods = []PaymentMethod{CASH, STRIPE, PAYPAL}
+var PaymentMethods = []PaymentMethod{CASH, STRIPE, PAYPAL, CARD, GIFTCARD}
 
 func GetColorForMethod(method PaymentMethod) int {
 	switch method {
@@ -158,6 +164,10 @@ func GetColorForMethod(method PaymentMethod) int {
 		return 0xD22371
 	case PAYPAL:
 		return 0x0D3575
+	case CARD:
+		return 0xF2A541
+	case GIFTCARD:
+		return 0x8E5CC2
 	default:
 		return 0x22BB6F
 	}
@@ -171,47 +181,150 @@ func ParsePaymentMethod(method string) PaymentMethod {
 		return STRIPE
 	case "paypal":
 		return PAYPAL
+	case "card":
+		return CARD
+	case "giftcard":
+		return GIFTCARD
 	default:
 		return CASH
 	}
 }
 
+type Channel string
+
+const (
+	ONLINE Channel = "online"
+	WALKIN Channel = "walk-in"
+)
+
+var Channels = []Channel{ONLINE, WALKIN}
+
+// ParseChannel returns an empty channel, meaning every channel, for unknown values
+func ParseChannel(channel string) Channel {
+	switch channel {
+	case "online":
+		return ONLINE
+	case "walk-in":
+		return WALKIN
+	default:
+		return ""
+	}
+}
+
+// channelFilter restricts a query to the orders of a channel when one is given
+func channelFilter(column string, channel Channel) string {
+	if channel == "" {
+		return ""
+	}
+
+	return " AND " + column + " = '" + string(channel) + "'"
+}
+
+// channelPurchases restricts a purchases join to the orders of a channel when one is given
+func channelPurchases(channel Channel) string {
+	if channel == "" {
+		return ""
+	}
+
+	return " AND p.orderid IN (SELECT id FROM orders WHERE channel = '" + string(channel) + "')"
+}
+
 type DbOrder struct {
-	Id         string    `json:"id"`
-	CustomerId string    `json:"customer_id" db:"customer"`
-	Pickuptime time.Time `json:"pickuptime"`
-	Fulfilled  bool      `json:"fulfilled"`
-	Method     string    `json:"method"`
-	Created    time.Time `json:"created"`
-	Updated    time.Time `json:"updated"`
+	Id            string     `json:"id"`
+	CustomerId    string     `json:"customer_id" db:"customer"`
+	Pickuptime    time.Time  `json:"pickuptime"`
+	Fulfilled     bool       `json:"fulfilled"`
+	Cancelled     bool       `json:"cancelled"`
+	Method        string     `json:"method"`
+	Channel       string     `json:"channel"`
+	Notes         string     `json:"notes"`
+	Discount      int        `json:"discount"`
+	Fee           int        `json:"fee"`
+	Tip           int        `json:"tip"`
+	GiftCard      int        `json:"gift_card" db:"giftcard"`
+	Referral      string     `json:"referral"`
+	PaymentIntent string     `json:"payment_intent" db:"paymentintent"`
+	ChargeId      string     `json:"charge_id" db:"chargeid"`
+	PaymentStatus string     `json:"payment_status" db:"paymentstatus"`
+	Paid          int        `json:"paid"`
+	Refunded      int        `json:"refunded"`
+	AddressId     *int       `json:"address_id" db:"addressid"`
+	Fulfilment    string     `json:"fulfilment"`
+	DeliveryFee   int        `json:"delivery_fee" db:"deliveryfee"`
+	DeliveryZone  *int       `json:"delivery_zone" db:"deliveryzone"`
+	WindowStart   *time.Time `json:"window_start" db:"windowstart"`
+	WindowEnd     *time.Time `json:"window_end" db:"windowend"`
+	Created       time.Time  `json:"created"`
+	Updated       time.Time  `json:"updated"`
 }
 
 type Order struct {
-	Id         string     `json:"id"`
-	Customer   Customer   `json:"customer"`
-	Purchases  []Purchase `json:"purchases"`
-	Pickuptime time.Time  `json:"pickuptime"`
-	Fulfilled  bool       `json:"fulfilled"`
-	Method     string     `json:"method"`
-	Created    time.Time  `json:"created"`
-	Updated    time.Time  `json:"updated"`
+	Id            string     `json:"id"`
+	Customer      Customer   `json:"customer"`
+	Purchases     []Purchase `json:"purchases"`
+	Pickuptime    time.Time  `json:"pickuptime"`
+	Fulfilled     bool       `json:"fulfilled"`
+	Cancelled     bool       `json:"cancelled"`
+	Method        string     `json:"method"`
+	Channel       string     `json:"channel"`
+	Notes         string     `json:"notes"`
+	Discount      int        `json:"discount"`
+	Fee           int        `json:"fee"`
+	Tip           int        `json:"tip"`       // Left for the bakers, not part of the sales
+	GiftCard      int        `json:"gift_card"` // Covered by a gift card or store credit
+	Referral      string     `json:"referral"`  // Referral code the order was placed with
+	PaymentIntent string     `json:"payment_intent"`
+	ChargeId      string     `json:"charge_id"`
+	PaymentStatus string     `json:"payment_status"`
+	Paid          int        `json:"paid"`
+	Refunded      int        `json:"refunded"`
+	AddressId     *int       `json:"address_id"` // Saved address of the customer the order goes to
+	Fulfilment    string     `json:"fulfilment"`
+	DeliveryFee   int        `json:"delivery_fee"`  // Charged on top of the items for deliveries
+	DeliveryZone  *int       `json:"delivery_zone"` // Zone the delivery address fell in
+	WindowStart   *time.Time `json:"window_start"`  // Delivery window the order is brought in
+	WindowEnd     *time.Time `json:"window_end"`
+	Created       time.Time  `json:"created"`
+	Updated       time.Time  `json:"updated"`
 }
 
 func (dbp *DbOrder) ConvertToOrder(customer Customer, purchases []Purchase) *Order {
 	return &Order{
-		Id:         dbp.Id,
-		Customer:   customer,
-		Purchases:  purchases,
-		Pickuptime: dbp.Pickuptime,
-		Fulfilled:  dbp.Fulfilled,
-		Method:     dbp.Method,
-		Created:    dbp.Created,
-		Updated:    dbp.Updated,
+		Id:            dbp.Id,
+		Customer:      customer,
+		Purchases:     purchases,
+		Pickuptime:    dbp.Pickuptime,
+		Fulfilled:     dbp.Fulfilled,
+		Cancelled:     dbp.Cancelled,
+		Method:        dbp.Method,
+		Channel:       dbp.Channel,
+		Notes:         dbp.Notes,
+		Discount:      dbp.Discount,
+		Fee:           dbp.Fee,
+		Tip:           dbp.Tip,
+		GiftCard:      dbp.GiftCard,
+		Referral:      dbp.Referral,
+		PaymentIntent: dbp.PaymentIntent,
+		ChargeId:      dbp.ChargeId,
+		PaymentStatus: dbp.PaymentStatus,
+		Paid:          dbp.Paid,
+		Refunded:      dbp.Refunded,
+		AddressId:     dbp.AddressId,
+		Fulfilment:    dbp.Fulfilment,
+		DeliveryFee:   dbp.DeliveryFee,
+		DeliveryZone:  dbp.DeliveryZone,
+		WindowStart:   dbp.WindowStart,
+		WindowEnd:     dbp.WindowEnd,
+		Created:       dbp.Created,
+		Updated:       dbp.Updated,
 	}
 }
 
-func CreateOrder(customerId string, pickuptime time.Time, items []PurchasedItem, method PaymentMethod) (*Order, error) {
-	statement := "INSERT INTO orders (id, customer, pickuptime, fulfilled, method) VALUES ($1, $2, $3, $4, $5)"
+// OrderStep records part of a new order in the transaction creating it, so an order is never left half written
+type OrderStep func(tx *sqlx.Tx, order *Order) error
+
+func CreateOrder(customerId string, pickuptime time.Time, items []PurchasedItem, method PaymentMethod, channel Channel, notes string, tip int, steps ...OrderStep) (*Order, error) {
+	statement := "INSERT INTO orders (id, customer, pickuptime, fulfilled, method, channel, notes, tip) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
 
 	customer, err := GetDbCustomer(customerId)
 	if err != nil {
@@ -219,9 +332,9 @@ func CreateOrder(customerId string, pickuptime time.Time, items []PurchasedItem,
 	}
 	tx := db.MustBegin()
 
-	newOrder := &Order{Id: uuid.NewV4().String(), Customer: *(*customer).ConvertToCustomer(time.Time{}, 0), Pickuptime: pickuptime, Purchases: make([]Purchase, len(items)), Fulfilled: false, Method: string(method)}
+	newOrder := &Order{Id: uuid.NewV4().String(), Customer: *(*customer).ConvertToCustomer(time.Time{}, 0), Pickuptime: pickuptime, Purchases: make([]Purchase, len(items)), Fulfilled: false, Method: string(method), Channel: string(channel), Notes: notes, Tip: tip}
 
-	if _, err = tx.Exec(statement, newOrder.Id, newOrder.Customer.Id, newOrder.Pickuptime, newOrder.Fulfilled, newOrder.Method); err != nil {
+	if _, err = tx.Exec(statement, newOrder.Id, newOrder.Customer.Id, newOrder.Pickuptime, newOrder.Fulfilled, newOrder.Method, newOrder.Channel, newOrder.Notes, newOrder.Tip); err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			return nil, rollbackErr
 		}
@@ -237,6 +350,15 @@ func CreateOrder(customerId string, pickuptime time.Time, items []PurchasedItem,
 		newOrder.Purchases[i] = *purchase
 	}
 
+	for _, step := range steps {
+		if err := step(tx, newOrder); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			return nil, err
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
@@ -360,6 +482,10 @@ func (o *Order) Fulfill() (*Order, error) {
 		return nil, err
 	}
 
+	if err := updatedOrder.EarnLoyaltyPoints(); err != nil {
+		log.Errorf("Error crediting loyalty points for order %s <- %v", o.Id, err)
+	}
+
 	return updatedOrder, nil
 }
 
@@ -432,9 +558,11 @@ type FinancesResponse struct {
 	PendingMoney    int `json:"pending_money"`    // Paid online but still unfulfilled
 	Gains           int `json:"gains"`            // All money from fulfilled orders
 	Total           int `json:"total"`            // Total money registred under every order made
+	Tips            int `json:"tips"`             // Tips left on orders, kept out of gains and total
 
 	OrdersData   Dataset `json:"orders_data"`
 	MonetaryData Dataset `json:"monetary_data"`
+	TipsData     Dataset `json:"tips_data"`
 
 	PreferredMethodData []Dataset `json:"preferred_method_data"`
 
@@ -454,6 +582,7 @@ type FinancesStats struct {
 	PendingMoney    int `json:"pending_money"`    // Paid online but still unfulfilled
 	Gains           int `json:"gains"`            // All money from fulfilled orders
 	Total           int `json:"total"`
+	Tips            int `json:"tips"`
 }
 
 type OrdersStandingsResponse struct {
@@ -464,9 +593,9 @@ type OrdersStandingsResponse struct {
 	FloppedGainers []RankedGainer `json:"flopped_gainers"`
 }
 
-func GetOrdersAmount() (int, error) {
+func GetOrdersAmount(channel Channel) (int, error) {
 	var amount int
-	statement := "SELECT COUNT(*) FROM orders"
+	statement := "SELECT COUNT(*) FROM orders WHERE true" + channelFilter("channel", channel)
 
 	err := db.Get(&amount, statement)
 	if err != nil {
@@ -476,7 +605,7 @@ func GetOrdersAmount() (int, error) {
 	return amount, nil
 }
 
-func GetOutstandingCash() (int, error) {
+func GetOutstandingCash(channel Channel) (int, error) {
 	var outstanding int
 
 	statement := `SELECT COALESCE(
@@ -489,10 +618,11 @@ func GetOutstandingCash() (int, error) {
             0
         ) AS outstanding
 								FROM orders
-								JOIN purchases ON orders.id = purchases.orderid
-								JOIN products ON purchases.productid = products.id
+								JOIN purchases p ON orders.id = p.orderid
+								JOIN products pr ON p.productid = pr.id
 								WHERE orders.fulfilled = false
-								AND orders.method = 'cash'`
+								AND orders.cancelled = false
+								AND orders.method = 'cash'` + channelFilter("orders.channel", channel)
 
 	err := db.Get(&outstanding, statement)
 	if err != nil {
@@ -502,7 +632,7 @@ func GetOutstandingCash() (int, error) {
 	return outstanding, nil
 }
 
-func GetPendingMoney() (int, error) {
+func GetPendingMoney(channel Channel) (int, error) {
 	var pending int
 
 	statement := `SELECT COALESCE(ROUND(SUM(total_cost)), 0) AS pending
@@ -520,7 +650,8 @@ func GetPendingMoney() (int, error) {
 										JOIN purchases p ON o.id = p.orderid
 										JOIN products pr ON p.productid = pr.id
 										WHERE o.fulfilled = false
-										AND o.method != 'cash'
+										AND o.cancelled = false
+										AND o.method != 'cash'` + channelFilter("o.channel", channel) + `
 										GROUP BY o.id
 								) AS order_totals`
 
@@ -532,7 +663,8 @@ func GetPendingMoney() (int, error) {
 	return pending, nil
 }
 
-func GetGains() (int, error) {
+// GetGains sums the product sales of fulfilled orders, tips are reported apart by GetTips
+func GetGains(channel Channel) (int, error) {
 	var gains int
 
 	statement := `SELECT COALESCE(ROUND(SUM(total_cost), 0)) AS gains
@@ -549,7 +681,7 @@ func GetGains() (int, error) {
 										FROM orders o
 										JOIN purchases p ON o.id = p.orderid
 										JOIN products pr ON p.productid = pr.id
-										WHERE o.fulfilled = true
+										WHERE o.fulfilled = true` + channelFilter("o.channel", channel) + `
 										GROUP BY o.id
 								) AS order_totals`
 
@@ -561,7 +693,7 @@ func GetGains() (int, error) {
 	return gains, nil
 }
 
-func GetTotalFromOrders() (int, error) {
+func GetTotalFromOrders(channel Channel) (int, error) {
 	var total int
 
 	statement := `SELECT COALESCE(ROUND(SUM(total_cost), 0)) AS total
@@ -578,6 +710,7 @@ func GetTotalFromOrders() (int, error) {
 										FROM orders o
 										JOIN purchases p ON o.id = p.orderid
 										JOIN products pr ON p.productid = pr.id
+										WHERE true` + channelFilter("o.channel", channel) + `
 										GROUP BY o.id
 								) AS order_totals`
 
@@ -589,7 +722,7 @@ func GetTotalFromOrders() (int, error) {
 	return total, nil
 }
 
-func GetOrdersData(timeframe Timeframe, method PaymentMethod, fulfilled bool) (Dataset, error) {
+func GetOrdersData(timeframe Timeframe, method PaymentMethod, fulfilled bool, channel Channel) (Dataset, error) {
 
 	var results []Count = make([]Count, 0)
 	var whereStm string
@@ -612,6 +745,8 @@ func GetOrdersData(timeframe Timeframe, method PaymentMethod, fulfilled bool) (D
 		whereStm += " AND fulfilled = true"
 	}
 
+	whereStm += channelFilter("channel", channel)
+
 	statement := `SELECT DATE(created) as date, COUNT(*) as count FROM orders ` + whereStm + ` GROUP BY created ORDER BY created ASC`
 
 	err = db.Select(&results, statement)
@@ -627,7 +762,7 @@ func GetOrdersData(timeframe Timeframe, method PaymentMethod, fulfilled bool) (D
 	return Dataset{Horizontal: horizontal, Vertical: vertical}, nil
 }
 
-func GetMonetaryData(timeframe Timeframe, method PaymentMethod, fulfilled bool) (Dataset, error) {
+func GetMonetaryData(timeframe Timeframe, method PaymentMethod, fulfilled bool, channel Channel) (Dataset, error) {
 	var results []Count = make([]Count, 0)
 	var whereStm string
 
@@ -649,6 +784,8 @@ func GetMonetaryData(timeframe Timeframe, method PaymentMethod, fulfilled bool)
 		whereStm += " AND fulfilled = true"
 	}
 
+	whereStm += channelFilter("orders.channel", channel)
+
 	statement := `SELECT DATE(orders.created) as date, COALESCE(
             SUM(
                 CASE
@@ -657,7 +794,54 @@ func GetMonetaryData(timeframe Timeframe, method PaymentMethod, fulfilled bool)
                 END
             ),
             0
-        ) as count FROM orders JOIN purchases ON orders.id = purchases.orderid JOIN products ON purchases.productid = products.id ` + whereStm + `  GROUP BY orders.created ORDER BY orders.created ASC`
+        ) as count FROM orders JOIN purchases p ON orders.id = p.orderid JOIN products pr ON p.productid = pr.id ` + whereStm + `  GROUP BY orders.created ORDER BY orders.created ASC`
+
+	err = db.Select(&results, statement)
+
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	vertical, err := ComputeVertical(results, horizontal, timeframe)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	return Dataset{Horizontal: horizontal, Vertical: vertical}, nil
+}
+
+// GetTips sums the tips left on orders that were not cancelled
+func GetTips(channel Channel) (int, error) {
+	var tips int
+
+	statement := "SELECT COALESCE(SUM(tip), 0) FROM orders WHERE cancelled = false" + channelFilter("channel", channel)
+
+	err := db.Get(&tips, statement)
+	if err != nil {
+		return 0, err
+	}
+
+	return tips, nil
+}
+
+func GetTipsData(timeframe Timeframe, fulfilled bool, channel Channel) (Dataset, error) {
+	var results []Count = make([]Count, 0)
+	var whereStm string
+
+	horizontal, err := GetHorizonalDataAndQueryByTimeframe("orders.created", timeframe, &whereStm)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	if !fulfilled {
+		whereStm += " AND fulfilled = false"
+	} else {
+		whereStm += " AND fulfilled = true"
+	}
+
+	whereStm += " AND cancelled = false" + channelFilter("orders.channel", channel)
+
+	statement := `SELECT DATE(orders.created) as date, COALESCE(SUM(orders.tip), 0) as count FROM orders ` + whereStm + ` GROUP BY orders.created ORDER BY orders.created ASC`
 
 	err = db.Select(&results, statement)
 
@@ -673,7 +857,7 @@ func GetMonetaryData(timeframe Timeframe, method PaymentMethod, fulfilled bool)
 	return Dataset{Horizontal: horizontal, Vertical: vertical}, nil
 }
 
-func GetPreferredMethodData(timeframe Timeframe, fulfilled bool) ([]Dataset, error) {
+func GetPreferredMethodData(timeframe Timeframe, fulfilled bool, channel Channel) ([]Dataset, error) {
 	var results []Dataset = make([]Dataset, 0)
 	var whereStm string
 
@@ -689,6 +873,8 @@ func GetPreferredMethodData(timeframe Timeframe, fulfilled bool) ([]Dataset, err
 		whereStm += " AND fulfilled = true"
 	}
 
+	whereStm += channelFilter("channel", channel)
+
 	for method := range PaymentMethods {
 		var result []Count = make([]Count, 0)
 		whereStm2 := whereStm + ` AND method = '` + string(PaymentMethods[method]) + `' `
@@ -721,7 +907,7 @@ func GetPreferredMethodData(timeframe Timeframe, fulfilled bool) ([]Dataset, err
 	return results, nil
 }
 
-func GetTopSellers() ([]RankedSeller, error) {
+func GetTopSellers(channel Channel) ([]RankedSeller, error) {
 	var results []RankedSeller = make([]RankedSeller, 0)
 
 	statement := `SELECT
@@ -734,7 +920,7 @@ func GetTopSellers() ([]RankedSeller, error) {
 								JOIN
 										categories cat ON pr.category = cat.id
 								LEFT JOIN
-										purchases p ON pr.id = p.productid
+										purchases p ON pr.id = p.productid` + channelPurchases(channel) + `
 								GROUP BY
 										pr.id, pr.name, cat.name
 								ORDER BY
@@ -749,7 +935,7 @@ func GetTopSellers() ([]RankedSeller, error) {
 	return results, nil
 }
 
-func GetTopOrders() ([]RankedOrder, error) {
+func GetTopOrders(channel Channel) ([]RankedOrder, error) {
 	var results []RankedOrder = make([]RankedOrder, 0)
 
 	statement := `SELECT
@@ -773,6 +959,7 @@ func GetTopOrders() ([]RankedOrder, error) {
 										purchases p ON o.id = p.orderid
 								JOIN
 										products pr ON p.productid = pr.id
+								WHERE true` + channelFilter("o.channel", channel) + `
 								GROUP BY
 										o.id, c.fullname, o.created
 								ORDER BY
@@ -787,7 +974,7 @@ func GetTopOrders() ([]RankedOrder, error) {
 	return results, nil
 }
 
-func GetTopGainers() ([]RankedGainer, error) {
+func GetTopGainers(channel Channel) ([]RankedGainer, error) {
 	var results []RankedGainer = make([]RankedGainer, 0)
 
 	statement := `SELECT
@@ -808,7 +995,7 @@ func GetTopGainers() ([]RankedGainer, error) {
 								JOIN
 										categories cat ON pr.category = cat.id
 								LEFT JOIN
-										purchases p ON pr.id = p.productid
+										purchases p ON pr.id = p.productid` + channelPurchases(channel) + `
 								GROUP BY
 										pr.id, pr.name, cat.name
 								ORDER BY
@@ -823,7 +1010,7 @@ func GetTopGainers() ([]RankedGainer, error) {
 	return results, nil
 }
 
-func GetFlopSellers() ([]RankedSeller, error) {
+func GetFlopSellers(channel Channel) ([]RankedSeller, error) {
 	var results []RankedSeller = make([]RankedSeller, 0)
 
 	statement := `SELECT
@@ -836,7 +1023,7 @@ func GetFlopSellers() ([]RankedSeller, error) {
 								JOIN
 										categories cat ON pr.category = cat.id
 								LEFT JOIN
-										purchases p ON pr.id = p.productid
+										purchases p ON pr.id = p.productid` + channelPurchases(channel) + `
 								GROUP BY
 										pr.id, pr.name, cat.name
 								ORDER BY
@@ -851,7 +1038,7 @@ func GetFlopSellers() ([]RankedSeller, error) {
 	return results, nil
 }
 
-func GetFlopGainers() ([]RankedGainer, error) {
+func GetFlopGainers(channel Channel) ([]RankedGainer, error) {
 	var results []RankedGainer = make([]RankedGainer, 0)
 
 	statement := `SELECT
@@ -872,7 +1059,7 @@ func GetFlopGainers() ([]RankedGainer, error) {
 								JOIN
 										categories cat ON pr.category = cat.id
 								LEFT JOIN
-										purchases p ON pr.id = p.productid
+										purchases p ON pr.id = p.productid` + channelPurchases(channel) + `
 								GROUP BY
 										pr.id, pr.name, cat.name
 								ORDER BY
@@ -887,7 +1074,7 @@ func GetFlopGainers() ([]RankedGainer, error) {
 	return results, nil
 }
 
-func GetFilledPie() (Pie, error) {
+func GetFilledPie(channel Channel) (Pie, error) {
 	type OrderState struct {
 		Filled      float64
 		Unfulfilled float64
@@ -898,7 +1085,8 @@ func GetFilledPie() (Pie, error) {
 	statement := `SELECT
 								ROUND(COALESCE(COUNT(CASE WHEN fulfilled THEN 1 END) * 100.0 / NULLIF(COUNT(*), 0), 0), 2) AS filled,
 								ROUND(COALESCE(COUNT(CASE WHEN NOT fulfilled THEN 1 END) * 100.0 / NULLIF(COUNT(*), 0), 0), 2) AS unfulfilled
-								FROM orders`
+								FROM orders
+								WHERE true` + channelFilter("channel", channel)
 
 	err := db.Get(&results, statement)
 	if err != nil {
@@ -908,15 +1096,16 @@ func GetFilledPie() (Pie, error) {
 	return Pie{Title: "Orders State", Items: []PieItem{{Label: "Fulfilled", Value: results.Filled, Color: 0x00FF00}, {Label: "Unfulfilled", Value: results.Unfulfilled, Color: 0xFF0000}}}, nil
 }
 
-func GetMethodsPie() (Pie, error) {
+func GetMethodsPie(channel Channel) (Pie, error) {
 
 	var results []PieItem = make([]PieItem, 0)
 
 	statement := `SELECT
 								method AS label,
-								ROUND(COALESCE(COUNT(*) * 100.0 / NULLIF((SELECT COUNT(*) FROM orders), 0), 0), 2) AS value
+								ROUND(COALESCE(COUNT(*) * 100.0 / NULLIF((SELECT COUNT(*) FROM orders WHERE true` + channelFilter("channel", channel) + `), 0), 0), 2) AS value
 								FROM
 										orders
+								WHERE true` + channelFilter("channel", channel) + `
 								GROUP BY
 										method`
 
@@ -931,3 +1120,172 @@ func GetMethodsPie() (Pie, error) {
 
 	return Pie{Title: "Used Payment Methods", Items: results}, nil
 }
+
+type Settlement struct {
+	Day           string        `json:"day"`
+	Method        PaymentMethod `json:"method"`
+	Orders        int           `json:"orders"`
+	Sales         int           `json:"sales"`           // Gross product sales, tax included
+	Tax           int           `json:"tax"`             // Tax share of the gross sales
+	Discounts     int           `json:"discounts"`       // Discounts granted on the sales
+	Refunds       int           `json:"refunds"`         // Money returned to customers on that day
+	Fees          int           `json:"fees"`            // Processing fees charged by the provider
+	Tips          int           `json:"tips"`            // Tips collected for the staff, owed to them
+	Delivery      int           `json:"delivery"`        // Delivery fees charged on top of the sales
+	GiftCards     int           `json:"gift_cards"`      // Paid with gift cards or store credit instead of the method
+	GiftCardsSold int           `json:"gift_cards_sold"` // Gift cards sold, owed as balance rather than earned
+	StoreCredit   int           `json:"store_credit"`    // Refunds given back as store credit
+	Net           int           `json:"net"`             // What actually lands in the method's account
+}
+
+// GetDailySettlements sums sales, discounts, fees, refunds and gift card use for [from, to) per local day and payment method.
+// Cancelled orders and their refunds make no sales, only the processing fees kept by the provider remain.
+func GetDailySettlements(from time.Time, to time.Time, loc *time.Location) ([]Settlement, error) {
+	type settlementRow struct {
+		Day       time.Time
+		Method    string
+		Orders    int
+		Sales     int
+		Discounts int
+		Fees      int
+		Tips      int
+		Delivery  int
+		GiftCards int
+		Sold      int
+	}
+
+	type refundRow struct {
+		Day     time.Time
+		Method  string
+		Refunds int
+	}
+
+	var sales []settlementRow = make([]settlementRow, 0)
+	var refunds []refundRow = make([]refundRow, 0)
+	var credits []refundRow = make([]refundRow, 0)
+
+	statement := `SELECT (o.created AT TIME ZONE 'UTC' AT TIME ZONE $3)::DATE AS day,
+										o.method::TEXT AS method,
+										COUNT(*) FILTER (WHERE o.cancelled = false) AS orders,
+										COALESCE(SUM(t.total) FILTER (WHERE o.cancelled = false), 0) AS sales,
+										COALESCE(SUM(o.discount) FILTER (WHERE o.cancelled = false), 0) AS discounts,
+										COALESCE(SUM(o.fee), 0) AS fees,
+										COALESCE(SUM(o.tip) FILTER (WHERE o.cancelled = false), 0) AS tips,
+										COALESCE(SUM(o.deliveryfee) FILTER (WHERE o.cancelled = false), 0) AS delivery,
+										COALESCE(SUM(o.giftcard) FILTER (WHERE o.cancelled = false), 0) AS giftcards,
+										COALESCE(SUM(t.giftcards) FILTER (WHERE o.cancelled = false), 0) AS sold
+									FROM orders o
+									LEFT JOIN ` + orderTotals + ` t ON t.orderid = o.id
+									WHERE o.created >= $1 AND o.created < $2
+									GROUP BY day, o.method`
+
+	err := db.Select(&sales, statement, from.UTC(), to.UTC(), loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	statement = `SELECT (r.created AT TIME ZONE 'UTC' AT TIME ZONE $3)::DATE AS day,
+										o.method::TEXT AS method,
+										COALESCE(SUM(r.amount), 0) AS refunds
+									FROM refunds r
+									JOIN orders o ON r.orderid = o.id
+									WHERE r.created >= $1 AND r.created < $2 AND o.cancelled = false
+									GROUP BY day, o.method`
+
+	err = db.Select(&refunds, statement, from.UTC(), to.UTC(), loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	statement = `SELECT (g.created AT TIME ZONE 'UTC' AT TIME ZONE $3)::DATE AS day,
+										o.method::TEXT AS method,
+										COALESCE(SUM(g.initial), 0) AS refunds
+									FROM giftcards g
+									JOIN orders o ON g.orderid = o.id
+									WHERE g.kind = $4 AND g.created >= $1 AND g.created < $2 AND o.cancelled = false
+									GROUP BY day, o.method`
+
+	err = db.Select(&credits, statement, from.UTC(), to.UTC(), loc.String(), STORE_CREDIT)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := getTaxRate()
+	settlements := make(map[string]*Settlement)
+	keys := make([]string, 0)
+
+	get := func(day time.Time, method string) *Settlement {
+		key := day.Format("2006-01-02") + "|" + method
+		settlement, ok := settlements[key]
+		if !ok {
+			settlement = &Settlement{Day: day.Format("2006-01-02"), Method: ParsePaymentMethod(method)}
+			settlements[key] = settlement
+			keys = append(keys, key)
+		}
+		return settlement
+	}
+
+	for _, row := range sales {
+		settlement := get(row.Day, row.Method)
+		settlement.Orders = row.Orders
+		settlement.Sales = row.Sales
+		settlement.Tax = int(math.Round(float64(row.Sales-row.Sold) * rate / (1 + rate)))
+		settlement.Discounts = row.Discounts
+		settlement.Fees = row.Fees
+		settlement.Tips = row.Tips
+		settlement.Delivery = row.Delivery
+		settlement.GiftCards = row.GiftCards
+		settlement.GiftCardsSold = row.Sold
+	}
+
+	for _, row := range refunds {
+		get(row.Day, row.Method).Refunds = row.Refunds
+	}
+
+	for _, row := range credits {
+		get(row.Day, row.Method).StoreCredit = row.Refunds
+	}
+
+	sort.Strings(keys)
+
+	results := make([]Settlement, 0, len(keys))
+	for _, key := range keys {
+		settlement := settlements[key]
+		settlement.Net = settlement.Sales + settlement.Delivery + settlement.Tips - settlement.Discounts - settlement.Refunds - settlement.Fees - settlement.GiftCards
+		results = append(results, *settlement)
+	}
+
+	return results, nil
+}
+
+// getTaxRate reads TAX_RATE (e.g. 0.13), the tax included in product prices
+func getTaxRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("TAX_RATE"), 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+
+	return rate
+}
+
+// GetOrderTotal prices an order the same way the finance aggregates do, weighed items included
+func GetOrderTotal(id string) (int, error) {
+	var total int
+
+	statement := `SELECT COALESCE(ROUND(SUM(
+										CASE
+											WHEN pr.weighed = true THEN (p.quantity / 10.0) * pr.price
+											ELSE p.quantity * pr.price
+										END
+									)), 0) AS total
+								FROM purchases p
+								JOIN products pr ON p.productid = pr.id
+								WHERE p.orderid = $1`
+
+	err := db.Get(&total, statement, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
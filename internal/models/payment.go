@@ -0,0 +1,252 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PaymentStatus string
+
+const (
+	PAYMENT_SUCCEEDED          PaymentStatus = "succeeded"
+	PAYMENT_FAILED             PaymentStatus = "failed"
+	PAYMENT_CANCELED           PaymentStatus = "canceled"
+	PAYMENT_REFUNDED           PaymentStatus = "refunded"
+	PAYMENT_PARTIALLY_REFUNDED PaymentStatus = "partially_refunded"
+	PAYMENT_DISPUTED           PaymentStatus = "disputed"
+	PAYMENT_DISPUTE_WON        PaymentStatus = "dispute_won"
+	PAYMENT_DISPUTE_LOST       PaymentStatus = "dispute_lost"
+)
+
+// PaymentNotice tells admins what a payment provider reported
+type PaymentNotice struct {
+	Event   string `json:"event"`
+	OrderId string `json:"order_id"`
+	Amount  int    `json:"amount"`
+	Message string `json:"message"`
+}
+
+type Refund struct {
+	Id      string    `json:"id"`
+	OrderId string    `json:"order_id" db:"orderid"`
+	Amount  int       `json:"amount"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+type Dispute struct {
+	Id       string    `json:"id"`
+	OrderId  *string   `json:"order_id" db:"orderid"`
+	ChargeId string    `json:"charge_id" db:"chargeid"`
+	Amount   int       `json:"amount"`
+	Reason   string    `json:"reason"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+type Payout struct {
+	Id       string    `json:"id"`
+	Amount   int       `json:"amount"`
+	Currency string    `json:"currency"`
+	Arrival  time.Time `json:"arrival"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+}
+
+// GetOrderByPayment finds the order paid by a Stripe PaymentIntent or charge, whichever is known
+func GetOrderByPayment(intentId string, chargeId string) (*Order, error) {
+	var id string
+
+	statement := "SELECT id FROM orders WHERE (paymentintent = $1 AND $1 != '') OR (chargeid = $2 AND $2 != '') LIMIT 1"
+
+	err := db.Get(&id, statement, intentId, chargeId)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetOrder(id)
+}
+
+// OrderPayment is the online payment a new order was settled with
+type OrderPayment struct {
+	IntentId string // Stripe PaymentIntent or PayPal order
+	ChargeId string // Stripe charge or PayPal capture
+	Paid     int    // In cents
+	Fee      int    // In cents, kept by the provider
+}
+
+// LinkPayment ties the new order to the payment that settled it, replays of the payment then find the order
+func LinkPayment(payment OrderPayment) OrderStep {
+	return func(tx *sqlx.Tx, order *Order) error {
+		statement := "UPDATE orders SET paymentintent = $1, chargeid = $2, paid = $3, fee = $4, paymentstatus = $5 WHERE id = $6"
+
+		_, err := tx.Exec(statement, payment.IntentId, payment.ChargeId, payment.Paid, payment.Fee, PAYMENT_SUCCEEDED, order.Id)
+		return err
+	}
+}
+
+func (o *Order) SetPaymentStatus(status PaymentStatus) (*Order, error) {
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec("UPDATE orders SET paymentstatus = $1 WHERE id = $2", status, o.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetOrder(o.Id)
+}
+
+// RecordRefunds stores the refunds issued on the order's charge and the total refunded so far.
+// Refunds already recorded are skipped, so replayed events do not count twice.
+func (o *Order) RecordRefunds(refunded int, refunds []Refund) (*Order, error) {
+	status := PAYMENT_PARTIALLY_REFUNDED
+	if o.Paid > 0 && refunded >= o.Paid {
+		status = PAYMENT_REFUNDED
+	}
+
+	tx := db.MustBegin()
+
+	for _, refund := range refunds {
+		statement := "INSERT INTO refunds (id, orderid, amount, reason) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO NOTHING"
+
+		if _, err := tx.Exec(statement, refund.Id, o.Id, refund.Amount, refund.Reason); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE orders SET refunded = $1, paymentstatus = $2 WHERE id = $3", refunded, status, o.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetOrder(o.Id)
+}
+
+// GetRefundedAmount sums the refund rows already recorded for an order
+func GetRefundedAmount(orderId string) (int, error) {
+	var refunded int
+
+	err := db.Get(&refunded, "SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE orderid = $1", orderId)
+	if err != nil {
+		return 0, err
+	}
+
+	return refunded, nil
+}
+
+// SaveDispute creates or updates a chargeback and moves the disputed order to the matching payment status
+func SaveDispute(dispute Dispute, status PaymentStatus) (*Dispute, error) {
+	statement := `INSERT INTO disputes (id, orderid, chargeid, amount, reason, status) VALUES ($1, $2, $3, $4, $5, $6)
+								ON CONFLICT (id) DO UPDATE SET amount = EXCLUDED.amount, reason = EXCLUDED.reason, status = EXCLUDED.status`
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, dispute.Id, dispute.OrderId, dispute.ChargeId, dispute.Amount, dispute.Reason, dispute.Status); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if dispute.OrderId != nil {
+		if _, err := tx.Exec("UPDATE orders SET paymentstatus = $1 WHERE id = $2", status, *dispute.OrderId); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	var saved Dispute
+
+	err := db.Get(&saved, "SELECT * FROM disputes WHERE id = $1", dispute.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+func GetDisputes() ([]Dispute, error) {
+	var disputes []Dispute = make([]Dispute, 0)
+
+	err := db.Select(&disputes, "SELECT * FROM disputes ORDER BY created DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	return disputes, nil
+}
+
+// RecordPayout stores a payout Stripe sent to the bank account
+func RecordPayout(payout Payout) (*Payout, error) {
+	statement := `INSERT INTO payouts (id, amount, currency, arrival, status) VALUES ($1, $2, $3, $4, $5)
+								ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status`
+
+	tx := db.MustBegin()
+
+	if _, err := tx.Exec(statement, payout.Id, payout.Amount, payout.Currency, payout.Arrival.Format("2006-01-02"), payout.Status); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	var saved Payout
+
+	err := db.Get(&saved, "SELECT * FROM payouts WHERE id = $1", payout.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+func GetPayouts(from time.Time, to time.Time) ([]Payout, error) {
+	var payouts []Payout = make([]Payout, 0)
+
+	err := db.Select(&payouts, "SELECT * FROM payouts WHERE arrival >= $1 AND arrival < $2 ORDER BY arrival DESC", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	return payouts, nil
+}
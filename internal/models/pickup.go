@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrOrderCancelled = errors.New("order was cancelled")
+	ErrOrderCollected = errors.New("order was already picked up")
+)
+
+type PickupSummary struct {
+	Order *Order `json:"order"`
+	Total int    `json:"total"`
+	Due   int    `json:"due"` // Left to pay at the counter, only cash orders are paid on pickup
+}
+
+// CheckPickup tells why an order cannot be handed over, if it cannot
+func (o *Order) CheckPickup() error {
+	if o.Cancelled {
+		return ErrOrderCancelled
+	}
+
+	if o.Fulfilled {
+		return ErrOrderCollected
+	}
+
+	return nil
+}
+
+func GetPickupSummary(orderId string) (*PickupSummary, error) {
+	order, err := GetOrder(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := GetOrderTotal(order.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &PickupSummary{Order: order, Total: total}
+	if PaymentMethod(order.Method) == CASH {
+		summary.Due = max(total+order.DeliveryFee-order.Discount, 0)
+	}
+
+	return summary, nil
+}
+
+// Pickup hands the order over to the customer, collecting the cash due when it was not paid online.
+// The collection is nil for orders paid in advance.
+func (o *Order) Pickup(userId string, tendered int) (*CashCollection, error) {
+	if err := o.CheckPickup(); err != nil {
+		return nil, err
+	}
+
+	if PaymentMethod(o.Method) == CASH {
+		return o.CollectCash(userId, tendered)
+	}
+
+	tx := db.MustBegin()
+
+	result, err := tx.Exec("UPDATE orders SET fulfilled = true WHERE id = $1 AND fulfilled = false AND cancelled = false", o.Id)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, ErrOrderCollected
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	rewardLoyalty(o.Id)
+
+	return nil, nil
+}
+
+// Cancel marks an order that will not be picked up, it stays in the books for the record.
+// The gift card amounts and loyalty points it used are given back, its online payment is left to refund.
+func (o *Order) Cancel() (*Order, error) {
+	if err := o.CheckPickup(); err != nil {
+		return nil, err
+	}
+
+	tx := db.MustBegin()
+
+	result, err := tx.Exec("UPDATE orders SET cancelled = true WHERE id = $1 AND fulfilled = false AND cancelled = false", o.Id)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	// Picked up or cancelled meanwhile
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, ErrOrderCancelled
+	}
+
+	if err := dropPendingReferral(tx, o.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := releaseOrderGiftCards(tx, o.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := releaseOrderLoyaltyPoints(tx, o.Id); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetOrder(o.Id)
+}
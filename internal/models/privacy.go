@@ -0,0 +1,264 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PrivacyRequestKind string
+
+const (
+	PRIVACY_EXPORT PrivacyRequestKind = "export"
+	PRIVACY_ERASE  PrivacyRequestKind = "erase"
+)
+
+// PrivacyRequest records a customer asking for their data or to be forgotten. It names the customer
+// by id only so the record outlives the erasure.
+type PrivacyRequest struct {
+	Id         int       `json:"id"`
+	CustomerId string    `json:"customer_id" db:"customerid"`
+	Kind       string    `json:"kind"`
+	Actor      string    `json:"actor"`
+	Reason     string    `json:"reason"`
+	Created    time.Time `json:"created"`
+}
+
+type CustomerIp struct {
+	Ip   string    `json:"ip"`
+	Seen time.Time `json:"seen"`
+}
+
+// PrivacyExport is everything held about a customer
+type PrivacyExport struct {
+	Generated     time.Time         `json:"generated"`
+	Customer      DbCustomer        `json:"customer"`
+	Account       *Account          `json:"account"`
+	Addresses     []AccountAddress  `json:"addresses"`
+	Orders        []Order           `json:"orders"`
+	Ips           []CustomerIp      `json:"ips"`
+	Visits        []Visit           `json:"visits"` // Matched by the addresses the customer ordered from
+	Emails        []EmailRecord     `json:"emails"`
+	LoyaltyPoints []LoyaltyEntry    `json:"loyalty_points"`
+	GiftCards     []GiftCard        `json:"gift_cards"`
+	Referrals     []Referral        `json:"referrals"`
+	Locations     []CustomerAddress `json:"locations"` // Addresses the customer ordered to
+	Notes         []CustomerNote    `json:"notes"`
+	Tags          []string          `json:"tags"`
+}
+
+// RecordCustomerIp remembers the address an order came from so the customer's visits can be exported
+func RecordCustomerIp(customerId string, ip string) error {
+	if customerId == AnonymousCustomerId || ip == "" {
+		return nil
+	}
+
+	statement := `INSERT INTO customer_ips (customerid, ip) VALUES ($1, $2)
+								ON CONFLICT (customerid, ip) DO UPDATE SET seen = NOW()`
+
+	_, err := db.Exec(statement, customerId, ip)
+
+	return err
+}
+
+func GetPrivacyRequests() ([]PrivacyRequest, error) {
+	var requests []PrivacyRequest = make([]PrivacyRequest, 0)
+
+	statement := "SELECT * FROM privacy_requests ORDER BY created DESC"
+
+	err := db.Select(&requests, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// ExportCustomerData gathers what is held about the customer and records the request
+func ExportCustomerData(customerId string, actor string, reason string) (*PrivacyExport, error) {
+	if customerId == AnonymousCustomerId {
+		return nil, fmt.Errorf("the walk-in customer holds no personal data")
+	}
+
+	customer, err := GetDbCustomer(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &PrivacyExport{
+		Generated:     time.Now(),
+		Customer:      *customer,
+		Addresses:     make([]AccountAddress, 0),
+		Orders:        make([]Order, 0),
+		Ips:           make([]CustomerIp, 0),
+		Visits:        make([]Visit, 0),
+		Emails:        make([]EmailRecord, 0),
+		LoyaltyPoints: make([]LoyaltyEntry, 0),
+		GiftCards:     make([]GiftCard, 0),
+		Referrals:     make([]Referral, 0),
+		Locations:     make([]CustomerAddress, 0),
+		Notes:         make([]CustomerNote, 0),
+		Tags:          make([]string, 0),
+	}
+
+	var account Account
+	if err := db.Get(&account, "SELECT * FROM accounts WHERE customerid = $1", customer.Id); err == nil {
+		export.Account = &account
+
+		if export.Addresses, err = account.GetAddresses(); err != nil {
+			return nil, err
+		}
+	}
+
+	var orderIds []string = make([]string, 0)
+	if err := db.Select(&orderIds, "SELECT id FROM orders WHERE customer = $1 ORDER BY created ASC", customer.Id); err != nil {
+		return nil, err
+	}
+
+	for _, id := range orderIds {
+		order, err := GetOrder(id)
+		if err != nil {
+			return nil, err
+		}
+		export.Orders = append(export.Orders, *order)
+	}
+
+	if err := db.Select(&export.Ips, "SELECT ip, seen FROM customer_ips WHERE customerid = $1 ORDER BY seen ASC", customer.Id); err != nil {
+		return nil, err
+	}
+
+	for _, ip := range export.Ips {
+		visits, err := GetVisitsFromIp(ip.Ip)
+		if err != nil {
+			return nil, err
+		}
+		export.Visits = append(export.Visits, visits...)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(customer.Email))
+
+	if email != "" {
+		if export.Emails, err = GetEmailsTo(email); err != nil {
+			return nil, err
+		}
+
+		if err := db.Select(&export.LoyaltyPoints, "SELECT * FROM loyalty_points WHERE email = $1 ORDER BY created ASC", email); err != nil {
+			return nil, err
+		}
+
+		if err := db.Select(&export.GiftCards, "SELECT * FROM giftcards WHERE LOWER(recipient) = $1 ORDER BY created ASC", email); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.Select(&export.Referrals, "SELECT * FROM referrals WHERE referrer = $1 OR referred = $1 ORDER BY created ASC", customer.Id); err != nil {
+		return nil, err
+	}
+
+	if export.Locations, err = GetCustomerAddresses(customer.Id); err != nil {
+		return nil, err
+	}
+
+	if export.Notes, err = GetCustomerNotes(customer.Id); err != nil {
+		return nil, err
+	}
+
+	if export.Tags, err = GetCustomerTags(customer.Id); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("INSERT INTO privacy_requests (customerid, kind, actor, reason) VALUES ($1, $2, $3, $4)", customer.Id, PRIVACY_EXPORT, actor, reason); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// EraseCustomer anonymises the customer's personal details in place. Orders, amounts and ledgers stay
+// for the tax records, pointing at the anonymised row. The sign in account, the addresses the customer
+// ordered from and to, staff notes and tags are deleted, the notes left on orders are cleared and past snapshots
+// of the customer in the audit log and in customer merges are redacted.
+func EraseCustomer(customerId string, actor string, reason string) (*DbCustomer, error) {
+	if customerId == AnonymousCustomerId {
+		return nil, fmt.Errorf("the walk-in customer cannot be erased")
+	}
+
+	customer, err := GetDbCustomer(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	if customer.Erased != nil {
+		return nil, fmt.Errorf("the customer was already erased")
+	}
+
+	var open bool
+	if err := db.Get(&open, "SELECT EXISTS(SELECT 1 FROM orders WHERE customer = $1 AND fulfilled = false AND cancelled = false)", customer.Id); err != nil {
+		return nil, err
+	}
+
+	if open {
+		return nil, fmt.Errorf("the customer has open orders, fulfill or cancel them first")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(customer.Email))
+	erasedEmail := fmt.Sprintf("erased-%s@invalid", customer.Id[:min(8, len(customer.Id))])
+
+	erased := *customer
+	erased.Fullname = "Erased Customer"
+	erased.Email = erasedEmail
+	erased.Address = ""
+	erased.Phone = ""
+
+	redacted, err := json.Marshal(erased.ConvertToCustomer(time.Time{}, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE customers SET fullname = $1, email = $2, address = '', phone = '', erased = NOW() WHERE id = $3", []interface{}{erased.Fullname, erasedEmail, customer.Id}},
+		{"DELETE FROM accounts WHERE customerid = $1 OR ($2 != '' AND email = $2)", []interface{}{customer.Id, email}},
+		{"DELETE FROM customer_ips WHERE customerid = $1", []interface{}{customer.Id}},
+		{"DELETE FROM customer_addresses WHERE customerid = $1", []interface{}{customer.Id}},
+		{"DELETE FROM customer_notes WHERE customerid = $1", []interface{}{customer.Id}},
+		{"DELETE FROM customer_note_events WHERE customerid = $1", []interface{}{customer.Id}},
+		{"DELETE FROM customer_tags WHERE customerid = $1", []interface{}{customer.Id}},
+		{"UPDATE orders SET notes = '' WHERE customer = $1", []interface{}{customer.Id}},
+		{"UPDATE emails SET recipient = $1 WHERE $2 != '' AND LOWER(recipient) = $2", []interface{}{erasedEmail, email}},
+		{"UPDATE loyalty_points SET email = $1 WHERE $2 != '' AND email = $2", []interface{}{erasedEmail, email}},
+		{"UPDATE giftcards SET recipient = $1 WHERE $2 != '' AND LOWER(recipient) = $2", []interface{}{erasedEmail, email}},
+		{"UPDATE audits SET before = NULL, after = NULL WHERE entity = $1 AND entityid = $2", []interface{}{AuditCustomer, customer.Id}},
+		{`UPDATE audits SET
+								before = CASE WHEN before->'customer'->>'id' = $1 THEN jsonb_set(before, '{customer}', $2::jsonb) ELSE before END,
+								after = CASE WHEN after->'customer'->>'id' = $1 THEN jsonb_set(after, '{customer}', $2::jsonb) ELSE after END
+							WHERE before->'customer'->>'id' = $1 OR after->'customer'->>'id' = $1`, []interface{}{customer.Id, string(redacted)}},
+		// A merge undone later would otherwise bring the details back
+		{`UPDATE customer_merges SET snapshot = snapshot || jsonb_build_object('fullname', $2::TEXT, 'email', 'erased-' || LEFT(merged, 8) || '@invalid', 'address', '', 'phone', '')
+							WHERE survivor = $1 OR merged = $1`, []interface{}{customer.Id, erased.Fullname}},
+		{"INSERT INTO privacy_requests (customerid, kind, actor, reason) VALUES ($1, $2, $3, $4)", []interface{}{customer.Id, PRIVACY_ERASE, actor, reason}},
+	}
+
+	tx := db.MustBegin()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("error rolling back transaction: %v", rollbackErr)
+		}
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return GetDbCustomer(customer.Id)
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"mime/multipart"
+	"strings"
 	"time"
 
 	"github.com/Francesco99975/rosskery/internal/helpers"
@@ -23,6 +24,10 @@ type Product struct {
 }
 
 func (p *Product) GetPostfix() string {
+	if p.IsGiftCard() {
+		return "$"
+	}
+
 	if p.Weighed {
 		return "lb"
 	} else {
@@ -30,6 +35,11 @@ func (p *Product) GetPostfix() string {
 	}
 }
 
+// IsGiftCard tells whether the product sells gift cards, the quantity being the amount picked by the customer
+func (p *Product) IsGiftCard() bool {
+	return p.Category.Id == GiftCardCategoryId
+}
+
 type DbProduct struct {
 	Id           string    `json:"id"`
 	Name         string    `json:"name"`
@@ -190,6 +200,39 @@ func GetPublishedProducts() ([]Product, error) {
 	}), nil
 }
 
+// SearchProducts matches the query against product and category names, for the counter lookup
+func SearchProducts(query string) ([]Product, error) {
+	var products []DbProduct = make([]DbProduct, 0)
+	statement := `SELECT
+									p.id AS id,
+									p.name AS name,
+									p.description AS description,
+									p.price AS price,
+									p.image AS image,
+									p.featured AS featured,
+									p.published AS published,
+									p.weighed AS weighed,
+									p.lv AS lv,
+									p.created AS created,
+									p.updated AS updated,
+									c.id AS category_id,
+									c.name AS category_name
+								FROM products p
+								JOIN categories c ON p.category = c.id
+								WHERE p.name ILIKE $1 OR c.name ILIKE $1
+								ORDER BY p.name ASC
+								LIMIT 20`
+
+	err := db.Select(&products, statement, "%"+strings.TrimSpace(query)+"%")
+	if err != nil {
+		return nil, err
+	}
+
+	return helpers.MapSlice(products, func(dbp DbProduct) Product {
+		return *dbp.ConvertToProduct()
+	}), nil
+}
+
 func GetFeaturedProducts() ([]Product, error) {
 	var products []DbProduct = make([]DbProduct, 0)
 	statement := `SELECT
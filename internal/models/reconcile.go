@@ -0,0 +1,301 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// StripeCharge is a charge pulled from Stripe with the PaymentIntent metadata and balance transaction fee attached
+type StripeCharge struct {
+	Id        string
+	IntentId  string
+	SessionId string // sessionID metadata of the PaymentIntent
+	Amount    int    // Captured amount
+	Refunded  int
+	Fee       int
+	Status    string
+	Created   time.Time
+}
+
+type StripeRefund struct {
+	Id       string
+	ChargeId string
+	IntentId string
+	Amount   int
+	Status   string
+	Created  time.Time
+}
+
+// StripeActivity is everything Stripe recorded for a date range
+type StripeActivity struct {
+	Charges []StripeCharge
+	Refunds []StripeRefund
+}
+
+type ReconciliationIssueKind string
+
+const (
+	ORPHAN_CHARGE   ReconciliationIssueKind = "orphan_charge"   // Stripe took money for no order
+	ORPHAN_REFUND   ReconciliationIssueKind = "orphan_refund"   // Stripe refunded a charge of no order
+	ORPHAN_ORDER    ReconciliationIssueKind = "orphan_order"    // Order paid online without a succeeded charge
+	UNLINKED_ORDER  ReconciliationIssueKind = "unlinked_order"  // Order only found through the intent metadata
+	AMOUNT_MISMATCH ReconciliationIssueKind = "amount_mismatch" // Captured amount differs from what the order recorded
+	REFUND_MISMATCH ReconciliationIssueKind = "refund_mismatch" // Refunded amount differs from what the order recorded
+	MISSING_REFUND  ReconciliationIssueKind = "missing_refund"  // Stripe refund not recorded on the order
+	MISSING_FEE     ReconciliationIssueKind = "missing_fee"     // Processing fee not recorded on the order
+)
+
+// ReconciliationIssue is a difference between Stripe and the orders. Expected is what Stripe reports,
+// Actual what Rosskery recorded.
+type ReconciliationIssue struct {
+	Kind     ReconciliationIssueKind `json:"kind"`
+	OrderId  string                  `json:"order_id"`
+	IntentId string                  `json:"intent_id"`
+	ChargeId string                  `json:"charge_id"`
+	RefundId string                  `json:"refund_id"`
+	Expected int                     `json:"expected"`
+	Actual   int                     `json:"actual"`
+	Message  string                  `json:"message"`
+}
+
+type Reconciliation struct {
+	From    time.Time             `json:"from"`
+	To      time.Time             `json:"to"`
+	Charges int                   `json:"charges"`
+	Orders  int                   `json:"orders"`
+	Matched int                   `json:"matched"`
+	Issues  []ReconciliationIssue `json:"issues"`
+}
+
+type reconciledOrder struct {
+	Id            string
+	PaymentIntent string `db:"paymentintent"`
+	ChargeId      string `db:"chargeid"`
+	PaymentStatus string `db:"paymentstatus"`
+	Paid          int
+	Refunded      int
+	Fee           int
+	Cancelled     bool
+	Created       time.Time
+}
+
+// Reconcile matches the Stripe activity of [from, to) to the orders, by the intent or charge stored on
+// the order first, then by the checkout session kept in the intent metadata. Stripe charges outside
+// [from, to) are only used to match orders and refunds, so a margin can be fetched around the range.
+func Reconcile(from time.Time, to time.Time, activity StripeActivity) (*Reconciliation, error) {
+	intentIds := make([]string, 0, len(activity.Charges))
+	chargeIds := make([]string, 0, len(activity.Charges)+len(activity.Refunds))
+	sessionIds := make([]string, 0, len(activity.Charges))
+	refundIds := make([]string, 0, len(activity.Refunds))
+
+	for _, charge := range activity.Charges {
+		intentIds = append(intentIds, charge.IntentId)
+		chargeIds = append(chargeIds, charge.Id)
+		if charge.SessionId != "" {
+			sessionIds = append(sessionIds, charge.SessionId)
+		}
+	}
+	for _, refund := range activity.Refunds {
+		intentIds = append(intentIds, refund.IntentId)
+		chargeIds = append(chargeIds, refund.ChargeId)
+		refundIds = append(refundIds, refund.Id)
+	}
+
+	// Checkouts keep their idempotency keys as "<session>:<key>" with the order they produced
+	type sessionOrder struct {
+		Session string
+		OrderId string `db:"orderid"`
+	}
+
+	var sessions []sessionOrder = make([]sessionOrder, 0)
+
+	statement := `SELECT split_part(id, ':', 1) AS session, orderid FROM idempotency_keys
+									WHERE orderid IS NOT NULL AND split_part(id, ':', 1) = ANY($1)`
+
+	err := db.Select(&sessions, statement, pq.Array(sessionIds))
+	if err != nil {
+		return nil, err
+	}
+
+	sessionOrderIds := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionOrderIds = append(sessionOrderIds, session.OrderId)
+	}
+
+	var orders []reconciledOrder = make([]reconciledOrder, 0)
+
+	statement = `SELECT id, paymentintent, chargeid, paymentstatus, paid, refunded, fee, cancelled, created FROM orders
+								WHERE method = $1 AND (
+									(created >= $2 AND created < $3)
+									OR (paymentintent != '' AND paymentintent = ANY($4))
+									OR (chargeid != '' AND chargeid = ANY($5))
+									OR id = ANY($6)
+								)
+								ORDER BY created`
+
+	err = db.Select(&orders, statement, STRIPE, from.UTC(), to.UTC(), pq.Array(intentIds), pq.Array(chargeIds), pq.Array(sessionOrderIds))
+	if err != nil {
+		return nil, err
+	}
+
+	var recordedRefunds []string = make([]string, 0)
+
+	err = db.Select(&recordedRefunds, "SELECT id FROM refunds WHERE id = ANY($1)", pq.Array(refundIds))
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*reconciledOrder, len(orders))
+	byIntent := make(map[string]*reconciledOrder)
+	byCharge := make(map[string]*reconciledOrder)
+	for i := range orders {
+		order := &orders[i]
+		byId[order.Id] = order
+		if order.PaymentIntent != "" {
+			byIntent[order.PaymentIntent] = order
+		}
+		if order.ChargeId != "" {
+			byCharge[order.ChargeId] = order
+		}
+	}
+
+	bySession := make(map[string][]*reconciledOrder)
+	for _, session := range sessions {
+		if order, ok := byId[session.OrderId]; ok {
+			bySession[session.Session] = append(bySession[session.Session], order)
+		}
+	}
+
+	refunded := make(map[string]bool, len(recordedRefunds))
+	for _, id := range recordedRefunds {
+		refunded[id] = true
+	}
+
+	inRange := func(t time.Time) bool {
+		return !t.Before(from) && t.Before(to)
+	}
+
+	report := &Reconciliation{From: from, To: to, Issues: make([]ReconciliationIssue, 0)}
+	matched := make(map[string]bool)
+	stripeRefunded := make(map[string]int)
+
+	for _, charge := range activity.Charges {
+		if charge.Status != "succeeded" {
+			continue
+		}
+
+		stripeRefunded[charge.Id] = charge.Refunded
+		order := byIntent[charge.IntentId]
+		if order == nil {
+			order = byCharge[charge.Id]
+		}
+
+		unlinked := false
+		if order == nil {
+			// A session can place several orders, take the closest one left unlinked
+			for _, candidate := range bySession[charge.SessionId] {
+				if candidate.PaymentIntent != "" || matched[candidate.Id] {
+					continue
+				}
+				if order == nil || absDuration(candidate.Created.Sub(charge.Created)) < absDuration(order.Created.Sub(charge.Created)) {
+					order = candidate
+				}
+			}
+			unlinked = order != nil
+		}
+
+		if !inRange(charge.Created) && (order == nil || !inRange(order.Created)) {
+			if order != nil {
+				matched[order.Id] = true
+			}
+			continue
+		}
+
+		report.Charges++
+
+		if order == nil {
+			report.Issues = append(report.Issues, ReconciliationIssue{Kind: ORPHAN_CHARGE, IntentId: charge.IntentId, ChargeId: charge.Id, Expected: charge.Amount, Message: "Charge matches no order"})
+			continue
+		}
+
+		matched[order.Id] = true
+		report.Matched++
+
+		if unlinked {
+			report.Issues = append(report.Issues, ReconciliationIssue{Kind: UNLINKED_ORDER, OrderId: order.Id, IntentId: charge.IntentId, ChargeId: charge.Id, Expected: charge.Amount, Actual: order.Paid, Message: "Order matched through the checkout session, the payment intent is not stored on it"})
+		}
+
+		if charge.Amount != order.Paid {
+			report.Issues = append(report.Issues, ReconciliationIssue{Kind: AMOUNT_MISMATCH, OrderId: order.Id, IntentId: charge.IntentId, ChargeId: charge.Id, Expected: charge.Amount, Actual: order.Paid, Message: "Captured amount differs from the amount paid on the order"})
+		}
+
+		if charge.Refunded != order.Refunded {
+			report.Issues = append(report.Issues, ReconciliationIssue{Kind: REFUND_MISMATCH, OrderId: order.Id, IntentId: charge.IntentId, ChargeId: charge.Id, Expected: charge.Refunded, Actual: order.Refunded, Message: "Refunded amount differs from the amount refunded on the order"})
+		}
+
+		if charge.Fee != order.Fee {
+			report.Issues = append(report.Issues, ReconciliationIssue{Kind: MISSING_FEE, OrderId: order.Id, IntentId: charge.IntentId, ChargeId: charge.Id, Expected: charge.Fee, Actual: order.Fee, Message: "Processing fee is not recorded on the order"})
+		}
+	}
+
+	for _, refund := range activity.Refunds {
+		if refund.Status == "failed" || refund.Status == "canceled" || !inRange(refund.Created) {
+			continue
+		}
+
+		order := byCharge[refund.ChargeId]
+		if order == nil {
+			order = byIntent[refund.IntentId]
+		}
+
+		if order == nil {
+			report.Issues = append(report.Issues, ReconciliationIssue{Kind: ORPHAN_REFUND, IntentId: refund.IntentId, ChargeId: refund.ChargeId, RefundId: refund.Id, Expected: refund.Amount, Message: "Refund matches no order"})
+			continue
+		}
+
+		// Refunds booked from a charge without its refund list carry a synthetic id, the total still has to add up
+		if !refunded[refund.Id] {
+			if total, ok := stripeRefunded[refund.ChargeId]; !ok || order.Refunded < total {
+				report.Issues = append(report.Issues, ReconciliationIssue{Kind: MISSING_REFUND, OrderId: order.Id, IntentId: refund.IntentId, ChargeId: refund.ChargeId, RefundId: refund.Id, Expected: refund.Amount, Actual: 0, Message: "Refund is not recorded on the order"})
+			}
+		}
+	}
+
+	for _, order := range orders {
+		if !inRange(order.Created) {
+			continue
+		}
+
+		report.Orders++
+
+		if matched[order.Id] || (order.Cancelled && order.Paid == 0) {
+			continue
+		}
+
+		report.Issues = append(report.Issues, ReconciliationIssue{Kind: ORPHAN_ORDER, OrderId: order.Id, IntentId: order.PaymentIntent, ChargeId: order.ChargeId, Actual: order.Paid, Message: fmt.Sprintf("No succeeded charge found for the order (payment status: %s)", orDefault(order.PaymentStatus, "none"))})
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		return strings.Compare(string(report.Issues[i].Kind), string(report.Issues[j].Kind)) < 0
+	})
+
+	return report, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
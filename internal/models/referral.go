@@ -0,0 +1,331 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+type ReferralStatus string
+
+const (
+	REFERRAL_PENDING  ReferralStatus = "pending"  // Waiting on the referred order to be fulfilled
+	REFERRAL_CREDITED ReferralStatus = "credited" // The referrer received their store credit
+	REFERRAL_REJECTED ReferralStatus = "rejected" // Caught by a fraud guard after the order was paid
+)
+
+type Referral struct {
+	Id         string         `json:"id"`
+	Referrer   string         `json:"referrer"`
+	Referred   string         `json:"referred"`
+	OrderId    *string        `json:"order_id" db:"orderid"`
+	Code       string         `json:"code"`
+	Discount   int            `json:"discount"` // Taken off the referred customer's first order
+	Credit     int            `json:"credit"`   // Store credit owed to the referrer
+	Status     ReferralStatus `json:"status"`
+	Reason     string         `json:"reason"`
+	GiftCardId *string        `json:"gift_card_id" db:"giftcardid"`
+	Created    time.Time      `json:"created"`
+	Updated    time.Time      `json:"updated"`
+}
+
+// ReferralDiscount reads REFERRAL_DISCOUNT, what a referral takes off the first order in cents (500 by default)
+func ReferralDiscount() int {
+	return loyaltySetting("REFERRAL_DISCOUNT", 500)
+}
+
+// ReferralCredit reads REFERRAL_CREDIT, the store credit the referrer receives in cents (500 by default)
+func ReferralCredit() int {
+	return loyaltySetting("REFERRAL_CREDIT", 500)
+}
+
+// ReferralLimit reads REFERRAL_LIMIT, how many customers one referrer can bring in (10 by default, 0 for no limit)
+func ReferralLimit() int {
+	return loyaltySetting("REFERRAL_LIMIT", 10)
+}
+
+// NormalizeReferralCode formats a code typed by a customer, ignoring case and spaces
+func NormalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+// NormalizePhone keeps the digits of a phone number, dropping the North American country code
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+
+	if len(digits) == 11 && strings.HasPrefix(digits, "1") {
+		return digits[1:]
+	}
+
+	return digits
+}
+
+// NormalizeAddress lowercases an address and keeps only its letters and digits so formatting does not matter
+func NormalizeAddress(address string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, address)
+}
+
+func GetCustomerByReferral(code string) (*DbCustomer, error) {
+	var customer DbCustomer
+
+	statement := "SELECT * FROM customers WHERE referral = $1"
+
+	err := db.Get(&customer, statement, NormalizeReferralCode(code))
+	if err != nil {
+		return nil, err
+	}
+
+	return &customer, nil
+}
+
+type referralQuerier interface {
+	Get(dest interface{}, query string, args ...interface{}) error
+}
+
+// CheckReferral runs the fraud guards on a referral code used by the customer behind email, phone and address.
+// Referrers cannot refer themselves or someone sharing their phone or address, the referral discount is for a
+// first order only and every referrer can bring in at most ReferralLimit customers. The order being placed
+// is left out of the first order check.
+func CheckReferral(code string, email string, phone string, address string, orderId string) (*DbCustomer, error) {
+	return checkReferral(db, code, email, phone, address, orderId)
+}
+
+func checkReferral(q referralQuerier, code string, email string, phone string, address string, orderId string) (*DbCustomer, error) {
+	var referrer DbCustomer
+
+	if err := q.Get(&referrer, "SELECT * FROM customers WHERE referral = $1", NormalizeReferralCode(code)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("referral code not found")
+		}
+		return nil, err
+	}
+
+	if referrer.Id == AnonymousCustomerId {
+		return nil, fmt.Errorf("referral code not found")
+	}
+
+	if strings.EqualFold(strings.TrimSpace(referrer.Email), strings.TrimSpace(email)) {
+		return &referrer, fmt.Errorf("you cannot use your own referral code")
+	}
+
+	if phone := NormalizePhone(phone); phone != "" && phone == NormalizePhone(referrer.Phone) {
+		return &referrer, fmt.Errorf("the referral code belongs to someone with the same phone number")
+	}
+
+	if address := NormalizeAddress(address); address != "" && address == NormalizeAddress(referrer.Address) {
+		return &referrer, fmt.Errorf("the referral code belongs to someone at the same address")
+	}
+
+	var previous bool
+
+	statement := `SELECT EXISTS(
+									SELECT 1 FROM orders o
+									JOIN customers c ON o.customer = c.id
+									WHERE LOWER(c.email) = LOWER($1) AND o.cancelled = false AND o.id != $2
+								) OR EXISTS(
+									SELECT 1 FROM referrals r
+									JOIN customers c ON r.referred = c.id
+									WHERE LOWER(c.email) = LOWER($1) AND (r.orderid IS NULL OR r.orderid != $2)
+								)`
+
+	if err := q.Get(&previous, statement, strings.TrimSpace(email), orderId); err != nil {
+		return &referrer, err
+	}
+
+	if previous {
+		return &referrer, fmt.Errorf("referral discounts are for a first order only")
+	}
+
+	if limit := ReferralLimit(); limit > 0 {
+		var referred int
+
+		statement = "SELECT COUNT(*) FROM referrals WHERE referrer = $1 AND status != $2"
+
+		if err := q.Get(&referred, statement, referrer.Id, REFERRAL_REJECTED); err != nil {
+			return &referrer, err
+		}
+
+		if referred >= limit {
+			return &referrer, fmt.Errorf("the referral code reached its limit")
+		}
+	}
+
+	return &referrer, nil
+}
+
+// ApplyReferral attributes a new order to the referral code it was placed with and records the discount the
+// checkout already took off. The guards run again since the customer was checked before paying, a referral
+// failing them now keeps its discount but never earns the referrer credit.
+func ApplyReferral(code string, discount int) OrderStep {
+	return func(tx *sqlx.Tx, order *Order) error {
+		code = NormalizeReferralCode(code)
+
+		// Serialises the referrals of a code so the limit holds
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", "referral:"+code); err != nil {
+			return err
+		}
+
+		referrer, err := checkReferral(tx, code, order.Customer.Email, order.Customer.Phone, order.Customer.Address, order.Id)
+		if referrer != nil {
+			status, reason := REFERRAL_PENDING, ""
+			if err != nil {
+				status, reason = REFERRAL_REJECTED, err.Error()
+			}
+
+			statement := `INSERT INTO referrals (id, referrer, referred, orderid, code, discount, credit, status, reason)
+										VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+										ON CONFLICT (referred) DO NOTHING`
+
+			if _, err := tx.Exec(statement, uuid.NewV4().String(), referrer.Id, order.Customer.Id, order.Id, code, discount, ReferralCredit(), status, reason); err != nil {
+				return err
+			}
+		}
+
+		// A code gone since the checkout still leaves the discount the customer was given
+		if _, err := tx.Exec("UPDATE orders SET referral = $1, discount = discount + $2 WHERE id = $3", code, discount, order.Id); err != nil {
+			return err
+		}
+
+		order.Referral = code
+		order.Discount += discount
+
+		return nil
+	}
+}
+
+// CreditReferral gives the referrer their store credit once the referred order is fulfilled.
+// Nil is returned when the order has no referral waiting on it.
+func CreditReferral(orderId string) (*Referral, *GiftCard, error) {
+	var referral Referral
+
+	statement := `UPDATE referrals r SET status = $1
+								FROM orders o
+								WHERE r.orderid = o.id AND r.orderid = $2 AND r.status = $3 AND o.fulfilled = true AND o.cancelled = false
+								RETURNING r.*`
+
+	if err := db.Get(&referral, statement, REFERRAL_CREDITED, orderId, REFERRAL_PENDING); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	referrer, err := GetDbCustomer(referral.Referrer)
+	if err == nil && referral.Credit > 0 {
+		var card *GiftCard
+		card, err = IssueGiftCard(STORE_CREDIT, referral.Credit, referrer.Email, "", "", fmt.Sprintf("Referral credit for order %s", orderId))
+		if err == nil {
+			if _, err = db.Exec("UPDATE referrals SET giftcardid = $1 WHERE id = $2", card.Id, referral.Id); err == nil {
+				referral.GiftCardId = &card.Id
+				return &referral, card, nil
+			}
+		}
+	}
+
+	if err != nil {
+		// Put the referral back so crediting it can be tried again
+		if _, resetErr := db.Exec("UPDATE referrals SET status = $1 WHERE id = $2 AND giftcardid IS NULL", REFERRAL_PENDING, referral.Id); resetErr != nil {
+			return nil, nil, fmt.Errorf("%v, and the referral could not be reset: %v", err, resetErr)
+		}
+		return nil, nil, err
+	}
+
+	return &referral, nil, nil
+}
+
+// dropPendingReferral forgets the referral of a cancelled order so the customer can still use a code later
+func dropPendingReferral(tx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, orderId string) error {
+	_, err := tx.Exec("DELETE FROM referrals WHERE orderid = $1 AND status = $2", orderId, REFERRAL_PENDING)
+	return err
+}
+
+type ReferrerLine struct {
+	Id        string `json:"id"`
+	Fullname  string `json:"fullname"`
+	Email     string `json:"email"`
+	Code      string `json:"code"`
+	Referred  int    `json:"referred"`
+	Pending   int    `json:"pending"`
+	Credited  int    `json:"credited"`
+	Rejected  int    `json:"rejected"`
+	Discounts int    `json:"discounts"` // Given to the referred customers
+	Credits   int    `json:"credits"`   // Store credit issued to the referrer
+	Revenue   int    `json:"revenue"`   // Brought in by the referred first orders
+}
+
+type ReferralReport struct {
+	Referrers []ReferrerLine `json:"referrers"`
+	Referred  int            `json:"referred"`
+	Rejected  int            `json:"rejected"`
+	Discounts int            `json:"discounts"`
+	Credits   int            `json:"credits"`
+	Revenue   int            `json:"revenue"`
+	Recent    []Referral     `json:"recent"`
+}
+
+// GetReferralReport sums the referrals of every referrer, best referrers first, with the latest referrals for review
+func GetReferralReport() (*ReferralReport, error) {
+	var lines []ReferrerLine = make([]ReferrerLine, 0)
+
+	statement := `SELECT
+									c.id AS id,
+									c.fullname AS fullname,
+									c.email AS email,
+									c.referral AS code,
+									COUNT(r.id) AS referred,
+									COUNT(r.id) FILTER (WHERE r.status = 'pending') AS pending,
+									COUNT(r.id) FILTER (WHERE r.status = 'credited') AS credited,
+									COUNT(r.id) FILTER (WHERE r.status = 'rejected') AS rejected,
+									COALESCE(SUM(r.discount), 0) AS discounts,
+									COALESCE(SUM(r.credit) FILTER (WHERE r.status = 'credited'), 0) AS credits,
+									COALESCE(SUM((
+										SELECT ROUND(SUM(CASE WHEN pr.weighed = true THEN (p.quantity / 10.0) * pr.price ELSE p.quantity * pr.price END))
+										FROM purchases p
+										JOIN products pr ON p.productid = pr.id
+										WHERE p.orderid = r.orderid
+									) - r.discount), 0) AS revenue
+								FROM referrals r
+								JOIN customers c ON r.referrer = c.id
+								GROUP BY c.id, c.fullname, c.email, c.referral
+								ORDER BY credited DESC, referred DESC`
+
+	err := db.Select(&lines, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReferralReport{Referrers: lines}
+	for _, line := range lines {
+		report.Referred += line.Referred
+		report.Rejected += line.Rejected
+		report.Discounts += line.Discounts
+		report.Credits += line.Credits
+		report.Revenue += line.Revenue
+	}
+
+	report.Recent = make([]Referral, 0)
+
+	err = db.Select(&report.Recent, "SELECT * FROM referrals ORDER BY created DESC LIMIT 50")
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/geo"
+)
+
+// Straight-line distances are stretched by this much to account for the streets
+const routeDetour = 1.3
+
+// RouteStop is a delivery on the driver's round
+type RouteStop struct {
+	Sequence    int       `json:"sequence" db:"-"` // 1 for the first stop, 0 for stops left off the round
+	OrderId     string    `json:"order_id" db:"id"`
+	Customer    string    `json:"customer" db:"fullname"`
+	Phone       string    `json:"phone"`
+	Address     string    `json:"address"`
+	Notes       string    `json:"notes"`
+	Lat         float64   `json:"lat"`
+	Lng         float64   `json:"lng"`
+	Method      string    `json:"method"`
+	Due         int       `json:"due"` // In cents, cash the driver collects
+	WindowStart time.Time `json:"window_start" db:"windowstart"`
+	WindowEnd   time.Time `json:"window_end" db:"windowend"`
+	Distance    float64   `json:"distance" db:"-"` // In km, from the previous stop
+	Eta         time.Time `json:"eta" db:"-"`
+	Late        bool      `json:"late" db:"-"` // Arrives after the end of its window
+}
+
+// DeliveryRoute is the driver's round for a day, stops without coordinates are left for the driver to place
+type DeliveryRoute struct {
+	Date      string      `json:"date"`
+	Window    string      `json:"window"` // Start of the window routed, empty for the whole day
+	Departure time.Time   `json:"departure"`
+	Stops     []RouteStop `json:"stops"`
+	Unrouted  []RouteStop `json:"unrouted"`
+	Distance  float64     `json:"distance"` // In km
+	Finish    time.Time   `json:"finish"`   // When the last stop is done
+	MapsURLs  []string    `json:"maps_urls"`
+}
+
+// routeSetting reads a positive number setting of the route planner
+func routeSetting(name string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && value > 0 {
+		return value
+	}
+
+	return fallback
+}
+
+// GetDeliveryStops lists the open deliveries whose window starts on the day, only those of the window
+// starting at window ("15:04") unless it is empty
+func GetDeliveryStops(day time.Time, window string) ([]RouteStop, error) {
+	var stops []RouteStop = make([]RouteStop, 0)
+
+	statement := `SELECT o.id, c.fullname, c.phone, COALESCE(a.formatted, c.address) AS address, o.notes,
+										COALESCE(a.lat, 0) AS lat, COALESCE(a.lng, 0) AS lng, o.method::TEXT AS method,
+										CASE WHEN o.method = 'cash' THEN GREATEST(COALESCE(t.total, 0) + o.deliveryfee - o.discount, 0) ELSE 0 END AS due,
+										o.windowstart, o.windowend
+									FROM orders o
+									JOIN customers c ON c.id = o.customer
+									LEFT JOIN customer_addresses a ON a.id = o.addressid
+									LEFT JOIN ` + orderTotals + ` t ON t.orderid = o.id
+									WHERE o.fulfilment = $1 AND o.cancelled = false AND o.fulfilled = false
+									AND o.windowstart >= $2 AND o.windowstart < $3
+									AND ($4 = '' OR TO_CHAR(o.windowstart, 'HH24:MI') = $4)
+									ORDER BY o.windowstart ASC, o.created ASC`
+
+	err := db.Select(&stops, statement, DELIVERY, day, day.AddDate(0, 0, 1), window)
+	if err != nil {
+		return nil, err
+	}
+
+	return stops, nil
+}
+
+// PlanDeliveryRoute orders the day's deliveries into a round from the shop and estimates when each is reached,
+// driving at DELIVERY_SPEED_KMH (25 by default) and spending DELIVERY_STOP_MINUTES (5 by default) at each door.
+// The driver leaves at the start of the earliest window.
+func PlanDeliveryRoute(day time.Time, window string) (*DeliveryRoute, error) {
+	stops, err := GetDeliveryStops(day, window)
+	if err != nil {
+		return nil, err
+	}
+
+	route := &DeliveryRoute{Date: day.Format("2006-01-02"), Window: window, Stops: make([]RouteStop, 0), Unrouted: make([]RouteStop, 0), MapsURLs: make([]string, 0)}
+
+	var located []RouteStop = make([]RouteStop, 0)
+	var points []geo.Point = make([]geo.Point, 0)
+	for _, stop := range stops {
+		if stop.Lat == 0 && stop.Lng == 0 {
+			route.Unrouted = append(route.Unrouted, stop)
+			continue
+		}
+
+		located = append(located, stop)
+		points = append(points, geo.Point{Lat: stop.Lat, Lng: stop.Lng})
+	}
+
+	if len(stops) > 0 {
+		route.Departure = stops[0].WindowStart
+	}
+	route.Finish = route.Departure
+
+	if len(located) == 0 {
+		return route, nil
+	}
+
+	lat, lng := geo.Shop()
+	shop := geo.Point{Lat: lat, Lng: lng}
+
+	speed := routeSetting("DELIVERY_SPEED_KMH", 25)
+	stay := time.Duration(routeSetting("DELIVERY_STOP_MINUTES", 5) * float64(time.Minute))
+
+	ordered := make([]geo.Point, 0, len(located))
+	previous := shop
+	clock := route.Departure
+
+	for i, index := range geo.PlanRoute(shop, points) {
+		stop := located[index]
+		point := points[index]
+
+		stop.Sequence = i + 1
+		stop.Distance = geo.DistanceKm(previous.Lat, previous.Lng, point.Lat, point.Lng) * routeDetour
+
+		clock = clock.Add(time.Duration(math.Round(stop.Distance / speed * float64(time.Hour))))
+		// Nobody is expected to open the door before their window
+		if clock.Before(stop.WindowStart) {
+			clock = stop.WindowStart
+		}
+
+		stop.Eta = clock
+		stop.Late = clock.After(stop.WindowEnd)
+
+		clock = clock.Add(stay)
+
+		route.Stops = append(route.Stops, stop)
+		route.Distance += stop.Distance
+		ordered = append(ordered, point)
+		previous = point
+	}
+
+	route.Finish = clock
+	route.MapsURLs = geo.MapsDirectionsURLs(shop, ordered)
+
+	return route, nil
+}
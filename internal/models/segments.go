@@ -0,0 +1,296 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Segment string
+
+const (
+	SEGMENT_NEW         Segment = "new"
+	SEGMENT_LOYAL       Segment = "loyal"
+	SEGMENT_AT_RISK     Segment = "at-risk"
+	SEGMENT_LAPSED      Segment = "lapsed"
+	SEGMENT_BIG_SPENDER Segment = "big-spender"
+	SEGMENT_OCCASIONAL  Segment = "occasional"
+)
+
+// Segments in the order they are tried, a customer falls in the first that fits
+var Segments = []Segment{SEGMENT_NEW, SEGMENT_LAPSED, SEGMENT_AT_RISK, SEGMENT_BIG_SPENDER, SEGMENT_LOYAL, SEGMENT_OCCASIONAL}
+
+var segmentColors = map[Segment]int{
+	SEGMENT_NEW:         0x1CE2D4,
+	SEGMENT_LOYAL:       0x2ECC71,
+	SEGMENT_AT_RISK:     0xE67E22,
+	SEGMENT_LAPSED:      0xE74C3C,
+	SEGMENT_BIG_SPENDER: 0xCFE410,
+	SEGMENT_OCCASIONAL:  0x95A5A6,
+}
+
+const (
+	// Customers whose first order is this recent are new
+	segmentNewDays = 30
+	// Good customers who have not ordered for this long are at risk
+	segmentAtRiskDays = 60
+	// Customers who have not ordered for this long are lapsed
+	segmentLapsedDays = 180
+)
+
+func ParseSegment(str string) (Segment, error) {
+	for _, segment := range Segments {
+		if string(segment) == str {
+			return segment, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown segment: %s", str)
+}
+
+// CustomerRFM scores a customer on recency, frequency and monetary value from 1 (lowest fifth) to 5 (highest fifth)
+type CustomerRFM struct {
+	Id         string    `json:"id"`
+	Fullname   string    `json:"fullname"`
+	Email      string    `json:"email"`
+	Phone      string    `json:"phone"`
+	FirstOrder time.Time `json:"first_order" db:"first_order"`
+	LastOrder  time.Time `json:"last_order" db:"last_order"`
+	Recency    int       `json:"recency"`   // Days since the last order
+	Frequency  int       `json:"frequency"` // Orders placed
+	Monetary   int       `json:"monetary"`  // In cents, spent after discounts
+	RScore     int       `json:"r_score" db:"-"`
+	FScore     int       `json:"f_score" db:"-"`
+	MScore     int       `json:"m_score" db:"-"`
+	Segment    Segment   `json:"segment" db:"-"`
+}
+
+type SegmentSize struct {
+	Segment Segment `json:"segment"`
+	Count   int     `json:"count"`
+}
+
+type SegmentsStats struct {
+	Sizes []SegmentSize `json:"sizes"`
+	Data  []Dataset     `json:"data"` // Size of each segment at the end of every period of the timeframe
+}
+
+// quintile places value among the sorted values, 1 for the lowest fifth up to 5 for the highest
+func quintile(sorted []int, value int) int {
+	if len(sorted) == 0 {
+		return 1
+	}
+
+	below := sort.SearchInts(sorted, value)
+
+	return 1 + below*5/len(sorted)
+}
+
+// GetCustomersRFM scores every customer who ordered before at, cancelled orders and erased customers left out
+func GetCustomersRFM(at time.Time) ([]CustomerRFM, error) {
+	var customers []CustomerRFM = make([]CustomerRFM, 0)
+
+	statement := `WITH totals AS (
+									SELECT o.id, o.customer, o.created,
+										GREATEST(COALESCE(ROUND(SUM(
+											CASE
+												WHEN pr.weighed = true THEN (p.quantity / 10.0) * pr.price
+												ELSE p.quantity * pr.price
+											END
+										)), 0) - o.discount, 0) AS spent
+									FROM orders o
+									LEFT JOIN purchases p ON p.orderid = o.id
+									LEFT JOIN products pr ON pr.id = p.productid
+									WHERE o.cancelled = false AND o.created < $1::TIMESTAMP
+									GROUP BY o.id
+								)
+								SELECT
+									c.id AS id,
+									c.fullname AS fullname,
+									c.email AS email,
+									c.phone AS phone,
+									MIN(t.created) AS first_order,
+									MAX(t.created) AS last_order,
+									DATE_PART('day', $1::TIMESTAMP - MAX(t.created))::INT AS recency,
+									COUNT(*) AS frequency,
+									SUM(t.spent)::INT AS monetary
+								FROM customers c
+								JOIN totals t ON t.customer = c.id
+								WHERE c.id != $2 AND c.erased IS NULL
+								GROUP BY c.id
+								ORDER BY c.fullname ASC`
+
+	if err := db.Select(&customers, statement, at, AnonymousCustomerId); err != nil {
+		return nil, err
+	}
+
+	recencies := make([]int, len(customers))
+	frequencies := make([]int, len(customers))
+	monetaries := make([]int, len(customers))
+	for i, customer := range customers {
+		// Negated so the most recent customers rank highest
+		recencies[i] = -customer.Recency
+		frequencies[i] = customer.Frequency
+		monetaries[i] = customer.Monetary
+	}
+
+	sort.Ints(recencies)
+	sort.Ints(frequencies)
+	sort.Ints(monetaries)
+
+	for i := range customers {
+		customer := &customers[i]
+		customer.RScore = quintile(recencies, -customer.Recency)
+		customer.FScore = quintile(frequencies, customer.Frequency)
+		customer.MScore = quintile(monetaries, customer.Monetary)
+		customer.Segment = customer.segment(at)
+	}
+
+	return customers, nil
+}
+
+func (c *CustomerRFM) segment(at time.Time) Segment {
+	switch {
+	case at.Sub(c.FirstOrder) <= segmentNewDays*24*time.Hour:
+		return SEGMENT_NEW
+	case c.Recency > segmentLapsedDays:
+		return SEGMENT_LAPSED
+	case c.Recency > segmentAtRiskDays && (c.FScore >= 3 || c.MScore >= 4):
+		return SEGMENT_AT_RISK
+	case c.MScore == 5:
+		return SEGMENT_BIG_SPENDER
+	case c.FScore >= 4:
+		return SEGMENT_LOYAL
+	default:
+		return SEGMENT_OCCASIONAL
+	}
+}
+
+// FilterSegment keeps the customers of one segment, all of them for an empty segment
+func FilterSegment(customers []CustomerRFM, segment Segment) []CustomerRFM {
+	if segment == "" {
+		return customers
+	}
+
+	var filtered []CustomerRFM = make([]CustomerRFM, 0)
+	for _, customer := range customers {
+		if customer.Segment == segment {
+			filtered = append(filtered, customer)
+		}
+	}
+
+	return filtered
+}
+
+func countSegments(customers []CustomerRFM) map[Segment]int {
+	counts := make(map[Segment]int, len(Segments))
+	for _, customer := range customers {
+		counts[customer.Segment]++
+	}
+
+	return counts
+}
+
+// timeframeEnds returns when each period of the timeframe ends, one per label of GetHorizonalDataAndQueryByTimeframe
+func timeframeEnds(timeframe Timeframe, now time.Time) ([]time.Time, error) {
+	var ends []time.Time
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	switch timeframe {
+	case L7:
+		for i := 6; i >= 0; i-- {
+			ends = append(ends, today.AddDate(0, 0, -i+1))
+		}
+	case PW:
+		offset := int(now.Weekday())
+		for i := 6 + offset; i >= offset; i-- {
+			ends = append(ends, today.AddDate(0, 0, -i+1))
+		}
+	case L30:
+		for i := 30; i > 0; i-- {
+			if i%6 == 0 {
+				ends = append(ends, today.AddDate(0, 0, -i+7))
+			}
+		}
+	case PM:
+		offset := now.Day()
+		for i := 30 + offset; i > offset; i-- {
+			if i%6 == 0 {
+				ends = append(ends, today.AddDate(0, 0, -i+7))
+			}
+		}
+	case L12:
+		for i := 11; i >= 0; i-- {
+			ends = append(ends, thisMonth.AddDate(0, -i+1, 0))
+		}
+	case PY:
+		offset := int(now.Month())
+		for i := 11 + offset; i >= offset; i-- {
+			ends = append(ends, thisMonth.AddDate(0, -i+1, 0))
+		}
+	default:
+		return nil, fmt.Errorf("invalid timeframe: %v", timeframe)
+	}
+
+	for i := range ends {
+		if ends[i].After(now) {
+			ends[i] = now
+		}
+	}
+
+	return ends, nil
+}
+
+// GetSegmentsStats sizes the segments today and at the end of every period of the timeframe
+func GetSegmentsStats(timeframe Timeframe) (*SegmentsStats, error) {
+	var ignored string
+	horizontal, err := GetHorizonalDataAndQueryByTimeframe("created", timeframe, &ignored)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	ends, err := timeframeEnds(timeframe, now)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := GetCustomersRFM(now)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := countSegments(current)
+
+	stats := &SegmentsStats{Sizes: make([]SegmentSize, 0, len(Segments)), Data: make([]Dataset, 0, len(Segments))}
+
+	verticals := make(map[Segment][]int, len(Segments))
+	for _, segment := range Segments {
+		stats.Sizes = append(stats.Sizes, SegmentSize{Segment: segment, Count: counts[segment]})
+		verticals[segment] = make([]int, len(horizontal))
+	}
+
+	for i, end := range ends {
+		if i >= len(horizontal) {
+			break
+		}
+
+		customers, err := GetCustomersRFM(end)
+		if err != nil {
+			return nil, err
+		}
+
+		for segment, count := range countSegments(customers) {
+			verticals[segment][i] = count
+		}
+	}
+
+	for _, segment := range Segments {
+		stats.Data = append(stats.Data, Dataset{Topic: string(segment), Horizontal: horizontal, Vertical: verticals[segment], Color: segmentColors[segment]})
+	}
+
+	return stats, nil
+}
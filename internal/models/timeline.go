@@ -0,0 +1,138 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type TimelineKind string
+
+const (
+	TIMELINE_ORDER  TimelineKind = "order"
+	TIMELINE_REFUND TimelineKind = "refund"
+	TIMELINE_EMAIL  TimelineKind = "email"
+	TIMELINE_NOTE   TimelineKind = "note"
+)
+
+// TimelineEntry is one thing that happened with a customer, Ref points at the order, refund, email or note
+type TimelineEntry struct {
+	Kind   TimelineKind `json:"kind"`
+	Ref    string       `json:"ref"`
+	Title  string       `json:"title"`
+	Detail string       `json:"detail"`
+	Amount int          `json:"amount"` // In cents, for orders and refunds
+	Actor  string       `json:"actor"`
+	Date   time.Time    `json:"date"`
+}
+
+// CustomerProfile is what the admin sees of a customer when they call
+type CustomerProfile struct {
+	Customer
+	Addresses []CustomerAddress `json:"addresses"`
+	Notes     []CustomerNote    `json:"notes"`
+	Timeline  []TimelineEntry   `json:"timeline"`
+}
+
+// GetCustomerTimeline merges the customer's orders, refunds, emails sent and note edits, newest first
+func GetCustomerTimeline(customer *Customer) ([]TimelineEntry, error) {
+	var timeline []TimelineEntry = make([]TimelineEntry, 0)
+
+	var orders []struct {
+		Id        string    `db:"id"`
+		Method    string    `db:"method"`
+		Fulfilled bool      `db:"fulfilled"`
+		Cancelled bool      `db:"cancelled"`
+		Total     int       `db:"total"`
+		Created   time.Time `db:"created"`
+	}
+
+	statement := `SELECT o.id, o.method, o.fulfilled, o.cancelled, o.created,
+									GREATEST(COALESCE(ROUND(SUM(
+										CASE
+											WHEN pr.weighed = true THEN (p.quantity / 10.0) * pr.price
+											ELSE p.quantity * pr.price
+										END
+									)), 0) + o.deliveryfee - o.discount, 0) AS total
+								FROM orders o
+								LEFT JOIN purchases p ON p.orderid = o.id
+								LEFT JOIN products pr ON pr.id = p.productid
+								WHERE o.customer = $1
+								GROUP BY o.id`
+
+	if err := db.Select(&orders, statement, customer.Id); err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		status := "open"
+		switch {
+		case order.Cancelled:
+			status = "cancelled"
+		case order.Fulfilled:
+			status = "fulfilled"
+		}
+
+		timeline = append(timeline, TimelineEntry{Kind: TIMELINE_ORDER, Ref: order.Id, Title: fmt.Sprintf("Order %s", order.Id[:min(8, len(order.Id))]), Detail: fmt.Sprintf("%s, paid by %s", status, order.Method), Amount: order.Total, Date: order.Created})
+	}
+
+	var refunds []Refund = make([]Refund, 0)
+	if err := db.Select(&refunds, "SELECT r.* FROM refunds r JOIN orders o ON o.id = r.orderid WHERE o.customer = $1", customer.Id); err != nil {
+		return nil, err
+	}
+
+	for _, refund := range refunds {
+		timeline = append(timeline, TimelineEntry{Kind: TIMELINE_REFUND, Ref: refund.Id, Title: fmt.Sprintf("Refund on order %s", refund.OrderId[:min(8, len(refund.OrderId))]), Detail: refund.Reason, Amount: refund.Amount, Date: refund.Created})
+	}
+
+	if strings.TrimSpace(customer.Email) != "" {
+		emails, err := GetEmailsTo(customer.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, email := range emails {
+			timeline = append(timeline, TimelineEntry{Kind: TIMELINE_EMAIL, Ref: fmt.Sprint(email.Id), Title: email.Subject, Detail: email.Tag, Date: email.Created})
+		}
+	}
+
+	events, err := GetCustomerNoteEvents(customer.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		timeline = append(timeline, TimelineEntry{Kind: TIMELINE_NOTE, Ref: fmt.Sprint(event.NoteId), Title: fmt.Sprintf("Note %s", event.Action), Detail: event.Body, Actor: event.Actor, Date: event.Created})
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Date.After(timeline[j].Date)
+	})
+
+	return timeline, nil
+}
+
+func GetCustomerProfile(id string) (*CustomerProfile, error) {
+	customer, err := GetCustomer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := GetCustomerAddresses(customer.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := GetCustomerNotes(customer.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline, err := GetCustomerTimeline(customer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CustomerProfile{Customer: *customer, Addresses: addresses, Notes: notes, Timeline: timeline}, nil
+}
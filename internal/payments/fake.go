@@ -0,0 +1,89 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/google/uuid"
+)
+
+// Fake approves every payment without contacting anyone, for development and demos.
+// Customers are sent straight to the return URL as if they had approved on the provider's page.
+type Fake struct {
+	method models.PaymentMethod
+
+	mu       sync.Mutex
+	payments map[string]*Payment
+}
+
+func NewFake(method models.PaymentMethod) *Fake {
+	return &Fake{method: method, payments: make(map[string]*Payment)}
+}
+
+func (f *Fake) Method() models.PaymentMethod {
+	return f.method
+}
+
+func (f *Fake) CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error) {
+	if request.ReturnURL == "" {
+		return nil, fmt.Errorf("fake payments need a return url")
+	}
+
+	returnURL, err := url.Parse(request.ReturnURL)
+	if err != nil {
+		return nil, err
+	}
+
+	id := "fake_" + uuid.NewString()
+
+	query := returnURL.Query()
+	query.Set("token", id)
+	returnURL.RawQuery = query.Encode()
+
+	payment := &Payment{Id: id, Amount: request.Amount, Status: PENDING, ApproveURL: returnURL.String()}
+
+	f.mu.Lock()
+	f.payments[id] = payment
+	f.mu.Unlock()
+
+	return payment, nil
+}
+
+func (f *Fake) ConfirmPayment(ctx context.Context, paymentId string) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[paymentId]
+	if !ok {
+		return nil, fmt.Errorf("fake payment %s not found", paymentId)
+	}
+
+	payment.Status = SUCCEEDED
+	payment.ChargeId = paymentId
+
+	confirmed := *payment
+	return &confirmed, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, payment *Payment, amount int, reason string) (*RefundResult, error) {
+	if amount <= 0 || (payment.Amount > 0 && amount > payment.Amount) {
+		return nil, fmt.Errorf("invalid refund amount: %d", amount)
+	}
+
+	return &RefundResult{Id: "fake_re_" + uuid.NewString(), Amount: amount, Status: "succeeded"}, nil
+}
+
+// VerifyWebhook accepts any {id, type, data} payload, there is no signature to check
+func (f *Fake) VerifyWebhook(ctx context.Context, payload []byte, header http.Header) (*WebhookEvent, error) {
+	var event WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
@@ -0,0 +1,296 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+)
+
+const paypalSandbox = "https://api-m.sandbox.paypal.com"
+
+// PayPal takes payments through the Orders v2 API, the customer approves on paypal.com
+// and the order is captured when they come back
+type PayPal struct {
+	api       string
+	clientId  string
+	secret    string
+	webhookId string
+	client    *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewPayPal(api string, clientId string, secret string, webhookId string) *PayPal {
+	if api == "" {
+		api = paypalSandbox
+	}
+
+	return &PayPal{api: strings.TrimSuffix(api, "/"), clientId: clientId, secret: secret, webhookId: webhookId, client: &http.Client{Timeout: 20 * time.Second}}
+}
+
+func (p *PayPal) Method() models.PaymentMethod {
+	return models.PAYPAL
+}
+
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type paypalLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+type paypalCapture struct {
+	Id        string       `json:"id"`
+	Status    string       `json:"status"`
+	Amount    paypalAmount `json:"amount"`
+	Breakdown struct {
+		PayPalFee *paypalAmount `json:"paypal_fee"`
+	} `json:"seller_receivable_breakdown"`
+}
+
+type paypalOrder struct {
+	Id            string       `json:"id"`
+	Status        string       `json:"status"`
+	Links         []paypalLink `json:"links"`
+	PurchaseUnits []struct {
+		Payments struct {
+			Captures []paypalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+func (p *PayPal) CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error) {
+	body := map[string]interface{}{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]interface{}{
+			{
+				"custom_id": request.Reference,
+				"amount":    paypalAmount{CurrencyCode: request.Currency, Value: formatPayPalAmount(request.Amount)},
+			},
+		},
+		"application_context": map[string]string{
+			"brand_name":  "Rosskery",
+			"user_action": "PAY_NOW",
+			"return_url":  request.ReturnURL,
+			"cancel_url":  request.CancelURL,
+		},
+	}
+
+	var order paypalOrder
+	if err := p.do(ctx, http.MethodPost, "/v2/checkout/orders", body, &order); err != nil {
+		return nil, err
+	}
+
+	payment := &Payment{Id: order.Id, Amount: request.Amount, Status: PENDING}
+	for _, link := range order.Links {
+		if link.Rel == "approve" || link.Rel == "payer-action" {
+			payment.ApproveURL = link.Href
+		}
+	}
+
+	if payment.ApproveURL == "" {
+		return nil, fmt.Errorf("paypal order %s has no approval link", order.Id)
+	}
+
+	return payment, nil
+}
+
+func (p *PayPal) ConfirmPayment(ctx context.Context, paymentId string) (*Payment, error) {
+	var order paypalOrder
+	if err := p.do(ctx, http.MethodPost, "/v2/checkout/orders/"+url.PathEscape(paymentId)+"/capture", map[string]string{}, &order); err != nil {
+		return nil, err
+	}
+
+	payment := &Payment{Id: order.Id, Status: PENDING}
+
+	for _, unit := range order.PurchaseUnits {
+		for _, capture := range unit.Payments.Captures {
+			payment.ChargeId = capture.Id
+
+			amount, err := ParsePayPalAmount(capture.Amount.Value)
+			if err != nil {
+				return nil, err
+			}
+			payment.Amount += amount
+
+			if capture.Breakdown.PayPalFee != nil {
+				fee, err := ParsePayPalAmount(capture.Breakdown.PayPalFee.Value)
+				if err != nil {
+					return nil, err
+				}
+				payment.Fee += fee
+			}
+
+			switch capture.Status {
+			case "COMPLETED":
+				payment.Status = SUCCEEDED
+			case "DECLINED", "FAILED":
+				payment.Status = FAILED
+			}
+		}
+	}
+
+	return payment, nil
+}
+
+func (p *PayPal) Refund(ctx context.Context, payment *Payment, amount int, reason string) (*RefundResult, error) {
+	if payment.ChargeId == "" {
+		return nil, fmt.Errorf("paypal order %s was never captured", payment.Id)
+	}
+
+	body := map[string]interface{}{
+		"amount":        paypalAmount{CurrencyCode: "CAD", Value: formatPayPalAmount(amount)},
+		"note_to_payer": reason,
+	}
+
+	var result struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/v2/payments/captures/"+url.PathEscape(payment.ChargeId)+"/refund", body, &result); err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{Id: result.Id, Amount: amount, Status: strings.ToLower(result.Status)}, nil
+}
+
+func (p *PayPal) VerifyWebhook(ctx context.Context, payload []byte, header http.Header) (*WebhookEvent, error) {
+	if p.webhookId == "" {
+		return nil, fmt.Errorf("PAYPAL_WEBHOOK_ID is not set")
+	}
+
+	body := map[string]interface{}{
+		"auth_algo":         header.Get("PAYPAL-AUTH-ALGO"),
+		"cert_url":          header.Get("PAYPAL-CERT-URL"),
+		"transmission_id":   header.Get("PAYPAL-TRANSMISSION-ID"),
+		"transmission_sig":  header.Get("PAYPAL-TRANSMISSION-SIG"),
+		"transmission_time": header.Get("PAYPAL-TRANSMISSION-TIME"),
+		"webhook_id":        p.webhookId,
+		"webhook_event":     json.RawMessage(payload),
+	}
+
+	var verification struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/v1/notifications/verify-webhook-signature", body, &verification); err != nil {
+		return nil, err
+	}
+
+	if verification.VerificationStatus != "SUCCESS" {
+		return nil, fmt.Errorf("paypal webhook signature %s", strings.ToLower(verification.VerificationStatus))
+	}
+
+	var event struct {
+		Id        string          `json:"id"`
+		EventType string          `json:"event_type"`
+		Resource  json.RawMessage `json:"resource"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+
+	return &WebhookEvent{Id: event.Id, Type: event.EventType, Data: event.Resource}, nil
+}
+
+// accessToken returns a cached OAuth token, requesting a new one shortly before it expires
+func (p *PayPal) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" && time.Now().Before(p.expires) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.api+"/v1/oauth2/token", strings.NewReader("grant_type=client_credentials"))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(p.clientId, p.secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("paypal authentication failed: %s, Response: %s", resp.Status, body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	p.token = token.AccessToken
+	p.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return p.token, nil
+}
+
+func (p *PayPal) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.api+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("paypal request to %s failed: %s, Response: %s", path, resp.Status, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func formatPayPalAmount(cents int) string {
+	return strconv.FormatFloat(float64(cents)/100.0, 'f', 2, 64)
+}
+
+// ParsePayPalAmount converts a PayPal decimal amount to cents
+func ParsePayPalAmount(value string) (int, error) {
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid paypal amount %q: %v", value, err)
+	}
+
+	return int(math.Round(amount * 100)), nil
+}
@@ -0,0 +1,125 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/gommon/log"
+)
+
+type Status string
+
+const (
+	PENDING   Status = "pending"
+	SUCCEEDED Status = "succeeded"
+	FAILED    Status = "failed"
+)
+
+type PaymentRequest struct {
+	Amount    int    // In cents
+	Currency  string // ISO code, e.g. CAD
+	Reference string // Checkout session the payment belongs to
+	ReturnURL string // Where redirect providers send the customer after approving
+	CancelURL string
+}
+
+type Payment struct {
+	Id           string `json:"id"`
+	ChargeId     string `json:"charge_id"` // Stripe charge or PayPal capture, what refunds are issued against
+	Amount       int    `json:"amount"`
+	Fee          int    `json:"fee"` // In cents, what the provider kept once the payment settled
+	Status       Status `json:"status"`
+	ClientSecret string `json:"client_secret,omitempty"` // Set by providers confirming in the browser
+	ApproveURL   string `json:"approve_url,omitempty"`   // Set by providers the customer is redirected to
+}
+
+type RefundResult struct {
+	Id     string `json:"id"`
+	Amount int    `json:"amount"`
+	Status string `json:"status"`
+}
+
+type WebhookEvent struct {
+	Id   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Provider takes online payments for a checkout
+type Provider interface {
+	Method() models.PaymentMethod
+	CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error)
+	// ConfirmPayment settles an approved payment and reports its outcome
+	ConfirmPayment(ctx context.Context, paymentId string) (*Payment, error)
+	Refund(ctx context.Context, payment *Payment, amount int, reason string) (*RefundResult, error)
+	// VerifyWebhook authenticates a notification sent by the provider
+	VerifyWebhook(ctx context.Context, payload []byte, header http.Header) (*WebhookEvent, error)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = make(map[models.PaymentMethod]Provider)
+)
+
+func Register(provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers[provider.Method()] = provider
+}
+
+// Get returns the provider taking payments for the method
+func Get(method models.PaymentMethod) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	provider, ok := providers[method]
+	if !ok {
+		return nil, fmt.Errorf("%s payments are not available", method)
+	}
+
+	return provider, nil
+}
+
+// Available lists the online methods customers can pick at checkout
+func Available() []models.PaymentMethod {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	methods := make([]models.PaymentMethod, 0, len(providers))
+	for _, method := range models.PaymentMethods {
+		if _, ok := providers[method]; ok {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
+// Setup registers the providers configured in the environment. With PAYMENTS_FAKE=true
+// every online method is served by the fake provider instead, which is only allowed in development
+// since it trusts any webhook posted to it.
+func Setup() {
+	if os.Getenv("PAYMENTS_FAKE") == "true" {
+		if os.Getenv("GO_ENV") != "development" {
+			log.Fatal("PAYMENTS_FAKE=true is only allowed with GO_ENV=development, its webhooks are not signed")
+		}
+		log.Warn("Online payments are faked, no money will be taken")
+		Register(NewFake(models.STRIPE))
+		Register(NewFake(models.PAYPAL))
+		return
+	}
+
+	if key := os.Getenv("STRIPE_SECRET_KEY"); key != "" {
+		Register(NewStripe(key, os.Getenv("STRIPE_WEBHOOK_SECRET"), os.Getenv("STRIPE_API_BASE")))
+	}
+
+	if clientId := os.Getenv("PAYPAL_CLIENT_ID"); clientId != "" {
+		Register(NewPayPal(os.Getenv("PAYPAL_API"), clientId, os.Getenv("PAYPAL_SECRET"), os.Getenv("PAYPAL_WEBHOOK_ID")))
+	}
+}
@@ -0,0 +1,187 @@
+package payments
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/balancetransaction"
+	"github.com/stripe/stripe-go/v78/charge"
+	"github.com/stripe/stripe-go/v78/paymentintent"
+	"github.com/stripe/stripe-go/v78/refund"
+	"github.com/stripe/stripe-go/v78/webhook"
+)
+
+// Stripe confirms payments in the browser with the PaymentIntent client secret
+type Stripe struct {
+	webhookSecret string
+}
+
+// NewStripe sets up the Stripe client. apiBase points it at another server, such as stripe-mock, when set.
+func NewStripe(secretKey string, webhookSecret string, apiBase string) *Stripe {
+	stripe.Key = secretKey
+
+	if apiBase != "" {
+		stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{URL: stripe.String(apiBase)}))
+	}
+
+	return &Stripe{webhookSecret: webhookSecret}
+}
+
+func (s *Stripe) Method() models.PaymentMethod {
+	return models.STRIPE
+}
+
+func (s *Stripe) CreatePayment(ctx context.Context, request PaymentRequest) (*Payment, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(int64(request.Amount)),
+		Currency: stripe.String(strings.ToLower(request.Currency)),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+		Metadata: map[string]string{
+			"sessionID": request.Reference,
+		},
+	}
+	params.Context = ctx
+
+	pi, err := paymentintent.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Payment{Id: pi.ID, Amount: int(pi.Amount), Status: PENDING, ClientSecret: pi.ClientSecret}, nil
+}
+
+func (s *Stripe) ConfirmPayment(ctx context.Context, paymentId string) (*Payment, error) {
+	params := &stripe.PaymentIntentParams{}
+	// The fee is only known from the balance transaction of the charge
+	params.AddExpand("latest_charge.balance_transaction")
+	params.Context = ctx
+
+	pi, err := paymentintent.Get(paymentId, params)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &Payment{Id: pi.ID, Amount: int(pi.AmountReceived), Status: PENDING}
+	if pi.LatestCharge != nil {
+		payment.ChargeId = pi.LatestCharge.ID
+		if pi.LatestCharge.BalanceTransaction != nil {
+			payment.Fee = int(pi.LatestCharge.BalanceTransaction.Fee)
+		}
+	}
+
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		payment.Status = SUCCEEDED
+	case stripe.PaymentIntentStatusCanceled, stripe.PaymentIntentStatusRequiresPaymentMethod:
+		payment.Status = FAILED
+	}
+
+	return payment, nil
+}
+
+func (s *Stripe) Refund(ctx context.Context, payment *Payment, amount int, reason string) (*RefundResult, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(payment.Id),
+		Amount:        stripe.Int64(int64(amount)),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	params.AddMetadata("reason", reason)
+	params.Context = ctx
+
+	r, err := refund.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{Id: r.ID, Amount: int(r.Amount), Status: string(r.Status)}, nil
+}
+
+func (s *Stripe) VerifyWebhook(ctx context.Context, payload []byte, header http.Header) (*WebhookEvent, error) {
+	event, err := webhook.ConstructEvent(payload, header.Get("Stripe-Signature"), s.webhookSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookEvent{Id: event.ID, Type: string(event.Type), Data: event.Data.Raw}, nil
+}
+
+// Activity pulls the PaymentIntents, charges, refunds and balance transactions created in [from, to)
+func (s *Stripe) Activity(ctx context.Context, from time.Time, to time.Time) (*models.StripeActivity, error) {
+	created := &stripe.RangeQueryParams{GreaterThanOrEqual: from.Unix(), LesserThan: to.Unix()}
+
+	sessions := make(map[string]string)
+
+	intentParams := &stripe.PaymentIntentListParams{CreatedRange: created}
+	intentParams.Context = ctx
+	intents := paymentintent.List(intentParams)
+	for intents.Next() {
+		pi := intents.PaymentIntent()
+		sessions[pi.ID] = pi.Metadata["sessionID"]
+	}
+	if err := intents.Err(); err != nil {
+		return nil, err
+	}
+
+	fees := make(map[string]int)
+
+	transactionParams := &stripe.BalanceTransactionListParams{CreatedRange: created}
+	transactionParams.Context = ctx
+	transactions := balancetransaction.List(transactionParams)
+	for transactions.Next() {
+		bt := transactions.BalanceTransaction()
+		if bt.Source != nil && (bt.Type == stripe.BalanceTransactionTypeCharge || bt.Type == stripe.BalanceTransactionTypePayment) {
+			fees[bt.Source.ID] += int(bt.Fee)
+		}
+	}
+	if err := transactions.Err(); err != nil {
+		return nil, err
+	}
+
+	activity := &models.StripeActivity{Charges: make([]models.StripeCharge, 0), Refunds: make([]models.StripeRefund, 0)}
+
+	chargeParams := &stripe.ChargeListParams{CreatedRange: created}
+	chargeParams.Context = ctx
+	charges := charge.List(chargeParams)
+	for charges.Next() {
+		ch := charges.Charge()
+
+		record := models.StripeCharge{Id: ch.ID, Amount: int(ch.AmountCaptured), Refunded: int(ch.AmountRefunded), Fee: fees[ch.ID], Status: string(ch.Status), Created: time.Unix(ch.Created, 0)}
+		if ch.PaymentIntent != nil {
+			record.IntentId = ch.PaymentIntent.ID
+			record.SessionId = sessions[ch.PaymentIntent.ID]
+		}
+
+		activity.Charges = append(activity.Charges, record)
+	}
+	if err := charges.Err(); err != nil {
+		return nil, err
+	}
+
+	refundParams := &stripe.RefundListParams{CreatedRange: created}
+	refundParams.Context = ctx
+	refunds := refund.List(refundParams)
+	for refunds.Next() {
+		r := refunds.Refund()
+
+		record := models.StripeRefund{Id: r.ID, Amount: int(r.Amount), Status: string(r.Status), Created: time.Unix(r.Created, 0)}
+		if r.Charge != nil {
+			record.ChargeId = r.Charge.ID
+		}
+		if r.PaymentIntent != nil {
+			record.IntentId = r.PaymentIntent.ID
+		}
+
+		activity.Refunds = append(activity.Refunds, record)
+	}
+	if err := refunds.Err(); err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
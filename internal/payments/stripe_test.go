@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMockStripe points the client at a running stripe-mock, e.g. STRIPE_MOCK_URL=http://localhost:12111
+func newMockStripe(t *testing.T) *Stripe {
+	t.Helper()
+
+	apiBase := os.Getenv("STRIPE_MOCK_URL")
+	if apiBase == "" {
+		t.Skip("STRIPE_MOCK_URL is not set")
+	}
+
+	return NewStripe("sk_test_123", "whsec_test", apiBase)
+}
+
+func TestStripeAgainstMock(t *testing.T) {
+	provider := newMockStripe(t)
+	ctx := context.Background()
+
+	payment, err := provider.CreatePayment(ctx, PaymentRequest{Amount: 2500, Currency: "CAD", Reference: "session"})
+	if err != nil {
+		t.Fatalf("creating payment: %v", err)
+	}
+	if !strings.HasPrefix(payment.Id, "pi_") {
+		t.Errorf("expected a PaymentIntent id, got %q", payment.Id)
+	}
+	if payment.ClientSecret == "" {
+		t.Error("expected a client secret")
+	}
+	if payment.Status != PENDING {
+		t.Errorf("expected status %s, got %s", PENDING, payment.Status)
+	}
+
+	confirmed, err := provider.ConfirmPayment(ctx, payment.Id)
+	if err != nil {
+		t.Fatalf("confirming payment: %v", err)
+	}
+	if confirmed.Id == "" {
+		t.Error("expected the confirmed payment to keep its id")
+	}
+
+	refunded, err := provider.Refund(ctx, payment, 1000, "test")
+	if err != nil {
+		t.Fatalf("refunding payment: %v", err)
+	}
+	if !strings.HasPrefix(refunded.Id, "re_") {
+		t.Errorf("expected a refund id, got %q", refunded.Id)
+	}
+
+	to := time.Now()
+	if _, err := provider.Activity(ctx, to.AddDate(0, 0, -1), to); err != nil {
+		t.Fatalf("listing activity: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ const (
 	Online    Setting = "online"
 	Operative Setting = "operative"
 	Message   Setting = "message"
+	AutoPrint Setting = "autoprint"
 )
 
 type Settings struct {
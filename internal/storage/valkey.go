@@ -46,4 +46,9 @@ func ValkeySetup(ctx context.Context) {
 	if err := Valkey.Set(ctx, string(Message), "", 0).Err(); err != nil {
 		panic(err)
 	}
+
+	// Kept across restarts once an admin toggles it, PRINTER_AUTOPRINT only sets the initial value
+	if err := Valkey.SetNX(ctx, string(AutoPrint), os.Getenv("PRINTER_AUTOPRINT") == "true", 0).Err(); err != nil {
+		panic(err)
+	}
 }
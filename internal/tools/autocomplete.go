@@ -0,0 +1,23 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/cache"
+)
+
+// ScheduleSuggestionsPrune forgets every hour the address suggestions of sessions gone quiet
+func ScheduleSuggestionsPrune(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cache.PruneSuggestions(time.Hour)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/johnfercher/maroto/v2"
+	"github.com/johnfercher/maroto/v2/pkg/components/col"
+	"github.com/johnfercher/maroto/v2/pkg/components/row"
+	"github.com/johnfercher/maroto/v2/pkg/components/text"
+	"github.com/johnfercher/maroto/v2/pkg/config"
+	"github.com/johnfercher/maroto/v2/pkg/consts/align"
+	"github.com/johnfercher/maroto/v2/pkg/consts/fontstyle"
+	"github.com/johnfercher/maroto/v2/pkg/core"
+	"github.com/johnfercher/maroto/v2/pkg/props"
+)
+
+// GenerateCashClosingReport renders the end-of-day cash report as a printable PDF
+func GenerateCashClosingReport(report *models.CashClosingReport) ([]byte, error) {
+	cfg := config.NewBuilder().Build()
+
+	m := maroto.New(cfg)
+
+	err := m.RegisterHeader(getPageHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	m.AddRows(text.NewRow(10, fmt.Sprintf("Cash Closing %s", report.Day), props.Text{
+		Top:   3,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+	}))
+
+	m.AddRow(7,
+		text.NewCol(12, "Staff", props.Text{
+			Top:   1.5,
+			Size:  9,
+			Style: fontstyle.Bold,
+			Align: align.Center,
+			Color: &props.WhiteColor,
+		}),
+	).WithStyle(&props.Cell{BackgroundColor: getDarkGrayColor()})
+
+	m.AddRows(getCashClosingLines(report)...)
+
+	if report.Flagged {
+		m.AddRows(text.NewRow(10, "Discrepancies found, lines marked with ! need review", props.Text{
+			Top:   3,
+			Style: fontstyle.Bold,
+			Align: align.Center,
+			Color: getRedColor(),
+		}))
+	}
+
+	m.AddRows(text.NewRow(8, fmt.Sprintf("Tolerance %s - Cash still outstanding %s", cents(report.Tolerance), cents(report.Outstanding)), props.Text{
+		Top:   2,
+		Size:  8,
+		Style: fontstyle.Italic,
+		Align: align.Center,
+	}))
+
+	document, err := m.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	return document.GetBytes(), nil
+}
+
+func getCashClosingLines(report *models.CashClosingReport) []core.Row {
+	header := props.Text{Size: 9, Align: align.Center, Style: fontstyle.Bold}
+
+	rows := []core.Row{
+		row.New(5).Add(
+			text.NewCol(3, "Staff", header),
+			text.NewCol(1, "Orders", header),
+			text.NewCol(2, "Tendered", header),
+			text.NewCol(2, "Expected", header),
+			text.NewCol(2, "Counted", header),
+			text.NewCol(2, "Difference", header),
+		),
+	}
+
+	for i, line := range report.Lines {
+		counted := "not counted"
+		if line.Counted != nil {
+			counted = cents(*line.Counted)
+		}
+
+		name := line.Username
+		if line.Flagged {
+			name = "! " + name
+		}
+
+		content := props.Text{Size: 8, Align: align.Center}
+		if line.Flagged {
+			content.Color = getRedColor()
+		}
+
+		r := row.New(4).Add(
+			text.NewCol(3, name, content),
+			text.NewCol(1, fmt.Sprint(line.Collections), content),
+			text.NewCol(2, cents(line.Tendered), content),
+			text.NewCol(2, cents(line.Expected), content),
+			text.NewCol(2, counted, content),
+			text.NewCol(2, cents(line.Discrepancy), content),
+		)
+		if i%2 == 0 {
+			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
+		}
+
+		rows = append(rows, r)
+
+		if line.Notes != "" {
+			rows = append(rows, row.New(4).Add(
+				col.New(3),
+				text.NewCol(9, line.Notes, props.Text{Size: 7, Style: fontstyle.Italic}),
+			))
+		}
+	}
+
+	total := props.Text{Top: 5, Style: fontstyle.Bold, Size: 8, Align: align.Center}
+
+	rows = append(rows, row.New(20).Add(
+		text.NewCol(4, "Totals:", props.Text{Top: 5, Style: fontstyle.Bold, Size: 8, Align: align.Right}),
+		col.New(2),
+		text.NewCol(2, cents(report.Expected), total),
+		text.NewCol(2, cents(report.Counted), total),
+		text.NewCol(2, cents(report.Discrepancy), total),
+	))
+
+	return rows
+}
+
+func cents(amount int) string {
+	return helpers.FormatPrice(float64(amount) / 100.0)
+}
@@ -0,0 +1,31 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/gommon/log"
+)
+
+// ScheduleDuplicateDetection looks for customers that are likely the same person once a day,
+// the pairs found wait in the review queue until an admin merges or dismisses them
+func ScheduleDuplicateDetection(ctx context.Context) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		suggested, err := models.DetectDuplicateCustomers()
+		if err != nil {
+			log.Errorf("Error detecting duplicate customers <- %v", err)
+		} else if suggested > 0 {
+			log.Infof("Suggested %d duplicate customers", suggested)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
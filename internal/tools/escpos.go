@@ -0,0 +1,274 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Francesco99975/rosskery/internal/helpers"
+	"github.com/Francesco99975/rosskery/internal/models"
+)
+
+const (
+	esc = 0x1B
+	gs  = 0x1D
+)
+
+type EscPosAlign byte
+
+const (
+	LEFT   EscPosAlign = 0
+	CENTER EscPosAlign = 1
+	RIGHT  EscPosAlign = 2
+)
+
+// EscPos builds the raw byte stream understood by ESC/POS thermal printers
+type EscPos struct {
+	buf     bytes.Buffer
+	columns int
+}
+
+func NewEscPos(columns int) *EscPos {
+	p := &EscPos{columns: columns}
+	p.buf.Write([]byte{esc, '@'})
+
+	return p
+}
+
+func (p *EscPos) Align(align EscPosAlign) *EscPos {
+	p.buf.Write([]byte{esc, 'a', byte(align)})
+	return p
+}
+
+func (p *EscPos) Bold(on bool) *EscPos {
+	p.buf.Write([]byte{esc, 'E', flag(on)})
+	return p
+}
+
+// Large doubles the width and height of the following text
+func (p *EscPos) Large(on bool) *EscPos {
+	size := byte(0x00)
+	if on {
+		size = 0x11
+	}
+	p.buf.Write([]byte{gs, '!', size})
+	return p
+}
+
+func (p *EscPos) Line(text string) *EscPos {
+	p.buf.WriteString(printable(text))
+	p.buf.WriteByte('\n')
+	return p
+}
+
+// Row prints left and right on the same line, wrapping left when it does not fit
+func (p *EscPos) Row(left string, right string) *EscPos {
+	left, right = printable(left), printable(right)
+	width := p.columns - len(right) - 1
+
+	for width > 0 && len(left) > width {
+		p.Line(left[:width])
+		left = left[width:]
+	}
+
+	return p.Line(left + strings.Repeat(" ", max(p.columns-len(left)-len(right), 1)) + right)
+}
+
+func (p *EscPos) Rule() *EscPos {
+	return p.Line(strings.Repeat("-", p.columns))
+}
+
+func (p *EscPos) Feed(lines int) *EscPos {
+	p.buf.Write([]byte{esc, 'd', byte(lines)})
+	return p
+}
+
+// QR prints data as a model 2 QR code using the printer's own encoder
+func (p *EscPos) QR(data string) *EscPos {
+	length := len(data) + 3
+
+	p.buf.Write([]byte{gs, '(', 'k', 4, 0, '1', 'A', '2', 0})
+	p.buf.Write([]byte{gs, '(', 'k', 3, 0, '1', 'C', 6})
+	p.buf.Write([]byte{gs, '(', 'k', 3, 0, '1', 'E', '1'})
+	p.buf.Write([]byte{gs, '(', 'k', byte(length % 256), byte(length / 256), '1', 'P', '0'})
+	p.buf.WriteString(data)
+	p.buf.Write([]byte{gs, '(', 'k', 3, 0, '1', 'Q', '0'})
+
+	return p
+}
+
+// Cut feeds the paper past the cutter and performs a partial cut
+func (p *EscPos) Cut() *EscPos {
+	p.buf.Write([]byte{gs, 'V', 66, 0})
+	return p
+}
+
+func (p *EscPos) Bytes() []byte {
+	return p.buf.Bytes()
+}
+
+// RenderReceipt prints the customer copy of an order
+func RenderReceipt(order *models.Order) []byte {
+	loc := printLocation()
+	p := NewEscPos(printerColumns())
+
+	p.Align(CENTER).Large(true).Bold(true).Line("Rosskery").Large(false).Bold(false)
+	p.Line("robarra@rosskery.com").Feed(1)
+
+	p.Align(LEFT)
+	p.Row("Order", OrderNumber(order.Id))
+	p.Row("Date", order.Created.In(loc).Format("2006-01-02 03:04 PM"))
+	if order.IsDelivery() {
+		p.Row("Delivery", order.DeliveryWindow())
+	} else {
+		p.Row("Pickup", order.Pickuptime.In(loc).Format("2006-01-02 03:04 PM"))
+	}
+	p.Row("Customer", order.Customer.Fullname)
+	if order.IsDelivery() {
+		p.Line(order.DeliveryAddress())
+	}
+	p.Rule()
+
+	total := 0
+	for _, purchase := range order.Purchases {
+		amount := purchase.Product.Price * purchase.Quantity
+		if purchase.Product.Weighed {
+			amount /= 10
+		}
+		total += amount
+
+		p.Row(fmt.Sprintf("%s %s", purchaseQuantity(purchase), purchase.Product.Name), cents(amount))
+	}
+
+	if order.DeliveryFee > 0 {
+		p.Row("Delivery", cents(order.DeliveryFee))
+		total += order.DeliveryFee
+	}
+
+	if order.Tip > 0 {
+		p.Row("Tip", cents(order.Tip))
+		total += order.Tip
+	}
+
+	if order.Discount > 0 {
+		p.Row("Discount", "-"+cents(order.Discount))
+		total -= order.Discount
+	}
+
+	if order.GiftCard > 0 {
+		p.Row("Gift card", "-"+cents(order.GiftCard))
+		total -= order.GiftCard
+	}
+
+	p.Rule()
+	p.Bold(true).Row("TOTAL", cents(total)).Bold(false)
+	p.Row("Payment", strings.ToUpper(order.Method))
+
+	if models.ParsePaymentMethod(order.Method) == models.CASH && !order.Fulfilled {
+		if order.IsDelivery() {
+			p.Row("Due on delivery", cents(total))
+		} else {
+			p.Row("Due at pickup", cents(total))
+		}
+	}
+
+	if order.HasLoyalty() {
+		if balance, err := models.GetLoyaltyBalance(order.Customer.Email); err == nil {
+			p.Row("Points balance", fmt.Sprint(balance))
+		}
+	}
+
+	p.Feed(1).Align(CENTER).QR(helpers.SignPickupCode(order.Id)).Feed(1)
+	p.Line("Thank you!").Feed(3).Cut()
+
+	return p.Bytes()
+}
+
+// RenderKitchenTicket prints what has to be prepared for an order, large enough to read at a glance
+func RenderKitchenTicket(order *models.Order) []byte {
+	loc := printLocation()
+	p := NewEscPos(printerColumns())
+
+	p.Align(CENTER).Bold(true).Line("KITCHEN").Large(true).Line("#" + OrderNumber(order.Id))
+	p.Line(order.Pickuptime.In(loc).Format("Mon 03:04 PM")).Large(false).Bold(false)
+	p.Line(fmt.Sprintf("%s - %s", order.Customer.Fullname, order.Channel))
+	if order.IsDelivery() {
+		p.Bold(true).Line("DELIVERY").Bold(false)
+	}
+
+	p.Align(LEFT).Rule()
+	for _, purchase := range order.Purchases {
+		p.Bold(true).Line(fmt.Sprintf("%s  %s", purchaseQuantity(purchase), purchase.Product.Name)).Bold(false)
+	}
+	p.Rule()
+
+	if order.Notes != "" {
+		p.Bold(true).Line("NOTES:").Bold(false).Line(order.Notes).Rule()
+	}
+
+	p.Feed(1).Align(CENTER).QR(helpers.SignPickupCode(order.Id)).Feed(3).Cut()
+
+	return p.Bytes()
+}
+
+// OrderNumber is the short reference printed and read out at the counter
+func OrderNumber(id string) string {
+	number := strings.ToUpper(strings.ReplaceAll(id, "-", ""))
+
+	return number[:min(8, len(number))]
+}
+
+func purchaseQuantity(purchase models.Purchase) string {
+	if purchase.Product.Weighed {
+		return fmt.Sprintf("%.1f lbs", float64(purchase.Quantity)/10.0)
+	}
+
+	return fmt.Sprintf("%dx", purchase.Quantity)
+}
+
+// printable replaces what the printer's default code page cannot render
+func printable(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToValidUTF8(text, "?") {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+func flag(on bool) byte {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// printerColumns reads PRINTER_COLUMNS, the characters per line of the paper roll (48 on 80mm, 32 on 58mm)
+func printerColumns() int {
+	columns, err := strconv.Atoi(os.Getenv("PRINTER_COLUMNS"))
+	if err != nil || columns < 24 {
+		return 48
+	}
+
+	return columns
+}
+
+func printLocation() *time.Location {
+	loc, err := ExportLocation("")
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
@@ -0,0 +1,234 @@
+package tools
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/xuri/excelize/v2"
+)
+
+type ExportFormat string
+
+const (
+	CSV  ExportFormat = "csv"
+	XLSX ExportFormat = "xlsx"
+	JSON ExportFormat = "json"
+)
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch format {
+	case "", "csv":
+		return CSV, nil
+	case "xlsx":
+		return XLSX, nil
+	case "json":
+		return JSON, nil
+	default:
+		return "", fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case JSON:
+		return "application/json"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// ExportWriter encodes export records as they arrive
+type ExportWriter interface {
+	WriteHeader(columns []models.ExportColumn) error
+	WriteRecord(record []string) error
+	Close() error
+}
+
+func NewExportWriter(format ExportFormat, w io.Writer) (ExportWriter, error) {
+	switch format {
+	case CSV:
+		return &csvExportWriter{w: csv.NewWriter(w)}, nil
+	case XLSX:
+		file := excelize.NewFile()
+		stream, err := file.NewStreamWriter("Sheet1")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxExportWriter{out: w, file: file, stream: stream}, nil
+	case JSON:
+		return &jsonExportWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+// flushEvery bounds how many rows are buffered before being pushed to the client
+const flushEvery = 500
+
+type csvExportWriter struct {
+	w     *csv.Writer
+	count int
+}
+
+func (cw *csvExportWriter) WriteHeader(columns []models.ExportColumn) error {
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+
+	return cw.w.Write(header)
+}
+
+func (cw *csvExportWriter) WriteRecord(record []string) error {
+	if err := cw.w.Write(record); err != nil {
+		return err
+	}
+
+	cw.count++
+	if cw.count%flushEvery == 0 {
+		cw.w.Flush()
+		return cw.w.Error()
+	}
+
+	return nil
+}
+
+func (cw *csvExportWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonExportWriter struct {
+	w       io.Writer
+	columns []models.ExportColumn
+	count   int
+}
+
+func (jw *jsonExportWriter) WriteHeader(columns []models.ExportColumn) error {
+	jw.columns = columns
+	_, err := io.WriteString(jw.w, "[")
+	return err
+}
+
+func (jw *jsonExportWriter) WriteRecord(record []string) error {
+	object := make(map[string]interface{}, len(record))
+	for i, value := range record {
+		object[jw.columns[i].Name] = typedValue(jw.columns[i].Kind, value)
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	if jw.count > 0 {
+		if _, err := io.WriteString(jw.w, ","); err != nil {
+			return err
+		}
+	}
+	jw.count++
+
+	_, err = jw.w.Write(data)
+	return err
+}
+
+func (jw *jsonExportWriter) Close() error {
+	_, err := io.WriteString(jw.w, "]")
+	return err
+}
+
+// xlsxExportWriter rows go through the excelize stream writer, which spills to disk
+// instead of building the sheet in memory. The workbook is written out on Close.
+type xlsxExportWriter struct {
+	out     io.Writer
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	columns []models.ExportColumn
+	row     int
+}
+
+func (xw *xlsxExportWriter) WriteHeader(columns []models.ExportColumn) error {
+	xw.columns = columns
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+
+	return xw.writeRow(header)
+}
+
+func (xw *xlsxExportWriter) WriteRecord(record []string) error {
+	row := make([]interface{}, len(record))
+	for i, value := range record {
+		row[i] = typedValue(xw.columns[i].Kind, value)
+	}
+
+	return xw.writeRow(row)
+}
+
+func (xw *xlsxExportWriter) writeRow(values []interface{}) error {
+	xw.row++
+
+	cell, err := excelize.CoordinatesToCellName(1, xw.row)
+	if err != nil {
+		return err
+	}
+
+	return xw.stream.SetRow(cell, values)
+}
+
+func (xw *xlsxExportWriter) Close() error {
+	defer xw.file.Close()
+
+	if err := xw.stream.Flush(); err != nil {
+		return err
+	}
+
+	_, err := xw.file.WriteTo(xw.out)
+	return err
+}
+
+func typedValue(kind models.ExportKind, value string) interface{} {
+	if value == "" {
+		return nil
+	}
+
+	switch kind {
+	case models.ExportMoney, models.ExportNumber:
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	case models.ExportBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+
+	return value
+}
+
+// WriteExport streams a whole dataset for [from, to) into w using the given format
+func WriteExport(w io.Writer, format ExportFormat, dataset models.ExportDataset, columns []models.ExportColumn, from time.Time, to time.Time, loc *time.Location) error {
+	writer, err := NewExportWriter(format, w)
+	if err != nil {
+		return err
+	}
+
+	if err := writer.WriteHeader(columns); err != nil {
+		return err
+	}
+
+	if err := models.StreamExport(dataset, columns, from, to, loc, writer.WriteRecord); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/johnfercher/maroto/v2"
+	"github.com/johnfercher/maroto/v2/pkg/components/code"
+	"github.com/johnfercher/maroto/v2/pkg/components/text"
+	"github.com/johnfercher/maroto/v2/pkg/config"
+	"github.com/johnfercher/maroto/v2/pkg/consts/align"
+	"github.com/johnfercher/maroto/v2/pkg/consts/fontstyle"
+	"github.com/johnfercher/maroto/v2/pkg/props"
+)
+
+// GenerateGiftCard renders the printable card sent to the recipient, the QR code holding the card code
+func GenerateGiftCard(card *models.GiftCard, from string) ([]byte, error) {
+	cfg := config.NewBuilder().Build()
+
+	m := maroto.New(cfg)
+
+	err := m.RegisterHeader(getPageHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	title := "Rosskery Gift Card"
+	if card.Kind == models.STORE_CREDIT {
+		title = "Rosskery Store Credit"
+	}
+
+	m.AddRows(text.NewRow(14, title, props.Text{
+		Top:   4,
+		Size:  16,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+	}))
+
+	if from != "" {
+		m.AddRows(text.NewRow(8, fmt.Sprintf("A gift from %s", from), props.Text{
+			Top:   1,
+			Style: fontstyle.Italic,
+			Align: align.Center,
+		}))
+	}
+
+	m.AddRows(text.NewRow(20, cents(card.Initial), props.Text{
+		Top:   4,
+		Size:  28,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+		Color: getRedColor(),
+	}))
+
+	m.AddRow(10,
+		text.NewCol(12, card.Code, props.Text{
+			Top:   2,
+			Size:  14,
+			Style: fontstyle.Bold,
+			Align: align.Center,
+			Color: &props.WhiteColor,
+		}),
+	).WithStyle(&props.Cell{BackgroundColor: getDarkGrayColor()})
+
+	m.AddRow(50,
+		code.NewQrCol(12, card.Code, props.Rect{
+			Top:     5,
+			Center:  true,
+			Percent: 75,
+		}),
+	)
+
+	m.AddRows(text.NewRow(8, "Enter the code at checkout on rosskery.com. Any balance left stays on the card.", props.Text{
+		Top:   2,
+		Size:  8,
+		Style: fontstyle.Italic,
+		Align: align.Center,
+	}))
+
+	document, err := m.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	return document.GetBytes(), nil
+}
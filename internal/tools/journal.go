@@ -0,0 +1,129 @@
+package tools
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+)
+
+type JournalFormat string
+
+const (
+	IIF  JournalFormat = "iif"
+	XERO JournalFormat = "xero"
+)
+
+func ParseJournalFormat(format string) (JournalFormat, error) {
+	switch format {
+	case "iif", "quickbooks":
+		return IIF, nil
+	case "xero":
+		return XERO, nil
+	default:
+		return "", fmt.Errorf("unknown journal format: %s", format)
+	}
+}
+
+func (f JournalFormat) ContentType() string {
+	if f == IIF {
+		return "text/plain; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+func (f JournalFormat) Extension() string {
+	if f == IIF {
+		return "iif"
+	}
+	return "csv"
+}
+
+func WriteJournal(w io.Writer, format JournalFormat, entries []models.JournalEntry) error {
+	switch format {
+	case IIF:
+		return writeIIF(w, entries)
+	case XERO:
+		return writeXeroCSV(w, entries)
+	default:
+		return fmt.Errorf("unknown journal format: %s", format)
+	}
+}
+
+// writeIIF emits QuickBooks Desktop general journal transactions. Debits are positive, credits negative.
+func writeIIF(w io.Writer, entries []models.JournalEntry) error {
+	header := "!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tAMOUNT\tMEMO\r\n" +
+		"!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tAMOUNT\tMEMO\r\n" +
+		"!ENDTRNS\r\n"
+
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		day, err := time.Parse("2006-01-02", entry.Day)
+		if err != nil {
+			return err
+		}
+
+		for i, line := range entry.Lines {
+			kind := "SPL"
+			if i == 0 {
+				kind = "TRNS"
+			}
+
+			row := strings.Join([]string{kind, "", "GENERAL JOURNAL", day.Format("01/02/2006"), iifField(line.Account.Name), formatAmount(line.Debit - line.Credit), iifField(entry.Memo + " - " + line.Memo)}, "\t")
+			if _, err := io.WriteString(w, row+"\r\n"); err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(w, "ENDTRNS\r\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func iifField(value string) string {
+	return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(value)
+}
+
+// writeXeroCSV emits rows for the Xero manual journal import. Debits are positive, credits negative.
+func writeXeroCSV(w io.Writer, entries []models.JournalEntry) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"*Narration", "*Date", "Description", "*AccountCode", "*TaxRate", "*Amount", "TrackingName1", "TrackingOption1", "TrackingName2", "TrackingOption2"}); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		day, err := time.Parse("2006-01-02", entry.Day)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range entry.Lines {
+			if err := writer.Write([]string{entry.Memo, day.Format("02/01/2006"), line.Memo, line.Account.Code, "Tax Exempt", formatAmount(line.Debit - line.Credit), "", "", "", ""}); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,36 @@
+package tools
+
+import (
+	"context"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/gommon/log"
+)
+
+// ScheduleLoyaltyExpiry takes off the loyalty points older than LOYALTY_EXPIRY_DAYS every hour.
+// Expiring twice takes nothing more, so restarts do no harm.
+func ScheduleLoyaltyExpiry(ctx context.Context) {
+	if models.LoyaltyExpiry() == 0 {
+		log.Info("Loyalty points never expire, expiry disabled")
+		return
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		expired, err := models.ExpireLoyaltyPoints(time.Now())
+		if err != nil {
+			log.Errorf("Error expiring loyalty points <- %v", err)
+		} else if expired > 0 {
+			log.Infof("Expired %d loyalty points", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
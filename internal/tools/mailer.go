@@ -2,10 +2,12 @@ package tools
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 
+	"github.com/Francesco99975/rosskery/internal/models"
 	"github.com/labstack/gommon/log"
 	"github.com/mattevans/postmark-go"
 )
@@ -24,6 +26,8 @@ type Receipt struct {
 	OrderID                 string          `json:"order_id"`
 	Date                    string          `json:"date"`
 	PickupDate              string          `json:"pickup_date"`
+	DeliveryAddress         string          `json:"delivery_address"` // Empty for pickups
+	DeliveryWindow          string          `json:"delivery_window"`
 	ReceiptDetails          []ReceiptDetail `json:"receipt_details"`
 	Total                   string          `json:"total"`
 	SupportURL              string          `json:"support_url"`
@@ -87,5 +91,90 @@ func SendReceipt(customerEmail string, receipt Receipt, attachment string) error
 		return err
 	}
 
+	if err := models.LogEmail(customerEmail, "Your Rosskery receipt", "receipt"); err != nil {
+		log.Errorf("Error logging receipt email <- %v", err)
+	}
+
+	return nil
+}
+
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+func SendReport(recipient string, subject string, body string, attachments []MailAttachment) error {
+	client := postmark.NewClient(
+		postmark.WithClient(&http.Client{
+			Transport: &postmark.AuthTransport{Token: os.Getenv("POSTMARK_API_TOKEN")},
+		}),
+	)
+
+	emailAttachments := make([]postmark.EmailAttachment, len(attachments))
+	for i, attachment := range attachments {
+		contentType := attachment.ContentType
+		emailAttachments[i] = postmark.EmailAttachment{
+			Name:        attachment.Name,
+			ContentType: &contentType,
+			Content:     attachment.Content,
+		}
+	}
+
+	emailReq := &postmark.Email{
+		From:        os.Getenv("POSTMARK_SENDER"),
+		To:          recipient,
+		Subject:     subject,
+		TextBody:    body,
+		Tag:         "report",
+		Attachments: emailAttachments,
+	}
+
+	_, _, err := client.Email.Send(emailReq)
+	if err != nil {
+		return err
+	}
+
+	if err := models.LogEmail(recipient, subject, "report"); err != nil {
+		log.Errorf("Error logging email <- %v", err)
+	}
+
 	return nil
 }
+
+// SendGiftCard emails a gift card or store credit to its recipient with the printable card attached
+func SendGiftCard(card *models.GiftCard, from string) error {
+	document, err := GenerateGiftCard(card, from)
+	if err != nil {
+		return err
+	}
+
+	var subject, body string
+	switch card.Kind {
+	case models.STORE_CREDIT:
+		subject = "Your Rosskery store credit"
+		body = fmt.Sprintf("Hello,\n\n%s of store credit has been added for you at Rosskery.\n\nCode: %s\n\nEnter the code at checkout to use it, any balance left stays on it.\n\nRosskery", cents(card.Initial), card.Code)
+	default:
+		subject = "You received a Rosskery gift card"
+		if from != "" {
+			subject = fmt.Sprintf("%s sent you a Rosskery gift card", from)
+		}
+		body = fmt.Sprintf("Hello,\n\nYou received a Rosskery gift card of %s.\n\nCode: %s\n\nEnter the code at checkout to use it, any balance left stays on the card. The printable card is attached.\n\nRosskery", cents(card.Initial), card.Code)
+	}
+
+	return SendReport(card.Recipient, subject, body, []MailAttachment{{Name: "giftcard.pdf", ContentType: "application/pdf", Content: document}})
+}
+
+// SendAccountVerification emails the link confirming a storefront account owns its email
+func SendAccountVerification(recipient string, link string) error {
+	body := fmt.Sprintf("Hello,\n\nConfirm your email to finish setting up your Rosskery account:\n\n%s\n\nThe link expires in 48 hours. Once confirmed, the orders you placed with this email show up in your account.\n\nIf you did not sign up, ignore this email.\n\nRosskery", link)
+
+	return SendReport(recipient, "Confirm your Rosskery account", body, nil)
+}
+
+// SendMagicLink emails a single use link signing the customer in without a password
+func SendMagicLink(recipient string, link string) error {
+	body := fmt.Sprintf("Hello,\n\nFollow this link to sign in to Rosskery:\n\n%s\n\nThe link works once and expires in 15 minutes.\n\nIf you did not ask to sign in, ignore this email.\n\nRosskery", link)
+
+	return SendReport(recipient, "Your Rosskery sign in link", body, nil)
+}
@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/johnfercher/maroto/v2"
+	"github.com/johnfercher/maroto/v2/pkg/components/code"
+	"github.com/johnfercher/maroto/v2/pkg/components/col"
+	"github.com/johnfercher/maroto/v2/pkg/components/row"
+	"github.com/johnfercher/maroto/v2/pkg/components/text"
+	"github.com/johnfercher/maroto/v2/pkg/config"
+	"github.com/johnfercher/maroto/v2/pkg/consts/align"
+	"github.com/johnfercher/maroto/v2/pkg/consts/fontstyle"
+	"github.com/johnfercher/maroto/v2/pkg/core"
+	"github.com/johnfercher/maroto/v2/pkg/props"
+)
+
+// GenerateDriverManifest renders the delivery round as a printable PDF for the driver, with a QR code
+// opening each leg of the directions in Google Maps
+func GenerateDriverManifest(route *models.DeliveryRoute) ([]byte, error) {
+	cfg := config.NewBuilder().Build()
+
+	m := maroto.New(cfg)
+
+	err := m.RegisterHeader(getPageHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("Delivery Route %s", route.Date)
+	if route.Window != "" {
+		title = fmt.Sprintf("%s from %s", title, route.Window)
+	}
+
+	m.AddRows(text.NewRow(10, title, props.Text{
+		Top:   3,
+		Style: fontstyle.Bold,
+		Align: align.Center,
+	}))
+
+	m.AddRows(text.NewRow(8, fmt.Sprintf("%d stops - %.1f km - leave %s - done by %s", len(route.Stops), route.Distance, route.Departure.Format("03:04 PM"), route.Finish.Format("03:04 PM")), props.Text{
+		Top:   1,
+		Size:  9,
+		Style: fontstyle.Italic,
+		Align: align.Center,
+	}))
+
+	m.AddRow(7,
+		text.NewCol(12, "Stops", props.Text{
+			Top:   1.5,
+			Size:  9,
+			Style: fontstyle.Bold,
+			Align: align.Center,
+			Color: &props.WhiteColor,
+		}),
+	).WithStyle(&props.Cell{BackgroundColor: getDarkGrayColor()})
+
+	m.AddRows(getManifestStops(route.Stops)...)
+
+	if len(route.Unrouted) > 0 {
+		m.AddRows(text.NewRow(10, "Not located, place these stops by hand", props.Text{
+			Top:   3,
+			Style: fontstyle.Bold,
+			Align: align.Center,
+			Color: getRedColor(),
+		}))
+
+		m.AddRows(getManifestStops(route.Unrouted)...)
+	}
+
+	for i, url := range route.MapsURLs {
+		m.AddRow(40,
+			code.NewQrCol(4, url, props.Rect{
+				Center:  true,
+				Percent: 90,
+			}),
+			text.NewCol(8, fmt.Sprintf("Directions, leg %d of %d", i+1, len(route.MapsURLs)), props.Text{
+				Top:   18,
+				Size:  9,
+				Style: fontstyle.Bold,
+			}),
+		)
+	}
+
+	document, err := m.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	return document.GetBytes(), nil
+}
+
+func getManifestStops(stops []models.RouteStop) []core.Row {
+	header := props.Text{Size: 9, Align: align.Center, Style: fontstyle.Bold}
+
+	rows := []core.Row{
+		row.New(5).Add(
+			text.NewCol(1, "#", header),
+			text.NewCol(1, "ETA", header),
+			text.NewCol(3, "Customer", header),
+			text.NewCol(5, "Address", header),
+			text.NewCol(2, "Collect", header),
+		),
+	}
+
+	for i, stop := range stops {
+		sequence := "-"
+		eta := "-"
+		if stop.Sequence > 0 {
+			sequence = fmt.Sprint(stop.Sequence)
+			eta = stop.Eta.Format("03:04 PM")
+		}
+
+		collect := "Paid"
+		if stop.Due > 0 {
+			collect = cents(stop.Due)
+		}
+
+		content := props.Text{Size: 8, Align: align.Center}
+		if stop.Late {
+			content.Color = getRedColor()
+			eta = "! " + eta
+		}
+
+		r := row.New(8).Add(
+			text.NewCol(1, sequence, content),
+			text.NewCol(1, eta, content),
+			col.New(3).Add(
+				text.New(stop.Customer, content),
+				text.New(stop.Phone, props.Text{Top: 4, Size: 7, Align: align.Center}),
+			),
+			text.NewCol(5, stop.Address, content),
+			text.NewCol(2, collect, content),
+		)
+		if i%2 == 0 {
+			r.WithStyle(&props.Cell{BackgroundColor: getGrayColor()})
+		}
+
+		rows = append(rows, r)
+
+		if stop.Notes != "" {
+			rows = append(rows, row.New(4).Add(
+				col.New(2),
+				text.NewCol(10, stop.Notes, props.Text{Size: 7, Style: fontstyle.Italic}),
+			))
+		}
+	}
+
+	return rows
+}
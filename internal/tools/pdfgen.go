@@ -37,11 +37,25 @@ func GenerateInvoice(order *models.Order) (string, error) {
 		Align: align.Center,
 	}))
 
-	m.AddRows(text.NewRow(10, fmt.Sprintf("Pickup Date and Time %s", order.Pickuptime.Format("2006-01-02 03:04 PM")), props.Text{
-		Top:   1,
-		Style: fontstyle.Italic,
-		Align: align.Center,
-	}))
+	if order.IsDelivery() {
+		m.AddRows(text.NewRow(10, fmt.Sprintf("Delivery Window %s", order.DeliveryWindow()), props.Text{
+			Top:   1,
+			Style: fontstyle.Italic,
+			Align: align.Center,
+		}))
+
+		m.AddRows(text.NewRow(10, fmt.Sprintf("Deliver to %s", order.DeliveryAddress()), props.Text{
+			Top:   1,
+			Style: fontstyle.Italic,
+			Align: align.Center,
+		}))
+	} else {
+		m.AddRows(text.NewRow(10, fmt.Sprintf("Pickup Date and Time %s", order.Pickuptime.Format("2006-01-02 03:04 PM")), props.Text{
+			Top:   1,
+			Style: fontstyle.Italic,
+			Align: align.Center,
+		}))
+	}
 
 	m.AddRow(7,
 		text.NewCol(3, "Transactions", props.Text{
@@ -53,10 +67,10 @@ func GenerateInvoice(order *models.Order) (string, error) {
 		}),
 	).WithStyle(&props.Cell{BackgroundColor: getDarkGrayColor()})
 
-	m.AddRows(getTransactions(order.Purchases)...)
+	m.AddRows(getTransactions(order.Purchases, order.DeliveryFee, order.Tip, order.GiftCard, order.Discount)...)
 
 	m.AddRow(40,
-		code.NewQrCol(6, order.Id, props.Rect{
+		code.NewQrCol(6, helpers.SignPickupCode(order.Id), props.Rect{
 			Center:  true,
 			Percent: 75,
 		}),
@@ -115,7 +129,7 @@ func getPageHeader() core.Row {
 	)
 }
 
-func getTransactions(purchases []models.Purchase) []core.Row {
+func getTransactions(purchases []models.Purchase, delivery int, tip int, giftCard int, discount int) []core.Row {
 	rows := []core.Row{
 		row.New(5).Add(
 			col.New(3),
@@ -154,6 +168,42 @@ func getTransactions(purchases []models.Purchase) []core.Row {
 
 	rows = append(rows, contentsRow...)
 
+	if delivery > 0 {
+		rows = append(rows, row.New(4).Add(
+			col.New(3),
+			text.NewCol(4, "Delivery", props.Text{Size: 8, Align: align.Center, Style: fontstyle.Italic}),
+			col.New(2),
+			text.NewCol(3, helpers.FormatPrice(float64(delivery)/100), props.Text{Size: 8, Align: align.Center}),
+		))
+	}
+
+	if tip > 0 {
+		rows = append(rows, row.New(4).Add(
+			col.New(3),
+			text.NewCol(4, "Tip", props.Text{Size: 8, Align: align.Center, Style: fontstyle.Italic}),
+			col.New(2),
+			text.NewCol(3, helpers.FormatPrice(float64(tip)/100), props.Text{Size: 8, Align: align.Center}),
+		))
+	}
+
+	if discount > 0 {
+		rows = append(rows, row.New(4).Add(
+			col.New(3),
+			text.NewCol(4, "Discount", props.Text{Size: 8, Align: align.Center, Style: fontstyle.Italic}),
+			col.New(2),
+			text.NewCol(3, "-"+helpers.FormatPrice(float64(discount)/100), props.Text{Size: 8, Align: align.Center}),
+		))
+	}
+
+	if giftCard > 0 {
+		rows = append(rows, row.New(4).Add(
+			col.New(3),
+			text.NewCol(4, "Gift card", props.Text{Size: 8, Align: align.Center, Style: fontstyle.Italic}),
+			col.New(2),
+			text.NewCol(3, "-"+helpers.FormatPrice(float64(giftCard)/100), props.Text{Size: 8, Align: align.Center}),
+		))
+	}
+
 	rows = append(rows, row.New(20).Add(
 		col.New(7),
 		text.NewCol(2, "Total:", props.Text{
@@ -168,7 +218,7 @@ func getTransactions(purchases []models.Purchase) []core.Row {
 			} else {
 				return prev.Product.Price*prev.Quantity + cur
 			}
-		}, 0))/100.0), props.Text{
+		}, delivery+tip-giftCard-discount))/100.0), props.Text{
 			Top:   5,
 			Style: fontstyle.Bold,
 			Size:  8,
@@ -0,0 +1,300 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/labstack/gommon/log"
+)
+
+type TicketKind string
+
+const (
+	RECEIPT_TICKET TicketKind = "receipt"
+	KITCHEN_TICKET TicketKind = "kitchen"
+)
+
+func ParseTicketKinds(kind string) ([]TicketKind, error) {
+	switch kind {
+	case "", "both":
+		return []TicketKind{RECEIPT_TICKET, KITCHEN_TICKET}, nil
+	case "receipt":
+		return []TicketKind{RECEIPT_TICKET}, nil
+	case "kitchen":
+		return []TicketKind{KITCHEN_TICKET}, nil
+	default:
+		return nil, fmt.Errorf("unknown ticket kind: %s", kind)
+	}
+}
+
+type PrintStatus string
+
+const (
+	PRINT_PENDING PrintStatus = "pending"
+	PRINT_DONE    PrintStatus = "printed"
+	PRINT_FAILED  PrintStatus = "failed"
+)
+
+type PrintJob struct {
+	Id          uint        `json:"id"`
+	Kind        TicketKind  `json:"kind"`
+	OrderId     string      `json:"order_id"`
+	Status      PrintStatus `json:"status"`
+	Attempts    int         `json:"attempts"`
+	Error       string      `json:"error"`
+	Created     time.Time   `json:"created"`
+	NextAttempt time.Time   `json:"next_attempt"`
+	Printed     *time.Time  `json:"printed"`
+	data        []byte
+}
+
+type PrinterStatus struct {
+	Configured  bool       `json:"configured"`
+	Address     string     `json:"address"`
+	Online      bool       `json:"online"`
+	LastContact *time.Time `json:"last_contact"`
+	LastError   string     `json:"last_error"`
+	Pending     int        `json:"pending"`
+	Failed      int        `json:"failed"`
+	Jobs        []PrintJob `json:"jobs"`
+}
+
+// In-memory queue of tickets waiting for the counter printer
+type PrintQueue struct {
+	mu          sync.Mutex
+	jobs        []PrintJob
+	lastId      uint
+	online      bool
+	lastContact *time.Time
+	lastError   string
+}
+
+const keptPrintJobs = 50
+
+// PrinterAddress reads PRINTER_ADDR, the network printer taking raw jobs on port 9100 unless another is given
+func PrinterAddress() string {
+	addr := os.Getenv("PRINTER_ADDR")
+	if addr == "" {
+		return ""
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, "9100")
+	}
+
+	return addr
+}
+
+// Enqueue adds a ticket to the queue, it is sent by ProcessQueue
+func (q *PrintQueue) Enqueue(kind TicketKind, orderId string, data []byte) (PrintJob, error) {
+	if PrinterAddress() == "" {
+		return PrintJob{}, fmt.Errorf("no printer configured")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.lastId++
+	now := time.Now()
+	job := PrintJob{Id: q.lastId, Kind: kind, OrderId: orderId, Status: PRINT_PENDING, Created: now, NextAttempt: now, data: data}
+	q.jobs = append(q.jobs, job)
+
+	return job, nil
+}
+
+// PrintOrder renders the requested tickets of an order and queues them
+func (q *PrintQueue) PrintOrder(order *models.Order, kinds ...TicketKind) ([]PrintJob, error) {
+	jobs := make([]PrintJob, 0, len(kinds))
+
+	for _, kind := range kinds {
+		var data []byte
+		switch kind {
+		case RECEIPT_TICKET:
+			data = RenderReceipt(order)
+		case KITCHEN_TICKET:
+			data = RenderKitchenTicket(order)
+		default:
+			return jobs, fmt.Errorf("unknown ticket kind: %s", kind)
+		}
+
+		job, err := q.Enqueue(kind, order.Id, data)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Retry puts a failed job back in the queue with a fresh set of attempts
+func (q *PrintQueue) Retry(id uint) (PrintJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.jobs {
+		if job.Id != id {
+			continue
+		}
+
+		if job.Status != PRINT_FAILED {
+			return job, fmt.Errorf("print job %d is %s", id, job.Status)
+		}
+
+		q.jobs[i].Status = PRINT_PENDING
+		q.jobs[i].Attempts = 0
+		q.jobs[i].Error = ""
+		q.jobs[i].NextAttempt = time.Now()
+
+		return q.jobs[i], nil
+	}
+
+	return PrintJob{}, fmt.Errorf("print job %d not found", id)
+}
+
+func (q *PrintQueue) Status() PrinterStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	addr := PrinterAddress()
+	status := PrinterStatus{Configured: addr != "", Address: addr, Online: q.online, LastContact: q.lastContact, LastError: q.lastError, Jobs: make([]PrintJob, 0, len(q.jobs))}
+
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		switch q.jobs[i].Status {
+		case PRINT_PENDING:
+			status.Pending++
+		case PRINT_FAILED:
+			status.Failed++
+		}
+		status.Jobs = append(status.Jobs, q.jobs[i])
+	}
+
+	return status
+}
+
+// Get the jobs due for an attempt
+func (q *PrintQueue) getDueJobs() []PrintJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var due []PrintJob
+	for _, job := range q.jobs {
+		if job.Status == PRINT_PENDING && !job.NextAttempt.After(now) {
+			due = append(due, job)
+		}
+	}
+
+	return due
+}
+
+// Record the outcome of an attempt, backing off before the next retry
+func (q *PrintQueue) markAttempt(id uint, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.online = err == nil
+
+	for i, job := range q.jobs {
+		if job.Id != id {
+			continue
+		}
+
+		q.jobs[i].Attempts++
+
+		if err == nil {
+			q.jobs[i].Status = PRINT_DONE
+			q.jobs[i].Printed = &now
+			q.jobs[i].Error = ""
+			q.jobs[i].data = nil
+			q.lastContact = &now
+			q.lastError = ""
+		} else {
+			q.jobs[i].Error = err.Error()
+			q.lastError = err.Error()
+			if q.jobs[i].Attempts >= printerRetries() {
+				q.jobs[i].Status = PRINT_FAILED
+			} else {
+				q.jobs[i].NextAttempt = now.Add(time.Duration(1<<q.jobs[i].Attempts) * time.Second)
+			}
+		}
+		break
+	}
+
+	q.prune()
+}
+
+// Drop the oldest finished jobs so the queue does not grow forever, pending and failed jobs are kept
+func (q *PrintQueue) prune() {
+	finished := 0
+	for _, job := range q.jobs {
+		if job.Status == PRINT_DONE {
+			finished++
+		}
+	}
+
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if job.Status == PRINT_DONE && finished > keptPrintJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+}
+
+func sendToPrinter(addr string, data []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+
+	_, err = conn.Write(data)
+	return err
+}
+
+// Worker to send queued tickets to the printer
+func (q *PrintQueue) ProcessQueue(ctx context.Context) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, job := range q.getDueJobs() {
+				err := sendToPrinter(PrinterAddress(), job.data)
+				if err != nil {
+					log.Errorf("Failed to print %s ticket for order %s: %v", job.Kind, job.OrderId, err)
+				}
+				q.markAttempt(job.Id, err)
+			}
+		}
+	}
+}
+
+// printerRetries reads PRINTER_RETRIES, the attempts made before a job is marked failed
+func printerRetries() int {
+	retries, err := strconv.Atoi(os.Getenv("PRINTER_RETRIES"))
+	if err != nil || retries < 1 {
+		return 5
+	}
+
+	return retries
+}
+
+var PrinterQueue = &PrintQueue{}
@@ -0,0 +1,38 @@
+package tools
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+)
+
+// WriteReconciliationCSV emits one row per issue, amounts in dollars
+func WriteReconciliationCSV(w io.Writer, report *models.Reconciliation) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"Kind", "Order", "Payment Intent", "Charge", "Refund", "Stripe", "Rosskery", "Difference", "Message"}); err != nil {
+		return err
+	}
+
+	for _, issue := range report.Issues {
+		if err := writer.Write([]string{string(issue.Kind), issue.OrderId, issue.IntentId, issue.ChargeId, issue.RefundId, formatAmount(issue.Expected), formatAmount(issue.Actual), formatAmount(issue.Expected - issue.Actual), issue.Message}); err != nil {
+			return err
+		}
+	}
+
+	summary := [][]string{
+		{""},
+		{"Charges", strconv.Itoa(report.Charges)},
+		{"Orders", strconv.Itoa(report.Orders)},
+		{"Matched", strconv.Itoa(report.Matched)},
+		{"Issues", strconv.Itoa(len(report.Issues))},
+	}
+
+	if err := writer.WriteAll(summary); err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/internal/storage"
+	"github.com/labstack/gommon/log"
+)
+
+// ExportLocation resolves the timezone exports are rendered in, defaulting to EXPORT_TIMEZONE and then UTC
+func ExportLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = os.Getenv("EXPORT_TIMEZONE")
+	}
+
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+// ScheduleMonthlyExports mails the previous month's exports to EXPORT_RECIPIENT once the month is over.
+// A Valkey flag guarantees a single delivery per month even across restarts.
+func ScheduleMonthlyExports(ctx context.Context) {
+	recipient := os.Getenv("EXPORT_RECIPIENT")
+	if recipient == "" {
+		log.Info("No export recipient configured, monthly exports disabled")
+		return
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if err := sendMonthlyExports(ctx, recipient); err != nil {
+			log.Errorf("Error sending monthly exports <- %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendMonthlyExports(ctx context.Context, recipient string) error {
+	loc, err := ExportLocation("")
+	if err != nil {
+		return err
+	}
+
+	format, err := ParseExportFormat(os.Getenv("EXPORT_FORMAT"))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	from := to.AddDate(0, -1, 0)
+	period := from.Format("2006-01")
+
+	key := "exports:" + period
+	claimed, err := storage.Valkey.SetNX(ctx, key, recipient, 62*24*time.Hour).Result()
+	if err != nil {
+		return err
+	}
+
+	if !claimed {
+		return nil
+	}
+
+	attachments := make([]MailAttachment, 0, len(models.ExportDatasets))
+	for _, dataset := range models.ExportDatasets {
+		columns, err := models.GetExportColumns(dataset)
+		if err != nil {
+			storage.Valkey.Del(ctx, key)
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := WriteExport(&buf, format, dataset, columns, from, to, loc); err != nil {
+			storage.Valkey.Del(ctx, key)
+			return fmt.Errorf("error exporting %s: %v", dataset, err)
+		}
+
+		attachments = append(attachments, MailAttachment{Name: fmt.Sprintf("%s-%s.%s", dataset, period, format), ContentType: format.ContentType(), Content: buf.Bytes()})
+	}
+
+	datasets := make([]string, len(models.ExportDatasets))
+	for i, dataset := range models.ExportDatasets {
+		datasets[i] = string(dataset)
+	}
+
+	body := fmt.Sprintf("Attached are the Rosskery exports (%s) for %s, with times in %s.", strings.Join(datasets, ", "), from.Format("January 2006"), loc.String())
+
+	if err := SendReport(recipient, fmt.Sprintf("Rosskery exports %s", period), body, attachments); err != nil {
+		storage.Valkey.Del(ctx, key)
+		return err
+	}
+
+	log.Infof("Sent %s exports to %s", period, recipient)
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/Francesco99975/rosskery/internal/models"
+)
+
+// WriteSegmentsCSV emits one row per customer for marketing lists, amounts in dollars
+func WriteSegmentsCSV(w io.Writer, customers []models.CustomerRFM) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"Customer", "Fullname", "Email", "Phone", "Segment", "First Order", "Last Order", "Recency (days)", "Orders", "Spent", "R", "F", "M"}); err != nil {
+		return err
+	}
+
+	for _, customer := range customers {
+		row := []string{
+			customer.Id,
+			customer.Fullname,
+			customer.Email,
+			customer.Phone,
+			string(customer.Segment),
+			customer.FirstOrder.Format("2006-01-02"),
+			customer.LastOrder.Format("2006-01-02"),
+			strconv.Itoa(customer.Recency),
+			strconv.Itoa(customer.Frequency),
+			formatAmount(customer.Monetary),
+			strconv.Itoa(customer.RScore),
+			strconv.Itoa(customer.FScore),
+			strconv.Itoa(customer.MScore),
+		}
+
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -163,3 +163,634 @@ CREATE TABLE IF NOT EXISTS visits(
   PRIMARY KEY(id)
 );
 
+
+CREATE TABLE IF NOT EXISTS audits(
+  id TEXT NOT NULL UNIQUE,
+  actor TEXT NOT NULL,
+  action VARCHAR(15) NOT NULL,
+  entity VARCHAR(15) NOT NULL,
+  entityid TEXT NOT NULL DEFAULT '',
+  before JSONB,
+  after JSONB,
+  method VARCHAR(7) NOT NULL,
+  path TEXT NOT NULL,
+  status INT NOT NULL,
+  ip TEXT NOT NULL,
+  agent TEXT NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_audits_created ON audits(created);
+CREATE INDEX IF NOT EXISTS idx_audits_entity ON audits(entity, entityid);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+  id TEXT NOT NULL UNIQUE,
+  orderid TEXT,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_events(
+  id TEXT NOT NULL UNIQUE,
+  type TEXT NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE TABLE IF NOT EXISTS refunds(
+  id TEXT NOT NULL UNIQUE,
+  orderid TEXT NOT NULL,
+  amount INT NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_ro
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_created ON refunds(created);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount INT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS fee INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS ledger_accounts(
+  key VARCHAR(15) NOT NULL UNIQUE,
+  code VARCHAR(15) NOT NULL,
+  name VARCHAR(50) NOT NULL,
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(key)
+);
+
+SELECT apply_update_trigger('ledger_accounts');
+
+INSERT INTO ledger_accounts (key, code, name) VALUES ('sales', '4000', 'Sales') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('tax', '2200', 'Sales Tax Payable') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('discounts', '4900', 'Sales Discounts') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('refunds', '4100', 'Sales Refunds') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('fees', '6100', 'Payment Processing Fees') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('cash', '1000', 'Cash on Hand') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('stripe', '1010', 'Stripe Clearing') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('paypal', '1020', 'PayPal Clearing') ON CONFLICT (key)
+DO NOTHING;
+INSERT INTO ledger_accounts (key, code, name) VALUES ('card', '1030', 'Card Terminal Clearing') ON CONFLICT (key)
+DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS closed_periods(
+  day DATE NOT NULL UNIQUE,
+  starts TIMESTAMP NOT NULL,
+  ends TIMESTAMP NOT NULL,
+  closedby TEXT NOT NULL,
+  snapshot JSONB NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(day)
+);
+
+-- Reject changes to sales, refunds and fees recorded in a closed accounting period
+CREATE OR REPLACE FUNCTION guard_closed_period()
+RETURNS TRIGGER AS $$
+DECLARE
+  target RECORD;
+  stamp TIMESTAMP;
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    target := OLD;
+  ELSE
+    target := NEW;
+  END IF;
+
+  IF TG_TABLE_NAME = 'purchases' THEN
+    SELECT created INTO stamp FROM orders WHERE id = target.orderid;
+  ELSE
+    stamp := target.created;
+  END IF;
+
+  IF EXISTS (SELECT 1 FROM closed_periods WHERE stamp >= starts AND stamp < ends) THEN
+    RAISE EXCEPTION 'accounting period of % is closed', stamp::DATE;
+  END IF;
+
+  IF TG_OP = 'DELETE' THEN
+    RETURN OLD;
+  END IF;
+
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- refunded is left out, refunds are booked from their own rows on the day they are issued
+DROP TRIGGER IF EXISTS trigger_guard_closed_orders ON orders;
+CREATE TRIGGER trigger_guard_closed_orders
+BEFORE UPDATE OF method, discount, fee, cancelled, created OR DELETE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION guard_closed_period();
+
+DROP TRIGGER IF EXISTS trigger_guard_closed_purchases ON purchases;
+CREATE TRIGGER trigger_guard_closed_purchases
+BEFORE INSERT OR UPDATE OR DELETE ON purchases
+FOR EACH ROW
+EXECUTE FUNCTION guard_closed_period();
+
+DROP TRIGGER IF EXISTS trigger_guard_closed_refunds ON refunds;
+CREATE TRIGGER trigger_guard_closed_refunds
+BEFORE INSERT OR UPDATE OR DELETE ON refunds
+FOR EACH ROW
+EXECUTE FUNCTION guard_closed_period();
+
+CREATE TABLE IF NOT EXISTS cash_collections(
+  id TEXT NOT NULL UNIQUE,
+  orderid TEXT NOT NULL UNIQUE,
+  collectedby TEXT NOT NULL,
+  amount INT NOT NULL,
+  tendered INT NOT NULL,
+  change INT NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cco
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id)
+  ON DELETE CASCADE,
+  CONSTRAINT fk_ccu
+  FOREIGN KEY (collectedby)
+  REFERENCES users(id),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_cash_collections_created ON cash_collections(created);
+
+CREATE TABLE IF NOT EXISTS cash_counts(
+  id TEXT NOT NULL UNIQUE,
+  day DATE NOT NULL,
+  userid TEXT NOT NULL,
+  counted INT NOT NULL,
+  notes TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cnu
+  FOREIGN KEY (userid)
+  REFERENCES users(id),
+  UNIQUE(day, userid),
+  PRIMARY KEY(id)
+);
+
+SELECT apply_update_trigger('cash_counts');
+
+ALTER TYPE PAYMENT ADD VALUE IF NOT EXISTS 'card';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS channel VARCHAR(10) NOT NULL DEFAULT 'online';
+
+CREATE INDEX IF NOT EXISTS idx_orders_channel ON orders(channel);
+
+INSERT INTO customers (id, fullname, email, address, phone) VALUES ('anonymous', 'Walk-in Customer', '', '', '') ON CONFLICT (id)
+DO NOTHING;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancelled BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paymentintent TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS chargeid TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paymentstatus VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paid INT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded INT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_orders_paymentintent ON orders(paymentintent);
+CREATE INDEX IF NOT EXISTS idx_orders_chargeid ON orders(chargeid);
+
+CREATE TABLE IF NOT EXISTS disputes(
+  id TEXT NOT NULL UNIQUE,
+  orderid TEXT,
+  chargeid TEXT NOT NULL,
+  amount INT NOT NULL,
+  reason VARCHAR(30) NOT NULL DEFAULT '',
+  status VARCHAR(30) NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_do
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id)
+  ON DELETE SET NULL,
+  PRIMARY KEY(id)
+);
+
+SELECT apply_update_trigger('disputes');
+
+CREATE TABLE IF NOT EXISTS payouts(
+  id TEXT NOT NULL UNIQUE,
+  amount INT NOT NULL,
+  currency VARCHAR(3) NOT NULL,
+  arrival DATE NOT NULL,
+  status VARCHAR(15) NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payouts_arrival ON payouts(arrival);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tip INT NOT NULL DEFAULT 0;
+
+-- Tips are booked with the sales, so they are locked once their period is closed
+DROP TRIGGER IF EXISTS trigger_guard_closed_orders ON orders;
+CREATE TRIGGER trigger_guard_closed_orders
+BEFORE UPDATE OF method, discount, fee, tip, cancelled, created OR DELETE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION guard_closed_period();
+
+INSERT INTO ledger_accounts (key, code, name) VALUES ('tips', '2300', 'Tips Payable') ON CONFLICT (key)
+DO NOTHING;
+
+INSERT INTO categories (id, name) VALUES ('giftcards', 'Gift Cards') ON CONFLICT (id)
+DO NOTHING;
+
+ALTER TYPE PAYMENT ADD VALUE IF NOT EXISTS 'giftcard';
+
+INSERT INTO ledger_accounts (key, code, name) VALUES ('giftcard', '2400', 'Gift Card Liability') ON CONFLICT (key)
+DO NOTHING;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS giftcard INT NOT NULL DEFAULT 0;
+
+DROP TRIGGER IF EXISTS trigger_guard_closed_orders ON orders;
+CREATE TRIGGER trigger_guard_closed_orders
+BEFORE UPDATE OF method, discount, fee, tip, giftcard, cancelled, created OR DELETE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION guard_closed_period();
+
+CREATE TABLE IF NOT EXISTS giftcards(
+  id TEXT NOT NULL UNIQUE,
+  code VARCHAR(19) NOT NULL UNIQUE,
+  kind VARCHAR(10) NOT NULL,
+  initial INT NOT NULL,
+  balance INT NOT NULL,
+  recipient TEXT NOT NULL DEFAULT '',
+  orderid TEXT,
+  voided BOOLEAN NOT NULL DEFAULT false,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_go
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id)
+  ON DELETE SET NULL,
+  CONSTRAINT chk_giftcards_balance CHECK (balance >= 0),
+  PRIMARY KEY(id)
+);
+
+SELECT apply_update_trigger('giftcards');
+
+CREATE INDEX IF NOT EXISTS idx_giftcards_recipient ON giftcards(recipient);
+
+CREATE TABLE IF NOT EXISTS giftcard_ledger(
+  id SERIAL NOT NULL,
+  cardid TEXT NOT NULL,
+  reason VARCHAR(10) NOT NULL,
+  amount INT NOT NULL,
+  balance INT NOT NULL,
+  orderid TEXT,
+  ref INT,
+  actor TEXT NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_glc
+  FOREIGN KEY (cardid)
+  REFERENCES giftcards(id)
+  ON DELETE CASCADE,
+  CONSTRAINT fk_glo
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id)
+  ON DELETE SET NULL,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_giftcard_ledger_card ON giftcard_ledger(cardid);
+CREATE INDEX IF NOT EXISTS idx_giftcard_ledger_order ON giftcard_ledger(orderid);
+
+CREATE TABLE IF NOT EXISTS loyalty_points(
+  id SERIAL NOT NULL,
+  email TEXT NOT NULL,
+  reason VARCHAR(10) NOT NULL,
+  points INT NOT NULL,
+  orderid TEXT,
+  ref INT,
+  actor TEXT NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_lpo
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id)
+  ON DELETE SET NULL,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_points_email ON loyalty_points(email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loyalty_points_earned ON loyalty_points(orderid, reason) WHERE reason IN ('earn', 'bonus');
+
+CREATE TABLE IF NOT EXISTS loyalty_promotions(
+  id TEXT NOT NULL UNIQUE,
+  productid TEXT NOT NULL,
+  multiplier INT NOT NULL,
+  starts TIMESTAMP NOT NULL,
+  ends TIMESTAMP NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_lpp
+  FOREIGN KEY (productid)
+  REFERENCES products(id)
+  ON DELETE CASCADE,
+  CONSTRAINT chk_loyalty_promotions_multiplier CHECK (multiplier > 1),
+  CONSTRAINT chk_loyalty_promotions_period CHECK (ends > starts),
+  PRIMARY KEY(id)
+);
+
+SELECT apply_update_trigger('loyalty_promotions');
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS referral VARCHAR(12);
+ALTER TABLE customers ALTER COLUMN referral SET DEFAULT UPPER(SUBSTR(MD5(RANDOM()::TEXT || CLOCK_TIMESTAMP()::TEXT), 1, 10));
+UPDATE customers SET referral = DEFAULT WHERE referral IS NULL;
+ALTER TABLE customers ALTER COLUMN referral SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_referral ON customers(referral);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS referral VARCHAR(12) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS referrals(
+  id TEXT NOT NULL UNIQUE,
+  referrer TEXT NOT NULL,
+  referred TEXT NOT NULL UNIQUE,
+  orderid TEXT UNIQUE,
+  code VARCHAR(12) NOT NULL,
+  discount INT NOT NULL DEFAULT 0,
+  credit INT NOT NULL DEFAULT 0,
+  status VARCHAR(10) NOT NULL DEFAULT 'pending',
+  reason TEXT NOT NULL DEFAULT '',
+  giftcardid TEXT,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_rfr
+  FOREIGN KEY (referrer)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  CONSTRAINT fk_rfd
+  FOREIGN KEY (referred)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  CONSTRAINT fk_rfo
+  FOREIGN KEY (orderid)
+  REFERENCES orders(id)
+  ON DELETE SET NULL,
+  CONSTRAINT fk_rfg
+  FOREIGN KEY (giftcardid)
+  REFERENCES giftcards(id)
+  ON DELETE SET NULL,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals(referrer);
+
+SELECT apply_update_trigger('referrals');
+
+CREATE TABLE IF NOT EXISTS accounts(
+  id TEXT NOT NULL UNIQUE,
+  email VARCHAR(254) NOT NULL UNIQUE,
+  password TEXT NOT NULL DEFAULT '',
+  fullname VARCHAR(30) NOT NULL DEFAULT '',
+  phone VARCHAR(15) NOT NULL DEFAULT '',
+  verified BOOLEAN NOT NULL DEFAULT false,
+  customerid TEXT UNIQUE,
+  lastlogin TIMESTAMP,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_acc
+  FOREIGN KEY (customerid)
+  REFERENCES customers(id)
+  ON DELETE SET NULL,
+  PRIMARY KEY(id)
+);
+
+SELECT apply_update_trigger('accounts');
+
+CREATE TABLE IF NOT EXISTS account_addresses(
+  id TEXT NOT NULL UNIQUE,
+  accountid TEXT NOT NULL,
+  label VARCHAR(30) NOT NULL DEFAULT '',
+  address TEXT NOT NULL,
+  preferred BOOLEAN NOT NULL DEFAULT false,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_aad
+  FOREIGN KEY (accountid)
+  REFERENCES accounts(id)
+  ON DELETE CASCADE,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_addresses_account ON account_addresses(accountid);
+
+SELECT apply_update_trigger('account_addresses');
+
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS erased TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS customer_ips(
+  customerid TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  seen TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cip
+  FOREIGN KEY (customerid)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  PRIMARY KEY(customerid, ip)
+);
+
+CREATE TABLE IF NOT EXISTS emails(
+  id SERIAL NOT NULL,
+  recipient TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  tag VARCHAR(20) NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_emails_recipient ON emails(LOWER(recipient));
+
+CREATE TABLE IF NOT EXISTS privacy_requests(
+  id SERIAL NOT NULL,
+  customerid TEXT NOT NULL,
+  kind VARCHAR(10) NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  reason TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_customer ON privacy_requests(customerid);
+
+CREATE TABLE IF NOT EXISTS customer_duplicates(
+  id SERIAL NOT NULL,
+  customera TEXT NOT NULL,
+  customerb TEXT NOT NULL,
+  score INT NOT NULL,
+  reasons TEXT NOT NULL DEFAULT '',
+  status VARCHAR(10) NOT NULL DEFAULT 'pending',
+  actor TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cda
+  FOREIGN KEY (customera)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  CONSTRAINT fk_cdb
+  FOREIGN KEY (customerb)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  CONSTRAINT chk_customer_duplicates_order CHECK (customera < customerb),
+  UNIQUE(customera, customerb),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_duplicates_status ON customer_duplicates(status);
+
+SELECT apply_update_trigger('customer_duplicates');
+
+CREATE TABLE IF NOT EXISTS customer_merges(
+  id SERIAL NOT NULL,
+  survivor TEXT NOT NULL,
+  merged TEXT NOT NULL,
+  snapshot JSONB NOT NULL,
+  moves JSONB NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  undone TIMESTAMP,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_merges_survivor ON customer_merges(survivor);
+
+CREATE TABLE IF NOT EXISTS customer_notes(
+  id SERIAL NOT NULL,
+  customerid TEXT NOT NULL,
+  body TEXT NOT NULL,
+  author TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cnc
+  FOREIGN KEY (customerid)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_notes_customer ON customer_notes(customerid);
+
+SELECT apply_update_trigger('customer_notes');
+
+CREATE TABLE IF NOT EXISTS customer_note_events(
+  id SERIAL NOT NULL,
+  noteid INT NOT NULL,
+  customerid TEXT NOT NULL,
+  action VARCHAR(10) NOT NULL,
+  body TEXT NOT NULL DEFAULT '',
+  actor TEXT NOT NULL DEFAULT '',
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cnec
+  FOREIGN KEY (customerid)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_note_events_customer ON customer_note_events(customerid);
+
+CREATE TABLE IF NOT EXISTS customer_tags(
+  customerid TEXT NOT NULL,
+  tag VARCHAR(40) NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_ctc
+  FOREIGN KEY (customerid)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  PRIMARY KEY(customerid, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_tags_tag ON customer_tags(tag);
+
+CREATE TABLE IF NOT EXISTS geocodes(
+  query TEXT NOT NULL,
+  address JSONB NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(query)
+);
+
+CREATE TABLE IF NOT EXISTS customer_addresses(
+  id SERIAL NOT NULL,
+  customerid TEXT NOT NULL,
+  label VARCHAR(30) NOT NULL DEFAULT '',
+  street TEXT NOT NULL DEFAULT '',
+  unit TEXT NOT NULL DEFAULT '',
+  city TEXT NOT NULL DEFAULT '',
+  province TEXT NOT NULL DEFAULT '',
+  postalcode TEXT NOT NULL DEFAULT '',
+  country TEXT NOT NULL DEFAULT '',
+  lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+  lng DOUBLE PRECISION NOT NULL DEFAULT 0,
+  formatted TEXT NOT NULL,
+  preferred BOOLEAN NOT NULL DEFAULT false,
+  lastused TIMESTAMP NOT NULL DEFAULT NOW(),
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_cac
+  FOREIGN KEY (customerid)
+  REFERENCES customers(id)
+  ON DELETE CASCADE,
+  PRIMARY KEY(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_addresses_customer ON customer_addresses(customerid);
+
+SELECT apply_update_trigger('customer_addresses');
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS addressid INT REFERENCES customer_addresses(id) ON DELETE SET NULL;
+
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS street TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS unit TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS city TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS province TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS postalcode TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '';
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS lat DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS lng DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE account_addresses ADD COLUMN IF NOT EXISTS formatted TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS delivery_zones(
+  id SERIAL NOT NULL,
+  name VARCHAR(50) NOT NULL,
+  kind VARCHAR(10) NOT NULL,
+  prefixes TEXT[] NOT NULL DEFAULT '{}',
+  radius DOUBLE PRECISION NOT NULL DEFAULT 0,
+  fee INT NOT NULL DEFAULT 0,
+  minimum INT NOT NULL DEFAULT 0,
+  windows JSONB NOT NULL DEFAULT '[]',
+  active BOOLEAN NOT NULL DEFAULT true,
+  created TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(id)
+);
+
+SELECT apply_update_trigger('delivery_zones');
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS fulfilment VARCHAR(10) NOT NULL DEFAULT 'pickup';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS deliveryfee INT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS deliveryzone INT REFERENCES delivery_zones(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS windowstart TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS windowend TIMESTAMP;
+
+DROP TRIGGER IF EXISTS trigger_guard_closed_orders ON orders;
+CREATE TRIGGER trigger_guard_closed_orders
+BEFORE UPDATE OF method, discount, fee, tip, giftcard, deliveryfee, cancelled, created OR DELETE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION guard_closed_period();
+
+INSERT INTO ledger_accounts (key, code, name) VALUES ('delivery', '4200', 'Delivery Revenue') ON CONFLICT (key)
+DO NOTHING;
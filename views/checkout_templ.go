@@ -12,10 +12,11 @@ import (
 	"fmt"
 	"github.com/Francesco99975/rosskery/internal/helpers"
 	"github.com/Francesco99975/rosskery/internal/models"
+	"github.com/Francesco99975/rosskery/views/components"
 	"github.com/Francesco99975/rosskery/views/layouts"
 )
 
-func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData string, csrf string, nonce string) templ.Component {
+func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData string, methods []models.PaymentMethod, referral string, prefill models.CheckoutPrefill, idempotencyKey string, csrf string, nonce string) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -60,7 +61,7 @@ func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData
 				var templ_7745c5c3_Var3 string
 				templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(helpers.Capitalize(item.Product.Name))
 				if templ_7745c5c3_Err != nil {
-					return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 20, Col: 82}
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 21, Col: 82}
 				}
 				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 				if templ_7745c5c3_Err != nil {
@@ -78,7 +79,7 @@ func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData
 					var templ_7745c5c3_Var4 string
 					templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(fmt.Sprint(item.Quantity))
 					if templ_7745c5c3_Err != nil {
-						return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 22, Col: 50}
+						return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 23, Col: 50}
 					}
 					_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
 					if templ_7745c5c3_Err != nil {
@@ -96,7 +97,7 @@ func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData
 					var templ_7745c5c3_Var5 string
 					templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(fmt.Sprint(float64(item.Quantity) / 10))
 					if templ_7745c5c3_Err != nil {
-						return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 24, Col: 62}
+						return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 25, Col: 62}
 					}
 					_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
 					if templ_7745c5c3_Err != nil {
@@ -114,7 +115,7 @@ func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData
 				var templ_7745c5c3_Var6 string
 				templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(helpers.FormatPrice(float64(item.Subtotal) / 100.0))
 				if templ_7745c5c3_Err != nil {
-					return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 28, Col: 81}
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 29, Col: 81}
 				}
 				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
 				if templ_7745c5c3_Err != nil {
@@ -125,46 +126,192 @@ func Checkout(site models.Site, cartPreview *models.CartPreview, overbookedData
 					return templ_7745c5c3_Err
 				}
 			}
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</div><div class=\"mt-6\"><div class=\"flex justify-between text-xl font-bold mt-4 text-accent\"><p>Total:</p><p>")
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</div><div id=\"delivery-quote\" class=\"mt-6\">")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = components.DeliveryQuote(cartPreview.Total, nil, "").Render(ctx, templ_7745c5c3_Buffer)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</div></section><!-- Customer Information Form Section --><section class=\"mb-6 text-primary\"><h2 class=\"text-xl md:text-2xl font-bold mb-4\">Customer Information</h2><form id=\"checkout-form\" hx-post=\"/orders\" id=\"checkout-form\" class=\"space-y-4\" hx-target=\"body\" hx-boost=\"true\"><input type=\"hidden\" name=\"dd\" id=\"dd\" value=\"")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			var templ_7745c5c3_Var7 string
-			templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(helpers.FormatPrice(float64(cartPreview.Total) / 100.0))
+			templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(overbookedData)
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 36, Col: 67}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 42, Col: 67}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var7))
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</p></div></div></section><!-- Customer Information Form Section --><section class=\"mb-6 text-primary\"><h2 class=\"text-xl md:text-2xl font-bold mb-4\">Customer Information</h2><form id=\"checkout-form\" hx-post=\"/orders\" id=\"checkout-form\" class=\"space-y-4\" hx-target=\"body\" hx-boost=\"true\"><input type=\"hidden\" name=\"dd\" id=\"dd\" value=\"")
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"> <input type=\"hidden\" name=\"_csrf\" id=\"_csrf\" value=\"")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			var templ_7745c5c3_Var8 string
-			templ_7745c5c3_Var8, templ_7745c5c3_Err = templ.JoinStringErrs(overbookedData)
+			templ_7745c5c3_Var8, templ_7745c5c3_Err = templ.JoinStringErrs(csrf)
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 44, Col: 67}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 43, Col: 63}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var8))
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"> <input type=\"hidden\" name=\"_csrf\" id=\"_csrf\" value=\"")
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"> <input type=\"hidden\" name=\"idempotency_key\" id=\"idempotency_key\" value=\"")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			var templ_7745c5c3_Var9 string
-			templ_7745c5c3_Var9, templ_7745c5c3_Err = templ.JoinStringErrs(csrf)
+			templ_7745c5c3_Var9, templ_7745c5c3_Err = templ.JoinStringErrs(idempotencyKey)
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 45, Col: 63}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 44, Col: 93}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var9))
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"><div class=\"grid grid-cols-1 md:grid-cols-2 gap-4\"><div><label for=\"email\" class=\"block text-sm font-medium\">Email</label> <input type=\"email\" id=\"email\" name=\"email\" required class=\"mt-1 block w-full rounded-md border-primary shadow-sm focus:ring-iaccent focus:border-accent p-1\"></div><div><label for=\"fullname\" class=\"block text-sm font-medium\">Full Name</label> <input type=\"text\" id=\"fullname\" name=\"fullname\" required class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"></div><div class=\"md:col-span-2\"><label for=\"address\" class=\"block text-sm font-medium\">Address</label> <input type=\"text\" id=\"address\" name=\"address\" required hx-get=\"/address\" hx-trigger=\"keyup changed delay:500ms\" hx-target=\"#suggestions\" autocomplete=\"off\" class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"><div id=\"suggestions\" class=\"border border-gray-300 mt-2 rounded bg-white shadow-lg\"></div></div><div><label for=\"phone\" class=\"block text-sm font-medium\">Phone Number</label> <input type=\"tel\" id=\"phone\" name=\"phone\" required class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"></div><div><label for=\"pickuptime\" class=\"block text-sm font-medium\">Pickup Time</label> <input type=\"hidden\" id=\"pickuptime\" name=\"pickuptime\" required class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"></div></div><!-- Payment Method Section --><section><h2 class=\"text-xl md:text-2xl font-bold mb-4\">Payment Method</h2><div class=\"flex space-x-2 border-[3px] border-accent rounded-xl select-none md:w-1/3\"><label class=\"radio flex flex-grow items-center justify-center rounded-lg p-1 cursor-pointer\"><input type=\"radio\" name=\"method\" value=\"stripe\" class=\"peer hidden\" checked=\"\"> <span class=\"tracking-widest peer-checked:bg-primary peer-checked:text-std text-primary p-2 rounded-lg transition duration-150 ease-in-out\">Pay Online</span></label> <label class=\"radio flex flex-grow items-center justify-center rounded-lg p-1 cursor-pointer\"><input type=\"radio\" name=\"method\" value=\"cash\" class=\"peer hidden\"> <span class=\"tracking-widest peer-checked:bg-primary peer-checked:text-std text-primary p-2 rounded-lg transition duration-150 ease-in-out\">Cash at Pickup</span></label></div></section><button type=\"submit\" form=\"checkout-form\" class=\"mt-6 w-full bg-primary text-std py-3 rounded-lg font-bold text-lg hover:bg-accent\">Place Order</button><div id=\"errors\"></div></form></section></div></main>")
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\"><div class=\"grid grid-cols-1 md:grid-cols-2 gap-4\"><div><label for=\"email\" class=\"block text-sm font-medium\">Email</label> <input type=\"email\" id=\"email\" name=\"email\" value=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var10 string
+			templ_7745c5c3_Var10, templ_7745c5c3_Err = templ.JoinStringErrs(prefill.Email)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 48, Col: 73}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var10))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" required class=\"mt-1 block w-full rounded-md border-primary shadow-sm focus:ring-iaccent focus:border-accent p-1\"></div><div><label for=\"fullname\" class=\"block text-sm font-medium\">Full Name</label> <input type=\"text\" id=\"fullname\" name=\"fullname\" value=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var11 string
+			templ_7745c5c3_Var11, templ_7745c5c3_Err = templ.JoinStringErrs(prefill.Fullname)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 52, Col: 81}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var11))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" required class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"></div><div class=\"md:col-span-2\"><label for=\"address\" class=\"block text-sm font-medium\">Address</label> <input type=\"text\" id=\"address\" name=\"address\" value=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var12 string
+			templ_7745c5c3_Var12, templ_7745c5c3_Err = templ.JoinStringErrs(prefill.Address)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 56, Col: 78}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var12))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" required hx-get=\"/address\" hx-trigger=\"keyup changed delay:500ms\" hx-target=\"#suggestions\" autocomplete=\"off\" class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"><div id=\"suggestions\" class=\"border border-gray-300 mt-2 rounded bg-white shadow-lg\"></div></div><div><label for=\"phone\" class=\"block text-sm font-medium\">Phone Number</label> <input type=\"tel\" id=\"phone\" name=\"phone\" value=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var13 string
+			templ_7745c5c3_Var13, templ_7745c5c3_Err = templ.JoinStringErrs(prefill.Phone)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 61, Col: 71}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var13))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" required class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"></div><div><label for=\"pickuptime\" class=\"block text-sm font-medium\">Pickup or Delivery Time</label> <input type=\"hidden\" id=\"pickuptime\" name=\"pickuptime\" required class=\"mt-1 block w-full rounded-md border-primaryshadow-sm focus:ring-accent focus:border-accent p-1\"></div><div class=\"md:col-span-2\"><label for=\"notes\" class=\"block text-sm font-medium\">Notes</label> <textarea id=\"notes\" name=\"notes\" rows=\"2\" maxlength=\"500\" class=\"mt-1 block w-full rounded-md border-primary shadow-sm focus:ring-accent focus:border-accent p-1\"></textarea></div>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			if cartPreview.HasGiftCards() {
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<div class=\"md:col-span-2\"><label for=\"gift_recipient\" class=\"block text-sm font-medium\">Send the gift cards to (your email when empty)</label> <input type=\"email\" id=\"gift_recipient\" name=\"gift_recipient\" autocomplete=\"off\" class=\"mt-1 block w-full rounded-md border-primary shadow-sm focus:ring-accent focus:border-accent p-1\"></div>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			} else {
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<div class=\"md:col-span-2\"><label for=\"giftcard\" class=\"block text-sm font-medium\">Gift Card or Store Credit Code</label> <input type=\"text\" id=\"giftcard\" name=\"giftcard\" maxlength=\"19\" placeholder=\"XXXX-XXXX-XXXX-XXXX\" autocomplete=\"off\" class=\"mt-1 block w-full rounded-md border-primary shadow-sm focus:ring-accent focus:border-accent p-1 uppercase\"></div><div class=\"md:col-span-2 flex items-center gap-2\"><input type=\"checkbox\" id=\"redeem_points\" name=\"redeem_points\" value=\"true\" class=\"rounded border-primary text-primary focus:ring-accent\"> <label for=\"redeem_points\" class=\"text-sm font-medium\">Use my loyalty points</label></div><div class=\"md:col-span-2\"><label for=\"referral\" class=\"block text-sm font-medium\">Referral Code</label> <input type=\"text\" id=\"referral\" name=\"referral\" maxlength=\"12\" value=\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var14 string
+				templ_7745c5c3_Var14, templ_7745c5c3_Err = templ.JoinStringErrs(referral)
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 87, Col: 89}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var14))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" autocomplete=\"off\" class=\"mt-1 block w-full rounded-md border-primary shadow-sm focus:ring-accent focus:border-accent p-1 uppercase\"></div>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</div><!-- Fulfilment Section --><section><h2 class=\"text-xl md:text-2xl font-bold mb-4\">Pickup or Delivery</h2><div id=\"fulfilment\" class=\"flex space-x-2 border-[3px] border-accent rounded-xl select-none md:w-1/3\" hx-get=\"/delivery\" hx-trigger=\"change, change from:#address, change from:#pickuptime\" hx-include=\"[name=&#39;fulfilment&#39;]:checked, #address, #pickuptime\" hx-target=\"#delivery-quote\"><label class=\"radio flex flex-grow items-center justify-center rounded-lg p-1 cursor-pointer\"><input type=\"radio\" name=\"fulfilment\" value=\"pickup\" class=\"peer hidden\" checked> <span class=\"tracking-widest peer-checked:bg-primary peer-checked:text-std text-primary p-2 rounded-lg transition duration-150 ease-in-out\">Pickup</span></label> <label class=\"radio flex flex-grow items-center justify-center rounded-lg p-1 cursor-pointer\"><input type=\"radio\" name=\"fulfilment\" value=\"delivery\" class=\"peer hidden\"> <span class=\"tracking-widest peer-checked:bg-primary peer-checked:text-std text-primary p-2 rounded-lg transition duration-150 ease-in-out\">Delivery</span></label></div></section><!-- Payment Method Section --><section><h2 class=\"text-xl md:text-2xl font-bold mb-4\">Payment Method</h2><div class=\"flex space-x-2 border-[3px] border-accent rounded-xl select-none md:w-1/3\">")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			for i, method := range methods {
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<label class=\"radio flex flex-grow items-center justify-center rounded-lg p-1 cursor-pointer\"><input type=\"radio\" name=\"method\" value=\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var15 string
+				templ_7745c5c3_Var15, templ_7745c5c3_Err = templ.JoinStringErrs(string(method))
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `checkout.templ`, Line: 125, Col: 33}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var15))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("\" class=\"peer hidden\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				if i == 0 {
+					_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(" checked")
+					if templ_7745c5c3_Err != nil {
+						return templ_7745c5c3_Err
+					}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("> <span class=\"tracking-widest peer-checked:bg-primary peer-checked:text-std text-primary p-2 rounded-lg transition duration-150 ease-in-out\">")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				if method == models.PAYPAL {
+					_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("PayPal")
+					if templ_7745c5c3_Err != nil {
+						return templ_7745c5c3_Err
+					}
+				} else {
+					_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("Pay Online")
+					if templ_7745c5c3_Err != nil {
+						return templ_7745c5c3_Err
+					}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("</span></label> ")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("<label class=\"radio flex flex-grow items-center justify-center rounded-lg p-1 cursor-pointer\"><input type=\"radio\" name=\"method\" value=\"cash\" class=\"peer hidden\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			if len(methods) == 0 {
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(" checked")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString("> <span class=\"tracking-widest peer-checked:bg-primary peer-checked:text-std text-primary p-2 rounded-lg transition duration-150 ease-in-out\">Cash at Pickup</span></label></div></section><button type=\"submit\" form=\"checkout-form\" class=\"mt-6 w-full bg-primary text-std py-3 rounded-lg font-bold text-lg hover:bg-accent\">Place Order</button><div id=\"errors\"></div></form></section></div></main>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}